@@ -0,0 +1,551 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is the snapshot of operator activity published to API observers.
+type State struct {
+	Address int       `json:"address"`
+	Camera  string    `json:"camera,omitempty"`
+	Command string    `json:"command"` // last pelco-d frame sent, in hex
+	Action  string    `json:"action"`
+	Owner   string    `json:"owner,omitempty"`
+	Ack     string    `json:"ack,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+func newState(conf config.Config, dash Dashboard) State {
+	state := State{
+		Address: int(dash.Message[pelco.Addr]),
+		Camera:  conf.CameraName(int(dash.Message[pelco.Addr])),
+		Command: hex.EncodeToString(dash.Message[:]),
+		Action:  strings.Join(describeActions(dash.Message), ", "),
+		Owner:   dash.Owner,
+	}
+
+	if conf.Ack {
+		state.Ack = dash.Ack.String()
+	}
+
+	return state
+}
+
+// StateHub holds the latest State and fans updates out to subscribers.
+type StateHub struct {
+	mu          sync.Mutex
+	state       State
+	subscribers map[chan State]struct{}
+}
+
+func NewStateHub() *StateHub {
+	return &StateHub{subscribers: make(map[chan State]struct{})}
+}
+
+// records state and notifies subscribers, unless nothing changed.
+func (h *StateHub) Publish(state State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state.Updated = h.state.Updated
+	if state == h.state {
+		return
+	}
+
+	state.Updated = time.Now()
+	h.state = state
+
+	for c := range h.subscribers {
+		// subscribers only care about the latest state, so replace a stale one
+		select {
+		case <-c:
+		default:
+		}
+		c <- state
+	}
+}
+
+func (h *StateHub) Current() State {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.state
+}
+
+// returns a channel of state updates and a function to stop them.
+func (h *StateHub) Subscribe() (<-chan State, func()) {
+	c := make(chan State, 1)
+
+	h.mu.Lock()
+	h.subscribers[c] = struct{}{}
+	h.mu.Unlock()
+
+	return c, func() {
+		h.mu.Lock()
+		delete(h.subscribers, c)
+		h.mu.Unlock()
+	}
+}
+
+// api clients hold control this long after each command
+const apiLockTTL = 5 * time.Second
+
+var apiSource = Source{Name: "api", Priority: 60}
+
+// Command is a frame from a source other than the joystick, for the
+// interactive loop to send if the arbiter allows it.
+type Command struct {
+	Source  Source
+	Message PelcoDMessage
+}
+
+// APIServer exposes operator state over HTTP and websockets, preset and tour
+// control per camera, and stored recordings to play back.
+type APIServer struct {
+	conf     config.Config
+	hub      *StateHub
+	mux      *http.ServeMux
+	arbiter  *Arbiter
+	commands chan<- Command
+	tours    *TourRunner
+	position *Estimator
+	limiter  *RateLimiter
+
+	recordings *RecordingPlayer
+
+	mu      sync.Mutex
+	presets map[int]map[int]string // address to preset number to name
+}
+
+func NewAPIServer(conf config.Config, hub *StateHub, arbiter *Arbiter, commands chan<- Command, position *Estimator) *APIServer {
+	s := &APIServer{
+		conf:     conf,
+		hub:      hub,
+		mux:      http.NewServeMux(),
+		arbiter:  arbiter,
+		commands: commands,
+		position: position,
+		limiter:  NewRateLimiter(conf.RateLimit, conf.RateBurst),
+		presets:  make(map[int]map[int]string),
+	}
+
+	s.tours = NewTourRunner(conf, arbiter, apiSource, func(source Source, message PelcoDMessage) {
+		commands <- Command{source, message}
+	})
+
+	s.recordings = NewRecordingPlayer(arbiter, apiSource, func(source Source, message PelcoDMessage) {
+		commands <- Command{source, message}
+	})
+
+	for _, camera := range conf.Cameras {
+		for _, preset := range camera.Presets {
+			s.namePreset(camera.Address, preset.Number, preset.Name)
+		}
+	}
+
+	s.mux.HandleFunc("/api/state", requireScope(ScopeView, s.handleState))
+	s.mux.HandleFunc("/api/state/ws", requireScope(ScopeView, s.handleStateStream))
+	s.mux.HandleFunc("/api/orientation", requireScope(ScopeView, s.handleOrientation))
+	s.mux.HandleFunc("/api/cameras/", s.handleCamera)
+	s.mux.HandleFunc("/api/recordings", s.handleRecordings)
+	s.mux.HandleFunc("/api/recordings/", s.handleRecordings)
+	s.mux.HandleFunc("/api/stats", requireScope(ScopeView, s.handleStats))
+	s.mux.HandleFunc("/metrics", requireScope(ScopeView, s.handleMetrics))
+	s.mux.HandleFunc("/view", requireScope(ScopeView, s.handleView))
+	s.mux.HandleFunc("/api/openapi.json", requireScope(ScopeView, s.handleOpenAPI))
+	s.mux.HandleFunc("/api/docs", requireScope(ScopeView, s.handleDocs))
+
+	return s
+}
+
+// serves the API on addr, over TLS when a certificate is configured.
+func (s *APIServer) ListenAndServe(addr string) error {
+	handler := requireAuth(s.conf, limitRate(s.conf, s.limiter, s.mux))
+
+	if "" != s.conf.TLSCert {
+		return http.ListenAndServeTLS(addr, s.conf.TLSCert, s.conf.TLSKey, handler)
+	}
+
+	return http.ListenAndServe(addr, handler)
+}
+
+// serves the API in the background, reporting failure on stderr.
+func startAPIServer(conf config.Config, hub *StateHub, arbiter *Arbiter, commands chan<- Command, position *Estimator) {
+	if "" == conf.Listen {
+		return
+	}
+
+	if err := checkAPISecurity(conf); err != nil {
+		printError("api disabled. %s\n", err)
+		return
+	}
+
+	if "" != conf.TLSCert && conf.TLSSelfSigned && !fileExists(conf.TLSCert) && !fileExists(conf.TLSKey) {
+		if err := generateSelfSigned(conf.TLSCert, conf.TLSKey); err != nil {
+			printError("api disabled. unable to create self-signed certificate. %s\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Created self-signed certificate %s\n", conf.TLSCert)
+	}
+
+	server := NewAPIServer(conf, hub, arbiter, commands, position)
+
+	scheme := "http"
+	if "" != conf.TLSCert {
+		scheme = "https"
+	}
+
+	for _, addr := range listenAddresses(conf.Listen) {
+		go func(addr string) {
+			if err := server.ListenAndServe(addr); err != nil {
+				printError("api server on %s stopped. %s\n", addr, err)
+			}
+		}(addr)
+
+		fmt.Fprintf(os.Stderr, "API listening on %s://%s\n", scheme, addr)
+	}
+
+	if conf.MDNS {
+		startMDNS(conf)
+	}
+}
+
+// splits the listen setting into its addresses. several may be given,
+// comma separated, e.g. "127.0.0.1:8080,[::1]:8080" to serve both loopbacks.
+func listenAddresses(listen string) []string {
+	var addresses []string
+
+	for _, addr := range strings.Split(listen, ",") {
+		if addr = strings.TrimSpace(addr); "" != addr {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return addresses
+}
+
+func (s *APIServer) handleState(w http.ResponseWriter, r *http.Request) {
+	if "GET" != r.Method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := s.hub.Current()
+	if !principalFrom(r).CanAccess(s.conf, state.Address) {
+		http.Error(w, "forbidden. no access to camera", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+// pushes every state change to a websocket observer. observers can't send
+// commands; anything they send is discarded. changes of cameras the
+// observer's token has no access to are left out.
+func (s *APIServer) handleStateStream(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	updates, unsubscribe := s.hub.Subscribe()
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	principal := principalFrom(r)
+	state := s.hub.Current()
+
+	for {
+		if principal.CanAccess(s.conf, state.Address) {
+			payload, _ := json.Marshal(state)
+			if err := ws.WriteText(payload); err != nil {
+				return
+			}
+		}
+
+		select {
+		case state = <-updates:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// reports the estimated orientation of the selected camera, or of ?camera=.
+func (s *APIServer) handleOrientation(w http.ResponseWriter, r *http.Request) {
+	address := s.hub.Current().Address
+
+	if camera := r.URL.Query().Get("camera"); "" != camera {
+		if address = s.conf.CameraAddress(camera); address < 0 {
+			var err error
+			if address, err = strconv.Atoi(camera); err != nil {
+				http.Error(w, "unknown camera "+camera, http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	if !principalFrom(r).CanAccess(s.conf, address) {
+		http.Error(w, "forbidden. no access to camera", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.position.Orientation(address, time.Now()))
+}
+
+func (s *APIServer) handleView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, viewPage)
+}
+
+// routes /api/cameras/CAMERA/presets[/NUM[/call|/thumbnail]] and
+// /api/cameras/CAMERA/tours[/NAME[/start|/stop]]. CAMERA is a name or address.
+func (s *APIServer) handleCamera(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/cameras/"), "/"), "/")
+
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	address := s.conf.CameraAddress(parts[0])
+	if address < 0 {
+		var err error
+		if address, err = strconv.Atoi(parts[0]); err != nil || address < 0 || address > 255 {
+			http.Error(w, "unknown camera "+parts[0], http.StatusNotFound)
+			return
+		}
+	}
+
+	if !principalFrom(r).CanAccess(s.conf, address) {
+		http.Error(w, "forbidden. no access to camera "+parts[0], http.StatusForbidden)
+		return
+	}
+
+	switch parts[1] {
+	case "presets":
+		s.handlePresets(w, r, address, parts[2:])
+	case "tours":
+		s.handleTours(w, r, address, parts[2:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// PresetInfo is a preset as listed by the API. Thumbnail is the path of its
+// image, when one was taken as it was saved.
+type PresetInfo struct {
+	Number    int    `json:"number"`
+	Name      string `json:"name,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// GET lists named presets and those with thumbnails. POST NUM saves the
+// current position as preset NUM, optionally named by ?name=. POST NUM/call
+// recalls it. DELETE NUM clears it. GET NUM/thumbnail is its image.
+func (s *APIServer) handlePresets(w http.ResponseWriter, r *http.Request, address int, parts []string) {
+	if 0 == len(parts) {
+		if "GET" != r.Method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, s.listPresets(address))
+		})(w, r)
+		return
+	}
+
+	number, err := strconv.Atoi(parts[0])
+	if err != nil || number < 1 || number > 255 || len(parts) > 2 || (2 == len(parts) && "call" != parts[1] && "thumbnail" != parts[1]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if 2 == len(parts) && "thumbnail" == parts[1] {
+		if "GET" != r.Method && "HEAD" != r.Method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			path := thumbnailPath(s.conf, address, number)
+			if "" == path {
+				http.Error(w, "no thumbnail for preset "+parts[0], http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFile(w, r, path)
+		})(w, r)
+		return
+	}
+
+	var command uint8
+
+	switch {
+	case 2 == len(parts) && "POST" == r.Method:
+		command = pelco.CallPreset
+	case 1 == len(parts) && "POST" == r.Method:
+		command = pelco.SetPreset
+	case 1 == len(parts) && "DELETE" == r.Method:
+		command = pelco.ClearPreset
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requireScope(ScopePreset, func(w http.ResponseWriter, r *http.Request) {
+		message := pelco.New().To(address).Extended(command, 0x00, uint8(number)).Build()
+
+		// a preset hop would fight a tour running on the same camera
+		s.tours.Stop(address)
+
+		if err := s.send(message); errQueueFull == err {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		switch command {
+		case pelco.SetPreset:
+			s.namePreset(address, number, r.URL.Query().Get("name"))
+		case pelco.ClearPreset:
+			s.mu.Lock()
+			delete(s.presets[address], number)
+			s.mu.Unlock()
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"sent": hex.EncodeToString(message[:])})
+	})(w, r)
+}
+
+// acquires control for the api and queues message for the bus.
+func (s *APIServer) send(message PelcoDMessage) error {
+	if err := s.arbiter.Acquire(apiSource, apiLockTTL, time.Now()); err != nil {
+		return err
+	}
+
+	return enqueue(s.commands, Command{apiSource, message})
+}
+
+func (s *APIServer) namePreset(address, number int, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.presets[address] {
+		s.presets[address] = make(map[int]string)
+	}
+
+	s.presets[address][number] = name
+}
+
+func (s *APIServer) listPresets(address int) []PresetInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	presets := []PresetInfo{}
+	for number, name := range s.presets[address] {
+		presets = append(presets, PresetInfo{Number: number, Name: name})
+	}
+
+	// presets saved from the joystick or a keyboard are only known by their
+	// thumbnails
+	for _, number := range thumbnailPresets(s.conf, address) {
+		if _, ok := s.presets[address][number]; !ok {
+			presets = append(presets, PresetInfo{Number: number})
+		}
+	}
+
+	for i := range presets {
+		if "" != thumbnailPath(s.conf, address, presets[i].Number) {
+			presets[i].Thumbnail = fmt.Sprintf("/api/cameras/%d/presets/%d/thumbnail", address, presets[i].Number)
+		}
+	}
+
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Number < presets[j].Number })
+
+	return presets
+}
+
+// GET lists configured tours and the camera's tour status. POST NAME/start
+// starts a tour (looping with ?loop=true), POST NAME/stop or POST stop ends
+// it, GET NAME reports its status.
+func (s *APIServer) handleTours(w http.ResponseWriter, r *http.Request, address int, parts []string) {
+	switch {
+	case 0 == len(parts) && "GET" == r.Method:
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			var names []string
+			for _, tour := range s.conf.Tours {
+				if tourAddress, err := tourAddress(s.conf, tour); nil == err && ("" == tour.Camera || tourAddress == address) {
+					names = append(names, tour.Name)
+				}
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"tours": names, "status": s.tours.Status(address)})
+		})(w, r)
+
+	case 1 == len(parts) && "GET" == r.Method:
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			status := s.tours.Status(address)
+			if status.Tour != parts[0] {
+				http.Error(w, "tour "+parts[0]+" is not running", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+		})(w, r)
+
+	case 2 == len(parts) && "start" == parts[1] && "POST" == r.Method:
+		requireScope(ScopeMove, func(w http.ResponseWriter, r *http.Request) {
+			tour, ok := s.conf.Tour(parts[0])
+			if !ok {
+				http.Error(w, "unknown tour "+parts[0], http.StatusNotFound)
+				return
+			}
+
+			status, err := s.tours.Start(tour, address, "true" == r.URL.Query().Get("loop"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, status)
+		})(w, r)
+
+	case (1 == len(parts) && "stop" == parts[0] || 2 == len(parts) && "stop" == parts[1]) && "POST" == r.Method:
+		requireScope(ScopeMove, func(w http.ResponseWriter, r *http.Request) {
+			if !s.tours.Stop(address) {
+				http.Error(w, "no tour running", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, s.tours.Status(address))
+		})(w, r)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source identifies something that issues camera commands. Sources with
+// Preempts set (the local joystick) take control whenever they are active.
+// other sources must hold the lock before their commands reach the bus.
+type Source struct {
+	Name     string
+	Priority int
+	Preempts bool
+}
+
+var joystickSource = Source{Name: "joystick", Priority: 100, Preempts: true}
+
+// Arbiter decides which source currently controls the cameras.
+type Arbiter struct {
+	mu sync.Mutex
+
+	// how long a preempting source keeps control after its input goes idle
+	Holdoff time.Duration
+
+	owner       Source
+	hasOwner    bool
+	lockExpires time.Time // zero for preempting owners
+	lastActive  time.Time
+}
+
+func NewArbiter(holdoff time.Duration) *Arbiter {
+	return &Arbiter{Holdoff: holdoff}
+}
+
+// requests the lock for source for ttl. fails while a higher or equal
+// priority source holds control.
+func (a *Arbiter) Acquire(source Source, ttl time.Duration, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+
+	if a.hasOwner && a.owner.Name != source.Name && a.owner.Priority >= source.Priority {
+		return fmt.Errorf("%s has control", a.owner.Name)
+	}
+
+	a.owner = source
+	a.hasOwner = true
+	a.lockExpires = now.Add(ttl)
+	a.lastActive = now
+
+	return nil
+}
+
+// gives up control if source holds it.
+func (a *Arbiter) Release(source Source) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.hasOwner && a.owner.Name == source.Name {
+		a.hasOwner = false
+	}
+}
+
+// reports whether a command from source may be sent. active is false for
+// stop frames, which only the owner (or anyone, when uncontrolled) may send.
+func (a *Arbiter) Allow(source Source, active bool, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+
+	if source.Preempts && active {
+		if !a.hasOwner || a.owner.Name != source.Name {
+			a.owner = source
+			a.hasOwner = true
+			a.lockExpires = time.Time{}
+		}
+		a.lastActive = now
+		return true
+	}
+
+	if !a.hasOwner {
+		return !active || source.Preempts
+	}
+
+	if a.owner.Name != source.Name {
+		return false
+	}
+
+	if active {
+		a.lastActive = now
+	}
+
+	return true
+}
+
+// the name of the controlling source, or "" when no source has control.
+func (a *Arbiter) Owner(now time.Time) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+
+	if !a.hasOwner {
+		return ""
+	}
+
+	return a.owner.Name
+}
+
+// releases expired locks and idle preempting owners. callers hold a.mu.
+func (a *Arbiter) expire(now time.Time) {
+	if !a.hasOwner {
+		return
+	}
+
+	if a.owner.Preempts {
+		if now.Sub(a.lastActive) > a.Holdoff {
+			a.hasOwner = false
+		}
+	} else if now.After(a.lockExpires) {
+		a.hasOwner = false
+	}
+}
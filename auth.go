@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// api scopes. admin implies every other scope.
+const (
+	ScopeView   = "view"
+	ScopeMove   = "move"
+	ScopePreset = "preset"
+	ScopeAdmin  = "admin"
+)
+
+var allScopes = []string{ScopeView, ScopeMove, ScopePreset, ScopeAdmin}
+
+// Principal is an authenticated api client and what it may do.
+type Principal struct {
+	Name    string
+	Scopes  []string
+	Cameras []string // camera names or addresses. empty allows every camera.
+}
+
+type principalKey struct{}
+
+func (p Principal) Can(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || ScopeAdmin == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reports whether the principal may address the camera at address.
+func (p Principal) CanAccess(conf config.Config, address int) bool {
+	if 0 == len(p.Cameras) {
+		return true
+	}
+
+	for _, camera := range p.Cameras {
+		if camera == strconv.Itoa(address) || conf.CameraAddress(camera) == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+func principalFrom(r *http.Request) Principal {
+	p, _ := r.Context().Value(principalKey{}).(Principal)
+	return p
+}
+
+func hasAPICredentials(conf config.Config) bool {
+	return "" != conf.APIToken || ("" != conf.APIUser && "" != conf.APIPassword) || 0 != len(conf.APITokens)
+}
+
+// reports whether addr only accepts connections from this machine.
+func isLoopbackListener(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if "localhost" == host {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return nil != ip && ip.IsLoopback()
+}
+
+// refuses configurations that would expose an unauthenticated API to the network.
+func checkAPISecurity(conf config.Config) error {
+	for _, addr := range listenAddresses(conf.Listen) {
+		if !hasAPICredentials(conf) && !isLoopbackListener(addr) {
+			return fmt.Errorf("api on %s requires api-token or api-user/api-password. bind to 127.0.0.1 or [::1] to run without", addr)
+		}
+	}
+
+	if ("" == conf.TLSCert) != ("" == conf.TLSKey) {
+		return errors.New("tls-cert and tls-key must be given together")
+	}
+
+	return nil
+}
+
+// rejects requests without valid bearer or basic credentials. loopback-only
+// servers without configured credentials are left open.
+func requireAuth(conf config.Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := authenticate(conf, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cctv-ptz"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// rejects requests whose principal lacks scope.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !principalFrom(r).Can(scope) {
+			http.Error(w, "forbidden. requires "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func authenticate(conf config.Config, r *http.Request) (Principal, bool) {
+	if !hasAPICredentials(conf) {
+		return Principal{Name: "anonymous", Scopes: allScopes}, true
+	}
+
+	if token := bearerToken(r); "" != token {
+		if "" != conf.APIToken && secureEquals(token, conf.APIToken) {
+			return Principal{Name: "api-token", Scopes: allScopes}, true
+		}
+
+		for _, t := range conf.APITokens {
+			if "" != t.Token && secureEquals(token, t.Token) {
+				return Principal{Name: t.Name, Scopes: t.Scopes, Cameras: t.Cameras}, true
+			}
+		}
+	}
+
+	if "" != conf.APIUser && "" != conf.APIPassword {
+		if user, password, ok := r.BasicAuth(); ok {
+			// evaluate both comparisons to avoid leaking which one failed
+			userOk := secureEquals(user, conf.APIUser)
+			passwordOk := secureEquals(password, conf.APIPassword)
+			if userOk && passwordOk {
+				return Principal{Name: user, Scopes: allScopes}, true
+			}
+		}
+	}
+
+	return Principal{}, false
+}
+
+// returns the token from an "Authorization: Bearer" header, or from the
+// access_token query parameter for browsers opening websockets.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+
+	return r.URL.Query().Get("access_token")
+}
+
+func secureEquals(a, b string) bool {
+	return 1 == subtle.ConstantTimeCompare([]byte(a), []byte(b))
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return nil == err
+}
+
+// writes a self-signed certificate and key to certPath and keyPath, valid for
+// this host's name and loopback addresses.
+func generateSelfSigned(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"cctv-ptz"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if "" != hostname {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+
+	return writePEM(keyPath, "EC PRIVATE KEY", keyDer, 0600)
+}
+
+func writePEM(path, kind string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return pem.Encode(f, &pem.Block{Type: kind, Bytes: der})
+}
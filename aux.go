@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/simulatedsimian/joystick"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AuxBinding drives one aux output from a button chord.
+type AuxBinding struct {
+	Mask   uint32
+	Aux    uint8
+	Toggle bool
+
+	pressed bool // chord was held on the previous update
+	on      bool // output state last commanded
+}
+
+func newAuxBindings(bindings []config.AuxBinding) ([]AuxBinding, error) {
+	var result []AuxBinding
+
+	for _, binding := range bindings {
+		mask, err := buttonMask(binding.Buttons)
+		if err != nil {
+			return nil, err
+		}
+
+		if binding.Aux < 1 || binding.Aux > 255 {
+			return nil, fmt.Errorf("aux %d out of range 1-255", binding.Aux)
+		}
+
+		var toggle bool
+
+		switch binding.Mode {
+		case "", "momentary":
+			toggle = false
+		case "toggle":
+			toggle = true
+		default:
+			return nil, fmt.Errorf("unknown aux mode %s. expected momentary or toggle", binding.Mode)
+		}
+
+		result = append(result, AuxBinding{Mask: mask, Aux: uint8(binding.Aux), Toggle: toggle})
+	}
+
+	return result, nil
+}
+
+// converts button names (see xboxButtonNames) or numbers into a button mask.
+func buttonMask(buttons []string) (uint32, error) {
+	var mask uint32
+
+	if 0 == len(buttons) {
+		return 0, fmt.Errorf("binding has no buttons")
+	}
+
+	for _, button := range buttons {
+		if bit, ok := xboxButtonNames[strings.ToLower(button)]; ok {
+			mask |= bit
+			continue
+		}
+
+		index, err := strconv.ParseUint(button, 10, 5)
+		if err != nil {
+			return 0, fmt.Errorf("unknown button %s", button)
+		}
+
+		mask |= 1 << index
+	}
+
+	return mask, nil
+}
+
+// tracks the chord in state and returns the aux command to send, if any.
+// while the chord is held its buttons are removed from state, so they don't
+// also trigger the pan-tilt-zoom mapping.
+func (b *AuxBinding) Update(state *joystick.State) (uint8, bool) {
+	pressed := isChordPressed(*state, b.Mask)
+	changed := pressed != b.pressed
+	b.pressed = pressed
+
+	if pressed {
+		state.Buttons &^= b.Mask
+	}
+
+	if !changed {
+		return 0, false
+	}
+
+	if b.Toggle {
+		if !pressed {
+			return 0, false
+		}
+		b.on = !b.on
+	} else {
+		b.on = pressed
+	}
+
+	if b.on {
+		return pelco.SetAux, true
+	}
+
+	return pelco.ClearAux, true
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "aux",
+		Summary: "switch an aux output, e.g. a wiper, washer, or lights",
+		Usage:   []string{"AUX (on | off) [--duration DURATION]"},
+		Options: []string{"--duration DURATION      - switch back after DURATION, e.g. 3s for a wiper pass. (default = stay switched)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			aux, err := strconv.ParseUint(arguments["AUX"].(string), 10, 8)
+			if err != nil || 0 == aux {
+				printError("invalid aux %s. expected 1-255\n", arguments["AUX"])
+				os.Exit(1)
+			}
+			switchAux(conf, uint8(aux), arguments["on"].(bool), durationArg(arguments, "--duration", 0))
+		},
+	})
+}
+
+// switches aux output aux of the camera at conf.Address from the command
+// line. with a duration it's switched back afterwards, and an interrupt
+// switches it back early.
+func switchAux(conf config.Config, aux uint8, on bool, duration time.Duration) {
+	if duration < 0 {
+		printError("--duration can't be negative\n")
+		os.Exit(1)
+	}
+
+	line, err := openSerialLine(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer line.Close()
+
+	message := func(on bool) PelcoDMessage {
+		if on {
+			return pelco.New().To(conf.Address).SetAux(aux).Build()
+		}
+		return pelco.New().To(conf.Address).ClearAux(aux).Build()
+	}
+
+	line.Send(message(on))
+	fmt.Fprintf(os.Stderr, "%s\n", describeMessage(conf, message(on)))
+
+	if 0 == duration {
+		return
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	select {
+	case <-time.After(duration):
+	case <-interrupt:
+		fmt.Fprintf(os.Stderr, "%s: interrupted\n", describeCamera(conf, conf.Address))
+	}
+
+	line.Send(message(!on))
+	fmt.Fprintf(os.Stderr, "%s\n", describeMessage(conf, message(!on)))
+}
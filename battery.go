@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// how often the controller battery is checked
+const batteryInterval = 30 * time.Second
+
+// Battery is a wireless controller's charge. Percent is -1 when the
+// controller reports only a coarse Level ("Critical", "Low", "Normal",
+// "High", or "Full").
+type Battery struct {
+	Percent  int
+	Level    string
+	Charging bool
+}
+
+// reports whether the battery is at or below lowPercent, or reports a low
+// level.
+func (b Battery) Low(lowPercent int) bool {
+	if b.Charging {
+		return false
+	}
+
+	if b.Percent >= 0 {
+		return b.Percent <= lowPercent
+	}
+
+	return "Low" == b.Level || "Critical" == b.Level
+}
+
+func (b Battery) String() string {
+	if b.Percent >= 0 {
+		return fmt.Sprintf("bat %d%%", b.Percent)
+	}
+
+	return "bat " + b.Level
+}
+
+// leaves a line in the scrollback when the controller battery runs low.
+func announceBattery(w io.Writer, battery Battery) {
+	var bell, clearLine string
+
+	if stderrTerminal {
+		bell = "\a"
+		clearLine = "\033[K"
+	}
+
+	text := fmt.Sprintf(">>> controller battery low (%s) <<<", battery)
+
+	fmt.Fprintf(w, "%s%s%s\n", bell, clearLine, stderrColor.Paint(ansiBold+ansiYellow, text))
+}
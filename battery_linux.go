@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// reads the battery of joystick number js from sysfs, where the controller's
+// hid device exposes a power_supply. returns false for wired controllers.
+func readBattery(js int) (Battery, bool) {
+	pattern := filepath.Join("/sys/class/input", "js"+strconv.Itoa(js), "device", "device", "power_supply", "*")
+
+	supplies, _ := filepath.Glob(pattern)
+	for _, supply := range supplies {
+		battery := Battery{Percent: -1}
+
+		if text, err := os.ReadFile(filepath.Join(supply, "capacity")); nil == err {
+			if percent, err := strconv.Atoi(strings.TrimSpace(string(text))); nil == err {
+				battery.Percent = percent
+			}
+		}
+
+		if text, err := os.ReadFile(filepath.Join(supply, "capacity_level")); nil == err {
+			battery.Level = strings.TrimSpace(string(text))
+		}
+
+		if text, err := os.ReadFile(filepath.Join(supply, "status")); nil == err {
+			status := strings.TrimSpace(string(text))
+			battery.Charging = "Charging" == status || "Full" == status
+		}
+
+		if battery.Percent >= 0 || ("" != battery.Level && "Unknown" != battery.Level) {
+			return battery, true
+		}
+	}
+
+	return Battery{}, false
+}
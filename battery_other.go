@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// controller batteries are only read on linux.
+func readBattery(js int) (Battery, bool) {
+	return Battery{}, false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"os"
+	"time"
+)
+
+// axes must move this far from their last reported value to be reported again
+const axisReportStep = AxisMax / 4
+
+// names of the axes of an xbox controller, by index
+var xboxAxisNames = map[int]string{
+	int(xbox.LeftAxisX.Index):    "left stick x",
+	int(xbox.LeftAxisY.Index):    "left stick y",
+	int(xbox.RightAxisX.Index):   "right stick x",
+	int(xbox.RightAxisY.Index):   "right stick y",
+	int(xbox.LeftTrigger.Index):  "left trigger",
+	int(xbox.RightTrigger.Index): "right trigger",
+	int(xbox.DPadX.Index):        "d-pad x",
+	int(xbox.DPadY.Index):        "d-pad y",
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "buttons",
+		Summary: "print the index of each button and axis as it is used",
+		Usage:   []string{"[-j JOYSTICK]"},
+		Options: []string{joystickOption},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			identifyButtons(conf)
+		},
+	})
+}
+
+// prints the index of each button and axis as the user works the controls,
+// for writing bindings for unfamiliar controllers. runs until interrupted.
+func identifyButtons(conf config.Config) {
+	js, layout, err := openJoystick(conf)
+	if err != nil {
+		printError("error opening joystick %d. %s\n", conf.JoystickNumber, err)
+		os.Exit(1)
+	}
+	defer js.Close()
+
+	fmt.Fprintf(os.Stderr, "%s (/dev/input/js%d), %d axes, %d buttons.\n", js.Name(), conf.JoystickNumber, js.AxisCount(), js.ButtonCount())
+
+	// the guid is what controller-mappings entries are written for
+	if info, ok := readControllerInfo(conf.JoystickNumber); ok {
+		if "" != layout {
+			fmt.Fprintf(os.Stderr, "GUID %s, mapped as %s.\n", info.GUID, layout)
+		} else {
+			fmt.Fprintf(os.Stderr, "GUID %s, no controller mapping.\n", info.GUID)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Press buttons and move axes to identify them. Ctrl-C to quit.\n\n")
+
+	last, err := js.Read()
+	if err != nil {
+		panic(err)
+	}
+
+	// axes are reported relative to where they rested at start
+	reported := append([]int(nil), last.AxisData...)
+
+	for range time.Tick(20 * time.Millisecond) {
+		state, err := js.Read()
+		if err != nil {
+			panic(err)
+		}
+
+		for i := uint(0); i < 32; i++ {
+			bit := uint32(1) << i
+			if state.Buttons&bit == last.Buttons&bit {
+				continue
+			}
+
+			action := "released"
+			if 0 != state.Buttons&bit {
+				action = "pressed"
+			}
+			fmt.Printf("button %2d %-8s %s\n", i, action, describeButton(bit))
+		}
+
+		for i, value := range state.AxisData {
+			if i >= len(reported) || abs(value-reported[i]) < axisReportStep {
+				continue
+			}
+
+			fmt.Printf("axis   %2d %6d   %s\n", i, value, xboxAxisNames[i])
+			reported[i] = value
+		}
+
+		last = state
+	}
+}
+
+// returns the binding name of an xbox button, or "" for unnamed buttons.
+func describeButton(bit uint32) string {
+	for name, mask := range xboxButtonNames {
+		if mask == bit {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// speed bytes measured when --speeds is not given
+var defaultCalibrationSpeeds = []int{0x08, 0x10, 0x20, 0x30, 0x3f}
+
+// parses a comma separated list of pelco speed bytes, e.g. "8,16,0x20".
+func parseSpeeds(text string) ([]int, error) {
+	var speeds []int
+
+	for _, field := range strings.Split(text, ",") {
+		speed, err := strconv.ParseUint(strings.TrimSpace(field), 0, 8)
+		if err != nil || 0 == speed || speed > pelco.FullSpeed {
+			return nil, fmt.Errorf("invalid speed %s. expected 1-%d", field, pelco.FullSpeed)
+		}
+		speeds = append(speeds, int(speed))
+	}
+
+	sort.Ints(speeds)
+
+	return speeds, nil
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "calibrate",
+		Summary: "measure a camera's pan and tilt rates against reference marks",
+		Usage:   []string{"[--speeds LIST] [--pan-angle DEG] [--tilt-angle DEG]"},
+		Options: []string{
+			"--speeds LIST            - calibrate at comma separated speeds 1-63. (default = 8,16,32,48,63)",
+			"--pan-angle DEG          - degrees between pan reference marks. (default = 360)",
+			"--tilt-angle DEG         - degrees between tilt reference marks. (default = 90)",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			speeds, panAngle, tiltAngle := calibrationArgs(arguments)
+			calibrate(conf, speeds, panAngle, tiltAngle)
+		},
+	})
+}
+
+// times pans and tilts at several speeds while the installer marks reference
+// points, and stores the measured rates in the config file.
+func calibrate(conf config.Config, speeds []int, panAngle, tiltAngle float64) {
+	tty, err := openSerial(conf)
+	if err != nil {
+		panic(err)
+	}
+	if nil != tty {
+		defer tty.Close()
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	camera := describeCamera(conf, conf.Address)
+
+	fmt.Fprintf(os.Stderr, "Calibrating %s. Press Enter at each prompt.\n", camera)
+	fmt.Fprintf(os.Stderr, "Pan runs measure %.1f degrees between marks. Tilt runs measure %.1f degrees.\n\n", panAngle, tiltAngle)
+
+	var pan, tilt []config.SpeedPoint
+
+	for _, speed := range speeds {
+		elapsed, err := timeRun(conf, tty, input, speed, 0, panAngle)
+		if err != nil {
+			printError("calibration aborted. %s\n", err)
+			return
+		}
+		pan = append(pan, config.SpeedPoint{Speed: speed, DegreesPerSecond: panAngle / elapsed.Seconds()})
+	}
+
+	// alternate tilt direction so the camera stays within its tilt range
+	direction := -1
+	for _, speed := range speeds {
+		elapsed, err := timeRun(conf, tty, input, 0, direction*speed, tiltAngle)
+		if err != nil {
+			printError("calibration aborted. %s\n", err)
+			return
+		}
+		tilt = append(tilt, config.SpeedPoint{Speed: speed, DegreesPerSecond: tiltAngle / elapsed.Seconds()})
+		direction = -direction
+	}
+
+	fmt.Fprintf(os.Stderr, "\n  speed    pan deg/s   tilt deg/s\n")
+	for i := range speeds {
+		fmt.Fprintf(os.Stderr, "  %5d  %10.2f  %11.2f\n", speeds[i], pan[i].DegreesPerSecond, tilt[i].DegreesPerSecond)
+	}
+
+	path, err := config.SaveCameraSpeeds(conf.Address, pan, tilt)
+	if err != nil {
+		printError("unable to save calibration. %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nSaved speed table for %s to %s\n", camera, path)
+}
+
+// moves the camera at the given signed speeds and returns the time between
+// the installer's start and stop marks.
+func timeRun(conf config.Config, tty Port, input *bufio.Reader, pan, tilt int, angle float64) (time.Duration, error) {
+	axis, speed := "pan", pan
+	if 0 != tilt {
+		axis, speed = "tilt", tilt
+	}
+	if speed < 0 {
+		speed = -speed
+	}
+
+	stop := pelco.New().To(conf.Address).Build()
+	move := pelco.New().To(conf.Address).Pan(pan).Tilt(tilt).Build()
+
+	fmt.Fprintf(os.Stderr, "%s at speed %d: center a reference point and press Enter to start moving.", axis, speed)
+	if err := waitEnter(input); err != nil {
+		return 0, err
+	}
+
+	encoder := newFrameEncoder(conf)
+	sendMessage(tty, encoder, move)
+	defer sendMessage(tty, encoder, stop)
+
+	fmt.Fprintf(os.Stderr, "  press Enter as the first reference mark passes center.")
+	if err := waitEnter(input); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+
+	fmt.Fprintf(os.Stderr, "  press Enter after %.1f degrees, as the second mark passes center.", angle)
+	if err := waitEnter(input); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+
+	fmt.Fprintf(os.Stderr, "  %.2f seconds, %.2f deg/s\n", elapsed.Seconds(), angle/elapsed.Seconds())
+
+	return elapsed, nil
+}
+
+func waitEnter(input *bufio.Reader) error {
+	_, err := input.ReadString('\n')
+	return err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Subcommand is a cctv-ptz command. commands register themselves from init,
+// and main picks one by the first argument, parsing the rest against the
+// command's own usage and options along with the global options.
+type Subcommand struct {
+	Name    string   // typed after cctv-ptz. "" runs when no command is given
+	Summary string   // a line for the command list
+	Usage   []string // docopt patterns following the name
+	Options []string // docopt lines of the options only this command takes
+	Run     func(conf config.Config, arguments map[string]interface{})
+}
+
+// the options every command takes, e.g. to pick the serial port and camera
+var globalOptions = []string{
+	"-a, --address ADDRESS    - Pelco-D address 0-256. (default = 0)",
+	"--camera NAME            - the camera to use, by name or address.",
+	"-s, --serial FILE        - assign serial port for rs485 output, or tcp://, rfc2217://, or udp://HOST:PORT for a network gateway. (default = /dev/sttyUSB0)",
+	"-b, --baud BAUD          - set baud rate of serial port. (default = 9600)",
+	"--rs485-rts              - raise RTS only while writing, for RS485 adapters that need it to transmit.",
+	"--protocol NAME          - speak NAME on the serial port: pelco-d, pelco-p, visca, or sensormatic. (default = pelco-d)",
+	"--onvif URL              - drive the ONVIF camera at device service URL instead of the serial port.",
+	"--sony URL               - drive the Sony SRG/BRC camera at URL through its CGI instead of the serial port.",
+	"--hanwha URL             - drive the Hanwha Wisenet camera at URL through SUNAPI instead of the serial port.",
+	"-m, --maxspeed MAXSPEED  - set max speed setting 0-100. (default = 100)",
+	"--ack                    - track camera replies and show ok/fail in the status line.",
+	"--profile NAME           - use profile NAME instead of choosing by time of day.",
+	"-v, --verbose            - prints Pelco-D commands, hex and decoded, to stdout.",
+	"-q, --quiet              - suppress the status line.",
+	"--color WHEN             - colorize output: auto, never, always. (default = auto)",
+	"-h, --help               - print this help message.",
+	"-V, --version            - print version info.",
+}
+
+// option lines shared by several commands
+const (
+	joystickOption = "-j, --joystick JOYSTICK  - use joystick NUM (e.g. /dev/input/jsNUM). (default = 0)"
+	listenOption   = "-l, --listen ADDR        - serve the HTTP API on ADDR, e.g. :8080 or [::]:8080. (default = disabled)"
+)
+
+const cliTitle = "CCTV Pan-Tilt-Zoom via Xbox Controller"
+
+var commands []*Subcommand
+
+// adds command to those cctv-ptz runs. called from init.
+func registerCommand(command *Subcommand) {
+	commands = append(commands, command)
+}
+
+func findCommand(name string) (*Subcommand, bool) {
+	for _, command := range commands {
+		if name == command.Name {
+			return command, true
+		}
+	}
+
+	return nil, false
+}
+
+// returns the commands other than the default, by name.
+func namedCommands() []*Subcommand {
+	var named []*Subcommand
+	for _, command := range commands {
+		if "" != command.Name {
+			named = append(named, command)
+		}
+	}
+
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+
+	return named
+}
+
+// returns the docopt text of the command, usage then options. the default
+// command lists the others too, since its help is the program's.
+func (c *Subcommand) Doc() string {
+	var b strings.Builder
+
+	prefix := "cctv-ptz"
+	if "" != c.Name {
+		prefix += " " + c.Name
+		fmt.Fprintf(&b, "%s - %s\n\n", prefix, c.Summary)
+	} else {
+		fmt.Fprintf(&b, "%s\n\n", cliTitle)
+	}
+
+	b.WriteString("  Usage:\n")
+	// --serial may repeat, which docopt only allows when the usage says so
+	for _, usage := range c.Usage {
+		fmt.Fprintf(&b, "  %s\n", strings.Join(strings.Fields(prefix+" "+usage+" [--serial FILE]... [options]"), " "))
+	}
+
+	if "" == c.Name {
+		b.WriteString("\n  Commands:\n")
+		for _, command := range namedCommands() {
+			fmt.Fprintf(&b, "  %-24s %s\n", command.Name, command.Summary)
+		}
+		b.WriteString("\n  cctv-ptz help COMMAND prints the usage and options of COMMAND.\n")
+	}
+
+	if 0 != len(c.Options) {
+		b.WriteString("\n  Options:\n")
+		for _, option := range c.Options {
+			fmt.Fprintf(&b, "  %s\n", option)
+		}
+	}
+
+	b.WriteString("\n  Global options:\n")
+	for _, option := range globalOptions {
+		fmt.Fprintf(&b, "  %s\n", option)
+	}
+
+	return b.String()
+}
+
+// picks the command args run: the named one, or the default when args start
+// with an option or are empty. "help" prints a command's doc and exits.
+func selectCommand(args []string) *Subcommand {
+	interactive, _ := findCommand("")
+
+	if 0 == len(args) || strings.HasPrefix(args[0], "-") {
+		return interactive
+	}
+
+	if "help" == args[0] {
+		command := interactive
+		if 1 < len(args) {
+			command = mustFindCommand(args[1])
+		}
+		fmt.Print(command.Doc())
+		os.Exit(0)
+	}
+
+	return mustFindCommand(args[0])
+}
+
+func mustFindCommand(name string) *Subcommand {
+	command, ok := findCommand(name)
+	if !ok || "" == name {
+		printError("unknown command %s. see cctv-ptz help\n", name)
+		os.Exit(1)
+	}
+
+	return command
+}
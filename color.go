@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ansi escape codes used for terminal output
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiDim    = "\033[2m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
+// Palette colors text when enabled, and passes it through unchanged otherwise.
+type Palette struct {
+	Enabled bool
+}
+
+// palettes for stdout and stderr. selected once at startup by setColorMode.
+var (
+	stdoutColor    Palette
+	stderrColor    Palette
+	stderrTerminal bool // line editing escapes are only written to terminals
+)
+
+func (p Palette) Paint(code, text string) string {
+	if !p.Enabled {
+		return text
+	}
+
+	return code + text + ansiReset
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return 0 != info.Mode()&os.ModeCharDevice
+}
+
+func isValidColorMode(mode string) bool {
+	return "auto" == mode || "never" == mode || "always" == mode
+}
+
+// chooses palettes for stdout and stderr. "auto" colors only terminals, so
+// output under systemd or in a pipeline stays plain.
+func setColorMode(mode string) {
+	stderrTerminal = isTerminal(os.Stderr)
+
+	switch mode {
+	case "always":
+		stdoutColor.Enabled = true
+		stderrColor.Enabled = true
+	case "never":
+		stdoutColor.Enabled = false
+		stderrColor.Enabled = false
+	default:
+		stdoutColor.Enabled = isTerminal(os.Stdout)
+		stderrColor.Enabled = stderrTerminal
+	}
+}
+
+// prints an error message to stderr with the program name prefix.
+func printError(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, stderrColor.Paint(ansiRed, "cctv-ptz:")+" "+format, a...)
+}
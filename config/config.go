@@ -1,22 +1,444 @@
 package config
 
 import (
+	"fmt"
 	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 )
 
 const MaxSpeed int32 = 0x2f
 
+// Camera names a Pelco-D address on the bus.
+type Camera struct {
+	Name    string
+	Address int
+
+	// serial port and baud rate, for cameras not on the default port or
+	// speed
+	Serial string
+	Baud   int
+
+	// port used while Serial, or the default port, keeps failing
+	BackupSerial string `mapstructure:"backup-serial"`
+
+	// protocol spoken by the camera, overriding the global protocol
+	Protocol string
+
+	// ONVIF device service URL, with credentials, of a camera driven over
+	// the network in place of a serial port
+	Onvif string
+
+	// base URL, with credentials, of a Sony camera driven through its CGI
+	// in place of a serial port
+	Sony string
+
+	// base URL, with credentials, of a Hanwha Wisenet camera driven through
+	// SUNAPI in place of a serial port
+	Hanwha string
+
+	// measured by `cctv-ptz calibrate`
+	PanSpeeds  []SpeedPoint `mapstructure:"pan-speeds"`
+	TiltSpeeds []SpeedPoint `mapstructure:"tilt-speeds"`
+
+	// time to zoom from wide to tele, for estimating zoom
+	ZoomTime time.Duration `mapstructure:"zoom-time"`
+
+	// fraction of pan and tilt speed kept at full telephoto, overriding
+	// the global tele-speed
+	TeleSpeed float64 `mapstructure:"tele-speed"`
+
+	Presets []Preset
+
+	// where the camera is sent when cctv-ptz starts
+	Home *Home
+
+	// actions run when cctv-ptz starts, in place of sending the camera home.
+	// see the README for the action syntax.
+	Startup []string
+
+	// actions run when cctv-ptz exits normally
+	Shutdown []string
+
+	// motion past these is refused
+	Limits *Limits
+
+	// still image fetched at each step of `cctv-ptz panorama`
+	SnapshotURL string `mapstructure:"snapshot-url"`
+
+	// pan range swept by `cctv-ptz panorama`
+	Panorama *Panorama
+
+	// more places the camera's commands go, alongside its serial port
+	Outputs []Output
+}
+
+// Output is somewhere besides its serial port that a camera's commands are
+// sent, e.g. an analog matrix on another port or an IP encoder's CGI. Type
+// is "serial" for Pelco-D on Serial at Baud, or "http" for requests to the
+// URL templates, where {pan}, {tilt}, and {zoom} are speeds from -100 to
+// 100, {preset} and {aux} numbers, and {address} the Pelco-D address.
+type Output struct {
+	Type string
+
+	Serial string
+	Baud   int
+
+	Move        string
+	Stop        string
+	CallPreset  string `mapstructure:"call-preset"`
+	SetPreset   string `mapstructure:"set-preset"`
+	ClearPreset string `mapstructure:"clear-preset"`
+	SetAux      string `mapstructure:"set-aux"`
+	ClearAux    string `mapstructure:"clear-aux"`
+}
+
+// Panorama is a sweep clockwise from PanFrom to PanTo, in degrees, stopping
+// every Step degrees for Pause before the snapshot is taken. PanFrom equal
+// to PanTo sweeps the full circle.
+type Panorama struct {
+	PanFrom float64 `mapstructure:"pan-from"`
+	PanTo   float64 `mapstructure:"pan-to"`
+	Step    float64
+	Pause   time.Duration
+}
+
+// Limits bounds a camera's motion in degrees. the pan range runs clockwise
+// from PanMin to PanMax, so it may wrap through zero. unset limits don't
+// apply.
+type Limits struct {
+	PanMin  *float64 `mapstructure:"pan-min"`
+	PanMax  *float64 `mapstructure:"pan-max"`
+	TiltMin *float64 `mapstructure:"tilt-min"`
+	TiltMax *float64 `mapstructure:"tilt-max"`
+
+	// degrees from a limit where motion toward it starts slowing down
+	Slowdown float64
+}
+
+// Home is a camera's resting position, either a Preset to call or an
+// absolute Pan and Tilt in degrees.
+type Home struct {
+	Preset int
+	Pan    *float64
+	Tilt   *float64
+}
+
+// Preset labels a Pelco-D preset number on a camera.
+type Preset struct {
+	Number int
+	Name   string
+}
+
+// SpeedPoint is the measured rate of motion for a pelco speed byte.
+type SpeedPoint struct {
+	Speed            int
+	DegreesPerSecond float64 `mapstructure:"dps"`
+}
+
+// APIToken grants a client a set of scopes (view, move, preset, admin),
+// optionally limited to the named or numbered Cameras.
+type APIToken struct {
+	Name    string
+	Token   string
+	Scopes  []string
+	Cameras []string
+}
+
+// AuxBinding switches aux output Aux while the Buttons chord is held
+// ("momentary") or on alternate presses ("toggle"). buttons are xbox button
+// names or button numbers.
+type AuxBinding struct {
+	Buttons []string
+	Aux     int
+	Mode    string
+}
+
+// Repeat is how a held button repeats: once on the press, again after
+// Delay, then every Interval. without an Interval it doesn't repeat.
+type Repeat struct {
+	Delay    time.Duration
+	Interval time.Duration
+}
+
+// PresetStep calls the next of the selected camera's presets, Step along
+// (negative steps back), when the Buttons chord is pressed, and repeats
+// while it is held after Delay every Interval.
+type PresetStep struct {
+	Buttons  []string
+	Step     int
+	Delay    time.Duration
+	Interval time.Duration
+}
+
+// ButtonAction runs Actions (see the README for the action syntax) on the
+// selected camera when the Buttons chord is pressed.
+type ButtonAction struct {
+	Buttons []string
+	Actions []string
+}
+
+// Tour is a smooth move through absolute Waypoints on one camera.
+type Tour struct {
+	Name      string
+	Camera    string // camera name or address. defaults to the current address.
+	Waypoints []Waypoint
+}
+
+// Waypoint is a position reached Duration after the previous waypoint, where
+// the camera then rests for Hold. a Preset on the first waypoint is called to
+// put the camera in a known position before the tour starts.
+type Waypoint struct {
+	Pan      float64
+	Tilt     float64
+	Duration time.Duration
+	Hold     time.Duration
+	Preset   int
+}
+
+// Profile overrides operating settings from its Start time of day ("HH:MM")
+// until the next profile's start, e.g. for day and night shifts. Presets are
+// called on the named or numbered cameras when the profile takes effect.
+type Profile struct {
+	Name     string
+	Start    string
+	MaxSpeed int     `mapstructure:"max-speed"` // percent, like --maxspeed
+	Curve    float64 // response curve exponent. 1 is linear; higher is finer near center
+	Baud     int     // default baud rate, for cameras without their own
+	Presets  map[string]int
+}
+
+// AxisCalibration is the measured raw range of a joystick axis, as written by
+// calibrate-joystick. Deadzone is the distance from Center ignored as drift.
+type AxisCalibration struct {
+	Index    int
+	Min      int
+	Max      int
+	Center   int
+	Deadzone int
+}
+
+// OSCBinding runs Actions (see the README for the action syntax) on the
+// named or numbered Camera when an OSC message arrives at Address. Camera
+// defaults to the selected camera.
+type OSCBinding struct {
+	Address string
+	Camera  string
+	Actions []string
+}
+
+// OBS connects to obs-websocket (protocol v5) at URL, e.g.
+// "ws://localhost:4455". Scenes pair OBS scenes with camera presets.
+type OBS struct {
+	URL      string
+	Password string
+	Scenes   []OBSScene
+
+	// switch the program scene to the selected camera's first scene
+	SwitchScenes bool `mapstructure:"switch-scenes"`
+}
+
+// OBSScene calls Preset on the named or numbered Camera when Scene goes to
+// program.
+type OBSScene struct {
+	Scene  string
+	Camera string
+	Preset int
+}
+
+// Tally announces the live camera: the selected one, or with MovingOnly,
+// only while it moves. state is published as json to Topic on the MQTT
+// broker, e.g. "tcp://localhost:1883", and tally Lines are lit.
+type Tally struct {
+	MQTT       string
+	Topic      string
+	ClientID   string `mapstructure:"client-id"`
+	Username   string
+	Password   string
+	MovingOnly bool `mapstructure:"moving-only"`
+	Lines      []TallyLine
+}
+
+// TallyLine lights while the named or numbered Camera is live, either by
+// writing 1 and 0 to a GPIO value file or by raising RTS on a Serial port.
+type TallyLine struct {
+	Camera string
+	GPIO   string
+	Serial string
+}
+
 type Config struct {
 	Address        int
 	BaudRate       int
 	JoystickNumber int
 	MaxSpeed       int32
+	Curve          float64
 	SerialPort     string
+	Protocol       string // how frames are written on the serial port: pelco-d, pelco-p, visca, or sensormatic
+	Onvif          string // ONVIF device service driven in place of the serial port
+	Sony           string // Sony CGI driven in place of the serial port
+	Hanwha         string // Hanwha SUNAPI driven in place of the serial port
 	RecordFile     string
+	RecordRotate   string
+	RecordMaxSize  string
+	RecordKeep     int
+	StateFile      string
+	RecordingsDir  string
+	ThumbnailsDir  string
 	Verbose        bool
+	Quiet          bool
+	Color          string
+	Ack            bool
+	PollPosition   bool
+	Listen         string
+	Cameras        []Camera
+	Aux            []AuxBinding
+	Tours          []Tour
+	Profiles       []Profile
+	Profile        string // forces a profile instead of choosing by time
+	JoystickAxes   []AxisCalibration
+	OBS            OBS
+	Tally          Tally
+
+	// how long the joystick keeps control after returning to neutral
+	JoystickHoldoff time.Duration
+
+	// SDL gamecontrollerdb.txt to look joysticks up in, and mappings in its
+	// format that take precedence over it
+	ControllerDB       string
+	ControllerMappings []string
+
+	// wireless controller charge, in percent, that warns of a low battery
+	BatteryLow int
+
+	// left stick pans and tilts while the right stick adds up to
+	// FineAdjustSpeed (fraction of max speed) of trim.
+	FineAdjust      bool
+	FineAdjustSpeed float64
+
+	// pan with the right stick and tilt with the left
+	SwapAxes bool
+
+	// fraction of pan and tilt speed kept at full telephoto, scaled
+	// linearly from full speed at wide. 1 turns scaling off.
+	TeleSpeed float64
+
+	// a chord that steps the max speed through SpeedSteps, in percent,
+	// repeating while held as SpeedRepeat says
+	SpeedButtons []string
+	SpeedSteps   []int
+	SpeedRepeat  Repeat
+
+	// how the address buttons repeat while held
+	AddressRepeat Repeat
+
+	// chords stepping the selected camera through its presets
+	PresetSteps []PresetStep
+
+	// chords running actions on the selected camera
+	ButtonActions []ButtonAction
+
+	// the chord that continues a playback --confirm waiting at a mark
+	ConfirmButtons []string
+
+	// udp address to receive tracker targets on, and the controller tuning
+	Follow         string
+	FollowGain     float64
+	FollowDeadband float64
+
+	// udp address to receive OSC from control surfaces on, and messages
+	// mapped to actions
+	OSC         string
+	OSCBindings []OSCBinding
+
+	// tcp address to accept plain text commands from room controllers on
+	Control string
+
+	// advertise the api over mDNS/DNS-SD, as MDNSName
+	MDNS     bool
+	MDNSName string
+
+	// serial port a legacy Pelco keyboard is wired to, and its baud rate
+	// when it differs from BaudRate
+	Keyboard     string
+	KeyboardBaud int
+
+	// api transport security. a self-signed pair is generated at the given
+	// paths when TLSSelfSigned is set and the files don't exist yet.
+	TLSCert       string
+	TLSKey        string
+	TLSSelfSigned bool
+
+	// port switched to after FailoverErrors failed writes in a row on the
+	// serial port, and the wait before trying the serial port again
+	BackupSerial   string
+	FailoverErrors int
+	FailbackAfter  time.Duration
+
+	// more ports every frame is copied to, whichever camera it's for, e.g.
+	// a tcp:// sink to watch the bus. a --serial given more than once adds
+	// its later ports here.
+	Tee []string
+
+	// raise RTS only while writing, for RS485 adapters whose line driver it
+	// enables, waiting RS485RTSBefore after raising it and RS485RTSAfter once
+	// the frame is out before dropping it again
+	RS485RTS       bool
+	RS485RTSBefore time.Duration
+	RS485RTSAfter  time.Duration
+
+	// api credentials. either a bearer token or a basic auth user/password,
+	// both with full access, or any number of scoped tokens.
+	APIToken    string
+	APIUser     string
+	APIPassword string
+	APITokens   []APIToken
+
+	// commands a second each network client may send, in bursts of up to
+	// RateBurst. 0 doesn't limit clients. commands waiting past CommandQueue
+	// are refused.
+	RateLimit    float64
+	RateBurst    int
+	CommandQueue int
+}
+
+// the port a lone USB RS485 adapter usually gets
+func defaultSerialPort() string {
+	if "windows" == runtime.GOOS {
+		return "COM1"
+	}
+
+	return "/dev/ttyUSB0"
 }
 
-var defaultConfig = Config{0, 9600, 0, MaxSpeed, "/dev/ttyUSB0", "/dev/null", false}
+var defaultConfig = Config{
+	Address:         0,
+	BaudRate:        9600,
+	JoystickNumber:  0,
+	MaxSpeed:        MaxSpeed,
+	Curve:           1.0,
+	SerialPort:      defaultSerialPort(),
+	Protocol:        "pelco-d",
+	RecordFile:      "/dev/null",
+	Color:           "auto",
+	JoystickHoldoff: 2 * time.Second,
+	BatteryLow:      20,
+	FineAdjustSpeed: 0.2,
+	TeleSpeed:       1.0,
+	SpeedSteps:      []int{25, 50, 75, 100},
+	AddressRepeat:   Repeat{Delay: 125 * time.Millisecond, Interval: 125 * time.Millisecond},
+	ConfirmButtons:  []string{"a"},
+	FollowGain:      1.0,
+	FollowDeadband:  0.05,
+	FailoverErrors:  3,
+	FailbackAfter:   30 * time.Second,
+	RateLimit:       20,
+	RateBurst:       40,
+	CommandQueue:    32,
+}
 
 func GetDefault() Config {
 	return defaultConfig
@@ -31,36 +453,477 @@ func Load(args map[string]interface{}) Config {
 	viper.ReadInConfig()
 
 	viper.SetEnvPrefix("cctv")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
 	viper.SetDefault("address", defaultConfig.Address)
 	viper.SetDefault("baud", defaultConfig.BaudRate)
 	viper.SetDefault("joystick", defaultConfig.JoystickNumber)
 	viper.SetDefault("max-speed", defaultConfig.MaxSpeed)
+	viper.SetDefault("curve", defaultConfig.Curve)
+	viper.SetDefault("profile", defaultConfig.Profile)
 	viper.SetDefault("serial", defaultConfig.SerialPort)
+	viper.SetDefault("protocol", defaultConfig.Protocol)
+	viper.SetDefault("onvif", defaultConfig.Onvif)
+	viper.SetDefault("sony", defaultConfig.Sony)
+	viper.SetDefault("hanwha", defaultConfig.Hanwha)
+	viper.SetDefault("backup-serial", defaultConfig.BackupSerial)
+	viper.SetDefault("tee", defaultConfig.Tee)
+	viper.SetDefault("rs485-rts", defaultConfig.RS485RTS)
+	viper.SetDefault("rs485-rts-before", defaultConfig.RS485RTSBefore)
+	viper.SetDefault("rs485-rts-after", defaultConfig.RS485RTSAfter)
+	viper.SetDefault("failover-errors", defaultConfig.FailoverErrors)
+	viper.SetDefault("failback-after", defaultConfig.FailbackAfter)
 	viper.SetDefault("record", defaultConfig.RecordFile)
+	viper.SetDefault("record-rotate", defaultConfig.RecordRotate)
+	viper.SetDefault("record-max-size", defaultConfig.RecordMaxSize)
+	viper.SetDefault("record-keep", defaultConfig.RecordKeep)
+	viper.SetDefault("state-file", defaultConfig.StateFile)
+	viper.SetDefault("recordings-dir", defaultConfig.RecordingsDir)
+	viper.SetDefault("thumbnails-dir", defaultConfig.ThumbnailsDir)
 	viper.SetDefault("verbose", defaultConfig.Verbose)
+	viper.SetDefault("quiet", defaultConfig.Quiet)
+	viper.SetDefault("color", defaultConfig.Color)
+	viper.SetDefault("ack", defaultConfig.Ack)
+	viper.SetDefault("poll-position", defaultConfig.PollPosition)
+	viper.SetDefault("listen", defaultConfig.Listen)
+	viper.SetDefault("joystick-holdoff", defaultConfig.JoystickHoldoff)
+	viper.SetDefault("battery-low", defaultConfig.BatteryLow)
+	viper.SetDefault("controller-db", defaultConfig.ControllerDB)
+	viper.SetDefault("controller-mappings", defaultConfig.ControllerMappings)
+	viper.SetDefault("fine-adjust", defaultConfig.FineAdjust)
+	viper.SetDefault("fine-adjust-speed", defaultConfig.FineAdjustSpeed)
+	viper.SetDefault("swap-axes", defaultConfig.SwapAxes)
+	viper.SetDefault("tele-speed", defaultConfig.TeleSpeed)
+	viper.SetDefault("speed-buttons", defaultConfig.SpeedButtons)
+	viper.SetDefault("speed-steps", defaultConfig.SpeedSteps)
+	viper.SetDefault("speed-repeat-delay", defaultConfig.SpeedRepeat.Delay)
+	viper.SetDefault("speed-repeat-interval", defaultConfig.SpeedRepeat.Interval)
+	viper.SetDefault("address-repeat-delay", defaultConfig.AddressRepeat.Delay)
+	viper.SetDefault("address-repeat-interval", defaultConfig.AddressRepeat.Interval)
+	viper.SetDefault("confirm-buttons", defaultConfig.ConfirmButtons)
+	viper.SetDefault("follow", defaultConfig.Follow)
+	viper.SetDefault("follow-gain", defaultConfig.FollowGain)
+	viper.SetDefault("follow-deadband", defaultConfig.FollowDeadband)
+	viper.SetDefault("osc", defaultConfig.OSC)
+	viper.SetDefault("control", defaultConfig.Control)
+	viper.SetDefault("mdns", defaultConfig.MDNS)
+	viper.SetDefault("mdns-name", defaultConfig.MDNSName)
+	viper.SetDefault("keyboard", defaultConfig.Keyboard)
+	viper.SetDefault("keyboard-baud", defaultConfig.KeyboardBaud)
+	viper.SetDefault("tls-cert", defaultConfig.TLSCert)
+	viper.SetDefault("tls-key", defaultConfig.TLSKey)
+	viper.SetDefault("tls-self-signed", defaultConfig.TLSSelfSigned)
+	viper.SetDefault("api-token", defaultConfig.APIToken)
+	viper.SetDefault("api-user", defaultConfig.APIUser)
+	viper.SetDefault("api-password", defaultConfig.APIPassword)
+	viper.SetDefault("rate-limit", defaultConfig.RateLimit)
+	viper.SetDefault("rate-burst", defaultConfig.RateBurst)
+	viper.SetDefault("command-queue", defaultConfig.CommandQueue)
+
+	// lists and sections from the environment, before the command line
+	// overrides them
+	applyEnvironment(os.Environ())
 
 	setArg("address", args["--address"])
 	setArg("baud", args["--baud"])
 	setArg("joystick", args["--joystick"])
 	setArg("max-speed", args["--maxspeed"])
-	setArg("serial", args["--serial"])
+	setArg("profile", args["--profile"])
+	// the first --serial is the port, and any others are teed
+	if serials, ok := args["--serial"].([]string); ok {
+		if 0 != len(serials) {
+			viper.Set("serial", serials[0])
+		}
+		if 1 < len(serials) {
+			viper.Set("tee", serials[1:])
+		}
+	} else {
+		setArg("serial", args["--serial"])
+	}
+	setArg("protocol", args["--protocol"])
+	setArg("onvif", args["--onvif"])
+	setArg("sony", args["--sony"])
+	setArg("hanwha", args["--hanwha"])
 	setArg("record", args["--record"])
 	setArg("verbose", args["--verbose"])
+	setArg("quiet", args["--quiet"])
+	setArg("color", args["--color"])
+	setArg("ack", args["--ack"])
+	setArg("rs485-rts", args["--rs485-rts"])
+	setArg("listen", args["--listen"])
+	setArg("fine-adjust", args["--fine"])
+	setArg("swap-axes", args["--swap"])
+	setArg("follow", args["--follow"])
+	setArg("osc", args["--osc"])
+	setArg("control", args["--control"])
+	setArg("keyboard", args["--keyboard"])
+	setArg("tls-cert", args["--tls-cert"])
+	setArg("tls-key", args["--tls-key"])
 
 	config := Config{}
 	config.Address = viper.GetInt("address")
 	config.BaudRate = viper.GetInt("baud")
 	config.JoystickNumber = viper.GetInt("joystick")
 	config.MaxSpeed = int32(viper.GetInt("max-speed")) * MaxSpeed / 100
+	config.Curve = viper.GetFloat64("curve")
+	config.Profile = viper.GetString("profile")
 	config.SerialPort = viper.GetString("serial")
+	config.Protocol = viper.GetString("protocol")
+	config.Onvif = viper.GetString("onvif")
+	config.Sony = viper.GetString("sony")
+	config.Hanwha = viper.GetString("hanwha")
+	config.BackupSerial = viper.GetString("backup-serial")
+	config.Tee = viper.GetStringSlice("tee")
+	config.RS485RTS = viper.GetBool("rs485-rts")
+	config.RS485RTSBefore = viper.GetDuration("rs485-rts-before")
+	config.RS485RTSAfter = viper.GetDuration("rs485-rts-after")
+	config.FailoverErrors = viper.GetInt("failover-errors")
+	config.FailbackAfter = viper.GetDuration("failback-after")
 	config.RecordFile = viper.GetString("record")
+	config.RecordRotate = viper.GetString("record-rotate")
+	config.RecordMaxSize = viper.GetString("record-max-size")
+	config.RecordKeep = viper.GetInt("record-keep")
+	config.StateFile = viper.GetString("state-file")
+	config.RecordingsDir = viper.GetString("recordings-dir")
+	config.ThumbnailsDir = viper.GetString("thumbnails-dir")
 	config.Verbose = viper.GetBool("verbose")
+	config.Quiet = viper.GetBool("quiet")
+	config.Color = viper.GetString("color")
+	config.Ack = viper.GetBool("ack")
+	config.PollPosition = viper.GetBool("poll-position")
+	config.Listen = viper.GetString("listen")
+	config.JoystickHoldoff = viper.GetDuration("joystick-holdoff")
+	config.BatteryLow = viper.GetInt("battery-low")
+	config.ControllerDB = viper.GetString("controller-db")
+	config.ControllerMappings = viper.GetStringSlice("controller-mappings")
+	config.FineAdjust = viper.GetBool("fine-adjust")
+	config.FineAdjustSpeed = viper.GetFloat64("fine-adjust-speed")
+	config.SwapAxes = viper.GetBool("swap-axes")
+	config.TeleSpeed = viper.GetFloat64("tele-speed")
+	config.SpeedButtons = viper.GetStringSlice("speed-buttons")
+	config.SpeedSteps = viper.GetIntSlice("speed-steps")
+	config.SpeedRepeat = Repeat{viper.GetDuration("speed-repeat-delay"), viper.GetDuration("speed-repeat-interval")}
+	config.AddressRepeat = Repeat{viper.GetDuration("address-repeat-delay"), viper.GetDuration("address-repeat-interval")}
+	config.ConfirmButtons = viper.GetStringSlice("confirm-buttons")
+	config.Follow = viper.GetString("follow")
+	config.FollowGain = viper.GetFloat64("follow-gain")
+	config.FollowDeadband = viper.GetFloat64("follow-deadband")
+	config.OSC = viper.GetString("osc")
+	config.Control = viper.GetString("control")
+	config.MDNS = viper.GetBool("mdns")
+	config.MDNSName = viper.GetString("mdns-name")
+	config.Keyboard = viper.GetString("keyboard")
+	config.KeyboardBaud = viper.GetInt("keyboard-baud")
+	config.TLSCert = viper.GetString("tls-cert")
+	config.TLSKey = viper.GetString("tls-key")
+	config.TLSSelfSigned = viper.GetBool("tls-self-signed")
+	config.APIToken = viper.GetString("api-token")
+	config.APIUser = viper.GetString("api-user")
+	config.APIPassword = viper.GetString("api-password")
+	config.RateLimit = viper.GetFloat64("rate-limit")
+	config.RateBurst = viper.GetInt("rate-burst")
+	config.CommandQueue = viper.GetInt("command-queue")
+
+	viper.UnmarshalKey("cameras", &config.Cameras)
+	viper.UnmarshalKey("aux", &config.Aux)
+	viper.UnmarshalKey("preset-steps", &config.PresetSteps)
+	viper.UnmarshalKey("button-actions", &config.ButtonActions)
+	viper.UnmarshalKey("tours", &config.Tours)
+	viper.UnmarshalKey("profiles", &config.Profiles)
+	viper.UnmarshalKey("joystick-axes", &config.JoystickAxes)
+	viper.UnmarshalKey("api-tokens", &config.APITokens)
+	viper.UnmarshalKey("obs", &config.OBS)
+	viper.UnmarshalKey("tally", &config.Tally)
+	viper.UnmarshalKey("osc-bindings", &config.OSCBindings)
 
 	return config
 }
 
+// returns the camera at address, or false if none is configured.
+func (c Config) Camera(address int) (Camera, bool) {
+	for _, camera := range c.Cameras {
+		if camera.Address == address {
+			return camera, true
+		}
+	}
+
+	return Camera{}, false
+}
+
+// returns the tour named name, or false if none is configured.
+func (c Config) Tour(name string) (Tour, bool) {
+	for _, tour := range c.Tours {
+		if tour.Name == name {
+			return tour, true
+		}
+	}
+
+	return Tour{}, false
+}
+
+// returns the profile named name, or false if none is configured.
+func (c Config) FindProfile(name string) (Profile, bool) {
+	for _, profile := range c.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// returns the profile in effect at now: the forced Profile if set, otherwise
+// the one with the latest start at or before the time of day, wrapping to the
+// latest start of all before the earliest. false if there are no profiles.
+func (c Config) ActiveProfile(now time.Time) (Profile, bool) {
+	if "" != c.Profile {
+		return c.FindProfile(c.Profile)
+	}
+
+	var (
+		active, latest Profile
+		activeAt       = -1
+		latestAt       = -1
+		minute         = now.Hour()*60 + now.Minute()
+	)
+
+	for _, profile := range c.Profiles {
+		start, err := time.Parse("15:04", profile.Start)
+		if err != nil {
+			continue
+		}
+
+		at := start.Hour()*60 + start.Minute()
+		if at <= minute && at > activeAt {
+			active, activeAt = profile, at
+		}
+		if at > latestAt {
+			latest, latestAt = profile, at
+		}
+	}
+
+	if activeAt >= 0 {
+		return active, true
+	}
+
+	return latest, latestAt >= 0
+}
+
+// returns c with the settings the profile overrides.
+func (c Config) WithProfile(profile Profile) Config {
+	if profile.MaxSpeed > 0 {
+		c.MaxSpeed = int32(profile.MaxSpeed) * MaxSpeed / 100
+	}
+
+	if profile.Curve > 0 {
+		c.Curve = profile.Curve
+	}
+
+	if profile.Baud > 0 {
+		c.BaudRate = profile.Baud
+	}
+
+	return c
+}
+
+// returns c with the serial port, baud rate, and tele speed of the camera at
+// address, where it has its own.
+func (c Config) ForCamera(address int) Config {
+	if camera, ok := c.Camera(address); ok {
+		if "" != camera.Serial {
+			c.SerialPort = camera.Serial
+			c.BackupSerial = camera.BackupSerial
+			c.Onvif, c.Sony, c.Hanwha = camera.Onvif, camera.Sony, camera.Hanwha
+		} else if "" != camera.BackupSerial {
+			c.BackupSerial = camera.BackupSerial
+		}
+		// a camera on the network replaces the default one wholesale
+		switch {
+		case "" != camera.Onvif:
+			c.Onvif, c.Sony, c.Hanwha = camera.Onvif, "", ""
+		case "" != camera.Sony:
+			c.Onvif, c.Sony, c.Hanwha = "", camera.Sony, ""
+		case "" != camera.Hanwha:
+			c.Onvif, c.Sony, c.Hanwha = "", "", camera.Hanwha
+		}
+		if camera.Baud > 0 {
+			c.BaudRate = camera.Baud
+		}
+		if "" != camera.Protocol {
+			c.Protocol = camera.Protocol
+		}
+		if camera.TeleSpeed > 0 {
+			c.TeleSpeed = camera.TeleSpeed
+		}
+	}
+
+	return c
+}
+
+// returns the address of the camera named name, or -1 if none is configured.
+func (c Config) CameraAddress(name string) int {
+	for _, camera := range c.Cameras {
+		if camera.Name == name {
+			return camera.Address
+		}
+	}
+
+	return -1
+}
+
+// returns the name of the camera at address, or "" if none is configured.
+func (c Config) CameraName(address int) string {
+	for _, camera := range c.Cameras {
+		if camera.Address == address {
+			return camera.Name
+		}
+	}
+
+	return ""
+}
+
+// stores a camera's speed tables in the config file, leaving the rest of the
+// file untouched. creates $HOME/.config/cctv-ptz/cctz-ptz.yaml if no config
+// file was found.
+func SaveCameraSpeeds(address int, pan, tilt []SpeedPoint) (string, error) {
+	file, path, err := openConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	var cameras []map[string]interface{}
+	file.UnmarshalKey("cameras", &cameras)
+
+	found := false
+	for _, camera := range cameras {
+		if fmt.Sprint(camera["address"]) == fmt.Sprint(address) {
+			camera["pan-speeds"] = speedList(pan)
+			camera["tilt-speeds"] = speedList(tilt)
+			found = true
+		}
+	}
+
+	if !found {
+		cameras = append(cameras, map[string]interface{}{
+			"name":        fmt.Sprintf("camera-%d", address),
+			"address":     address,
+			"pan-speeds":  speedList(pan),
+			"tilt-speeds": speedList(tilt),
+		})
+	}
+
+	file.Set("cameras", cameras)
+
+	return path, file.WriteConfigAs(path)
+}
+
+// names preset number of the camera at address in the config file, or
+// removes its name when name is "". like SaveCameraSpeeds, the rest of the
+// file is left untouched. returns the path written.
+func SavePresetName(address, number int, name string) (string, error) {
+	file, path, err := openConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	var cameras []map[string]interface{}
+	file.UnmarshalKey("cameras", &cameras)
+
+	found := false
+	for _, camera := range cameras {
+		if fmt.Sprint(camera["address"]) != fmt.Sprint(address) {
+			continue
+		}
+		found = true
+
+		var presets []map[string]interface{}
+		if list, ok := camera["presets"].([]interface{}); ok {
+			for _, item := range list {
+				if preset, ok := item.(map[string]interface{}); ok && fmt.Sprint(preset["number"]) != fmt.Sprint(number) {
+					presets = append(presets, preset)
+				}
+			}
+		}
+		if "" != name {
+			presets = append(presets, map[string]interface{}{"number": number, "name": name})
+		}
+		camera["presets"] = presets
+	}
+
+	if !found {
+		if "" == name {
+			return path, nil
+		}
+		cameras = append(cameras, map[string]interface{}{
+			"name":    fmt.Sprintf("camera-%d", address),
+			"address": address,
+			"presets": []map[string]interface{}{{"number": number, "name": name}},
+		})
+	}
+
+	file.Set("cameras", cameras)
+
+	return path, file.WriteConfigAs(path)
+}
+
+// stores measured joystick axis ranges in the config file, replacing any
+// previous calibration. returns the path written.
+func SaveJoystickAxes(axes []AxisCalibration) (string, error) {
+	file, path, err := openConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	var list []map[string]interface{}
+	for _, axis := range axes {
+		list = append(list, map[string]interface{}{
+			"index":    axis.Index,
+			"min":      axis.Min,
+			"max":      axis.Max,
+			"center":   axis.Center,
+			"deadzone": axis.Deadzone,
+		})
+	}
+
+	file.Set("joystick-axes", list)
+
+	return path, file.WriteConfigAs(path)
+}
+
+// reads the config file in use, or an empty one at the default path, for
+// rewriting with calibration results.
+func openConfigFile() (*viper.Viper, string, error) {
+	path := viper.ConfigFileUsed()
+	if "" == path {
+		dir := filepath.Join(os.Getenv("HOME"), ".config", "cctv-ptz")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, "", err
+		}
+		path = filepath.Join(dir, "cctz-ptz.yaml")
+	}
+
+	file := viper.New()
+	file.SetConfigFile(path)
+	if _, err := os.Stat(path); nil == err {
+		if err := file.ReadInConfig(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return file, path, nil
+}
+
+func speedList(points []SpeedPoint) []map[string]interface{} {
+	var list []map[string]interface{}
+
+	for _, point := range points {
+		list = append(list, map[string]interface{}{"speed": point.Speed, "dps": point.DegreesPerSecond})
+	}
+
+	return list
+}
+
 func setArg(key string, arg interface{}) {
 	if nil != arg {
 		viper.Set(key, arg)
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// environment variables are the key in capitals, with dashes as underscores,
+// after this prefix, e.g. CCTV_MAX_SPEED for max-speed
+const envPrefix = "CCTV_"
+
+// keys holding lists and sections, and the types they are read into. their
+// environment variables name a path into them.
+var envNestedKeys = map[string]interface{}{
+	"cameras":             []Camera{},
+	"aux":                 []AuxBinding{},
+	"tours":               []Tour{},
+	"profiles":            []Profile{},
+	"joystick-axes":       []AxisCalibration{},
+	"api-tokens":          []APIToken{},
+	"obs":                 OBS{},
+	"tally":               Tally{},
+	"osc-bindings":        []OSCBinding{},
+	"speed-buttons":       []string{},
+	"speed-steps":         []int{},
+	"controller-mappings": []string{},
+	"button-actions":      []ButtonAction{},
+	"preset-steps":        []PresetStep{},
+	"confirm-buttons":     []string{},
+	"tee":                 []string{},
+}
+
+// sets the nested keys from CCTV_ environment variables, over the config
+// file. a variable naming the key itself holds its whole value as JSON, e.g.
+// CCTV_CAMERAS='[{"name":"gate","address":1}]'. otherwise the rest of the
+// name is a path of list indexes and field names, e.g. CCTV_CAMERAS_0_NAME or
+// CCTV_CAMERAS_0_PAN_SPEEDS_1_DPS, each setting one value and leaving the
+// rest of the key as configured. plain keys are left to viper.
+func applyEnvironment(environ []string) {
+	// longer names first, so CCTV_OSC_BINDINGS isn't taken for osc
+	keys := make([]string, 0, len(envNestedKeys))
+	for key := range envNestedKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	// sorted so CCTV_CAMERAS comes before CCTV_CAMERAS_0_NAME patches it
+	variables := append([]string(nil), environ...)
+	sort.Strings(variables)
+
+	for _, variable := range variables {
+		parts := strings.SplitN(variable, "=", 2)
+		if 2 != len(parts) || !strings.HasPrefix(parts[0], envPrefix) {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(parts[0], envPrefix))
+
+		for _, key := range keys {
+			flat := strings.Replace(key, "-", "_", -1)
+			if name != flat && !strings.HasPrefix(name, flat+"_") {
+				continue
+			}
+
+			var path []string
+			if name != flat {
+				path = strings.Split(strings.TrimPrefix(name, flat+"_"), "_")
+			}
+
+			value, ok := patchValue(viper.Get(key), reflect.TypeOf(envNestedKeys[key]), path, parts[1])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "ignoring %s. not a valid %s setting\n", parts[0], key)
+				break
+			}
+
+			viper.Set(key, value)
+			break
+		}
+	}
+}
+
+// returns value with the part named by path, within a value of type t, set
+// from text. false when path doesn't name anything in t.
+func patchValue(value interface{}, t reflect.Type, path []string, text string) (interface{}, bool) {
+	for reflect.Ptr == t.Kind() {
+		t = t.Elem()
+	}
+
+	if 0 == len(path) {
+		return parseEnvValue(t, text)
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 {
+			return nil, false
+		}
+
+		list := envList(value)
+		for len(list) <= index {
+			list = append(list, nil)
+		}
+
+		element, ok := patchValue(list[index], t.Elem(), path[1:], text)
+		if !ok {
+			return nil, false
+		}
+		list[index] = element
+
+		return list, true
+
+	case reflect.Map:
+		// the rest of the name is the map key, e.g. a camera in a profile's
+		// presets
+		fields := envMap(value)
+		fields[strings.Join(path, "_")] = text
+
+		return fields, true
+
+	case reflect.Struct:
+		fields := envMap(value)
+
+		// a field may take several parts of the name, e.g. pan_speeds
+		for n := len(path); n > 0; n-- {
+			field, ok := envField(t, strings.Join(path[:n], "-"))
+			if !ok {
+				continue
+			}
+
+			element, ok := patchValue(fields[field.key], field.t, path[n:], text)
+			if !ok {
+				return nil, false
+			}
+			fields[field.key] = element
+
+			return fields, true
+		}
+	}
+
+	return nil, false
+}
+
+type envStructField struct {
+	key string
+	t   reflect.Type
+}
+
+// finds the field of struct type t read from key, as mapstructure does:
+// by its tag, or else by its name in any case.
+func envField(t reflect.Type, key string) (envStructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := strings.ToLower(field.Name)
+		if tag := field.Tag.Get("mapstructure"); "" != tag {
+			name = strings.Split(tag, ",")[0]
+		}
+
+		if name == key {
+			return envStructField{name, field.Type}, true
+		}
+	}
+
+	return envStructField{}, false
+}
+
+// parses text as a whole value of type t. lists and sections are JSON; a
+// list of plain values may also be comma separated. plain values are left
+// as text for viper to convert.
+func parseEnvValue(t reflect.Type, text string) (interface{}, bool) {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		var value map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return nil, false
+		}
+		return value, true
+
+	case reflect.Slice:
+		var value []interface{}
+		if strings.HasPrefix(strings.TrimSpace(text), "[") {
+			if err := json.Unmarshal([]byte(text), &value); err != nil {
+				return nil, false
+			}
+			return value, true
+		}
+
+		if k := t.Elem().Kind(); reflect.Struct == k || reflect.Slice == k || reflect.Map == k || reflect.Ptr == k {
+			return nil, false
+		}
+
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); "" != item {
+				value = append(value, item)
+			}
+		}
+		return value, true
+	}
+
+	return text, true
+}
+
+// copies a configured list, so patching it doesn't change viper's copy.
+func envList(value interface{}) []interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || reflect.Slice != v.Kind() {
+		return nil
+	}
+
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+
+	return list
+}
+
+// copies a configured section, with its keys in lower case as viper keeps
+// them.
+func envMap(value interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || reflect.Map != v.Kind() {
+		return fields
+	}
+
+	for _, key := range v.MapKeys() {
+		fields[strings.ToLower(fmt.Sprint(key.Interface()))] = v.MapIndex(key).Interface()
+	}
+
+	return fields
+}
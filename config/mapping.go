@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"time"
+)
+
+// version of the mapping file layout. importers refuse newer files.
+const mappingFormat = 1
+
+// settings that make the controller feel the way an operator tuned it. the
+// lists are replaced wholesale on import, so a station without them in the
+// bundle goes back to the built-in ranges and no aux bindings. cameras, the
+// bus, and the api stay with the station.
+var (
+	mappingSettings = []string{"curve", "max-speed", "fine-adjust", "fine-adjust-speed", "swap-axes", "joystick-holdoff", "speed-steps", "tele-speed"}
+	mappingLists    = []string{"joystick-axes", "aux", "speed-buttons", "controller-mappings"}
+)
+
+// writes the mapping settings in effect to path, in the format named by its
+// extension, or yaml without one.
+func ExportMapping(path string) error {
+	bundle := viper.New()
+	bundle.SetConfigType("yaml")
+	bundle.Set("mapping-format", mappingFormat)
+
+	for _, key := range mappingSettings {
+		value := viper.Get(key)
+
+		// durations are written the way they're usually typed
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+
+		bundle.Set(key, value)
+	}
+
+	for _, key := range mappingLists {
+		if viper.InConfig(key) {
+			bundle.Set(key, viper.Get(key))
+		}
+	}
+
+	return bundle.WriteConfigAs(path)
+}
+
+// replaces the mapping settings in the config file in use with those from
+// the file at path. returns the config file written.
+func ImportMapping(path string) (string, error) {
+	bundle := viper.New()
+	bundle.SetConfigFile(path)
+	if "" == filepath.Ext(path) {
+		bundle.SetConfigType("yaml")
+	}
+
+	if err := bundle.ReadInConfig(); err != nil {
+		return "", err
+	}
+
+	switch format := bundle.GetInt("mapping-format"); {
+	case 0 == format:
+		return "", fmt.Errorf("%s is not a mapping file", path)
+	case format > mappingFormat:
+		return "", fmt.Errorf("%s is mapping format %d. this version reads up to %d", path, format, mappingFormat)
+	}
+
+	file, configPath, err := openConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range mappingSettings {
+		if bundle.InConfig(key) {
+			file.Set(key, bundle.Get(key))
+		}
+	}
+
+	for _, key := range mappingLists {
+		if bundle.InConfig(key) {
+			file.Set(key, bundle.Get(key))
+		} else {
+			file.Set(key, []interface{}{})
+		}
+	}
+
+	return configPath, file.WriteConfigAs(configPath)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/simulatedsimian/joystick"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// markGate holds a guided playback at each mark until the operator
+// confirms it, with enter on the terminal or the confirm buttons of the
+// joystick. stdin carries the recording, so the terminal is opened itself.
+type markGate struct {
+	confirmed chan struct{}
+	prompt    string
+	tty       *os.File
+	js        joystick.Joystick
+}
+
+// the terminal of the process, whatever stdin is
+func terminalDevice() string {
+	if "windows" == runtime.GOOS {
+		return "CONIN$"
+	}
+
+	return "/dev/tty"
+}
+
+// opens whatever conf offers for confirming marks. having neither a
+// terminal nor a joystick is an error, since playback could never go on.
+func openMarkGate(conf config.Config) (*markGate, error) {
+	var (
+		gate = &markGate{confirmed: make(chan struct{}, 1)}
+		ways []string
+	)
+
+	mask, err := buttonMask(conf.ConfirmButtons)
+	if err != nil {
+		return nil, fmt.Errorf("invalid confirm-buttons. %s", err)
+	}
+
+	if tty, err := os.Open(terminalDevice()); nil == err {
+		gate.tty = tty
+		go gate.readTerminal()
+		ways = append(ways, "enter")
+	}
+
+	if js, _, err := openJoystick(conf); nil == err {
+		gate.js = js
+		go gate.watchJoystick(mask)
+		ways = append(ways, strings.Join(conf.ConfirmButtons, "+"))
+	}
+
+	if 0 == len(ways) {
+		return nil, errors.New("--confirm needs a terminal or a joystick to confirm marks")
+	}
+
+	gate.prompt = strings.Join(ways, " or ")
+
+	return gate, nil
+}
+
+func (g *markGate) confirm() {
+	select {
+	case g.confirmed <- struct{}{}:
+	default:
+	}
+}
+
+func (g *markGate) readTerminal() {
+	scanner := bufio.NewScanner(g.tty)
+	for scanner.Scan() {
+		g.confirm()
+	}
+}
+
+func (g *markGate) watchJoystick(mask uint32) {
+	var press Repeater
+
+	for state := range listenJoystick(g.js, time.NewTicker(50*time.Millisecond)) {
+		if press.Fire(isChordPressed(state, mask), time.Now()) {
+			g.confirm()
+		}
+	}
+}
+
+// waits for the operator to confirm the mark called label. presses made
+// before the mark was reached don't count.
+func (g *markGate) Wait(label string) {
+	select {
+	case <-g.confirmed:
+	default:
+	}
+
+	fmt.Fprintf(os.Stderr, "\nmark %s. press %s to continue\n", label, g.prompt)
+	<-g.confirmed
+}
+
+func (g *markGate) Close() {
+	if nil != g.tty {
+		g.tty.Close()
+	}
+	if nil != g.js {
+		g.js.Close()
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// room controllers sit beside the operator's control surfaces
+var controlSource = Source{Name: "control", Priority: 70}
+
+// percent of max speed for PAN and TILT without a speed
+const defaultControlSpeed = 50
+
+// parses a room controller command: "CAM 2" selects a camera, and
+// "CAM 2 PRESET 5", "CAM 1 PAN LEFT 50 TILT UP", "CAM 1 STOP" and the like
+// command it. keywords are not case sensitive; camera names are.
+func parseControl(conf config.Config, line string) (SurfaceCommand, error) {
+	command := SurfaceCommand{Select: -1}
+
+	words := strings.Fields(line)
+	if len(words) < 2 || "CAM" != strings.ToUpper(words[0]) {
+		return command, fmt.Errorf("expected CAM CAMERA [COMMAND]")
+	}
+
+	address, err := parseCamera(conf, words[1])
+	if err != nil {
+		return command, err
+	}
+
+	words = words[2:]
+	if 0 == len(words) {
+		command.Select = address
+		return command, nil
+	}
+
+	for i := range words {
+		words[i] = strings.ToUpper(words[i])
+	}
+
+	number := func(index int, max uint64) (uint64, error) {
+		if len(words) <= index {
+			return 0, fmt.Errorf("%s: missing number", words[0])
+		}
+
+		value, err := strconv.ParseUint(words[index], 10, 8)
+		if err != nil || value > max {
+			return 0, fmt.Errorf("%s: invalid number %s. expected 0-%d", words[0], words[index], max)
+		}
+
+		return value, nil
+	}
+
+	extended := func(code uint8, data2 uint64) {
+		command.Messages = append(command.Messages, pelco.New().To(address).Extended(code, 0x00, uint8(data2)).Build())
+	}
+
+	switch words[0] {
+	case "PRESET", "STORE", "CLEAR":
+		preset, err := number(1, 255)
+		if err != nil {
+			return command, err
+		}
+		if 0 == preset {
+			return command, fmt.Errorf("%s: invalid number 0. expected 1-255", words[0])
+		}
+
+		codes := map[string]uint8{"PRESET": pelco.CallPreset, "STORE": pelco.SetPreset, "CLEAR": pelco.ClearPreset}
+		extended(codes[words[0]], preset)
+
+	case "AUX":
+		aux, err := number(1, 255)
+		if err != nil {
+			return command, err
+		}
+		if 3 != len(words) || ("ON" != words[2] && "OFF" != words[2]) {
+			return command, fmt.Errorf("AUX: expected AUX NUM ON|OFF")
+		}
+		if "ON" == words[2] {
+			extended(pelco.SetAux, aux)
+		} else {
+			extended(pelco.ClearAux, aux)
+		}
+
+	case "STOP":
+		command.Messages = append(command.Messages, pelco.New().To(address).Build())
+
+	case "PAN", "TILT", "ZOOM":
+		// any mix of PAN DIR [SPEED], TILT DIR [SPEED], and ZOOM DIR
+		var pan, tilt, zoom float32
+
+		for 0 != len(words) {
+			if len(words) < 2 {
+				return command, fmt.Errorf("%s: missing direction", words[0])
+			}
+
+			directions := map[string]map[string]float32{
+				"PAN":  {"LEFT": -1, "RIGHT": 1},
+				"TILT": {"DOWN": -1, "UP": 1},
+				"ZOOM": {"OUT": -1, "IN": 1},
+			}[words[0]]
+			if nil == directions {
+				return command, fmt.Errorf("unknown command %s", words[0])
+			}
+
+			sign, ok := directions[words[1]]
+			if !ok {
+				return command, fmt.Errorf("%s: unknown direction %s", words[0], words[1])
+			}
+
+			speed, used := uint64(defaultControlSpeed), 2
+			if "ZOOM" != words[0] && 3 <= len(words) {
+				if value, err := strconv.ParseUint(words[2], 10, 8); nil == err {
+					if value > 100 {
+						return command, fmt.Errorf("%s: invalid speed %d. expected 0-100", words[0], value)
+					}
+					speed, used = value, 3
+				}
+			}
+
+			switch words[0] {
+			case "PAN":
+				pan = sign * float32(speed) / 100
+			case "TILT":
+				tilt = sign * float32(speed) / 100
+			case "ZOOM":
+				zoom = sign
+			}
+
+			words = words[used:]
+		}
+
+		command.Messages = append(command.Messages, applyJoystick(pelco.New().To(address), pan, tilt, zoom, false, false, false, conf.MaxSpeed).Build())
+
+	default:
+		return command, fmt.Errorf("unknown command %s", words[0])
+	}
+
+	return command, nil
+}
+
+// answers one room controller, a command per line, with OK or ERROR and a
+// reason. commands past the controller's allowance are refused, except
+// stops.
+func serveControl(conn net.Conn, conf config.Config, limiter *RateLimiter, inputs chan<- Input) {
+	defer conn.Close()
+
+	client := clientHost(conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line {
+			continue
+		}
+
+		command, err := parseControl(conf, line)
+		if nil == err && !isStopCommand(command) {
+			if ok, wait := limiter.Allow(client, time.Now()); !ok {
+				err = fmt.Errorf("too many commands. retry in %s", wait.Round(time.Millisecond))
+			}
+		}
+
+		if nil == err {
+			// answered once the interactive loop has sent it
+			reply := make(chan error)
+			inputs <- Input{Source: controlSource, Command: fixedCommand(command), Reply: reply}
+			err = <-reply
+		}
+
+		if err != nil {
+			fmt.Fprintf(conn, "ERROR %s\r\n", err)
+		} else {
+			fmt.Fprintf(conn, "OK\r\n")
+		}
+	}
+}
+
+func init() {
+	registerInput(controlInput{})
+}
+
+// controlInput takes plain text commands from room controllers.
+type controlInput struct{}
+
+func (controlInput) Source() Source {
+	return controlSource
+}
+
+// listens for room controllers on tcp conf.Control, when it is set.
+func (controlInput) Start(conf config.Config) (<-chan Input, error) {
+	if "" == conf.Control {
+		return nil, nil
+	}
+
+	listener, err := net.Listen("tcp", conf.Control)
+	if err != nil {
+		return nil, fmt.Errorf("unable to accept control commands on %s. %s", conf.Control, err)
+	}
+
+	inputs := make(chan Input)
+	limiter := NewRateLimiter(conf.RateLimit, conf.RateBurst)
+
+	fmt.Fprintf(os.Stderr, "Accepting control commands on tcp %s\n", listener.Addr())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				printError("control: %s\n", err)
+				return
+			}
+
+			go serveControl(conn, conf, limiter, inputs)
+		}
+	}()
+
+	return inputs, nil
+}
@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// reads the GUID SDL would give joystick number js, and the evdev codes of
+// its axes and buttons, from sysfs. returns false when the input device
+// can't be found.
+func readControllerInfo(js int) (ControllerInfo, bool) {
+	device := filepath.Join("/sys/class/input", "js"+strconv.Itoa(js), "device")
+
+	var id [4]uint64
+	for i, name := range []string{"bustype", "vendor", "product", "version"} {
+		text, err := os.ReadFile(filepath.Join(device, "id", name))
+		if err != nil {
+			return ControllerInfo{}, false
+		}
+		if id[i], err = strconv.ParseUint(strings.TrimSpace(string(text)), 16, 16); err != nil {
+			return ControllerInfo{}, false
+		}
+	}
+
+	// little endian 16 bit words: bus, name crc, vendor, 0, product, 0,
+	// version, driver
+	var guid string
+	for _, word := range []uint64{id[0], 0, id[1], 0, id[2], 0, id[3], 0} {
+		guid += fmt.Sprintf("%02x%02x", word&0xff, word>>8)
+	}
+
+	return ControllerInfo{
+		GUID: guid,
+		Axes: readCapabilities(filepath.Join(device, "capabilities", "abs")),
+		Keys: readCapabilities(filepath.Join(device, "capabilities", "key")),
+	}, true
+}
+
+// returns the codes set in a sysfs capability bitmap, lowest first. the
+// bitmap is hex words of the kernel's long, most significant first.
+func readCapabilities(path string) []int {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	words := strings.Fields(string(text))
+
+	var codes []int
+	for i := len(words) - 1; i >= 0; i-- {
+		word, err := strconv.ParseUint(words[i], 16, bits.UintSize)
+		if err != nil {
+			return nil
+		}
+
+		base := (len(words) - 1 - i) * bits.UintSize
+		for bit := 0; bit < bits.UintSize; bit++ {
+			if 0 != word&(1<<uint(bit)) {
+				codes = append(codes, base+bit)
+			}
+		}
+	}
+
+	return codes
+}
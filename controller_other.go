@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// controller GUIDs are only read on linux.
+func readControllerInfo(js int) (ControllerInfo, bool) {
+	return ControllerInfo{}, false
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+	"os"
+	"time"
+)
+
+// default gap between frames two runs may disagree on before it counts
+const defaultMaxDeviation = 100 * time.Millisecond
+
+// recordings differing by more frames than this aren't worth aligning
+const maxDiffEdits = 2000
+
+// returns the frames of a and b that match, as index pairs in order, along
+// the fewest frames added and removed. false when more than maxEdits are.
+func alignFrames(a, b []DelayedMessage, maxEdits int) ([][2]int, bool) {
+	n, m := len(a), len(b)
+	if n+m < maxEdits {
+		maxEdits = n + m
+	}
+
+	// myers' diff, keeping each round's furthest reaches to walk back
+	// through. only the diagonals a round can touch are kept, so memory
+	// grows with the edits rather than the recordings.
+	var (
+		offset = maxEdits + 1
+		v      = make([]int, 2*maxEdits+3)
+		trace  [][]int
+	)
+
+	for d := 0; d <= maxEdits; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x].Message == b[y].Message {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackFrames(trace, n, m), true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func backtrackFrames(trace [][]int, x, y int) [][2]int {
+	var matches [][2]int
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] holds diagonals -d-1 through d+1
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			matches = append(matches, [2]int{x, y})
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+
+	return matches
+}
+
+// returns the recording time each frame is sent at.
+func frameTimes(frames []DelayedMessage) []time.Duration {
+	var (
+		times   = make([]time.Duration, len(frames))
+		elapsed time.Duration
+	)
+
+	for i, frame := range frames {
+		elapsed += frame.Delay
+		times[i] = elapsed
+	}
+
+	return times
+}
+
+// formats a deviation with its sign, e.g. +450ms.
+func formatDeviation(deviation time.Duration) string {
+	if deviation >= 0 {
+		return "+" + deviation.String()
+	}
+
+	return deviation.String()
+}
+
+// RecordingDiff counts the differences between two recordings.
+type RecordingDiff struct {
+	Matched    int
+	OnlyA      int
+	OnlyB      int
+	Deviations int
+	MaxDrift   time.Duration // furthest b ran ahead of or behind a
+	MarksOff   int           // marks missing from either, or away from where they were
+}
+
+func (d RecordingDiff) Same() bool {
+	return 0 == d.OnlyA+d.OnlyB+d.Deviations+d.MarksOff
+}
+
+// writes to w how the recording b differs from the reference a: frames only
+// one of them sends, gaps between matching frames that differ by more than
+// maxDeviation, and marks missing or moved.
+func diffRecordings(w io.Writer, conf config.Config, nameA, nameB string, a, b []DelayedMessage, marksA, marksB []RecordingMark, maxDeviation time.Duration) (RecordingDiff, error) {
+	var diff RecordingDiff
+
+	matches, ok := alignFrames(a, b, maxDiffEdits)
+	if !ok {
+		return diff, fmt.Errorf("recordings differ by more than %d frames. too different to compare", maxDiffEdits)
+	}
+
+	timesA, timesB := frameTimes(a), frameTimes(b)
+
+	only := func(name string, frames []DelayedMessage, times []time.Duration, from, to int) {
+		for i := from; i < to; i++ {
+			fmt.Fprintf(w, "frame %d  %s  only in %s  %s\n", i+1, formatVerifyTime(times[i]), name,
+				describeMessage(conf, frames[i].Message))
+		}
+	}
+
+	var (
+		nextA, nextB int
+		lastA, lastB time.Duration
+	)
+
+	for _, match := range append(matches, [2]int{len(a), len(b)}) {
+		i, j := match[0], match[1]
+
+		only(nameA, a, timesA, nextA, i)
+		only(nameB, b, timesB, nextB, j)
+		diff.OnlyA += i - nextA
+		diff.OnlyB += j - nextB
+		nextA, nextB = i+1, j+1
+
+		if i == len(a) {
+			break
+		}
+
+		diff.Matched++
+
+		// gaps are compared rather than times, so one late frame isn't
+		// blamed on all those after it
+		deviation := (timesB[j] - lastB) - (timesA[i] - lastA)
+		lastA, lastB = timesA[i], timesB[j]
+
+		if deviation > maxDeviation || deviation < -maxDeviation {
+			diff.Deviations++
+			fmt.Fprintf(w, "frame %d  %s vs %s  %s  %s\n", i+1, formatVerifyTime(timesA[i]), formatVerifyTime(timesB[j]),
+				formatDeviation(deviation), describeMessage(conf, a[i].Message))
+		}
+
+		if drift := timesB[j] - timesA[i]; drift > diff.MaxDrift || -drift > diff.MaxDrift {
+			if drift < 0 {
+				drift = -drift
+			}
+			diff.MaxDrift = drift
+		}
+	}
+
+	// marks pair up by label, in order
+	used := make([]bool, len(marksB))
+	for _, mark := range marksA {
+		found := -1
+		for j, other := range marksB {
+			if !used[j] && other.Label == mark.Label {
+				found = j
+				break
+			}
+		}
+
+		at := time.Duration(mark.At) * time.Millisecond
+		if found < 0 {
+			diff.MarksOff++
+			fmt.Fprintf(w, "mark %s  %s  only in %s\n", mark.Label, formatVerifyTime(at), nameA)
+			continue
+		}
+		used[found] = true
+
+		otherAt := time.Duration(marksB[found].At) * time.Millisecond
+		if deviation := otherAt - at; deviation > maxDeviation || deviation < -maxDeviation {
+			diff.MarksOff++
+			fmt.Fprintf(w, "mark %s  %s vs %s  %s\n", mark.Label, formatVerifyTime(at), formatVerifyTime(otherAt),
+				formatDeviation(deviation))
+		}
+	}
+
+	for j, mark := range marksB {
+		if !used[j] {
+			diff.MarksOff++
+			fmt.Fprintf(w, "mark %s  %s  only in %s\n", mark.Label,
+				formatVerifyTime(time.Duration(mark.At)*time.Millisecond), nameB)
+		}
+	}
+
+	return diff, nil
+}
+
+// reads the recording at path whole.
+func readRecordingFile(path string) ([]DelayedMessage, []RecordingMark, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	_, frames, marks, err := parseRecording(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return frames, marks, nil
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "diff",
+		Summary: "compare a recording against a reference run",
+		Usage:   []string{"REFERENCE RECORDING [--max-deviation DURATION]"},
+		Options: []string{"--max-deviation DURATION - gaps between frames may differ by DURATION. (default = 100ms)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			diffCommand(conf, arguments["REFERENCE"].(string), arguments["RECORDING"].(string),
+				durationArg(arguments, "--max-deviation", defaultMaxDeviation))
+		},
+	})
+}
+
+// compares recording against reference, e.g. a tour recorded again against
+// the run it was made from, and prints a summary. like diff, exits 1 when
+// they differ and 2 when they can't be compared.
+func diffCommand(conf config.Config, reference, recording string, maxDeviation time.Duration) {
+	if maxDeviation < 0 {
+		printError("--max-deviation can't be negative\n")
+		os.Exit(2)
+	}
+
+	a, marksA, err := readRecordingFile(reference)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(2)
+	}
+
+	b, marksB, err := readRecordingFile(recording)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(2)
+	}
+
+	diff, err := diffRecordings(os.Stdout, conf, reference, recording, a, b, marksA, marksB, maxDeviation)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("%d frames match, %d only in %s, %d only in %s, %d gaps off by more than %s, %d marks missing or moved, drift up to %s\n",
+		diff.Matched, diff.OnlyA, reference, diff.OnlyB, recording, diff.Deviations, maxDeviation, diff.MarksOff, diff.MaxDrift)
+
+	if !diff.Same() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/onvif"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// an external tracker must report at least this often, or the target is
+// considered lost and the camera stops.
+const followTimeout = 500 * time.Millisecond
+
+// follow mode yields to the joystick and to api clients holding control.
+var followSource = Source{Name: "follow", Priority: 50}
+
+// the follow setting that tracks the objects the selected camera's own ONVIF
+// analytics report, rather than listening for a tracker
+const followONVIF = "onvif"
+
+// a camera that stops answering event pulls is subscribed to again after this
+const followResubscribe = 2 * time.Second
+
+// Target is a tracked subject's position in the picture, 0.0 to 1.0 from the
+// top left corner. Lost is set when the tracker gives up on the subject or
+// stops reporting.
+type Target struct {
+	X    float64
+	Y    float64
+	Lost bool
+}
+
+// parses a tracker datagram: a point "X Y", a bounding box "X Y WIDTH HEIGHT"
+// (as reported by camera analytics), or "lost".
+func parseTarget(text string) (Target, error) {
+	fields := strings.Fields(text)
+
+	if 1 == len(fields) && "lost" == strings.ToLower(fields[0]) {
+		return Target{Lost: true}, nil
+	}
+
+	if 2 != len(fields) && 4 != len(fields) {
+		return Target{}, fmt.Errorf("invalid target %q. expected \"X Y\", \"X Y WIDTH HEIGHT\", or \"lost\"", text)
+	}
+
+	var values []float64
+
+	for _, field := range fields {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil || value < 0 || value > 1 {
+			return Target{}, fmt.Errorf("invalid target %q. values must be 0.0-1.0", text)
+		}
+		values = append(values, value)
+	}
+
+	// steer toward the middle of a box
+	if 4 == len(values) {
+		return Target{X: values[0] + values[2]/2, Y: values[1] + values[3]/2}, nil
+	}
+
+	return Target{X: values[0], Y: values[1]}, nil
+}
+
+// receives tracker datagrams on conn. a Lost target is sent whenever the
+// tracker is quiet for followTimeout.
+func listenTargets(conn net.PacketConn) <-chan Target {
+	targets := make(chan Target)
+	buffer := make([]byte, 512)
+
+	go func() {
+		defer close(targets)
+
+		for {
+			conn.SetReadDeadline(time.Now().Add(followTimeout))
+
+			n, _, err := conn.ReadFrom(buffer)
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				targets <- Target{Lost: true}
+				continue
+			} else if err != nil {
+				printError("follow: %s\n", err)
+				return
+			}
+
+			target, err := parseTarget(string(buffer[:n]))
+			if err != nil {
+				printError("follow: %s\n", err)
+				continue
+			}
+
+			targets <- target
+		}
+	}()
+
+	return targets
+}
+
+// pulls the analytics events of the ONVIF camera of client and steers
+// toward the largest object each pull reports. a Lost target is sent once
+// followTimeout passes without an object, e.g. other events only, so the
+// camera stops when its analytics lose the subject.
+func listenONVIFTargets(client *onvif.Client, subscription *onvif.Subscription) <-chan Target {
+	targets := make(chan Target)
+
+	go func() {
+		var seen time.Time
+
+		for {
+			boxes, err := subscription.Pull(followTimeout)
+			if err != nil {
+				printError("follow: onvif events failed. %s. resubscribing\n", err)
+				targets <- Target{Lost: true}
+
+				subscription = resubscribe(client)
+				continue
+			}
+
+			if 0 != len(boxes) {
+				seen = time.Now()
+			} else if time.Since(seen) < followTimeout {
+				continue
+			}
+
+			targets <- boxTarget(boxes)
+		}
+	}()
+
+	return targets
+}
+
+// subscribes to the events of client until the camera accepts.
+func resubscribe(client *onvif.Client) *onvif.Subscription {
+	for {
+		time.Sleep(followResubscribe)
+
+		if subscription, err := client.Subscribe(); nil == err {
+			printError("follow: onvif events are back\n")
+			return subscription
+		}
+	}
+}
+
+// returns the middle of the largest of boxes, or a Lost target for none.
+// ONVIF coordinates run -1 to 1, bottom to top, and targets 0 to 1, top to
+// bottom.
+func boxTarget(boxes []onvif.Box) Target {
+	if 0 == len(boxes) {
+		return Target{Lost: true}
+	}
+
+	largest := boxes[0]
+	for _, box := range boxes[1:] {
+		if box.Area() > largest.Area() {
+			largest = box
+		}
+	}
+
+	clamp := func(value float64) float64 {
+		return math.Max(0, math.Min(1, value))
+	}
+
+	return Target{
+		X: clamp((largest.Left+largest.Right)/4 + 0.5),
+		Y: clamp(0.5 - (largest.Top+largest.Bottom)/4),
+	}
+}
+
+func listenNoTargets() <-chan Target {
+	return make(chan Target)
+}
+
+// Follower steers toward a target with a proportional controller.
+type Follower struct {
+	Gain     float64 // fraction of max speed per unit of offset from center
+	Deadband float64 // offsets from center smaller than this are ignored
+	MaxSpeed int32
+}
+
+// returns signed pan and tilt speeds that move target toward the center of
+// the picture.
+func (f Follower) Speeds(target Target) (int, int) {
+	if target.Lost {
+		return 0, 0
+	}
+
+	// -1.0 to 1.0, positive right of and above center
+	return f.speed(2*target.X - 1), f.speed(1 - 2*target.Y)
+}
+
+func (f Follower) speed(offset float64) int {
+	if math.Abs(offset) < f.Deadband {
+		return 0
+	}
+
+	speed := math.Min(math.Abs(offset)*f.Gain, 1) * float64(f.MaxSpeed)
+
+	if offset < 0 {
+		return -int(math.Ceil(speed))
+	}
+
+	return int(math.Ceil(speed))
+}
+
+// opens the udp port trackers report to, or subscribes to the analytics of
+// the selected ONVIF camera, or returns a channel that never delivers when
+// follow mode is disabled.
+func startFollow(conf config.Config) (<-chan Target, error) {
+	addr := conf.Follow
+	if "" == addr {
+		return listenNoTargets(), nil
+	}
+
+	if followONVIF == addr {
+		device := conf.ForCamera(conf.Address).Onvif
+		if "" == device {
+			return nil, errors.New("the selected camera has no onvif device")
+		}
+
+		client, err := onvif.Dial(device)
+		if err != nil {
+			return nil, err
+		}
+
+		subscription, err := client.Subscribe()
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(os.Stderr, "Following objects from the analytics of onvif %s\n", redactURL(device))
+
+		return listenONVIFTargets(client, subscription), nil
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Following targets on udp %s\n", conn.LocalAddr())
+
+	return listenTargets(conn), nil
+}
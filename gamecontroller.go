@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/simulatedsimian/joystick"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// evdev codes that decide how SDL numbers a controller's inputs differently
+// from the kernel's joystick device
+const (
+	absHat0X    = 0x10
+	absHat3Y    = 0x17
+	btnMisc     = 0x100
+	btnJoystick = 0x120
+)
+
+// ControllerMapping is an SDL game controller mapping, as found one per line
+// in gamecontrollerdb.txt: the controller's GUID and name, and the input
+// behind each standard control, e.g. "a" from "b0" or "lefty" from "a1".
+type ControllerMapping struct {
+	GUID     string
+	Name     string
+	Platform string
+	Bindings map[string]string
+}
+
+// parses a line of gamecontrollerdb.txt.
+func parseControllerMapping(line string) (ControllerMapping, error) {
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if len(fields) < 3 {
+		return ControllerMapping{}, fmt.Errorf("controller mapping needs a guid, a name, and bindings")
+	}
+
+	mapping := ControllerMapping{GUID: strings.ToLower(fields[0]), Name: fields[1], Bindings: make(map[string]string)}
+	if 32 != len(mapping.GUID) {
+		return mapping, fmt.Errorf("invalid controller guid %s", fields[0])
+	}
+
+	for _, field := range fields[2:] {
+		if "" == field {
+			continue
+		}
+
+		parts := strings.SplitN(field, ":", 2)
+		if 2 != len(parts) {
+			return mapping, fmt.Errorf("invalid controller binding %s", field)
+		}
+
+		if "platform" == parts[0] {
+			mapping.Platform = parts[1]
+		} else {
+			mapping.Bindings[parts[0]] = parts[1]
+		}
+	}
+
+	return mapping, nil
+}
+
+// reads the mappings in a gamecontrollerdb.txt for this platform. lines that
+// don't parse are skipped, since the community file changes under us.
+func loadControllerDB(path string) ([]ControllerMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mappings []ControllerMapping
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if mapping, err := parseControllerMapping(line); nil == err && mapping.ForPlatform() {
+			mappings = append(mappings, mapping)
+		}
+	}
+
+	return mappings, scanner.Err()
+}
+
+// reports whether the mapping is for this platform. mappings without a
+// platform are for all of them.
+func (m ControllerMapping) ForPlatform() bool {
+	platforms := map[string]string{"linux": "Linux", "windows": "Windows", "darwin": "Mac OS X", "android": "Android"}
+
+	return "" == m.Platform || platforms[runtime.GOOS] == m.Platform
+}
+
+// returns the mapping for guid, ignoring the name checksum newer SDL builds
+// put in the guid, then falling back to any version of the same controller.
+// earlier mappings win, so overrides go first.
+func findControllerMapping(mappings []ControllerMapping, guid string) (ControllerMapping, bool) {
+	// hex digits 4-7 are the name crc, 24-27 the version
+	mask := func(guid string, version bool) string {
+		if 32 != len(guid) {
+			return guid
+		}
+		guid = guid[:4] + "0000" + guid[8:]
+		if !version {
+			guid = guid[:24] + "0000" + guid[28:]
+		}
+		return guid
+	}
+
+	for _, version := range []bool{true, false} {
+		for _, mapping := range mappings {
+			if mask(mapping.GUID, version) == mask(guid, version) {
+				return mapping, true
+			}
+		}
+	}
+
+	return ControllerMapping{}, false
+}
+
+// returns the mappings from controller-mappings, followed by those in
+// controller-db, or $HOME/.config/cctv-ptz/gamecontrollerdb.txt when it
+// exists.
+func controllerMappings(conf config.Config) ([]ControllerMapping, error) {
+	var mappings []ControllerMapping
+
+	for _, line := range conf.ControllerMappings {
+		mapping, err := parseControllerMapping(line)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	path := conf.ControllerDB
+	if "" == path {
+		home := os.Getenv("HOME")
+		if "" == home {
+			return mappings, nil
+		}
+
+		path = filepath.Join(home, ".config", "cctv-ptz", "gamecontrollerdb.txt")
+		if !fileExists(path) {
+			return mappings, nil
+		}
+	}
+
+	db, err := loadControllerDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(mappings, db...), nil
+}
+
+// ControllerInfo is what the system tells about a joystick: its SDL GUID and
+// the evdev codes behind its axes and buttons, in joystick device order.
+type ControllerInfo struct {
+	GUID string
+	Axes []int
+	Keys []int
+}
+
+// returns the joystick device axis behind SDL axis n, which doesn't count
+// hats. without evdev codes the numbering is assumed to agree.
+func (c ControllerInfo) axis(n int) int {
+	if 0 == len(c.Axes) {
+		return n
+	}
+
+	for i, code := range c.Axes {
+		if code >= absHat0X && code <= absHat3Y {
+			continue
+		}
+		if 0 == n {
+			return i
+		}
+		n--
+	}
+
+	return -1
+}
+
+// returns the joystick device axes behind SDL hat n, horizontal then
+// vertical. without evdev codes a single hat is assumed to be on the last
+// two axes, as on most gamepads.
+func (c ControllerInfo) hat(n, axisCount int) (int, int) {
+	if 0 == len(c.Axes) {
+		if 0 != n {
+			return -1, -1
+		}
+		return axisCount - 2, axisCount - 1
+	}
+
+	index := func(code int) int {
+		for i, c := range c.Axes {
+			if c == code {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for code := absHat0X; code < absHat3Y; code += 2 {
+		x, y := index(code), index(code+1)
+		if x < 0 && y < 0 {
+			continue
+		}
+		if 0 == n {
+			return x, y
+		}
+		n--
+	}
+
+	return -1, -1
+}
+
+// returns the joystick device button behind SDL button n. both number
+// buttons from BTN_JOYSTICK up and then the rest, but SDL counts keys below
+// BTN_MISC that the joystick device ignores.
+func (c ControllerInfo) button(n int) int {
+	if 0 == len(c.Keys) {
+		return n
+	}
+
+	var sdl []int
+	for _, low := range []bool{false, true} {
+		for _, code := range c.Keys {
+			if (code < btnJoystick) == low {
+				sdl = append(sdl, code)
+			}
+		}
+	}
+
+	if n >= len(sdl) || sdl[n] < btnMisc {
+		return -1
+	}
+
+	for i, code := range c.Keys {
+		if code == sdl[n] {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// a device input behind a control. axes read only the half given by sign,
+// when it's non-zero.
+type controllerInput struct {
+	button bool
+	index  int
+	sign   int
+	invert bool
+}
+
+func (in controllerInput) read(state joystick.State) int {
+	if in.button {
+		if 0 != state.Buttons&(1<<uint(in.index)) {
+			return AxisMax
+		}
+		return 0
+	}
+
+	if in.index >= len(state.AxisData) {
+		return 0
+	}
+
+	value := state.AxisData[in.index]
+	if in.invert {
+		value = -value
+	}
+
+	if 0 != in.sign {
+		if value *= in.sign; value < 0 {
+			value = 0
+		}
+	}
+
+	return value
+}
+
+func (in controllerInput) pressed(state joystick.State) bool {
+	return in.read(state) > AxisMax/2
+}
+
+// a control of the xbox layout and the input that drives it. sign is
+// non-zero for the half axis controls SDL writes as "+leftx" and "-leftx".
+type controllerBinding struct {
+	control string
+	sign    int
+	input   controllerInput
+}
+
+// ControllerLayout translates a controller's inputs to the xbox layout the
+// ptz mapping, aux bindings, and calibration expect.
+type ControllerLayout struct {
+	Name     string
+	bindings []controllerBinding
+}
+
+// builds the layout of mapping for the controller described by info.
+// bindings to controls the xbox layout lacks, or to inputs the controller
+// lacks, are dropped.
+func newControllerLayout(mapping ControllerMapping, info ControllerInfo, axisCount int) ControllerLayout {
+	layout := ControllerLayout{Name: mapping.Name}
+
+	for control, source := range mapping.Bindings {
+		binding := controllerBinding{control: control}
+
+		if strings.HasPrefix(control, "+") || strings.HasPrefix(control, "-") {
+			binding.control, binding.sign = control[1:], 1
+			if '-' == control[0] {
+				binding.sign = -1
+			}
+		}
+
+		if _, ok := controllerButtons[binding.control]; !ok && !isControllerAxis(binding.control) && !isDPad(binding.control) {
+			continue
+		}
+
+		input, ok := parseControllerInput(source, info, axisCount)
+		if !ok {
+			continue
+		}
+		binding.input = input
+
+		layout.bindings = append(layout.bindings, binding)
+	}
+
+	return layout
+}
+
+// parses an SDL input: "b3", "a2", "a2~" (inverted), "+a2" or "-a2" (one
+// half), or "h0.4" (hat 0 down).
+func parseControllerInput(source string, info ControllerInfo, axisCount int) (controllerInput, bool) {
+	var input controllerInput
+
+	if strings.HasPrefix(source, "+") || strings.HasPrefix(source, "-") {
+		input.sign = 1
+		if '-' == source[0] {
+			input.sign = -1
+		}
+		source = source[1:]
+	}
+
+	if strings.HasSuffix(source, "~") {
+		input.invert = true
+		source = strings.TrimSuffix(source, "~")
+	}
+
+	if len(source) < 2 {
+		return input, false
+	}
+
+	switch source[0] {
+	case 'b':
+		n, err := strconv.Atoi(source[1:])
+		if err != nil {
+			return input, false
+		}
+		input.button, input.index = true, info.button(n)
+	case 'a':
+		n, err := strconv.Atoi(source[1:])
+		if err != nil {
+			return input, false
+		}
+		input.index = info.axis(n)
+	case 'h':
+		var hat, mask int
+		if _, err := fmt.Sscanf(source, "h%d.%d", &hat, &mask); err != nil {
+			return input, false
+		}
+
+		// a hat direction is one half of the axis the joystick device
+		// reports the hat on
+		x, y := info.hat(hat, axisCount)
+		switch mask {
+		case 1:
+			input.index, input.sign = y, -1
+		case 2:
+			input.index, input.sign = x, 1
+		case 4:
+			input.index, input.sign = y, 1
+		case 8:
+			input.index, input.sign = x, -1
+		default:
+			return input, false
+		}
+	default:
+		return input, false
+	}
+
+	return input, input.index >= 0 && (!input.button || input.index < 32)
+}
+
+// SDL names for the xbox buttons
+var controllerButtons = map[string]uint32{
+	"a":             xbox.A,
+	"b":             xbox.B,
+	"x":             xbox.X,
+	"y":             xbox.Y,
+	"back":          xbox.Back,
+	"start":         xbox.Start,
+	"guide":         xbox.XBox,
+	"leftshoulder":  xbox.LeftBumper,
+	"rightshoulder": xbox.RightBumper,
+	"leftstick":     xbox.LeftStick,
+	"rightstick":    xbox.RightStick,
+}
+
+// returns the xbox axis behind an SDL axis name. triggers rest at -AxisMax.
+func controllerAxis(control string) (int32, bool) {
+	switch control {
+	case "leftx":
+		return xbox.LeftAxisX.Index, false
+	case "lefty":
+		return xbox.LeftAxisY.Index, false
+	case "rightx":
+		return xbox.RightAxisX.Index, false
+	case "righty":
+		return xbox.RightAxisY.Index, false
+	case "lefttrigger":
+		return xbox.LeftTrigger.Index, true
+	case "righttrigger":
+		return xbox.RightTrigger.Index, true
+	}
+
+	return -1, false
+}
+
+func isControllerAxis(control string) bool {
+	index, _ := controllerAxis(control)
+	return index >= 0
+}
+
+func isDPad(control string) bool {
+	return "dpup" == control || "dpdown" == control || "dpleft" == control || "dpright" == control
+}
+
+// translates state from the controller's layout to the xbox layout.
+func (l ControllerLayout) Translate(state joystick.State) joystick.State {
+	translated := joystick.State{AxisData: make([]int, xbox.DPadY.Index+1)}
+	translated.AxisData[xbox.LeftTrigger.Index] = -AxisMax
+	translated.AxisData[xbox.RightTrigger.Index] = -AxisMax
+
+	bound := make(map[int32]bool)
+
+	for _, binding := range l.bindings {
+		input := binding.input
+
+		if bit, ok := controllerButtons[binding.control]; ok {
+			if input.pressed(state) {
+				translated.Buttons |= bit
+			}
+			continue
+		}
+
+		if isDPad(binding.control) {
+			if !input.pressed(state) {
+				continue
+			}
+			switch binding.control {
+			case "dpup":
+				translated.AxisData[xbox.DPadY.Index] -= AxisMax
+			case "dpdown":
+				translated.AxisData[xbox.DPadY.Index] += AxisMax
+			case "dpleft":
+				translated.AxisData[xbox.DPadX.Index] -= AxisMax
+			case "dpright":
+				translated.AxisData[xbox.DPadX.Index] += AxisMax
+			}
+			continue
+		}
+
+		index, trigger := controllerAxis(binding.control)
+		value := input.read(state)
+
+		switch {
+		case 0 != binding.sign && !input.button && 0 == input.sign:
+			// a whole axis driving half a control
+			value = binding.sign * (value + AxisMax) / 2
+		case 0 != binding.sign:
+			value *= binding.sign
+		case trigger && (input.button || 0 != input.sign):
+			// buttons and half axes press triggers from rest
+			value = 2*value - AxisMax
+		}
+
+		// the first binding to an axis replaces its rest value, later ones
+		// add to it, as "-leftx" and "+leftx" do
+		if !bound[index] {
+			translated.AxisData[index] = 0
+			bound[index] = true
+		}
+		translated.AxisData[index] += value
+	}
+
+	for i, value := range translated.AxisData {
+		if value > AxisMax {
+			translated.AxisData[i] = AxisMax
+		} else if value < -AxisMax {
+			translated.AxisData[i] = -AxisMax
+		}
+	}
+
+	return translated
+}
+
+// mappedJoystick reads a controller through its layout, so it looks like an
+// xbox controller to the rest of cctv-ptz.
+type mappedJoystick struct {
+	joystick.Joystick
+	layout ControllerLayout
+}
+
+func (js mappedJoystick) AxisCount() int {
+	return int(xbox.DPadY.Index) + 1
+}
+
+func (js mappedJoystick) ButtonCount() int {
+	return 11
+}
+
+func (js mappedJoystick) Read() (joystick.State, error) {
+	state, err := js.Joystick.Read()
+	if err != nil {
+		return state, err
+	}
+
+	return js.layout.Translate(state), nil
+}
+
+// opens joystick conf.JoystickNumber, translated to the xbox layout when a
+// controller mapping matches its GUID. returns the mapping's name, or "".
+func openJoystick(conf config.Config) (joystick.Joystick, string, error) {
+	js, err := joystick.Open(conf.JoystickNumber)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info, ok := readControllerInfo(conf.JoystickNumber)
+	if !ok {
+		return js, "", nil
+	}
+
+	mappings, err := controllerMappings(conf)
+	if err != nil {
+		printError("ignoring controller mappings. %s\n", err)
+		return js, "", nil
+	}
+
+	mapping, ok := findControllerMapping(mappings, info.GUID)
+	if !ok {
+		return js, "", nil
+	}
+
+	return mappedJoystick{js, newControllerLayout(mapping, info, js.AxisCount())}, mapping.Name, nil
+}
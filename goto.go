@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+)
+
+// checks a goto: pan or tilt, in degrees, and no zoom. tilt runs from 90
+// below the horizon to 90 above it.
+func checkGoto(move PTZMove) error {
+	if nil != move.Zoom {
+		return errors.New("goto takes pan and tilt in degrees, not zoom")
+	}
+
+	if nil == move.Pan && nil == move.Tilt {
+		return errors.New("expected pan or tilt")
+	}
+
+	if nil != move.Tilt && (*move.Tilt < -90 || *move.Tilt > 90) {
+		return fmt.Errorf("invalid tilt %.2f. expected -90 to 90 degrees", *move.Tilt)
+	}
+
+	return nil
+}
+
+// returns the Set Pan Position and Set Tilt Position frames of a goto.
+func (m PTZMove) Messages() []PelcoDMessage {
+	var messages []PelcoDMessage
+
+	if nil != m.Pan {
+		messages = append(messages, pelco.New().To(m.Address).PanPosition(pelcoFromPan(*m.Pan)).Build())
+	}
+
+	if nil != m.Tilt {
+		messages = append(messages, pelco.New().To(m.Address).TiltPosition(pelcoFromTilt(*m.Tilt)).Build())
+	}
+
+	return messages
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "goto",
+		Summary: "pan and tilt to a position in degrees",
+		Usage:   []string{"[--pan DEG] [--tilt DEG]"},
+		Options: []string{
+			"--pan DEG                - pan to DEG clockwise from the camera's zero, e.g. 135.5.",
+			"--tilt DEG               - tilt to DEG, -90 to 90. positive is up.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			move := PTZMove{Address: conf.Address, Degrees: true}
+			if nil != arguments["--pan"] {
+				pan := floatArg(arguments, "--pan", 0)
+				move.Pan = &pan
+			}
+			if nil != arguments["--tilt"] {
+				tilt := floatArg(arguments, "--tilt", 0)
+				move.Tilt = &tilt
+			}
+			gotoPosition(conf, move)
+		},
+	})
+}
+
+// sends the camera at conf.Address to an absolute position from the command
+// line. the camera gets there at its own speed.
+func gotoPosition(conf config.Config, move PTZMove) {
+	if err := checkGoto(move); err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	line, err := openSerialLine(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer line.Close()
+
+	messages := move.Messages()
+	for _, message := range messages {
+		fmt.Fprintf(os.Stderr, "%s\n", describeMessage(conf, message))
+	}
+
+	runMacro(line.Send, []Action{{Messages: messages}})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/boxofrox/cctv-ptz/config"
+	"sync/atomic"
+)
+
+// InputSource is a way of driving cameras besides the joystick, e.g. a
+// control surface, a room controller, or a legacy keyboard. sources register
+// themselves, and the interactive loop runs the inputs of every one that
+// starts without knowing what it is.
+type InputSource interface {
+	// the arbiter source its inputs are sent as
+	Source() Source
+
+	// starts the source, returning the inputs it produces, or nil when conf
+	// doesn't enable it. the channel closes if the source fails for good.
+	Start(conf config.Config) (<-chan Input, error)
+}
+
+// Input is a command from an input source, for the interactive loop to run
+// as the arbiter allows.
+type Input struct {
+	Source Source
+
+	// builds the command from the loop's config when it is run, e.g. for the
+	// selected camera and the speed in effect
+	Command func(conf config.Config) (SurfaceCommand, error)
+
+	// told the outcome, when set. otherwise errors are printed
+	Reply chan<- error
+}
+
+// returns an input's Command for a command known up front.
+func fixedCommand(command SurfaceCommand) func(config.Config) (SurfaceCommand, error) {
+	return func(config.Config) (SurfaceCommand, error) { return command, nil }
+}
+
+// input sources, in the order they registered
+var inputSources []InputSource
+
+// adds source to those started by the interactive loop. called from init.
+func registerInput(source InputSource) {
+	inputSources = append(inputSources, source)
+}
+
+// Inputs merges the inputs of the sources that started.
+type Inputs struct {
+	Commands <-chan Input
+	Ended    <-chan Source // sources whose inputs closed
+
+	running int32
+}
+
+// starts every registered source conf enables. a source that can't start
+// is an error, since the operator asked for it.
+func startInputs(conf config.Config) (*Inputs, error) {
+	var (
+		commands = make(chan Input)
+		ended    = make(chan Source, len(inputSources))
+		inputs   = &Inputs{Commands: commands, Ended: ended}
+	)
+
+	for _, source := range inputSources {
+		in, err := source.Start(conf)
+		if err != nil {
+			return nil, err
+		}
+		if nil == in {
+			continue
+		}
+
+		atomic.AddInt32(&inputs.running, 1)
+
+		go func(source Source, in <-chan Input) {
+			for input := range in {
+				commands <- input
+			}
+
+			atomic.AddInt32(&inputs.running, -1)
+			ended <- source
+		}(source.Source(), in)
+	}
+
+	return inputs, nil
+}
+
+// reports whether any source is still producing inputs.
+func (i *Inputs) Running() bool {
+	return atomic.LoadInt32(&i.running) > 0
+}
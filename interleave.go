@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"time"
+)
+
+// idle time left after each frame so receivers can frame the next one
+const frameGap = 3 * time.Millisecond
+
+// returns how long a frame occupies an rs485 bus at baud, including the gap.
+func frameSlot(baud int) time.Duration {
+	if baud <= 0 {
+		return 10 * time.Millisecond
+	}
+
+	bits := len(PelcoDMessage{}) * 10 // start, 8 data, and stop bits per byte
+
+	return time.Duration(bits)*time.Second/time.Duration(baud) + frameGap
+}
+
+// Interleaver queues frames per address and releases one per transmission
+// slot, taking addresses in turn, so several active cameras share the bus
+// without frames running together or one address starving the others.
+// a queued motion frame is replaced by a newer one for the same address,
+// since only the latest speeds matter.
+type Interleaver struct {
+	slot      func(PelcoDMessage) time.Duration
+	queues    map[int][]PelcoDMessage
+	order     []int // addresses with queued frames, next to send first
+	busyUntil time.Time
+}
+
+func NewInterleaver(slot func(PelcoDMessage) time.Duration) *Interleaver {
+	return &Interleaver{slot: slot, queues: make(map[int][]PelcoDMessage)}
+}
+
+func (q *Interleaver) Add(message PelcoDMessage) {
+	address := int(message[pelco.Addr])
+	queue, ok := q.queues[address]
+
+	if !ok {
+		q.order = append(q.order, address)
+	}
+
+	if n := len(queue); n > 0 && !queue[n-1].Extended() && !message.Extended() {
+		queue[n-1] = message
+	} else {
+		queue = append(queue, message)
+	}
+
+	q.queues[address] = queue
+}
+
+// returns the next frame to send, if the bus is free at now.
+func (q *Interleaver) Next(now time.Time) (PelcoDMessage, bool) {
+	if 0 == len(q.order) || now.Before(q.busyUntil) {
+		return PelcoDMessage{}, false
+	}
+
+	address := q.order[0]
+	queue := q.queues[address]
+	message := queue[0]
+
+	q.order = q.order[1:]
+	if 1 == len(queue) {
+		delete(q.queues, address)
+	} else {
+		q.queues[address] = queue[1:]
+		q.order = append(q.order, address)
+	}
+
+	q.busyUntil = now.Add(q.slot(message))
+
+	return message, true
+}
+
+// returns how long until the next frame may be sent, or false when nothing
+// is queued.
+func (q *Interleaver) Wait(now time.Time) (time.Duration, bool) {
+	if 0 == len(q.order) {
+		return 0, false
+	}
+
+	if now.After(q.busyUntil) {
+		return 0, true
+	}
+
+	return q.busyUntil.Sub(now), true
+}
+
+// drops every queued frame, e.g. ahead of an emergency stop.
+func (q *Interleaver) Clear() {
+	q.queues = make(map[int][]PelcoDMessage)
+	q.order = nil
+}
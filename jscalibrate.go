@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/simulatedsimian/joystick"
+	"os"
+	"time"
+)
+
+// how long axes are sampled at rest to measure center and drift
+const restSampleTime = 2 * time.Second
+
+// axes that travel less than this while being exercised are left uncalibrated
+const minAxisTravel = AxisMax / 4
+
+// raw values seen on one axis while sampling
+type axisSamples struct {
+	min, max int
+	sum      int
+	count    int
+}
+
+func (s *axisSamples) add(value int) {
+	if 0 == s.count || value < s.min {
+		s.min = value
+	}
+	if 0 == s.count || value > s.max {
+		s.max = value
+	}
+	s.sum += value
+	s.count++
+}
+
+func (s *axisSamples) mean() int {
+	if 0 == s.count {
+		return 0
+	}
+
+	return s.sum / s.count
+}
+
+// returns the largest distance of any sample from the mean.
+func (s *axisSamples) drift() int {
+	mean := s.mean()
+	if mean-s.min > s.max-mean {
+		return mean - s.min
+	}
+
+	return s.max - mean
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "calibrate-joystick",
+		Summary: "measure the range and deadzone of every joystick axis",
+		Usage:   []string{"[-j JOYSTICK]"},
+		Options: []string{joystickOption},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			calibrateJoystick(conf)
+		},
+	})
+}
+
+// walks the user through resting and exercising every joystick axis, and
+// stores the measured ranges and deadzones in the config file.
+func calibrateJoystick(conf config.Config) {
+	js, _, err := openJoystick(conf)
+	if err != nil {
+		printError("error opening joystick %d. %s\n", conf.JoystickNumber, err)
+		os.Exit(1)
+	}
+	defer js.Close()
+
+	input := bufio.NewReader(os.Stdin)
+
+	fmt.Fprintf(os.Stderr, "Calibrating %s (/dev/input/js%d), %d axes.\n\n", js.Name(), conf.JoystickNumber, js.AxisCount())
+
+	fmt.Fprintf(os.Stderr, "Let go of the sticks and triggers, then press Enter.")
+	if err := waitEnter(input); err != nil {
+		printError("calibration aborted. %s\n", err)
+		return
+	}
+
+	rested := make(chan struct{})
+	time.AfterFunc(restSampleTime, func() { close(rested) })
+
+	rest := make([]axisSamples, js.AxisCount())
+	if err := sampleAxes(js, rest, rested); err != nil {
+		printError("calibration aborted. %s\n", err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Push each stick around its full circle, squeeze both triggers all the way,\n")
+	fmt.Fprintf(os.Stderr, "and press every d-pad direction. Press Enter when done.")
+
+	entered := make(chan struct{})
+	go func() {
+		waitEnter(input)
+		close(entered)
+	}()
+
+	travel := make([]axisSamples, js.AxisCount())
+	if err := sampleAxes(js, travel, entered); err != nil {
+		printError("calibration aborted. %s\n", err)
+		return
+	}
+
+	var axes []config.AxisCalibration
+
+	fmt.Fprintf(os.Stderr, "\n  axis     min     max  center  drift  deadzone\n")
+	for i := range travel {
+		if travel[i].max-travel[i].min < minAxisTravel {
+			fmt.Fprintf(os.Stderr, "  %4d  not moved, keeping default range\n", i)
+			continue
+		}
+
+		axis := measureAxis(i, rest[i], travel[i])
+
+		fmt.Fprintf(os.Stderr, "  %4d  %6d  %6d  %6d  %5d  %8d\n", i, axis.Min, axis.Max, axis.Center, rest[i].drift(), axis.Deadzone)
+		axes = append(axes, axis)
+	}
+
+	if 0 == len(axes) {
+		printError("no axes moved. nothing saved.\n")
+		os.Exit(1)
+	}
+
+	path, err := config.SaveJoystickAxes(axes)
+	if err != nil {
+		printError("unable to save calibration. %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nSaved joystick calibration to %s\n", path)
+}
+
+// reads the joystick into samples, one per axis, until done.
+func sampleAxes(js joystick.Joystick, samples []axisSamples, done <-chan struct{}) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			state, err := js.Read()
+			if err != nil {
+				return err
+			}
+			for i := range samples {
+				if i < len(state.AxisData) {
+					samples[i].add(state.AxisData[i])
+				}
+			}
+		}
+	}
+}
+
+// derives an axis calibration from its samples at rest and in motion.
+func measureAxis(index int, rest, travel axisSamples) config.AxisCalibration {
+	axis := config.AxisCalibration{Index: index, Min: travel.min, Max: travel.max, Center: rest.mean()}
+
+	// triggers rest at one end. keep them centered like the default ranges so
+	// a half squeeze still reads as zero.
+	quarter := (travel.max - travel.min) / 4
+	if axis.Center < travel.min+quarter || axis.Center > travel.max-quarter {
+		axis.Center = (travel.min + travel.max) / 2
+	}
+
+	axis.Deadzone = 2*rest.drift() + (travel.max-travel.min)/100
+
+	return axis
+}
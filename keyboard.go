@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/mikepb/go-serial"
+	"io"
+	"os"
+)
+
+// a legacy control desk outranks the automated sources, but the local
+// joystick still preempts it
+var keyboardSource = Source{Name: "keyboard", Priority: 90}
+
+func init() {
+	registerInput(keyboardInput{})
+}
+
+// keyboardInput passes through a legacy control desk wired to a second port.
+type keyboardInput struct{}
+
+func (keyboardInput) Source() Source {
+	return keyboardSource
+}
+
+// opens the port a Pelco keyboard is wired to, when one is configured. the
+// inputs end if the port fails.
+func (keyboardInput) Start(conf config.Config) (<-chan Input, error) {
+	if "" == conf.Keyboard {
+		return nil, nil
+	}
+
+	settings := conf
+	if conf.KeyboardBaud > 0 {
+		settings.BaudRate = conf.KeyboardBaud
+	}
+
+	options := createSerialOptions(settings)
+	options.Mode = serial.MODE_READ
+
+	tty, err := options.Open(conf.Keyboard)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open keyboard port %s. %s", conf.Keyboard, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Reading Pelco keyboard on %s at %d baud\n", conf.Keyboard, settings.BaudRate)
+
+	frames := readFrames(tty)
+	inputs := make(chan Input)
+
+	go func() {
+		defer close(inputs)
+
+		for frame := range frames {
+			reply := make(chan error, 1)
+			inputs <- Input{
+				Source:  keyboardSource,
+				Command: fixedCommand(SurfaceCommand{Select: -1, Messages: []PelcoDMessage{frame}}),
+				Reply:   reply,
+			}
+
+			// frames refused while another source has control are dropped.
+			// the keyboard resends them as long as its stick is held
+			if err := <-reply; err != nil && conf.Verbose {
+				fmt.Fprintf(os.Stderr, "keyboard: %s\n", err)
+			}
+		}
+
+		printError("keyboard port %s failed. continuing without it\n", conf.Keyboard)
+	}()
+
+	return inputs, nil
+}
+
+// reads Pelco-D frames from r, skipping bytes until the next sync byte when
+// a frame's checksum is wrong. the channel closes when r fails.
+func readFrames(r io.ReadCloser) <-chan PelcoDMessage {
+	frames := make(chan PelcoDMessage, 20)
+
+	go func() {
+		defer close(frames)
+		defer r.Close()
+
+		var (
+			frame []byte
+			chunk = make([]byte, 64)
+		)
+
+		for {
+			n, err := r.Read(chunk)
+			if err != nil {
+				return
+			}
+
+			for _, b := range chunk[:n] {
+				if 0 == len(frame) && pelco.SyncByte != b {
+					continue
+				}
+
+				frame = append(frame, b)
+				if len(frame) < len(PelcoDMessage{}) {
+					continue
+				}
+
+				var message PelcoDMessage
+				copy(message[:], frame)
+
+				if message.Valid() {
+					frames <- message
+					frame = nil
+				} else {
+					frame = resync(frame)
+				}
+			}
+		}
+	}()
+
+	return frames
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+)
+
+// reports whether pan lies in the clockwise range from min to max.
+func inPanRange(pan, min, max float64) bool {
+	return wrapPan(pan-min) <= wrapPan(max-min)
+}
+
+// clears the pan and tilt motion in message that would push the camera
+// further past its soft limits, and slows motion approaching them. limits
+// are only trusted once the estimate is referenced to a reported or absolute
+// position. reports whether anything was clipped.
+func clipToLimits(message PelcoDMessage, limits *config.Limits, at Orientation) (PelcoDMessage, bool) {
+	if nil == limits || !at.Referenced || message.Extended() {
+		return message, false
+	}
+
+	command, _ := pelco.Decode(message)
+	original := command
+	clipped := false
+
+	if nil != limits.PanMin && nil != limits.PanMax && !inPanRange(at.Pan, *limits.PanMin, *limits.PanMax) {
+		// outside the range, so it is past whichever limit is nearer
+		pastMax := math.Abs(panError(at.Pan, *limits.PanMax)) < math.Abs(panError(at.Pan, *limits.PanMin))
+
+		if (pastMax && 1 == command.Pan) || (!pastMax && -1 == command.Pan) {
+			command.Pan, command.PanSpeed = 0, 0
+			clipped = true
+		}
+	}
+
+	if nil != limits.TiltMax && at.Tilt >= *limits.TiltMax && 1 == command.Tilt {
+		command.Tilt, command.TiltSpeed = 0, 0
+		clipped = true
+	}
+
+	if nil != limits.TiltMin && at.Tilt <= *limits.TiltMin && -1 == command.Tilt {
+		command.Tilt, command.TiltSpeed = 0, 0
+		clipped = true
+	}
+
+	if !clipped {
+		command = slowNearLimits(command, limits, at)
+	}
+
+	// leave bits the decoder doesn't model alone when nothing changed
+	if command == original {
+		return message.WithChecksum(), false
+	}
+
+	return command.Message(), clipped
+}
+
+// scales down motion toward a limit within limits.Slowdown degrees of it, so
+// the camera eases into the boundary.
+func slowNearLimits(command pelco.Command, limits *config.Limits, at Orientation) pelco.Command {
+	zone := limits.Slowdown
+	if zone <= 0 {
+		return command
+	}
+
+	scale := func(speed uint8, distance float64) uint8 {
+		if distance >= zone || 0 == speed {
+			return speed
+		}
+
+		return uint8(math.Max(1, math.Ceil(float64(speed)*math.Max(0, distance)/zone)))
+	}
+
+	if nil != limits.PanMin && nil != limits.PanMax && inPanRange(at.Pan, *limits.PanMin, *limits.PanMax) {
+		if 1 == command.Pan {
+			command.PanSpeed = scale(command.PanSpeed, wrapPan(*limits.PanMax-at.Pan))
+		} else if -1 == command.Pan {
+			command.PanSpeed = scale(command.PanSpeed, wrapPan(at.Pan-*limits.PanMin))
+		}
+	}
+
+	if nil != limits.TiltMax && 1 == command.Tilt {
+		command.TiltSpeed = scale(command.TiltSpeed, *limits.TiltMax-at.Tilt)
+	} else if nil != limits.TiltMin && -1 == command.Tilt {
+		command.TiltSpeed = scale(command.TiltSpeed, at.Tilt-*limits.TiltMin)
+	}
+
+	return command
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// returned by ReadLine when the user presses ctrl-c
+var errInterrupted = errors.New("interrupted")
+
+// LineEditor reads lines from a raw mode terminal with history and tab completion.
+type LineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	History  []string
+	Complete func(words []string) []string // candidates for the last word of words
+}
+
+func NewLineEditor(in io.Reader, out io.Writer) *LineEditor {
+	return &LineEditor{in: bufio.NewReader(in), out: out}
+}
+
+// reads a single line, echoing edits to out. returns io.EOF on ctrl-d at an empty prompt.
+func (e *LineEditor) ReadLine(prompt string) (string, error) {
+	var (
+		line    []rune
+		cursor  int
+		recall  = len(e.History) // index into history while browsing with up/down
+		pending []rune           // line being edited before browsing history
+	)
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r\033[K%s%s", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Fprintf(e.out, "\033[%dD", back)
+		}
+	}
+
+	redraw()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			text := string(line)
+			if "" != strings.TrimSpace(text) {
+				e.addHistory(text)
+			}
+			return text, nil
+		case 0x03: // ctrl-c
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 0x04: // ctrl-d
+			if 0 == len(line) {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 0x01: // ctrl-a
+			cursor = 0
+		case 0x05: // ctrl-e
+			cursor = len(line)
+		case 0x15: // ctrl-u
+			line = line[cursor:]
+			cursor = 0
+		case 0x7f, 0x08: // backspace
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor -= 1
+			}
+		case '\t':
+			line, cursor = e.completeLine(prompt, line, cursor)
+		case 0x1b: // escape sequence
+			switch e.readEscape() {
+			case 'A': // up
+				if recall > 0 {
+					if recall == len(e.History) {
+						pending = line
+					}
+					recall -= 1
+					line = []rune(e.History[recall])
+					cursor = len(line)
+				}
+			case 'B': // down
+				if recall < len(e.History) {
+					recall += 1
+					if recall == len(e.History) {
+						line = pending
+					} else {
+						line = []rune(e.History[recall])
+					}
+					cursor = len(line)
+				}
+			case 'C': // right
+				if cursor < len(line) {
+					cursor += 1
+				}
+			case 'D': // left
+				if cursor > 0 {
+					cursor -= 1
+				}
+			case '3': // delete
+				if cursor < len(line) {
+					line = append(line[:cursor], line[cursor+1:]...)
+				}
+			}
+		default:
+			if r >= 0x20 {
+				line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+				cursor += 1
+			}
+		}
+
+		redraw()
+	}
+}
+
+func (e *LineEditor) addHistory(text string) {
+	if n := len(e.History); n > 0 && e.History[n-1] == text {
+		return
+	}
+
+	e.History = append(e.History, text)
+}
+
+// reads the remainder of an ansi escape sequence and returns its final byte.
+// "\033[3~" (delete) is reported as '3'.
+func (e *LineEditor) readEscape() rune {
+	if r, _, err := e.in.ReadRune(); err != nil || ('[' != r && 'O' != r) {
+		return 0
+	}
+
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+
+	if r >= '0' && r <= '9' {
+		// consume up to the terminating '~'
+		for {
+			if next, _, err := e.in.ReadRune(); err != nil || '~' == next {
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+func (e *LineEditor) completeLine(prompt string, line []rune, cursor int) ([]rune, int) {
+	if nil == e.Complete {
+		return line, cursor
+	}
+
+	head := string(line[:cursor])
+	tail := line[cursor:]
+
+	words := strings.Fields(head)
+	if 0 == len(words) || strings.HasSuffix(head, " ") {
+		words = append(words, "")
+	}
+	word := words[len(words)-1]
+
+	candidates := e.Complete(words)
+
+	switch len(candidates) {
+	case 0:
+		return line, cursor
+	case 1:
+		head = head[:len(head)-len(word)] + candidates[0] + " "
+	default:
+		prefix := commonPrefix(candidates)
+		if len(prefix) > len(word) {
+			head = head[:len(head)-len(word)] + prefix
+		} else {
+			sort.Strings(candidates)
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		}
+	}
+
+	line = append([]rune(head), tail...)
+
+	return line, len([]rune(head))
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
+
+// returns the words that begin with prefix.
+func matchPrefix(prefix string, words []string) []string {
+	var matches []string
+
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+
+	return matches
+}
+
+// loads shell history from path. missing files are ignored.
+func loadHistory(path string) []string {
+	var history []string
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if text := scanner.Text(); "" != text {
+			history = append(history, text)
+		}
+	}
+
+	return history
+}
+
+// saves the most recent history entries to path.
+func saveHistory(path string, history []string, limit int) error {
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, text := range history {
+		fmt.Fprintln(f, text)
+	}
+
+	return nil
+}
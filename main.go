@@ -2,19 +2,19 @@ package main
 
 import (
 	"bufio"
-	"encoding/hex"
-	"errors"
+	"bytes"
 	"fmt"
 	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
 	"github.com/docopt/docopt-go"
 	"github.com/mikepb/go-serial"
 	"github.com/simulatedsimian/joystick"
 	"io"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -23,22 +23,13 @@ var (
 	BUILD_DATE string
 )
 
-// pelco d byte names
-const (
-	SYNC      = 0
-	ADDR      = 1
-	COMMAND_1 = 2
-	COMMAND_2 = 3
-	DATA_1    = 4
-	DATA_2    = 5
-	CHECKSUM  = 6
-)
-
-type PelcoDMessage [7]byte
+// frames are built and decoded by the pelco package
+type PelcoDMessage = pelco.Message
 
 type DelayedMessage struct {
 	Message PelcoDMessage
 	Delay   time.Duration
+	Move    *PTZMove // made in place of sending Message, when set
 }
 
 const (
@@ -50,6 +41,7 @@ type Axis struct {
 	Index    int32
 	Min      int32 // used for normalizing input -1.0 to 1.0
 	Max      int32
+	Center   int32 // raw value at rest
 	Deadzone int32
 	Inverted bool // flips normalized input
 }
@@ -72,15 +64,17 @@ var xbox = struct {
 	Start        uint32
 	Back         uint32
 	XBox         uint32
+	LeftStick    uint32
+	RightStick   uint32
 }{
-	Axis{0, -AxisMax, AxisMax, 8192, false}, // left analog stick
-	Axis{1, -AxisMax, AxisMax, 8192, true},
-	Axis{3, -AxisMax, AxisMax, 8192, false}, // right analog stick
-	Axis{4, -AxisMax, AxisMax, 8192, true},
-	Axis{2, -AxisMax, AxisMax, 1000, false}, // triggers
-	Axis{5, -AxisMax, AxisMax, 1000, false},
-	Axis{6, -AxisMax, AxisMax, 1000, false}, // directional pad
-	Axis{7, -AxisMax, AxisMax, 1000, false},
+	Axis{0, -AxisMax, AxisMax, 0, 8192, false}, // left analog stick
+	Axis{1, -AxisMax, AxisMax, 0, 8192, true},
+	Axis{3, -AxisMax, AxisMax, 0, 8192, false}, // right analog stick
+	Axis{4, -AxisMax, AxisMax, 0, 8192, true},
+	Axis{2, -AxisMax, AxisMax, 0, 1000, false}, // triggers
+	Axis{5, -AxisMax, AxisMax, 0, 1000, false},
+	Axis{6, -AxisMax, AxisMax, 0, 1000, false}, // directional pad
+	Axis{7, -AxisMax, AxisMax, 0, 1000, false},
 	1 << 4, // bumpers
 	1 << 5,
 	1 << 0, // A
@@ -90,6 +84,23 @@ var xbox = struct {
 	1 << 7, // start
 	1 << 6, // back
 	1 << 8, // xbox button
+	1 << 9, // analog sticks pressed
+	1 << 10,
+}
+
+// names for xbox buttons, as used by bindings in the config file
+var xboxButtonNames = map[string]uint32{
+	"a":            xbox.A,
+	"b":            xbox.B,
+	"x":            xbox.X,
+	"y":            xbox.Y,
+	"start":        xbox.Start,
+	"back":         xbox.Back,
+	"xbox":         xbox.XBox,
+	"left-bumper":  xbox.LeftBumper,
+	"right-bumper": xbox.RightBumper,
+	"left-stick":   xbox.LeftStick,
+	"right-stick":  xbox.RightStick,
 }
 
 // map xbox controller to pan-tilt-zoom controls and misc app controls
@@ -97,6 +108,9 @@ var ptz = struct {
 	// pan tilt zoom
 	PanX      Axis
 	PanY      Axis
+	FinePanY  Axis // tilt, in fine-adjust mode
+	TrimX     Axis // low speed trim, in fine-adjust mode
+	TrimY     Axis
 	ZoomIn    uint32
 	ZoomOut   uint32
 	OpenIris  uint32
@@ -109,67 +123,240 @@ var ptz = struct {
 	ResetTimer   uint32
 	MarkLeft     Axis
 	MarkRight    Axis
+	StopAll      uint32 // chord. all buttons must be pressed
+	SwapAxes     uint32
 }{
 	xbox.LeftAxisX,   // pan x
 	xbox.RightAxisY,  // pan y
+	xbox.LeftAxisY,   // fine pan y
+	xbox.RightAxisX,  // trim x
+	xbox.RightAxisY,  // trim y
 	xbox.LeftBumper,  // zoom in
 	xbox.RightBumper, // zoom out
 	xbox.A,           // open iris (enter)
 	xbox.B,           // close iris
 	xbox.Start,       // open menu
 
-	xbox.Y,            // increment pelco address
-	xbox.X,            // decrement pelco address
-	xbox.Back,         // reset timer
-	xbox.LeftTrigger,  // mark
-	xbox.RightTrigger, // mark
+	xbox.Y,                           // increment pelco address
+	xbox.X,                           // decrement pelco address
+	xbox.Back,                        // reset timer
+	xbox.LeftTrigger,                 // mark
+	xbox.RightTrigger,                // mark
+	xbox.LeftStick | xbox.RightStick, // emergency stop all cameras
+	xbox.XBox,                        // swap sticks for pan and tilt
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Summary: "drive cameras from the joystick and every configured source",
+		Usage: []string{"[-l ADDR [--tls-cert FILE --tls-key FILE]] [-j JOYSTICK] [-r FILE] [--fine] [--swap] " +
+			"[--follow ADDR] [--osc ADDR] [--control ADDR] [--keyboard FILE]"},
+		Options: []string{
+			listenOption,
+			"--tls-cert FILE          - serve the API over TLS with certificate FILE.",
+			"--tls-key FILE           - private key for --tls-cert.",
+			joystickOption,
+			"-r, --record FILE        - record rs485 commands to file. (default = /dev/null)",
+			"--fine                   - left stick pans and tilts, right stick trims at low speed.",
+			"--swap                   - swap the sticks used for pan and tilt. toggled by the xbox button.",
+			"--follow ADDR            - steer toward tracker targets received on udp ADDR, e.g. :9000, or onvif for the camera's own analytics.",
+			"--osc ADDR               - accept OSC from control surfaces on udp ADDR, e.g. :9001.",
+			"--control ADDR           - accept plain text commands on tcp ADDR, e.g. :9002.",
+			"--keyboard FILE          - merge frames from a Pelco keyboard wired to serial port FILE.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			interactive(conf, func() config.Config { return config.Load(arguments) })
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "playback",
+		Summary: "replay a recording from stdin",
+		Usage: []string{"[--max-delay DURATION] [--from OFFSET] [--to OFFSET] [--only-address LIST | --only-camera LIST] " +
+			"[--verify | --confirm [-j JOYSTICK]] [--progress]"},
+		Options: []string{
+			"--max-delay DURATION     - shorten idle gaps in playback to DURATION, e.g. 5s.",
+			"--from OFFSET            - start playback OFFSET into the recording, e.g. 00:05:00.",
+			"--to OFFSET              - end playback OFFSET into the recording.",
+			"--only-address LIST      - play only frames for the comma separated addresses.",
+			"--only-camera LIST       - play only frames for the comma separated camera names.",
+			"--verify                 - play against a simulated camera and print its trajectory.",
+			"--confirm                - stop at each mark until enter or the confirm buttons are pressed.",
+			joystickOption,
+			"--progress               - show playback progress, the next mark, and the estimated end.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			playback(conf, PlaybackOptions{
+				MaxDelay: durationArg(arguments, "--max-delay", 0),
+				From:     stringArg(arguments, "--from"),
+				To:       stringArg(arguments, "--to"),
+				Only:     onlyArgs(conf, arguments),
+				Verify:   arguments["--verify"].(bool),
+				Progress: arguments["--progress"].(bool),
+				Confirm:  arguments["--confirm"].(bool),
+			})
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "stop",
+		Summary: "stop every camera",
+		Usage:   []string{""},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			emergencyStop(conf)
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "mapping",
+		Summary: "copy the controller settings to or from FILE",
+		Usage:   []string{"(export | import) FILE"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			mapping(arguments["export"].(bool), arguments["FILE"].(string))
+		},
+	})
 }
 
 func main() {
+	command := selectCommand(os.Args[1:])
+
+	arguments, err := docopt.Parse(command.Doc(), nil, true, version(), false)
+
+	// fail if arguments failed to parse
+	if err != nil {
+		panic(err)
+	}
+
+	// docopt can't keep these apart once they're global
+	if true == arguments["--verbose"] && true == arguments["--quiet"] {
+		printError("-v and -q can't be used together\n")
+		os.Exit(1)
+	}
+	if nil != arguments["--address"] && nil != arguments["--camera"] {
+		printError("-a and --camera can't be used together\n")
+		os.Exit(1)
+	}
+
+	conf := config.Load(arguments)
+
+	if !isValidColorMode(conf.Color) {
+		printError("invalid color mode (%s). expected auto, never, or always.\n", conf.Color)
+		os.Exit(1)
+	}
+	setColorMode(conf.Color)
+
+	if name := stringArg(arguments, "--camera"); "" != name {
+		if conf.Address, err = parseCamera(conf, name); err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := checkProtocols(conf); err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	if _, ok := conf.FindProfile(conf.Profile); "" != conf.Profile && !ok {
+		printError("unknown profile %s\n", conf.Profile)
+		os.Exit(1)
+	}
+
+	command.Run(conf, arguments)
+}
+
+func calibrationArgs(arguments map[string]interface{}) ([]int, float64, float64) {
 	var (
-		err       error
-		arguments map[string]interface{}
+		speeds = defaultCalibrationSpeeds
+		err    error
 	)
 
-	usage := `CCTV Pan-Tilt-Zoom via Xbox Controller
+	if text, ok := arguments["--speeds"].(string); ok {
+		if speeds, err = parseSpeeds(text); err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	panAngle := floatArg(arguments, "--pan-angle", 360)
+	tiltAngle := floatArg(arguments, "--tilt-angle", 90)
 
-  Usage:
-  cctv-ptz [-v] [-a ADDRESS] [-s FILE] [-j JOYSTICK] [-r FILE] [-b BAUD] [-m MAXSPEED]
-  cctv-ptz playback [-a ADDRESS] [-s FILE] [-b BAUD] [-v]
-  cctv-ptz -h
-  cctv-ptz -V
+	if panAngle <= 0 || tiltAngle <= 0 {
+		printError("reference angles must be positive\n")
+		os.Exit(1)
+	}
 
-  Options:
-  -a, --address ADDRESS    - Pelco-D address 0-256. (default = 0)
-  -b, --baud BAUD          - set baud rate of serial port. (default = 9600)
-  -j, --joystick JOYSTICK  - use joystick NUM (e.g. /dev/input/jsNUM). (default = 0)
-  -m, --maxspeed MAXSPEED  - set max speed setting 0-100. (default = 100)
-  -s, --serial FILE        - assign serial port for rs485 output. (default = /dev/sttyUSB0)
-  -r, --record FILE        - record rs485 commands to file. (default = /dev/null)
-  -v, --verbose            - prints Pelco-D commands to stdout.
-  -h, --help               - print this help message.
-  -V, --version            - print version info.
-  `
+	return speeds, panAngle, tiltAngle
+}
 
-	arguments, err = docopt.Parse(usage, nil, true, version(), false)
+// returns the float value of an option, or fallback when it was not given.
+// exits on a malformed value.
+func floatArg(arguments map[string]interface{}, name string, fallback float64) float64 {
+	text, ok := arguments[name].(string)
+	if !ok {
+		return fallback
+	}
 
-	// fail if arguments failed to parse
+	value, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		panic(err)
+		printError("invalid %s %s\n", name, text)
+		os.Exit(1)
 	}
 
-	conf := config.Load(arguments)
+	return value
+}
 
-	if arguments["playback"].(bool) {
-		playback(conf)
-	} else {
-		interactive(conf)
+// returns the addresses given by --only-address and --only-camera, comma
+// separated. exits on an unknown camera.
+func onlyArgs(conf config.Config, arguments map[string]interface{}) []int {
+	var addresses []int
+
+	for _, name := range []string{"--only-address", "--only-camera"} {
+		if "" == stringArg(arguments, name) {
+			continue
+		}
+
+		for _, field := range strings.Split(stringArg(arguments, name), ",") {
+			address, err := parseCamera(conf, strings.TrimSpace(field))
+			if err != nil {
+				printError("%s\n", err)
+				os.Exit(1)
+			}
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// returns the value of an option, or "" when it was not given.
+func stringArg(arguments map[string]interface{}, name string) string {
+	text, _ := arguments[name].(string)
+	return text
+}
+
+// returns the duration value of an option, or fallback when it was not given.
+// exits on a malformed value.
+func durationArg(arguments map[string]interface{}, name string, fallback time.Duration) time.Duration {
+	text, ok := arguments[name].(string)
+	if !ok {
+		return fallback
+	}
+
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		printError("invalid %s %s\n", name, text)
+		os.Exit(1)
 	}
+
+	return value
 }
 
+// ports are opened for reading too, so replies can be shown and recorded
+// whether or not --ack tracks them.
 func createSerialOptions(conf config.Config) serial.Options {
 	return serial.Options{
-		Mode:        serial.MODE_WRITE,
+		Mode:        serial.MODE_READ_WRITE,
 		BitRate:     conf.BaudRate,
 		DataBits:    8,
 		StopBits:    1,
@@ -178,40 +365,24 @@ func createSerialOptions(conf config.Config) serial.Options {
 	}
 }
 
-func decodeMessage(text string) (PelcoDMessage, error) {
+// runs the joystick and every other command source until stdin ends the
+// session. reload rereads the config on SIGHUP.
+func interactive(conf config.Config, reload func() config.Config) {
 	var (
-		bytes []byte
-		err   error
-	)
-
-	message := PelcoDMessage{}
-	if bytes, err = hex.DecodeString(text); err != nil {
-		return message, err
-	}
-
-	copy(message[:], bytes)
-
-	return message, nil
-}
-
-func interactive(conf config.Config) {
-	var (
-		record          *os.File
-		tty             *serial.Port
-		jsObserver      <-chan joystick.State
-		err             error
-		resetTimer      = true
-		serialEnabled   = ("/dev/null" != conf.SerialPort)
-		hasSerialAccess bool
+		record     io.WriteCloser
+		line       *SerialLine
+		jsObserver <-chan joystick.State
+		err        error
+		resetTimer = true
+		swapHeld   = false
 	)
 
 	stdinObserver := listenFile(os.Stdin)
 
-	js, err := joystick.Open(conf.JoystickNumber)
+	// without a joystick the api and other sources still drive the cameras
+	js, layout, err := openJoystick(conf)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "cctv-ptz: error opening joystick %d. %s\n", conf.JoystickNumber, err)
-
-		jsObserver = listenNothing()
+		printError("error opening joystick %d. %s\n", conf.JoystickNumber, err)
 	} else {
 		defer js.Close()
 
@@ -219,60 +390,670 @@ func interactive(conf config.Config) {
 		fmt.Fprintf(os.Stderr, "  Joystick Name: %s\n", js.Name())
 		fmt.Fprintf(os.Stderr, "     Axis Count: %d\n", js.AxisCount())
 		fmt.Fprintf(os.Stderr, "   Button Count: %d\n", js.ButtonCount())
+		if "" != layout {
+			fmt.Fprintf(os.Stderr, "        Mapping: %s\n", layout)
+		}
+
+		applyAxisCalibration(conf.JoystickAxes)
 
 		jsTicker := time.NewTicker(100 * time.Millisecond)
 		jsObserver = listenJoystick(js, jsTicker)
 	}
 
-	hasSerialAccess, err = serialPortAvailable(conf.SerialPort)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cctv-ptz: cannot open serial port (%s). %s\n", conf.SerialPort, err)
+	if line, err = openSerialLine(conf); err != nil {
+		printError("unable to open tty: %s. %s\n", conf.ForCamera(conf.Address).SerialPort, err)
+		os.Exit(1)
 	}
+	defer line.Close()
 
-	if serialEnabled && hasSerialAccess {
-		ttyOptions := createSerialOptions(conf)
+	startCameras(line.Send, conf)
+	defer stopCameras(line.Send, conf)
 
-		tty, err = ttyOptions.Open(conf.SerialPort)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "cctz-ptz: unable to open tty: %s\n", conf.SerialPort)
-			os.Exit(1)
+	// motion frames leaving cameras moving in the recording, restated at the
+	// start of each rotated file so it plays on its own
+	recordedMotion := make(map[uint8]PelcoDMessage)
+
+	// frames to VISCA cameras are recorded as the commands they were sent as
+	recordEncoder := newFrameEncoder(conf)
+
+	record, err = openRecord(conf, func(w io.Writer, from string) {
+		if err := writeRecordingHeader(w, conf, time.Now()); err != nil {
+			printError("unable to write recording header. %s\n", err)
+		}
+		if "" == from {
+			return
+		}
+		fmt.Fprintf(w, "# Rotated from %s\n", from)
+		for _, motion := range recordedMotion {
+			fmt.Fprintf(w, "pelco-d %x 0\n", motion)
 		}
-		defer tty.Close()
+	})
+	if err != nil {
+		printError("unable to open record file %s. %s\n", conf.RecordFile, err)
+		os.Exit(1)
+	}
+	defer record.Close()
 
-		printSerialPortInfo(conf, tty)
-	} else {
-		fmt.Fprintf(os.Stderr, "cctv-ptz: serial port disabled\n")
+	auxBindings, err := newAuxBindings(conf.Aux)
+	if err != nil {
+		printError("invalid aux binding. %s\n", err)
+		os.Exit(1)
+	}
+
+	speedCycler, err := newSpeedCycler(conf)
+	if err != nil {
+		printError("invalid speed buttons. %s\n", err)
+		os.Exit(1)
+	}
+
+	presetSteppers, err := newPresetSteppers(conf.PresetSteps)
+	if err != nil {
+		printError("invalid preset step. %s\n", err)
+		os.Exit(1)
+	}
+
+	actionBindings, err := newActionBindings(conf.ButtonActions)
+	if err != nil {
+		printError("invalid button action. %s\n", err)
+		os.Exit(1)
 	}
 
-	if "-" == conf.RecordFile {
-		record = os.Stdout
+	// pace address changes while the buttons are held
+	addressDown := Repeater{Delay: conf.AddressRepeat.Delay, Interval: conf.AddressRepeat.Interval}
+	addressUp := addressDown
+
+	startTime := time.Now()
+	clockStart := startTime
+
+	// output is held back briefly after an emergency stop
+	suppressUntil := time.Time{}
+
+	// last frame sent to each address, to skip repeats
+	lastMessages := make(map[uint8]PelcoDMessage)
+	dash := Dashboard{Message: pelco.New().To(conf.Address).Build()}
+
+	// share operator state with api observers
+	hub := NewStateHub()
+	hub.Publish(newState(conf, dash))
+
+	// tell switchers and camera operators which head is live
+	tally, err := startTally(conf, hub)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer tally.Close()
+
+	// decide between the joystick and other command sources
+	arbiter := NewArbiter(conf.JoystickHoldoff)
+
+	// dead reckon camera orientation, picking up where the last run left off
+	estimator := NewEstimator(conf)
+	statePath := conf.StateFile
+	if "" == statePath {
+		statePath = defaultStatePath()
+	}
+
+	if saved, err := loadCameraState(statePath); err != nil {
+		printError("ignoring saved camera state. %s\n", err)
 	} else {
-		if record, err = os.Create(conf.RecordFile); err != nil {
-			panic(err)
+		estimator.Restore(saved, time.Now())
+	}
+
+	savedChanges := -1
+	saveState := func() {
+		if "/dev/null" == statePath || !estimator.Changed(savedChanges) {
+			return
+		}
+
+		var snapshot []Orientation
+		snapshot, savedChanges = estimator.Snapshot(time.Now())
+		if err := saveCameraState(statePath, snapshot); err != nil {
+			printError("unable to save camera state. %s\n", err)
 		}
 	}
-	defer record.Close()
+	defer saveState()
+
+	// bounded, so a flood of api commands is refused rather than queued
+	apiCommands := make(chan Command, conf.CommandQueue)
+	startAPIServer(conf, hub, arbiter, apiCommands, estimator)
+
+	// call presets as OBS scenes go to program, through the same queue
+	obs := startOBS(conf, arbiter, apiCommands)
+
+	// day/night profiles override settings from the config file
+	baseConf := conf
+	profile, hasProfile := conf.ActiveProfile(time.Now())
+	if hasProfile {
+		conf = baseConf.WithProfile(profile)
+		line.SetConfig(conf)
+		recordEncoder.SetConfig(conf)
+		dash.Profile = profile.Name
+	}
 
-	// limit rate at which Pelco address may change via joystick
-	allowAddressChange := make(chan struct{}, 1)
-	allowAddressChange <- struct{}{} // prime channel to allow first address change
+	// steer toward targets reported by an external tracker
+	follower := Follower{Gain: conf.FollowGain, Deadband: conf.FollowDeadband, MaxSpeed: conf.MaxSpeed}
+	followObserver, err := startFollow(conf)
+	if err != nil {
+		printError("unable to follow on %s. %s\n", conf.Follow, err)
+		os.Exit(1)
+	}
 
-	startTime := time.Now()
+	// take commands from control surfaces, room controllers, keyboards, and
+	// any other registered input source
+	inputs, err := startInputs(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
 
-	lastMessage := PelcoDMessage{}
+	// motion frames leaving cameras moving, by input source, to stop if the
+	// source goes away
+	inputMoving := make(map[string]map[uint8]PelcoDMessage)
+
+	// correlate camera replies with the frames that caused them. replies
+	// are read whether or not they are tracked, to show and record them
+	acks := NewAckTracker(500 * time.Millisecond)
+	responseObserver := line.Responses()
+
+	// frames for different addresses take turns on the bus
+	frames := NewInterleaver(func(message PelcoDMessage) time.Duration {
+		return frameSlot(conf.ForCamera(int(message[pelco.Addr])).BaudRate)
+	})
+	var slotReady <-chan time.Time
+
+	// sends the next queued frame, if the bus is free, and waits for the
+	// following slot while frames remain
+	sendQueued := func() {
+		slotReady = nil
+
+		if message, ok := frames.Next(time.Now()); ok {
+			line.Send(message)
+			if conf.Ack && line.Connected() {
+				acks.Sent(message, time.Now())
+			}
+		}
+
+		if wait, pending := frames.Wait(time.Now()); pending {
+			slotReady = time.After(wait)
+		}
+	}
+
+	// the last frame sent before soft limits were applied
+	requested := dash.Message
+
+	// sends message and records it with the delay since the previous message
+	transmit := func(message PelcoDMessage) {
+		var millis int64
+
+		requested = message
+		camera, _ := conf.Camera(int(message[pelco.Addr]))
+		message, dash.Limited = clipToLimits(message, camera.Limits, estimator.Orientation(int(message[pelco.Addr]), time.Now()))
+
+		if resetTimer {
+			millis = 0
+			resetTimer = false
+			startTime = time.Now()
+			clockStart = startTime
+		} else {
+			endTime := time.Now()
+			millis = (endTime.Sub(startTime)).Nanoseconds() / 1e6
+			startTime = endTime
+		}
+
+		frames.Add(message)
+		sendQueued()
+
+		estimator.Observe(message, time.Now())
+
+		acks.Expire(time.Now())
+		dash.Message = message
+		dash.Ack = acks.State(message[pelco.Addr])
+		dash.Elapsed = time.Since(clockStart)
+		dash.Owner = arbiter.Owner(time.Now())
+		dash.Link = line.Link()
+		hub.Publish(newState(conf, dash))
+
+		if conf.Verbose {
+			fmt.Printf("pelco-d %x %d  %s\n", message, millis,
+				stdoutColor.Paint(ansiDim, "("+describeMessage(conf, message)+")"))
+		} else if !conf.Quiet {
+			printStatus(os.Stderr, conf, dash)
+		}
+		protocol, packet := recordEncoder.Recorded(message)
+		fmt.Fprintf(record, "%s %x %d\n", protocol, packet, millis)
+		trackMotion(recordedMotion, message)
+		updateThumbnail(conf, message)
+	}
+
+	// slows manual pan and tilt as the camera zooms in
+	scaleForZoom := func(message PelcoDMessage) PelcoDMessage {
+		address := int(message[pelco.Addr])
+		return scaleToZoom(message, conf.ForCamera(address).TeleSpeed, estimator.Orientation(address, time.Now()).Zoom)
+	}
+
+	// selects the camera and sends the frames a control surface asked for,
+	// stopping at the first frame another source's control refuses
+	runSurface := func(source Source, command SurfaceCommand) error {
+		if nil == inputMoving[source.Name] {
+			inputMoving[source.Name] = make(map[uint8]PelcoDMessage)
+		}
+		moving := inputMoving[source.Name]
+
+		if command.Select >= 0 && command.Select != conf.Address {
+			conf.Address = command.Select
+			if !conf.Quiet {
+				announceAddress(os.Stderr, conf)
+			}
+			obs.CameraSelected(conf.Address)
+		}
+
+		for _, message := range command.Messages {
+			now := time.Now()
+
+			if message.Idle() {
+				// stop unless another source took over, then let go
+				if arbiter.Allow(source, false, now) {
+					transmit(message)
+					lastMessages[message[pelco.Addr]] = message
+					trackMotion(moving, message)
+				}
+				arbiter.Release(source)
+				continue
+			}
+
+			ttl := surfaceLockTTL
+			if !message.Extended() {
+				ttl = surfaceMoveTTL
+			}
+			if err := arbiter.Acquire(source, ttl, now); err != nil {
+				return err
+			}
+
+			message = scaleForZoom(message)
+			transmit(message)
+			lastMessages[message[pelco.Addr]] = message
+			trackMotion(moving, message)
+		}
+
+		return nil
+	}
+
+	// rebuilds conf from baseConf and the profile in effect, keeping what the
+	// operator changed while running
+	reconfigure := func() {
+		address, swap, maxSpeed := conf.Address, conf.SwapAxes, conf.MaxSpeed
+
+		conf, dash.Profile = baseConf, ""
+		if hasProfile {
+			conf, dash.Profile = baseConf.WithProfile(profile), profile.Name
+		}
+		conf.Address, conf.SwapAxes = address, swap
+		if 0 != dash.Speed {
+			conf.MaxSpeed = maxSpeed
+		}
+
+		line.SetConfig(conf)
+		recordEncoder.SetConfig(conf)
+		estimator.SetConfig(conf)
+		follower.Gain, follower.Deadband, follower.MaxSpeed = conf.FollowGain, conf.FollowDeadband, conf.MaxSpeed
+	}
+
+	// SIGHUP rereads the config, SIGUSR1 writes the state to stderr
+	reloadSignal, dumpSignal := notifyControlSignals()
+
+	// keep the elapsed time on the dashboard ticking while the joystick is idle
+	statusTicker := time.NewTicker(time.Second)
+	defer statusTicker.Stop()
+
+	// slow and stop cameras near soft limits, whoever is moving them
+	limitTicker := time.NewTicker(100 * time.Millisecond)
+	defer limitTicker.Stop()
+
+	// warn before a wireless controller goes flat. the warning is printed
+	// even when quiet, since it can't wait for someone to glance at the status
+	batteryTicker := time.NewTicker(batteryInterval)
+	defer batteryTicker.Stop()
+
+	batteryLow := false
+	checkBattery := func() {
+		battery, ok := readBattery(conf.JoystickNumber)
+		if !ok {
+			dash.Battery = nil
+			return
+		}
+
+		dash.Battery = &battery
+
+		low := battery.Low(conf.BatteryLow)
+		if low && !batteryLow {
+			announceBattery(os.Stderr, battery)
+		}
+		batteryLow = low
+	}
+	checkBattery()
 
 	for {
 		select {
-		case <-stdinObserver:
-			return
-		case state := <-jsObserver:
+		case text, ok := <-stdinObserver:
+			// an empty line quits. otherwise select the named or numbered camera
+			if ok && 0 == len(text) {
+				return
+			}
+
+			// without a terminal, e.g. run as a service, keep serving the
+			// joystick and network sources until killed
+			if !ok {
+				stdinObserver = nil
+				if nil == jsObserver && !hasRemoteSources(conf) && !inputs.Running() {
+					return
+				}
+				continue
+			}
+
+			address, err := parseCamera(conf, strings.TrimSpace(string(text)))
+			if err != nil {
+				printError("%s\n", err)
+			} else if address != conf.Address {
+				conf.Address = address
+				if !conf.Quiet {
+					announceAddress(os.Stderr, conf)
+				}
+				obs.CameraSelected(conf.Address)
+			}
+		case <-slotReady:
+			sendQueued()
+		case <-statusTicker.C:
+			saveState()
+
+			if next, ok := baseConf.ActiveProfile(time.Now()); ok && (!hasProfile || next.Name != profile.Name) {
+				profile, hasProfile = next, true
+				reconfigure()
+
+				if !conf.Quiet {
+					announceProfile(os.Stderr, profile)
+				}
+				fmt.Fprintf(record, "# Profile %s\n", profile.Name)
+
+				// move cameras to the profile's presets unless someone is driving
+				if "" == arbiter.Owner(time.Now()) {
+					for _, message := range profilePresets(conf, profile) {
+						transmit(message)
+					}
+				}
+			}
+
+			// correct the orientation estimate, without recording the queries
+			if conf.Ack && conf.PollPosition {
+				frames.Add(pelco.New().To(conf.Address).Query(pelco.QueryPan).Build())
+				frames.Add(pelco.New().To(conf.Address).Query(pelco.QueryTilt).Build())
+				frames.Add(pelco.New().To(conf.Address).Query(pelco.QueryZoom).Build())
+				sendQueued()
+			}
+
+			acks.Expire(time.Now())
+			dash.Position = positionReadout(estimator.Orientation(conf.Address, time.Now()), time.Now())
+			dash.Ack = acks.State(dash.Message[pelco.Addr])
+			dash.Elapsed = time.Since(clockStart)
+			dash.Owner = arbiter.Owner(time.Now())
+			dash.Link = line.Link()
+			hub.Publish(newState(conf, dash))
+
+			if !conf.Verbose && !conf.Quiet {
+				printStatus(os.Stderr, conf, dash)
+			}
+		case <-batteryTicker.C:
+			checkBattery()
+		case <-reloadSignal:
+			next := reload()
+
+			// a broken edit keeps the config that was working
+			nextAux, err := newAuxBindings(next.Aux)
+			if err != nil {
+				printError("config not reloaded. invalid aux binding. %s\n", err)
+				continue
+			}
+			nextCycler, err := newSpeedCycler(next)
+			if err != nil {
+				printError("config not reloaded. invalid speed buttons. %s\n", err)
+				continue
+			}
+			nextSteppers, err := newPresetSteppers(next.PresetSteps)
+			if err != nil {
+				printError("config not reloaded. invalid preset step. %s\n", err)
+				continue
+			}
+			nextActions, err := newActionBindings(next.ButtonActions)
+			if err != nil {
+				printError("config not reloaded. invalid button action. %s\n", err)
+				continue
+			}
+
+			if changed := restartSettings(baseConf, next); 0 != len(changed) {
+				printError("restart to apply changes to %s\n", strings.Join(changed, ", "))
+			}
+
+			auxBindings, speedCycler, presetSteppers, actionBindings = nextAux, nextCycler, nextSteppers, nextActions
+			addressDown = Repeater{Delay: next.AddressRepeat.Delay, Interval: next.AddressRepeat.Interval}
+			addressUp = addressDown
+			if nil == speedCycler {
+				dash.Speed = 0
+			}
+			applyAxisCalibration(next.JoystickAxes)
+			if isValidColorMode(next.Color) {
+				setColorMode(next.Color)
+			}
+
+			baseConf = next
+			profile, hasProfile = baseConf.ActiveProfile(time.Now())
+			reconfigure()
+
+			fmt.Fprintf(record, "# Reloaded config\n")
+			if !conf.Quiet {
+				announceReload(os.Stderr)
+			}
+		case <-dumpSignal:
+			dump := dash
+			dump.Owner = arbiter.Owner(time.Now())
+			dump.Elapsed = time.Since(clockStart)
+			dumpState(os.Stderr, conf, dump, lastMessages, estimator)
+		case <-limitTicker.C:
+			// soft limits are a safety stop, so they bypass arbitration
+			camera, _ := conf.Camera(int(requested[pelco.Addr]))
+			if requested.Idle() {
+				continue
+			}
+			if limited, _ := clipToLimits(requested, camera.Limits, estimator.Orientation(int(requested[pelco.Addr]), time.Now())); limited != dash.Message {
+				transmit(requested)
+			}
+		case response := <-responseObserver:
+			estimator.Report(response, time.Now())
+			acks.Received(response)
+			dash.Ack = acks.State(dash.Message[pelco.Addr])
+			dash.Position = positionReadout(estimator.Orientation(conf.Address, time.Now()), time.Now())
+			hub.Publish(newState(conf, dash))
+
+			// only Pelco-D cameras send these frames. anything else is what
+			// another protocol's reply happened to look like
+			if settings := conf.ForCamera(int(response.Address)); "pelco-d" != settings.Protocol || "" != networkCamera(settings) {
+				continue
+			}
+			if conf.Verbose {
+				fmt.Printf("reply %x  %s\n", response.Raw, stdoutColor.Paint(ansiDim, "("+describeResponse(conf, response)+")"))
+			}
+			fmt.Fprintf(record, "# Reply %x %s\n", response.Raw, describeResponse(conf, response))
+		case command := <-apiCommands:
+			if arbiter.Allow(command.Source, !command.Message.Idle(), time.Now()) {
+				transmit(command.Message)
+				lastMessages[command.Message[pelco.Addr]] = command.Message
+			}
+		case target := <-followObserver:
+			now := time.Now()
+			pan, tilt := follower.Speeds(target)
+			message := pelco.New().To(conf.Address).Pan(pan).Tilt(tilt).Build()
+
+			if message.Idle() {
+				// stop unless another source took over, then let go
+				if arbiter.Allow(followSource, false, now) && lastMessages[message[pelco.Addr]] != message {
+					transmit(message)
+					lastMessages[message[pelco.Addr]] = message
+				}
+				arbiter.Release(followSource)
+				continue
+			}
+
+			// outlives the tracker timeout so the lost target's stop is allowed
+			if nil != arbiter.Acquire(followSource, 2*followTimeout, now) {
+				continue
+			}
+
+			if lastMessages[message[pelco.Addr]] != message {
+				transmit(message)
+				lastMessages[message[pelco.Addr]] = message
+			}
+		case input := <-inputs.Commands:
+			command, err := input.Command(conf)
+			if nil == err {
+				err = runSurface(input.Source, command)
+			}
+
+			if nil != input.Reply {
+				input.Reply <- err
+			} else if err != nil {
+				printError("%s: %s\n", input.Source.Name, err)
+			}
+		case source := <-inputs.Ended:
+			// don't leave its cameras moving
+			if arbiter.Allow(source, false, time.Now()) {
+				for address := range inputMoving[source.Name] {
+					message := pelco.New().To(int(address)).Build()
+					transmit(message)
+					lastMessages[message[pelco.Addr]] = message
+				}
+			}
+			arbiter.Release(source)
+			delete(inputMoving, source.Name)
+
+			if nil == stdinObserver && nil == jsObserver && !hasRemoteSources(conf) && !inputs.Running() {
+				return
+			}
+		case state, ok := <-jsObserver:
+			// an unplugged controller stops the camera it was moving and
+			// leaves the cameras to the other sources
+			if !ok {
+				jsObserver = nil
+				printError("joystick %d lost. continuing without it\n", conf.JoystickNumber)
+
+				if joystickSource.Name == arbiter.Owner(time.Now()) {
+					message := pelco.New().To(conf.Address).Build()
+					transmit(message)
+					lastMessages[message[pelco.Addr]] = message
+				}
+				arbiter.Release(joystickSource)
+
+				if nil == stdinObserver && !hasRemoteSources(conf) && !inputs.Running() {
+					return
+				}
+				continue
+			}
+
+			// emergency stop overrides everything, including arbitration
+			if isChordPressed(state, ptz.StopAll) && time.Now().After(suppressUntil) {
+				frames.Clear()
+				count := stopAll(line.Send, conf)
+				suppressUntil = time.Now().Add(time.Second)
+				for _, address := range allAddresses(conf) {
+					lastMessages[uint8(address)] = pelco.New().To(address).Build()
+				}
+
+				fmt.Fprintf(record, "# Emergency stop\n")
+				if !conf.Quiet {
+					announceStop(os.Stderr, count)
+				}
+			}
+
+			if time.Now().Before(suppressUntil) {
+				continue
+			}
+
+			// configured aux bindings claim their buttons before the ptz mapping sees them
+			for i := range auxBindings {
+				if command, ok := auxBindings[i].Update(&state); ok {
+					if arbiter.Allow(joystickSource, true, time.Now()) {
+						transmit(pelco.New().To(conf.Address).Extended(command, 0x00, auxBindings[i].Aux).Build())
+					}
+				}
+			}
+
+			// as do preset steps, from the last preset the camera was sent to
+			for i := range presetSteppers {
+				if !presetSteppers[i].Update(&state, time.Now()) {
+					continue
+				}
+
+				current := estimator.Orientation(conf.Address, time.Now()).Preset
+				number, ok := nextPreset(conf, conf.Address, current, presetSteppers[i].Step)
+				if ok && arbiter.Allow(joystickSource, true, time.Now()) {
+					transmit(pelco.New().To(conf.Address).CallPreset(uint8(number)).Build())
+				}
+			}
+
+			// and button actions
+			for i := range actionBindings {
+				if !actionBindings[i].Update(&state, time.Now()) {
+					continue
+				}
+
+				messages, err := actionBindings[i].Messages(conf, conf.Address)
+				if err != nil {
+					printError("button action. %s\n", err)
+					continue
+				}
+				if arbiter.Allow(joystickSource, true, time.Now()) {
+					for _, message := range messages {
+						transmit(message)
+					}
+				}
+			}
+
+			if nil != speedCycler {
+				if percent, ok := speedCycler.Update(&state, time.Now()); ok {
+					conf.MaxSpeed, dash.Speed = speedFromPercent(percent), percent
+					if !conf.Verbose && !conf.Quiet {
+						printStatus(os.Stderr, conf, dash)
+					}
+				}
+			}
+
 			// adjust Pelco address
-			if isPressed(state, ptz.DecPelcoAddr) {
-				limitChange(allowAddressChange, func() { conf.Address -= 1 })
-			} else if isPressed(state, ptz.IncPelcoAddr) {
-				limitChange(allowAddressChange, func() { conf.Address += 1 })
+			previousAddress := conf.Address
+
+			down := addressDown.Fire(isPressed(state, ptz.DecPelcoAddr), time.Now())
+			up := addressUp.Fire(isPressed(state, ptz.IncPelcoAddr), time.Now())
+
+			if down {
+				conf.Address = stepAddress(conf, -1)
+			} else if up {
+				conf.Address = stepAddress(conf, 1)
+			}
+
+			if previousAddress != conf.Address {
+				if !conf.Quiet {
+					announceAddress(os.Stderr, conf)
+				}
+				obs.CameraSelected(conf.Address)
 			}
 
+			// swap sticks on press, not while held
+			swapPressed := isPressed(state, ptz.SwapAxes)
+			if swapPressed && !swapHeld {
+				conf.SwapAxes = !conf.SwapAxes
+				if !conf.Verbose && !conf.Quiet {
+					printStatus(os.Stderr, conf, dash)
+				}
+			}
+			swapHeld = swapPressed
+
 			// reset the clock if user presses Back
 			if isPressed(state, ptz.ResetTimer) {
 				resetTimer = true
@@ -286,36 +1067,15 @@ func interactive(conf config.Config) {
 				fmt.Fprintf(record, "# Mark Right\n")
 			}
 
-			message := pelcoCreate()
-			message = pelcoTo(message, conf.Address)
-			message = joystickToPelco(message, state, conf.MaxSpeed)
-			message = pelcoChecksum(message)
-
-			if lastMessage != message {
-				var millis int64
-
-				if resetTimer {
-					millis = 0
-					resetTimer = false
-					startTime = time.Now()
-				} else {
-					endTime := time.Now()
-					millis = (endTime.Sub(startTime)).Nanoseconds() / 1E6
-					startTime = endTime
-				}
-
-				if conf.Verbose {
-					fmt.Printf("pelco-d %x %d\n", message, millis)
-				} else {
-					fmt.Fprintf(os.Stderr, "\033[Kpelco-d %x %d\r", message, millis)
-				}
-				fmt.Fprintf(record, "pelco-d %x %d\n", message, millis)
+			message := scaleForZoom(joystickToPelco(pelco.New().To(conf.Address), state, conf).Build())
 
-				if serialEnabled {
-					tty.Write(message[:])
+			if lastMessages[message[pelco.Addr]] != message {
+				if !arbiter.Allow(joystickSource, !message.Idle(), time.Now()) {
+					continue
 				}
 
-				lastMessage = message
+				transmit(message)
+				lastMessages[message[pelco.Addr]] = message
 			}
 		}
 	}
@@ -325,11 +1085,34 @@ func isPressed(state joystick.State, mask uint32) bool {
 	return 0 != state.Buttons&mask
 }
 
-func joystickToPelco(buffer PelcoDMessage, state joystick.State, maxSpeed int32) PelcoDMessage {
+// reports whether every button in mask is pressed.
+func isChordPressed(state joystick.State, mask uint32) bool {
+	return 0 != mask && mask == state.Buttons&mask
+}
+
+func joystickToPelco(message *pelco.Builder, state joystick.State, conf config.Config) *pelco.Builder {
 	var zoom float32
 
-	panX := normalizeAxis(state, ptz.PanX)
-	panY := normalizeAxis(state, ptz.PanY)
+	axes := []Axis{ptz.PanX, ptz.PanY, ptz.FinePanY, ptz.TrimX, ptz.TrimY}
+	if conf.SwapAxes {
+		for i := range axes {
+			axes[i] = otherStick(axes[i])
+		}
+	}
+
+	panX := normalizeAxis(state, axes[0])
+	panY := normalizeAxis(state, axes[1])
+
+	// coarse motion on one stick, trim summed in from the other
+	if conf.FineAdjust {
+		trim := float32(conf.FineAdjustSpeed)
+		panX = clampAxis(panX + trim*normalizeAxis(state, axes[3]))
+		panY = clampAxis(normalizeAxis(state, axes[2]) + trim*normalizeAxis(state, axes[4]))
+	}
+
+	panX = applyCurve(panX, conf.Curve)
+	panY = applyCurve(panY, conf.Curve)
+
 	openIris := isPressed(state, ptz.OpenIris)
 	closeIris := isPressed(state, ptz.CloseIris)
 	openMenu := isPressed(state, ptz.OpenMenu)
@@ -340,26 +1123,11 @@ func joystickToPelco(buffer PelcoDMessage, state joystick.State, maxSpeed int32)
 		zoom = 1.0
 	}
 
-	buffer = pelcoApplyJoystick(buffer, panX, panY, zoom, openIris, closeIris, openMenu, maxSpeed)
-
-	return buffer
-}
-
-func limitChange(allowAddressChange chan struct{}, proc func()) {
-	select {
-	case <-allowAddressChange:
-		proc()
-
-		// delay next signal to allow change
-		go func() {
-			<-time.After(125 * time.Millisecond)
-			allowAddressChange <- struct{}{}
-		}()
-	default:
-		// do nothing
-	}
+	return applyJoystick(message, panX, panY, zoom, openIris, closeIris, openMenu, conf.MaxSpeed)
 }
 
+// sends each line of f. an empty line is sent as an empty slice and ends the
+// listening, as does the end of f, which closes the channel.
 func listenFile(f io.Reader) <-chan []byte {
 	io := make(chan []byte)
 	scanner := bufio.NewScanner(f)
@@ -371,10 +1139,11 @@ func listenFile(f io.Reader) <-chan []byte {
 			bytes := scanner.Bytes()
 
 			if len(bytes) == 0 {
+				io <- []byte{}
 				break
 			}
 
-			io <- bytes
+			io <- append([]byte(nil), bytes...)
 		}
 		if err := scanner.Err(); err != nil {
 			panic(err)
@@ -384,13 +1153,18 @@ func listenFile(f io.Reader) <-chan []byte {
 	return io
 }
 
+// polls js on every tick. the channel closes when js can't be read, e.g.
+// once unplugged.
 func listenJoystick(js joystick.Joystick, ticker *time.Ticker) <-chan joystick.State {
 	io := make(chan joystick.State, 20)
 
 	go func() {
+		defer close(io)
+		defer ticker.Stop()
+
 		for range ticker.C {
 			if state, err := js.Read(); err != nil {
-				panic(err)
+				return
 			} else {
 				io <- state
 			}
@@ -401,8 +1175,10 @@ func listenJoystick(js joystick.Joystick, ticker *time.Ticker) <-chan joystick.S
 	return io
 }
 
-func listenNothing() <-chan joystick.State {
-	return make(chan joystick.State)
+// reports whether conf has a network source, other than an input source,
+// that drives cameras without a local operator.
+func hasRemoteSources(conf config.Config) bool {
+	return "" != conf.Listen || "" != conf.Follow || "" != conf.OBS.URL
 }
 
 func isMarkTriggered(state joystick.State, axis Axis) bool {
@@ -413,131 +1189,275 @@ func isMarkTriggered(state joystick.State, axis Axis) bool {
 
 func normalizeAxis(state joystick.State, axis Axis) float32 {
 	var (
-		value    = float32(state.AxisData[axis.Index])
+		value    = float32(state.AxisData[axis.Index] - int(axis.Center))
 		deadzone = float32(axis.Deadzone)
-		max      = float32(axis.Max)
+		above    = float32(axis.Max-axis.Center) - deadzone // travel past the deadzone each way
+		below    = float32(axis.Center-axis.Min) - deadzone
 	)
 
-	if axis.Inverted {
-		value = -value
+	if value > deadzone && above > 0 {
+		value = clampAxis((value - deadzone) / above)
+	} else if value < -deadzone && below > 0 {
+		value = clampAxis((value + deadzone) / below)
+	} else {
+		value = 0
 	}
 
-	if value > 0 && value < deadzone {
-		value = 0
-	} else if value > deadzone {
-		value = (value - deadzone) / (max - deadzone)
-	} else if value < 0 && value > -deadzone {
-		value = 0
-	} else if value < -deadzone {
-		value = (value + deadzone) / (max - deadzone)
+	if axis.Inverted {
+		value = -value
 	}
 
 	return value
 }
 
-func pelcoCreate() PelcoDMessage {
-	buffer := PelcoDMessage{}
+// replaces default axis ranges with those measured by calibrate-joystick.
+func applyAxisCalibration(calibrations []config.AxisCalibration) {
+	axes := []*Axis{
+		&xbox.LeftAxisX, &xbox.LeftAxisY, &xbox.RightAxisX, &xbox.RightAxisY,
+		&xbox.LeftTrigger, &xbox.RightTrigger, &xbox.DPadX, &xbox.DPadY,
+		&ptz.PanX, &ptz.PanY, &ptz.FinePanY, &ptz.TrimX, &ptz.TrimY,
+		&ptz.MarkLeft, &ptz.MarkRight,
+	}
+
+	for _, calibration := range calibrations {
+		for _, axis := range axes {
+			if axis.Index == int32(calibration.Index) {
+				axis.Min = int32(calibration.Min)
+				axis.Max = int32(calibration.Max)
+				axis.Center = int32(calibration.Center)
+				axis.Deadzone = int32(calibration.Deadzone)
+			}
+		}
+	}
+}
 
-	buffer[SYNC] = 0xff
+// returns the same axis on the opposite analog stick. other axes are
+// returned unchanged.
+func otherStick(axis Axis) Axis {
+	switch axis {
+	case xbox.LeftAxisX:
+		return xbox.RightAxisX
+	case xbox.LeftAxisY:
+		return xbox.RightAxisY
+	case xbox.RightAxisX:
+		return xbox.LeftAxisX
+	case xbox.RightAxisY:
+		return xbox.LeftAxisY
+	}
 
-	return buffer
+	return axis
 }
 
-// should be last call before sending a pelco message
-func pelcoChecksum(buffer PelcoDMessage) PelcoDMessage {
-	buffer[CHECKSUM] = uint8(buffer[ADDR] + buffer[COMMAND_1] + buffer[COMMAND_2] + buffer[DATA_1] + buffer[DATA_2])
+// shapes a normalized axis value. exponents above 1 give finer control near
+// center while keeping full speed at full deflection.
+func applyCurve(value float32, exponent float64) float32 {
+	if exponent <= 0 || 1 == exponent {
+		return value
+	}
+
+	curved := float32(math.Pow(math.Abs(float64(value)), exponent))
+	if value < 0 {
+		return -curved
+	}
 
-	return buffer
+	return curved
 }
 
-func pelcoTo(buffer PelcoDMessage, addr int) PelcoDMessage {
-	buffer[ADDR] = uint8(addr)
-	return buffer
+// limits a summed axis value to -1.0 to 1.0
+func clampAxis(value float32) float32 {
+	if value > 1 {
+		return 1
+	} else if value < -1 {
+		return -1
+	}
+
+	return value
 }
 
-func pelcoApplyJoystick(buffer PelcoDMessage, panX, panY, zoom float32, openIris, closeIris, openMenu bool, maxSpeed int32) PelcoDMessage {
+// adds motion from normalized -1.0 to 1.0 inputs, scaled to maxSpeed.
+// positive pans right, tilts up, and zooms in.
+func applyJoystick(message *pelco.Builder, panX, panY, zoom float32, openIris, closeIris, openMenu bool, maxSpeed int32) *pelco.Builder {
 	if openMenu {
-		buffer[COMMAND_1] = 0x00
-		buffer[COMMAND_2] = 0x03
-		buffer[DATA_1] = 0x00
-		buffer[DATA_2] = 0x5F
-
-		return buffer
+		return message.SetPreset(pelco.MenuPreset)
 	}
 
+	panSpeed := uint8(float64(maxSpeed) * math.Abs(float64(panX)))
 	if panX > 0 {
-		buffer[COMMAND_2] |= 1 << 1
+		message.PanRight(panSpeed)
 	} else if panX < 0 {
-		buffer[COMMAND_2] |= 1 << 2
+		message.PanLeft(panSpeed)
 	}
 
-	// pan speed
-	buffer[DATA_1] = uint8(float64(maxSpeed) * math.Abs(float64(panX)))
-
+	tiltSpeed := uint8(float64(maxSpeed) * math.Abs(float64(panY)))
 	if panY > 0 {
-		buffer[COMMAND_2] |= 1 << 3
+		message.TiltUp(tiltSpeed)
 	} else if panY < 0 {
-		buffer[COMMAND_2] |= 1 << 4
+		message.TiltDown(tiltSpeed)
 	}
 
-	// tilt speed
-	buffer[DATA_2] = uint8(float64(maxSpeed) * math.Abs(float64(panY)))
-
 	if zoom > 0 {
-		buffer[COMMAND_2] |= 1 << 5
+		message.ZoomIn()
 	} else if zoom < 0 {
-		buffer[COMMAND_2] |= 1 << 6
+		message.ZoomOut()
 	}
 
 	if openIris {
-		buffer[COMMAND_1] |= 1 << 1
+		message.IrisOpen()
 	} else if closeIris {
-		buffer[COMMAND_1] |= 1 << 2
+		message.IrisClose()
 	}
 
-	return buffer
+	return message
+}
+
+// PlaybackOptions trims and paces a replay. From and To are offsets into the
+// recording, as durations from its start ("00:05:00", "90s") or wall clock
+// times ("2026-10-14T09:35:00") for recordings with a header. empty means the
+// start or end.
+type PlaybackOptions struct {
+	MaxDelay time.Duration
+	From     string
+	To       string
+	Only     []int // addresses to play. empty plays all
+	Verify   bool  // simulate instead of sending
+	Progress bool  // show progress on stderr. reads the whole recording first
+	Confirm  bool  // wait for the operator at each mark
 }
 
-func playback(conf config.Config) {
+func (o PlaybackOptions) Plays(address int) bool {
+	if 0 == len(o.Only) {
+		return true
+	}
+
+	for _, only := range o.Only {
+		if only == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replays a recording from stdin. gaps longer than MaxDelay while every
+// camera is stopped are shortened to MaxDelay; gaps while a camera moves are
+// kept, since cutting them would change where it ends up.
+func playback(conf config.Config, options PlaybackOptions) {
 	var (
-		message         PelcoDMessage
-		tty             *serial.Port
-		millis          uint64
-		err             error
-		serialEnabled   = ("/dev/null" != conf.SerialPort)
-		hasSerialAccess bool
+		message  PelcoDMessage
+		tty      Port
+		millis   uint64
+		err      error
+		elapsed  time.Duration         // recording time of the current frame
+		from, to time.Duration = 0, -1 // resolved once the header, if any, is read
+		started  time.Time             // from the header
+		resolved bool
+		skipping bool
+		cut      bool
+		lastSent time.Duration                   // recording time of the last frame sent
+		moving   = make(map[uint8]PelcoDMessage) // last motion frame of each moving camera
+		gate     *markGate
+		handed   time.Time // when the last frame handed to the sender goes out
 	)
 
-	hasSerialAccess, err = serialPortAvailable(conf.SerialPort)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cctv-ptz: cannot open serial port (%s). %s\n", conf.SerialPort, err)
+	if options.Confirm {
+		if gate, err = openMarkGate(conf); err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+		defer gate.Close()
 	}
 
-	if serialEnabled && hasSerialAccess {
-		ttyOptions := createSerialOptions(conf)
+	messageChannel := make(chan DelayedMessage)
+	sent := make(chan struct{})
+
+	if options.Verify {
+		go func() {
+			verifyMessages(messageChannel, conf, os.Stdout)
+			close(sent)
+		}()
+	} else {
+		if tty, err = openSerial(conf); err != nil {
+			panic(err)
+		}
+		if nil != tty {
+			defer tty.Close()
+		}
 
-		tty, err = ttyOptions.Open(conf.SerialPort)
+		outputs, err := openOutputs(conf)
 		if err != nil {
-			panic(err)
+			printError("%s\n", err)
+			os.Exit(1)
 		}
-		defer tty.Close()
+		defer outputs.Close()
 
-		printSerialPortInfo(conf, tty)
-	} else {
-		fmt.Fprintf(os.Stderr, "Serial port disabled\n")
+		go func() {
+			sendDelayedMessages(messageChannel, tty, conf, outputs)
+			close(sent)
+		}()
 	}
 
-	messageChannel := make(chan DelayedMessage)
-	defer close(messageChannel)
+	// a live recording piped in never ends, so progress is only shown when
+	// asked for
+	var (
+		input   io.Reader = os.Stdin
+		tracker *playbackTracker
+	)
+	if options.Progress && !options.Verify {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			printError("unable to read recording. %s\n", err)
+			os.Exit(1)
+		}
+		input = bytes.NewReader(data)
+
+		tracker = newPlaybackTracker(data)
+		go tracker.Report(os.Stderr)
+		defer tracker.Stop()
+	}
 
-	go sendDelayedMessages(messageChannel, tty, conf.Verbose)
+	// let the last frames go out before exiting
+	defer func() {
+		close(messageChannel)
+		<-sent
+	}()
 
 	lineCount := 0
-	lineScanner := bufio.NewScanner(os.Stdin)
+	lineNumber := 0
+	parser := newRecordParser()
+	lineScanner := bufio.NewScanner(input)
 
 	for lineScanner.Scan() {
+		lineNumber++
 		text := strings.TrimSpace(lineScanner.Text())
 
+		if header, ok, err := parseRecordingHeader(text); ok {
+			if nil == err {
+				err = checkRecordingHeader(header, conf)
+			}
+			if err != nil {
+				printError("%s\n", err)
+				os.Exit(1)
+			}
+			started = header.Started
+			continue
+		}
+
+		// hold the cameras where the mark was made until the operator is
+		// done, then set them moving again
+		if mark, ok := parseMark(text, elapsed); ok && nil != gate && resolved && !skipping {
+			for address := range moving {
+				messageChannel <- DelayedMessage{Message: pelco.New().To(int(address)).Build()}
+			}
+			time.Sleep(time.Until(handed))
+
+			gate.Wait(mark.Label)
+
+			for _, motion := range moving {
+				messageChannel <- DelayedMessage{Message: motion}
+			}
+			continue
+		}
+
 		if strings.HasPrefix(text, "#") {
 			continue
 		}
@@ -547,31 +1467,251 @@ func playback(conf config.Config) {
 		lineCount += 1
 
 		if 3 > len(words) {
-			fmt.Fprintf(os.Stderr, "cctv-ptz: error parsing playback. Too few fields.  Line %d: %s\n", lineCount, text)
+			printError("error parsing playback. Too few fields.  Line %d: %s\n", lineCount, text)
 			continue
 		}
 
-		if "pelco-d" != words[0] {
-			fmt.Fprintf(os.Stderr, "cctv-ptz: error parsing playback. Invalid protocol %s.  Line %d: %s\n", words[0], lineCount, text)
+		// moves ONVIF cameras make themselves, e.g.
+		// "absolute pan=0.5 tilt=-0.2 zoom=0.1 100", end with the delay
+		var move *PTZMove
+		duration := words[2]
+
+		if isPTZMove(words[0]) {
+			parsed, err := parsePTZMove(conf, words[:len(words)-1])
+			if err != nil {
+				printError("error parsing playback. Invalid move %s.  Line %d: %s\n", err.Error(), lineCount, text)
+				continue
+			}
+			move, duration = &parsed, words[len(words)-1]
+			message = pelco.New().To(parsed.Address).Build()
+		} else if !isRecordedProtocol(words[0]) {
+			printError("error parsing playback. Invalid protocol %s.  Line %d: %s\n", words[0], lineCount, text)
+			continue
+		} else if message, err = parser.Frame(words[0], words[1]); err != nil {
+			printError("error parsing playback. Invalid packet %s.  Line %d: %s\n", err.Error(), lineCount, text)
 			continue
 		}
 
-		if message, err = decodeMessage(words[1]); err != nil {
-			fmt.Fprintf(os.Stderr, "cctv-ptz: error parsing playback. Invalid packet %s.  Line %d: %s\n", err.Error(), lineCount, text)
+		if millis, err = strconv.ParseUint(duration, 10, 64); err != nil {
+			printError("error parsing playback. Invalid duration %s.  Line %d: %s\n", err.Error(), lineCount, text)
 			continue
 		}
 
-		if millis, err = strconv.ParseUint(words[2], 10, 64); err != nil {
-			fmt.Fprintf(os.Stderr, "cctv-ptz: error parsing playback. Invalid duration %s.  Line %d: %s\n", err.Error(), lineCount, text)
+		if !resolved {
+			if from, err = resolveOffset(options.From, started, 0); nil == err {
+				to, err = resolveOffset(options.To, started, -1)
+			}
+			if err != nil {
+				printError("%s\n", err)
+				os.Exit(1)
+			}
+			resolved, skipping = true, from > 0
+			if nil != tracker {
+				tracker.Limit(to)
+			}
+		}
+
+		elapsed += time.Duration(millis) * time.Millisecond
+
+		if to >= 0 && elapsed > to {
+			cut = true
+			break
+		}
+
+		// frames for other cameras are dropped, their delays kept
+		if !options.Plays(int(message[pelco.Addr])) {
 			continue
 		}
 
-		messageChannel <- DelayedMessage{message, time.Duration(millis) * time.Millisecond}
+		if skipping {
+			if elapsed < from {
+				if nil == move {
+					trackMotion(moving, message)
+				}
+				continue
+			}
 
-		if conf.Verbose {
-			fmt.Fprintf(os.Stderr, "%s\n", text)
+			// set cameras moving as they were at from, then wait out the rest
+			// of the gap
+			skipping = false
+			for _, motion := range moving {
+				messageChannel <- DelayedMessage{Message: motion}
+			}
+			lastSent = from
+		}
+
+		delay := elapsed - lastSent
+		previous := lastSent
+		lastSent = elapsed
+
+		if options.MaxDelay > 0 && delay > options.MaxDelay && 0 == len(moving) {
+			delay = options.MaxDelay
+		}
+
+		if nil == move {
+			trackMotion(moving, message)
+		}
+
+		messageChannel <- DelayedMessage{Message: message, Delay: delay, Move: move}
+		handed = time.Now().Add(delay)
+		if nil != tracker {
+			tracker.Handed(lineNumber, previous, elapsed)
+		}
+
+		if conf.Verbose && nil == move {
+			fmt.Fprintf(os.Stderr, "%s  %s\n", text,
+				stderrColor.Paint(ansiDim, "("+describeMessage(conf, message)+")"))
+		}
+	}
+
+	// cut off mid-recording. don't leave cameras running past To
+	if cut && !skipping {
+		stopAt := to - lastSent
+		for _, motion := range moving {
+			messageChannel <- DelayedMessage{Message: pelco.New().To(int(motion[pelco.Addr])).Build(), Delay: stopAt}
+			stopAt = 10 * time.Millisecond
+		}
+	}
+}
+
+// records the motion frames that leave cameras moving.
+func trackMotion(moving map[uint8]PelcoDMessage, message PelcoDMessage) {
+	if message.Extended() {
+		return
+	}
+
+	if message.Idle() {
+		delete(moving, message[pelco.Addr])
+	} else {
+		moving[message[pelco.Addr]] = message
+	}
+}
+
+// converts a playback offset, either a duration ("90s"), a clock style offset
+// ("00:05:00" or "05:00"), or a wall clock time compared to the recording's
+// start, to a duration into the recording. empty text returns fallback.
+func resolveOffset(text string, started time.Time, fallback time.Duration) (time.Duration, error) {
+	if "" == text {
+		return fallback, nil
+	}
+
+	if offset, err := time.ParseDuration(text); nil == err {
+		return offset, nil
+	}
+
+	if fields := strings.Split(text, ":"); len(fields) <= 3 && !strings.Contains(text, "T") {
+		var offset time.Duration
+		for _, field := range fields {
+			value, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid offset %s. expected HH:MM:SS, a duration, or a time", text)
+			}
+			offset = offset*60 + time.Duration(value)*time.Second
+		}
+		return offset, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		if at, err = time.ParseInLocation("2006-01-02T15:04:05", text, time.Local); err != nil {
+			return 0, fmt.Errorf("invalid offset %s. expected HH:MM:SS, a duration, or a time", text)
 		}
 	}
+
+	if started.IsZero() {
+		return 0, fmt.Errorf("offset %s is a time, but the recording has no header giving its start", text)
+	}
+
+	if at.Before(started) {
+		return 0, fmt.Errorf("offset %s is before the recording started (%s)", text, started.Format(time.RFC3339))
+	}
+
+	return at.Sub(started), nil
+}
+
+// opens the serial port of the camera at conf.Address and prints its settings.
+func openSerial(conf config.Config) (Port, error) {
+	conf = conf.ForCamera(conf.Address)
+
+	tty, err := openPort(conf)
+	switch port := tty.(type) {
+	case *serial.Port:
+		printSerialPortInfo(conf, port)
+	case *rs485Port:
+		printSerialPortInfo(conf, port.Port)
+		fmt.Fprintf(os.Stderr, "   RS485 RTS: raised %s before and held %s after each frame\n", port.before, port.after)
+	case fmt.Stringer:
+		fmt.Fprintf(os.Stderr, "Port opened. %s\n", port)
+	}
+
+	return tty, err
+}
+
+// opens conf.SerialPort, or returns nil when it is disabled or inaccessible.
+// a visca-ip://, tcp://, rfc2217://, or udp:// setting, an ONVIF device service, or a Sony or Hanwha CGI
+// opens a link to a camera on the network instead.
+func openPort(conf config.Config) (Port, error) {
+	if "" != conf.Onvif {
+		port, err := dialONVIF(conf.Onvif)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	if "" != conf.Sony {
+		port, err := dialSony(conf.Sony)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	if "" != conf.Hanwha {
+		port, err := dialHanwha(conf.Hanwha)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	if isVISCAIP(conf.SerialPort) {
+		port, err := dialVISCAIP(conf.SerialPort)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	if isTCPSerial(conf.SerialPort) {
+		port, err := dialTCPSerial(conf)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	if isUDPSerial(conf.SerialPort) {
+		port, err := dialUDPSerial(conf.SerialPort)
+		if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	serialEnabled := ("/dev/null" != conf.SerialPort)
+
+	hasSerialAccess, err := serialPortAvailable(conf.SerialPort)
+	if err != nil {
+		printError("cannot open serial port (%s). %s\n", conf.SerialPort, err)
+	}
+
+	if !serialEnabled || !hasSerialAccess {
+		fmt.Fprintf(os.Stderr, "Serial port disabled\n")
+		return nil, nil
+	}
+
+	return openSerialDevice(conf, conf.SerialPort)
 }
 
 func printSerialPortInfo(conf config.Config, tty *serial.Port) {
@@ -603,83 +1743,183 @@ func printSerialPortInfo(conf config.Config, tty *serial.Port) {
 	fmt.Fprintf(os.Stderr, "      Parity: %d\n", parity)
 }
 
-func sendMessage(tty *serial.Port, message PelcoDMessage) {
-	if nil != tty {
-		tty.Write(message[:])
+// returns the address of the camera named text, or the address text
+// spells out.
+func parseCamera(conf config.Config, text string) (int, error) {
+	for _, camera := range conf.Cameras {
+		if camera.Name == text {
+			return camera.Address, nil
+		}
+	}
+
+	address, err := strconv.ParseUint(text, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown camera %s", text)
 	}
+
+	return int(address), nil
 }
 
-func sendDelayedMessages(c <-chan DelayedMessage, tty *serial.Port, verbose bool) {
-	var (
-		pkg      DelayedMessage
-		lastTime time.Time
-	)
+// returns the address after (or, for a negative step, before) conf.Address.
+// with cameras configured only their addresses are visited, wrapping around.
+func stepAddress(conf config.Config, step int) int {
+	if 0 == len(conf.Cameras) {
+		return conf.Address + step
+	}
 
-	// send first message without delay
-	pkg = <-c
-	sendMessage(tty, pkg.Message)
-	lastTime = time.Now()
+	var addresses []int
+	for _, camera := range conf.Cameras {
+		addresses = append(addresses, camera.Address)
+	}
+	sort.Ints(addresses)
 
-	// all other messages are delayed wrt preceeding messages
-	for pkg = range c {
-		time.Sleep(pkg.Delay)
-		sendMessage(tty, pkg.Message)
+	if step > 0 {
+		for _, address := range addresses {
+			if address > conf.Address {
+				return address
+			}
+		}
+		return addresses[0]
+	}
 
-		if verbose {
-			duration := time.Now().Sub(lastTime) / 1E6
-			delay := pkg.Delay / 1E6
-			fmt.Fprintf(os.Stderr, "Sent %x after %d millis. target %d millis.  offset %d millis\n",
-				pkg.Message, duration, delay, duration-delay)
+	for i := len(addresses) - 1; i >= 0; i-- {
+		if addresses[i] < conf.Address {
+			return addresses[i]
 		}
+	}
 
-		lastTime = time.Now()
+	return addresses[len(addresses)-1]
+}
+
+// addresses of every configured camera, plus the current address.
+func allAddresses(conf config.Config) []int {
+	addresses := []int{conf.Address}
+
+	for _, camera := range conf.Cameras {
+		known := false
+		for _, address := range addresses {
+			known = known || address == camera.Address
+		}
+		if !known {
+			addresses = append(addresses, camera.Address)
+		}
 	}
+
+	return addresses
 }
 
-func serialPortAvailable(serialPort string) (bool, error) {
-	var err error
+// sends a stop frame to every known address in quick succession. returns the
+// number of cameras stopped.
+func stopAll(send func(PelcoDMessage), conf config.Config) int {
+	addresses := allAddresses(conf)
 
-	goStat, err := os.Stat(serialPort)
+	for _, address := range addresses {
+		send(pelco.New().To(address).Build())
 
-	if os.IsNotExist(err) || os.IsPermission(err) {
-		return false, err
+		// leave the bus idle long enough for receivers to frame each message
+		time.Sleep(10 * time.Millisecond)
 	}
 
-	euid := uint32(os.Geteuid())
+	return len(addresses)
+}
 
-	unixStat, ok := goStat.Sys().(*syscall.Stat_t)
+// copies the controller mapping, response curve, and speed settings to or
+// from a file that can be shared between operator stations.
+func mapping(export bool, path string) {
+	if export {
+		if err := config.ExportMapping(path); err != nil {
+			printError("unable to export mapping. %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Exported mapping to %s\n", path)
+		return
+	}
 
-	if !ok {
-		return false, errors.New("cannot determine file ownership or permissions")
+	written, err := config.ImportMapping(path)
+	if err != nil {
+		printError("unable to import mapping. %s\n", err)
+		os.Exit(1)
 	}
+	fmt.Fprintf(os.Stderr, "Imported mapping from %s into %s\n", path, written)
+}
 
-	if euid == unixStat.Uid && 0 != (0x600&unixStat.Mode) {
-		// we should have owner access!
-		return true, nil
+// sends stop frames to all cameras from the command line.
+func emergencyStop(conf config.Config) {
+	line, err := openSerialLine(conf)
+	if err != nil {
+		panic(err)
+	}
+	defer line.Close()
+
+	count := stopAll(line.Send, conf)
+	fmt.Fprintf(os.Stderr, "Sent stop to %d cameras\n", count)
+}
+
+// writes message on tty in the protocol of the camera it's addressed to.
+func sendMessage(tty Port, encoder *frameEncoder, message PelcoDMessage) error {
+	if nil == tty {
+		return nil
 	}
 
-	if 0 != (0x006 & unixStat.Mode) {
-		// we should have other access!
-		return true, nil
+	packet := encoder.Encode(message)
+	if 0 == len(packet) {
+		return nil
 	}
 
-	if 0 != (0x060 & unixStat.Mode) {
-		groups, err := os.Getgroups()
+	if _, err := tty.Write(packet); err != nil {
+		return err
+	}
+	busStats.Sent(message[pelco.Addr])
 
-		if err != nil {
-			return false, err
-		}
+	return nil
+}
+
+func sendDelayedMessages(c <-chan DelayedMessage, tty Port, conf config.Config, outputs *Outputs) {
+	var (
+		pkg      DelayedMessage
+		lastTime time.Time
+		encoder  = newFrameEncoder(conf)
+	)
 
-		// does any group for user match file's group?
-		for _, gid := range groups {
-			if uint32(gid) == unixStat.Gid {
-				// we should have group access!
-				return true, nil
+	send := func(pkg DelayedMessage) {
+		if nil != pkg.Move && pkg.Move.Degrees {
+			for _, message := range pkg.Move.Messages() {
+				sendMessage(tty, encoder, message)
+				outputs.Send(message)
 			}
+			return
 		}
+		if nil != pkg.Move {
+			sendPTZMove(conf, tty, *pkg.Move)
+			return
+		}
+		sendMessage(tty, encoder, pkg.Message)
+		outputs.Send(pkg.Message)
 	}
 
-	return false, errors.New(fmt.Sprintf("access denied. uid (%d) gid (%d) mode (%o)", unixStat.Uid, unixStat.Gid, 0xfff & unixStat.Mode))
+	// send first message without delay
+	pkg = <-c
+	send(pkg)
+	lastTime = time.Now()
+
+	// all other messages are delayed wrt preceeding messages
+	for pkg = range c {
+		time.Sleep(pkg.Delay)
+		send(pkg)
+
+		if conf.Verbose {
+			duration := time.Now().Sub(lastTime) / 1e6
+			delay := pkg.Delay / 1e6
+			sent := fmt.Sprintf("%x", pkg.Message)
+			if nil != pkg.Move {
+				sent = pkg.Move.String()
+			}
+			fmt.Fprintf(os.Stderr, "Sent %s after %d millis. target %d millis.  offset %d millis\n",
+				sent, duration, delay, duration-delay)
+		}
+
+		lastTime = time.Now()
+	}
 }
 
 func version() string {
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// the api is advertised as this DNS-SD service type, with the websocket and
+// REST paths in its TXT record
+const mdnsService = "_cctv-ptz._tcp.local."
+
+// browsers list service types by asking for this name
+const mdnsServices = "_services._dns-sd._udp.local."
+
+// records are cached this long by browsers. they ask again before expiry.
+const mdnsTTL = 120
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+
+	dnsClassIN = 1
+
+	// set on records only this host answers for, so caches replace rather
+	// than add to them
+	dnsCacheFlush = 0x8000
+)
+
+var (
+	mdnsGroup4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroup6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	class uint16
+}
+
+// MDNSAdvertiser answers mDNS queries for the api on one interface, so
+// companion apps find it without being given an address.
+type MDNSAdvertiser struct {
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	instance string // e.g. "cctv-ptz on desk._cctv-ptz._tcp.local."
+	host     string // e.g. "desk.local."
+	port     int
+	txt      []string
+	ips      []net.IP
+}
+
+// advertises the api on every multicast interface, as mdns-name or
+// "cctv-ptz on HOSTNAME". an api bound to loopback isn't advertised.
+func startMDNS(conf config.Config) {
+	addresses := listenAddresses(conf.Listen)
+	if 0 == len(addresses) {
+		return
+	}
+
+	host, portText, err := net.SplitHostPort(addresses[0])
+	if err != nil {
+		printError("mdns disabled. %s\n", err)
+		return
+	}
+
+	var port int
+	if port, err = net.LookupPort("tcp", portText); err != nil {
+		printError("mdns disabled. %s\n", err)
+		return
+	}
+
+	// an api bound to one address is only advertised there
+	var bound net.IP
+	if "" != host && "0.0.0.0" != host && "::" != host {
+		if bound = net.ParseIP(host); nil == bound || bound.IsLoopback() {
+			printError("mdns disabled. the api on %s isn't reachable from the network\n", addresses[0])
+			return
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	if i := strings.Index(hostname, "."); i >= 0 {
+		hostname = hostname[:i]
+	}
+	if "" == hostname {
+		hostname = "cctv-ptz"
+	}
+
+	name := conf.MDNSName
+	if "" == name {
+		name = "cctv-ptz on " + hostname
+	}
+
+	txt := []string{"version=" + VERSION, "api=/api", "ws=/api/state/ws", "docs=/api/docs"}
+	if "" != conf.TLSCert {
+		txt = append(txt, "tls=1")
+	}
+	if hasAPICredentials(conf) {
+		txt = append(txt, "auth=1")
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		printError("mdns disabled. %s\n", err)
+		return
+	}
+
+	advertised := 0
+	for _, iface := range interfaces {
+		if 0 == iface.Flags&net.FlagUp || 0 == iface.Flags&net.FlagMulticast || 0 != iface.Flags&net.FlagLoopback {
+			continue
+		}
+
+		ips4, ips6 := interfaceIPs(iface, bound)
+
+		for _, family := range []struct {
+			network string
+			group   *net.UDPAddr
+			ips     []net.IP
+		}{{"udp4", mdnsGroup4, ips4}, {"udp6", mdnsGroup6, ips6}} {
+			if 0 == len(family.ips) {
+				continue
+			}
+
+			conn, err := net.ListenMulticastUDP(family.network, &iface, family.group)
+			if err != nil {
+				continue
+			}
+
+			a := &MDNSAdvertiser{
+				conn:     conn,
+				group:    family.group,
+				instance: dnsEscapeLabel(name) + "." + mdnsService,
+				host:     hostname + ".local.",
+				port:     port,
+				txt:      txt,
+				ips:      append(ips4, ips6...),
+			}
+			go a.serve()
+			advertised++
+		}
+	}
+
+	if 0 == advertised {
+		printError("mdns disabled. no multicast interfaces\n")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Advertising the api as \"%s\" over mdns\n", name)
+}
+
+// returns the addresses of iface, by family, or only bound if iface has it.
+func interfaceIPs(iface net.Interface, bound net.IP) ([]net.IP, []net.IP) {
+	var ips4, ips6 []net.IP
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, addr := range addrs {
+		network, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := network.IP
+		if nil != bound && !bound.Equal(ip) {
+			continue
+		}
+
+		if nil != ip.To4() {
+			ips4 = append(ips4, ip.To4())
+		} else if ip.IsLinkLocalUnicast() || ip.IsGlobalUnicast() {
+			ips6 = append(ips6, ip)
+		}
+	}
+
+	return ips4, ips6
+}
+
+// announces the service, then answers queries for it until the socket
+// fails.
+func (a *MDNSAdvertiser) serve() {
+	defer a.conn.Close()
+
+	// announced twice, a second apart, as RFC 6762 asks
+	go func() {
+		for i := 0; i < 2; i++ {
+			a.conn.WriteToUDP(encodeDNSMessage(0, true, nil, a.records()), a.group)
+			time.Sleep(time.Second)
+		}
+	}()
+
+	buffer := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		id, questions, err := parseDNSQuery(buffer[:n])
+		if err != nil || !a.answers(questions) {
+			continue
+		}
+
+		// queries from a port other than 5353 are one-shot resolvers that
+		// expect a plain unicast reply to their query
+		if 5353 != from.Port {
+			a.conn.WriteToUDP(encodeDNSMessage(id, true, questions, a.records()), from)
+			continue
+		}
+
+		a.conn.WriteToUDP(encodeDNSMessage(0, true, nil, a.records()), a.group)
+	}
+}
+
+// reports whether any of questions asks about this service.
+func (a *MDNSAdvertiser) answers(questions []dnsQuestion) bool {
+	for _, q := range questions {
+		name := strings.ToLower(q.name)
+
+		switch {
+		case mdnsServices == name && (dnsTypePTR == q.qtype || dnsTypeANY == q.qtype):
+			return true
+		case mdnsService == name && (dnsTypePTR == q.qtype || dnsTypeANY == q.qtype):
+			return true
+		case strings.ToLower(a.instance) == name:
+			return true
+		case strings.ToLower(a.host) == name && (dnsTypeA == q.qtype || dnsTypeAAAA == q.qtype || dnsTypeANY == q.qtype):
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns every record for the service. answering with all of them saves
+// browsers asking again for the SRV, TXT, and addresses.
+func (a *MDNSAdvertiser) records() []dnsRecord {
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], uint16(a.port))
+	srv = append(srv, encodeDNSName(a.host)...)
+
+	var txt []byte
+	for _, entry := range a.txt {
+		txt = append(txt, byte(len(entry)))
+		txt = append(txt, entry...)
+	}
+
+	records := []dnsRecord{
+		{mdnsServices, dnsTypePTR, dnsClassIN, mdnsTTL, encodeDNSName(mdnsService)},
+		{mdnsService, dnsTypePTR, dnsClassIN, mdnsTTL, encodeDNSName(a.instance)},
+		{a.instance, dnsTypeSRV, dnsClassIN | dnsCacheFlush, mdnsTTL, srv},
+		{a.instance, dnsTypeTXT, dnsClassIN | dnsCacheFlush, mdnsTTL, txt},
+	}
+
+	for _, ip := range a.ips {
+		if ip4 := ip.To4(); nil != ip4 {
+			records = append(records, dnsRecord{a.host, dnsTypeA, dnsClassIN | dnsCacheFlush, mdnsTTL, []byte(ip4)})
+		} else {
+			records = append(records, dnsRecord{a.host, dnsTypeAAAA, dnsClassIN | dnsCacheFlush, mdnsTTL, []byte(ip.To16())})
+		}
+	}
+
+	return records
+}
+
+// escapes dots in an instance name, which may otherwise hold any text.
+func dnsEscapeLabel(label string) string {
+	return strings.NewReplacer(`\`, `\\`, ".", `\.`).Replace(label)
+}
+
+// encodes name, e.g. "desk.local.", as DNS labels. escaped dots stay in
+// their label.
+func encodeDNSName(name string) []byte {
+	var (
+		encoded []byte
+		label   []byte
+	)
+
+	flush := func() {
+		if 0 == len(label) {
+			return
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+		label = nil
+	}
+
+	for i := 0; i < len(name); i++ {
+		switch {
+		case '\\' == name[i] && i+1 < len(name):
+			i++
+			label = append(label, name[i])
+		case '.' == name[i]:
+			flush()
+		default:
+			label = append(label, name[i])
+		}
+	}
+	flush()
+
+	return append(encoded, 0)
+}
+
+func encodeDNSMessage(id uint16, response bool, questions []dnsQuestion, answers []dnsRecord) []byte {
+	message := make([]byte, 12)
+	binary.BigEndian.PutUint16(message[0:], id)
+	if response {
+		// authoritative answer
+		binary.BigEndian.PutUint16(message[2:], 0x8400)
+	}
+	binary.BigEndian.PutUint16(message[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(message[6:], uint16(len(answers)))
+
+	for _, q := range questions {
+		message = append(message, encodeDNSName(q.name)...)
+		message = binary.BigEndian.AppendUint16(message, q.qtype)
+		message = binary.BigEndian.AppendUint16(message, q.class&^dnsCacheFlush)
+	}
+
+	for _, r := range answers {
+		message = append(message, encodeDNSName(r.name)...)
+		message = binary.BigEndian.AppendUint16(message, r.rtype)
+		message = binary.BigEndian.AppendUint16(message, r.class)
+		message = binary.BigEndian.AppendUint32(message, r.ttl)
+		message = binary.BigEndian.AppendUint16(message, uint16(len(r.data)))
+		message = append(message, r.data...)
+	}
+
+	return message
+}
+
+// parses the id and questions of a DNS query. responses from other hosts
+// return no questions.
+func parseDNSQuery(message []byte) (uint16, []dnsQuestion, error) {
+	if len(message) < 12 {
+		return 0, nil, errors.New("short dns message")
+	}
+
+	id := binary.BigEndian.Uint16(message[0:])
+	if 0 != message[2]&0x80 {
+		return id, nil, nil
+	}
+
+	count := int(binary.BigEndian.Uint16(message[4:]))
+	offset := 12
+
+	var questions []dnsQuestion
+	for i := 0; i < count; i++ {
+		name, next, err := parseDNSName(message, offset)
+		if err != nil {
+			return id, nil, err
+		}
+		if next+4 > len(message) {
+			return id, nil, errors.New("short dns question")
+		}
+
+		questions = append(questions, dnsQuestion{
+			name:  name,
+			qtype: binary.BigEndian.Uint16(message[next:]),
+			class: binary.BigEndian.Uint16(message[next+2:]),
+		})
+		offset = next + 4
+	}
+
+	return id, questions, nil
+}
+
+// parses the name at offset, following compression pointers, and returns
+// it with dots escaped and the offset after it.
+func parseDNSName(message []byte, offset int) (string, int, error) {
+	var (
+		labels []string
+		next   = -1
+	)
+
+	for jumps := 0; ; {
+		if offset >= len(message) {
+			return "", 0, errors.New("short dns name")
+		}
+
+		length := int(message[offset])
+		switch {
+		case 0 == length:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case 0xc0 == length&0xc0:
+			if offset+1 >= len(message) {
+				return "", 0, errors.New("short dns name")
+			}
+			if jumps++; jumps > 10 {
+				return "", 0, errors.New("dns name loops")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(message[offset:]) & 0x3fff)
+
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, errors.New("short dns label")
+			}
+			labels = append(labels, dnsEscapeLabel(string(message[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
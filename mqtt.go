@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// minimal MQTT 3.1.1 publisher, enough to push retained tally messages at
+// QoS 0. no subscriptions.
+
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttDisconnect = 14
+)
+
+// MQTTClient publishes to one broker, connecting on first use and again
+// after the connection drops. it is safe for concurrent use.
+type MQTTClient struct {
+	addr     string
+	clientID string
+	username string
+	password string
+
+	// published retained on connect and by the broker if we vanish
+	willTopic, willMessage, onlineMessage string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// accepts "host:port", "tcp://host:port", or "mqtt://host:port". the port
+// defaults to 1883.
+func NewMQTTClient(broker, clientID, username, password string) (*MQTTClient, error) {
+	addr := broker
+
+	if target, err := url.Parse(broker); nil == err && "" != target.Host {
+		if "tcp" != target.Scheme && "mqtt" != target.Scheme {
+			return nil, fmt.Errorf("unsupported mqtt scheme %s", target.Scheme)
+		}
+		addr = target.Host
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+
+	return &MQTTClient{addr: addr, clientID: clientID, username: username, password: password}, nil
+}
+
+// has the broker publish message, retained, on topic when we disconnect
+// without saying goodbye, and online there on every connect.
+func (c *MQTTClient) SetWill(topic, message, online string) {
+	c.willTopic, c.willMessage, c.onlineMessage = topic, message, online
+}
+
+// publishes payload on topic at QoS 0, retried once on a fresh connection
+// when the old one has gone stale.
+func (c *MQTTClient) Publish(topic string, payload []byte, retain bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if nil == c.conn {
+			if err = c.connect(); err != nil {
+				return err
+			}
+		}
+
+		if err = writeMQTT(c.conn, publishPacket(topic, payload, retain)); nil == err {
+			return nil
+		}
+
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	return err
+}
+
+func (c *MQTTClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nil != c.conn {
+		writeMQTT(c.conn, []byte{mqttDisconnect << 4, 0})
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// dials the broker and waits for it to accept the session. callers hold c.mu.
+func (c *MQTTClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if err := writeMQTT(conn, c.connectPacket()); err != nil {
+		conn.Close()
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		conn.Close()
+		return err
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	if mqttConnack<<4 != ack[0] {
+		conn.Close()
+		return errors.New("mqtt broker did not acknowledge the connection")
+	}
+	if 0 != ack[3] {
+		conn.Close()
+		return fmt.Errorf("mqtt broker refused the connection, code %d", ack[3])
+	}
+
+	c.conn = conn
+
+	if "" != c.willTopic {
+		if err := writeMQTT(conn, publishPacket(c.willTopic, []byte(c.onlineMessage), true)); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+
+	// nothing is read after the handshake, but reading notices the broker
+	// hanging up sooner than the next publish would
+	go func() {
+		io.Copy(io.Discard, conn)
+
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn.Close()
+			c.conn = nil
+		}
+		c.mu.Unlock()
+	}()
+
+	return nil
+}
+
+func (c *MQTTClient) connectPacket() []byte {
+	var flags byte = 0x02 // clean session
+
+	body := mqttString("MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+
+	if "" != c.willTopic {
+		flags |= 0x04 | 0x20 // will, retained at QoS 0
+	}
+	if "" != c.username {
+		flags |= 0x80
+		if "" != c.password {
+			flags |= 0x40
+		}
+	}
+
+	// no keep alive; a dead connection shows up on the next publish
+	body = append(body, flags, 0, 0)
+	body = append(body, mqttString(c.clientID)...)
+
+	if "" != c.willTopic {
+		body = append(body, mqttString(c.willTopic)...)
+		body = append(body, mqttString(c.willMessage)...)
+	}
+	if "" != c.username {
+		body = append(body, mqttString(c.username)...)
+		if "" != c.password {
+			body = append(body, mqttString(c.password)...)
+		}
+	}
+
+	return mqttPacket(mqttConnect<<4, body)
+}
+
+func publishPacket(topic string, payload []byte, retain bool) []byte {
+	var header byte = mqttPublish << 4
+	if retain {
+		header |= 0x01
+	}
+
+	return mqttPacket(header, append(mqttString(topic), payload...))
+}
+
+// prefixes body with the fixed header and its variable length remaining
+// length.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+
+	for n := len(body); ; {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if 0 == n {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+func mqttString(text string) []byte {
+	b := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(b, uint16(len(text)))
+
+	return append(b, text...)
+}
+
+func writeMQTT(conn net.Conn, packet []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write(packet)
+
+	return err
+}
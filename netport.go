@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// netDriver carries decoded frames to a camera on the network.
+type netDriver interface {
+	Send(command pelco.Command) error
+	String() string
+}
+
+// netPort drives a network camera with the Pelco-D frames written to it,
+// handing them to its driver in the background so a slow camera doesn't
+// hold up the joystick. motion waiting its turn is replaced by newer motion,
+// and motion the camera already has isn't sent again, while other commands,
+// e.g. presets, all go out in order. nothing is read back.
+type netPort struct {
+	driver netDriver
+
+	mu      sync.Mutex
+	pending []pelco.Command
+	last    *pelco.Command // last motion sent
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	failing bool
+}
+
+func newNetPort(driver netDriver) *netPort {
+	p := &netPort{driver: driver, wake: make(chan struct{}, 1), done: make(chan struct{}), stopped: make(chan struct{})}
+	go p.run()
+
+	return p
+}
+
+// returns address without its password, for messages.
+func redactURL(address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+
+	return parsed.Redacted()
+}
+
+func (p *netPort) Write(data []byte) (int, error) {
+	for i := 0; i+len(PelcoDMessage{}) <= len(data); i += len(PelcoDMessage{}) {
+		var message PelcoDMessage
+		copy(message[:], data[i:])
+
+		command, err := pelco.Decode(message)
+		if err != nil {
+			continue
+		}
+
+		p.mu.Lock()
+		if n := len(p.pending); !command.Extended && n > 0 && !p.pending[n-1].Extended {
+			p.pending[n-1] = command
+		} else {
+			p.pending = append(p.pending, command)
+		}
+		p.mu.Unlock()
+
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(data), nil
+}
+
+// commands still waiting when the port closes, e.g. a last stop, are sent
+// first.
+func (p *netPort) run() {
+	defer close(p.stopped)
+
+	for closing := false; !closing; {
+		select {
+		case <-p.done:
+			closing = true
+		case <-p.wake:
+		}
+
+		for {
+			p.mu.Lock()
+			if 0 == len(p.pending) {
+				p.mu.Unlock()
+				break
+			}
+			command := p.pending[0]
+			p.pending = p.pending[1:]
+			repeated := !command.Extended && nil != p.last && command == *p.last
+			p.mu.Unlock()
+
+			if !repeated {
+				p.send(command)
+			}
+		}
+	}
+}
+
+func (p *netPort) send(command pelco.Command) {
+	if err := p.driver.Send(command); err != nil {
+		if !p.failing {
+			printError("%s failed. %s\n", p.driver, err)
+			p.failing = true
+		}
+		return
+	}
+
+	if p.failing {
+		printError("%s is back\n", p.driver)
+		p.failing = false
+	}
+
+	if !command.Extended {
+		p.mu.Lock()
+		p.last = &command
+		p.mu.Unlock()
+	}
+}
+
+func (p *netPort) Read(b []byte) (int, error) {
+	<-p.done
+	return 0, io.EOF
+}
+
+func (p *netPort) Close() error {
+	close(p.done)
+	<-p.stopped
+
+	return nil
+}
+
+func (p *netPort) String() string {
+	return fmt.Sprint(p.driver)
+}
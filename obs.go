@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scene changes yield to the joystick, but outrank trackers.
+var obsSource = Source{Name: "obs", Priority: 55}
+
+const (
+	obsLockTTL = 2 * time.Second
+	obsRetry   = 5 * time.Second
+)
+
+// obs-websocket v5 opcodes
+const (
+	obsHello      = 0
+	obsIdentify   = 1
+	obsIdentified = 2
+	obsEvent      = 5
+	obsRequest    = 6
+)
+
+// event subscription bit for scene events
+const obsSceneEvents = 1 << 2
+
+type obsMessage struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+// OBSClient calls camera presets as scenes go to program, and switches the
+// program scene when the operator selects another camera. it reconnects
+// whenever OBS goes away.
+type OBSClient struct {
+	conf     config.Config
+	arbiter  *Arbiter
+	commands chan<- Command
+
+	mu       sync.Mutex
+	ws       *WebSocket
+	program  string // current program scene
+	requests int
+}
+
+// connects to obs-websocket in the background, or returns nil when no url is
+// configured.
+func startOBS(conf config.Config, arbiter *Arbiter, commands chan<- Command) *OBSClient {
+	if "" == conf.OBS.URL {
+		return nil
+	}
+
+	c := &OBSClient{conf: conf, arbiter: arbiter, commands: commands}
+	go c.run()
+
+	return c
+}
+
+func (c *OBSClient) run() {
+	var lastErr string
+
+	for {
+		err := c.connect()
+
+		// a down OBS is reported once, not on every retry
+		if nil != err && err.Error() != lastErr {
+			printError("obs: %s\n", err)
+		}
+		if nil != err {
+			lastErr = err.Error()
+		}
+
+		time.Sleep(obsRetry)
+	}
+}
+
+// runs one connection until it fails.
+func (c *OBSClient) connect() error {
+	ws, err := dialWebSocket(c.conf.OBS.URL)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	if err := c.identify(ws); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Connected to OBS at %s\n", c.conf.OBS.URL)
+
+	c.mu.Lock()
+	c.ws = ws
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.ws = nil
+		c.mu.Unlock()
+	}()
+
+	for {
+		message, err := readOBS(ws)
+		if err != nil {
+			return err
+		}
+
+		if obsEvent != message.Op {
+			continue
+		}
+
+		var event struct {
+			EventType string `json:"eventType"`
+			EventData struct {
+				SceneName string `json:"sceneName"`
+			} `json:"eventData"`
+		}
+		if err := json.Unmarshal(message.D, &event); err != nil {
+			continue
+		}
+
+		if "CurrentProgramSceneChanged" == event.EventType {
+			c.sceneChanged(event.EventData.SceneName)
+		}
+	}
+}
+
+// answers the server's hello, authenticating when it asks for a password.
+func (c *OBSClient) identify(ws *WebSocket) error {
+	message, err := readOBS(ws)
+	if err != nil {
+		return err
+	}
+	if obsHello != message.Op {
+		return fmt.Errorf("expected hello, got op %d", message.Op)
+	}
+
+	var hello struct {
+		Authentication *struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		} `json:"authentication"`
+	}
+	if err := json.Unmarshal(message.D, &hello); err != nil {
+		return err
+	}
+
+	identify := map[string]interface{}{"rpcVersion": 1, "eventSubscriptions": obsSceneEvents}
+	if nil != hello.Authentication {
+		if "" == c.conf.OBS.Password {
+			return errors.New("OBS requires a password")
+		}
+		identify["authentication"] = obsAuthentication(c.conf.OBS.Password, hello.Authentication.Salt, hello.Authentication.Challenge)
+	}
+
+	if err := writeOBS(ws, obsIdentify, identify); err != nil {
+		return err
+	}
+
+	if message, err = readOBS(ws); err != nil {
+		return fmt.Errorf("identify failed, check the password. %s", err)
+	}
+	if obsIdentified != message.Op {
+		return fmt.Errorf("expected identified, got op %d", message.Op)
+	}
+
+	return nil
+}
+
+// base64(sha256(base64(sha256(password + salt)) + challenge))
+func obsAuthentication(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+func readOBS(ws *WebSocket) (obsMessage, error) {
+	var message obsMessage
+
+	text, err := ws.ReadMessage()
+	if err != nil {
+		return message, err
+	}
+
+	err = json.Unmarshal(text, &message)
+
+	return message, err
+}
+
+func writeOBS(ws *WebSocket, op int, data interface{}) error {
+	text, err := json.Marshal(map[string]interface{}{"op": op, "d": data})
+	if err != nil {
+		return err
+	}
+
+	return ws.WriteText(text)
+}
+
+// calls the presets paired with scene, unless another source has control.
+func (c *OBSClient) sceneChanged(scene string) {
+	c.mu.Lock()
+	echo := scene == c.program
+	c.program = scene
+	c.mu.Unlock()
+
+	// a switch we asked for, not a new shot
+	if echo {
+		return
+	}
+
+	for _, pairing := range c.conf.OBS.Scenes {
+		if pairing.Scene != scene {
+			continue
+		}
+
+		address, err := parseCamera(c.conf, pairing.Camera)
+		if err != nil {
+			printError("obs: scene %s: %s\n", scene, err)
+			continue
+		}
+		if pairing.Preset < 1 || pairing.Preset > 255 {
+			printError("obs: scene %s: invalid preset %d\n", scene, pairing.Preset)
+			continue
+		}
+
+		if err := c.arbiter.Acquire(obsSource, obsLockTTL, time.Now()); err != nil {
+			printError("obs: scene %s: %s\n", scene, err)
+			return
+		}
+
+		c.commands <- Command{obsSource, pelco.New().To(address).CallPreset(uint8(pairing.Preset)).Build()}
+	}
+}
+
+// switches the program scene to the first scene paired with address, when
+// switch-scenes is set. safe to call on a nil client.
+func (c *OBSClient) CameraSelected(address int) {
+	if nil == c || !c.conf.OBS.SwitchScenes {
+		return
+	}
+
+	for _, pairing := range c.conf.OBS.Scenes {
+		if camera, err := parseCamera(c.conf, pairing.Camera); err != nil || camera != address {
+			continue
+		}
+
+		c.mu.Lock()
+		ws := c.ws
+		if nil == ws || pairing.Scene == c.program {
+			c.mu.Unlock()
+			return
+		}
+		c.program = pairing.Scene
+		c.requests++
+		id := strconv.Itoa(c.requests)
+		c.mu.Unlock()
+
+		// don't hold up the joystick on a slow connection
+		go func(scene string) {
+			request := map[string]interface{}{
+				"requestType": "SetCurrentProgramScene",
+				"requestId":   id,
+				"requestData": map[string]string{"sceneName": scene},
+			}
+			if err := writeOBS(ws, obsRequest, request); err != nil {
+				printError("obs: %s\n", err)
+			}
+		}(pairing.Scene)
+
+		return
+	}
+}
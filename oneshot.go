@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// checks a one-shot move: speeds from -1.0 to 1.0, something to do, and
+// for how long.
+func checkOneShot(pan, tilt, zoom float32, duration time.Duration) error {
+	for _, speed := range []float32{pan, tilt} {
+		if speed < -1 || speed > 1 {
+			return fmt.Errorf("invalid speed %.2f. expected -1.0 to 1.0", speed)
+		}
+	}
+
+	if 0 == pan && 0 == tilt && 0 == zoom {
+		return errors.New("nothing to do. give --pan or --tilt")
+	}
+
+	if duration <= 0 {
+		return errors.New("--duration must be more than 0, e.g. 2s")
+	}
+
+	return nil
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "move",
+		Summary: "pan and tilt for a while, then stop",
+		Usage:   []string{"[--pan SPEED] [--tilt SPEED] --duration DURATION"},
+		Options: []string{
+			"--pan SPEED              - pan speed -1.0 to 1.0. positive is clockwise. (default = 0)",
+			"--tilt SPEED             - tilt speed -1.0 to 1.0. positive is up. (default = 0)",
+			"--duration DURATION      - move for DURATION, e.g. 2s, then stop.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			oneShot(conf, float32(floatArg(arguments, "--pan", 0)), float32(floatArg(arguments, "--tilt", 0)), 0,
+				durationArg(arguments, "--duration", 0))
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "zoom",
+		Summary: "zoom in or out for a while, then stop",
+		Usage:   []string{"(--in | --out) --duration DURATION"},
+		Options: []string{
+			"--in                     - zoom in.",
+			"--out                    - zoom out.",
+			"--duration DURATION      - zoom for DURATION, e.g. 2s, then stop.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			zoom := float32(1)
+			if arguments["--out"].(bool) {
+				zoom = -1
+			}
+			oneShot(conf, 0, 0, zoom, durationArg(arguments, "--duration", 0))
+		},
+	})
+}
+
+// moves, zooms, or both for duration from the command line, then stops,
+// e.g. for cron jobs. an interrupt stops the camera early.
+func oneShot(conf config.Config, pan, tilt, zoom float32, duration time.Duration) {
+	if err := checkOneShot(pan, tilt, zoom, duration); err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	line, err := openSerialLine(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer line.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	message := applyJoystick(pelco.New().To(conf.Address), pan, tilt, zoom, false, false, false, conf.MaxSpeed).Build()
+	camera := describeCamera(conf, conf.Address)
+
+	line.Send(message)
+	fmt.Fprintf(os.Stderr, "%s for %s\n", describeMessage(conf, message), duration)
+
+	select {
+	case <-time.After(duration):
+	case <-interrupt:
+		fmt.Fprintf(os.Stderr, "%s: interrupted\n", camera)
+	}
+
+	line.Send(pelco.New().To(conf.Address).Build())
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/onvif"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long to wait for an ONVIF camera to finish a move before reporting
+// where it got to
+const settleTimeout = 10 * time.Second
+
+// a camera's pan, tilt, and zoom velocities, from -1 to 1
+type velocity struct {
+	pan, tilt, zoom float64
+}
+
+// onvifDriver drives an ONVIF camera: motion becomes ContinuousMove and
+// stopping Stop. extended commands, focus, and iris have no ContinuousMove
+// counterpart and are dropped.
+type onvifDriver struct {
+	client *onvif.Client
+}
+
+// onvifPort is the port of an ONVIF camera, which makes moves of its own
+// besides those of the frames written to it.
+type onvifPort struct {
+	*netPort
+	client *onvif.Client
+}
+
+func dialONVIF(address string) (*onvifPort, error) {
+	client, err := onvif.Dial(address)
+	if err != nil {
+		return nil, fmt.Errorf("onvif %s: %s", redactURL(address), err)
+	}
+
+	return &onvifPort{netPort: newNetPort(onvifDriver{client}), client: client}, nil
+}
+
+// returns the velocity of a standard frame. speeds above full speed, i.e.
+// turbo, are full speed.
+func frameVelocity(command pelco.Command) velocity {
+	scale := func(speed uint8) float64 {
+		if speed > pelco.FullSpeed {
+			speed = pelco.FullSpeed
+		}
+		return float64(speed) / pelco.FullSpeed
+	}
+
+	return velocity{
+		pan:  float64(command.Pan) * scale(command.PanSpeed),
+		tilt: float64(command.Tilt) * scale(command.TiltSpeed),
+		zoom: float64(command.Zoom),
+	}
+}
+
+func (d onvifDriver) Send(command pelco.Command) error {
+	if command.Extended {
+		return nil
+	}
+
+	want := frameVelocity(command)
+	if (velocity{}) == want {
+		return d.client.Stop()
+	}
+
+	return d.client.ContinuousMove(want.pan, want.tilt, want.zoom)
+}
+
+func (d onvifDriver) String() string {
+	return fmt.Sprintf("onvif %s, profile %s", d.client.PTZ, d.client.Profile)
+}
+
+// PTZMove is a move an ONVIF camera makes on its own, to a position or by an
+// offset in the camera's generic spaces, or a goto: pan and tilt positions in
+// degrees, sent to Pelco-D cameras as position commands. axes left out stay
+// where they are.
+type PTZMove struct {
+	Address  int
+	Relative bool
+	Degrees  bool // a goto
+
+	Pan, Tilt, Zoom *float64
+}
+
+func isPTZMove(word string) bool {
+	return "absolute" == word || "relative" == word || "goto" == word
+}
+
+// parses a move written as in playback files and the shell, e.g.
+// "absolute pan=0.5 tilt=-0.2 zoom=0.1 camera=lobby" or "goto pan=135.5
+// tilt=-10". the camera defaults to conf.Address.
+func parsePTZMove(conf config.Config, words []string) (PTZMove, error) {
+	move := PTZMove{Address: conf.Address}
+
+	if 0 == len(words) || !isPTZMove(words[0]) {
+		return move, errors.New("expected absolute, relative, or goto")
+	}
+	move.Relative = "relative" == words[0]
+	move.Degrees = "goto" == words[0]
+
+	for _, word := range words[1:] {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok {
+			return move, fmt.Errorf("expected KEY=VALUE, not %s", word)
+		}
+
+		if "camera" == key {
+			address, err := parseCamera(conf, value)
+			if err != nil {
+				return move, err
+			}
+			move.Address = address
+			continue
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return move, fmt.Errorf("invalid %s. %s", key, err)
+		}
+
+		switch key {
+		case "pan":
+			move.Pan = &number
+		case "tilt":
+			move.Tilt = &number
+		case "zoom":
+			move.Zoom = &number
+		default:
+			return move, fmt.Errorf("unknown axis %s. expected pan, tilt, or zoom", key)
+		}
+	}
+
+	if move.Degrees {
+		return move, checkGoto(move)
+	}
+
+	if nil == move.Pan && nil == move.Tilt && nil == move.Zoom {
+		return move, errors.New("expected pan, tilt, or zoom")
+	}
+
+	return move, nil
+}
+
+func (m PTZMove) String() string {
+	words := []string{"absolute"}
+	if m.Relative {
+		words[0] = "relative"
+	} else if m.Degrees {
+		words[0] = "goto"
+	}
+
+	for _, axis := range []struct {
+		name  string
+		value *float64
+	}{{"pan", m.Pan}, {"tilt", m.Tilt}, {"zoom", m.Zoom}} {
+		if nil != axis.value {
+			words = append(words, axis.name+"="+strconv.FormatFloat(*axis.value, 'f', -1, 64))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// makes move. an absolute move of pan or tilt alone reads where the camera
+// is to keep the other axis there.
+func (p *onvifPort) Move(move PTZMove) error {
+	var target onvif.Position
+
+	panTilt := nil != move.Pan || nil != move.Tilt
+	if panTilt && !move.Relative && (nil == move.Pan || nil == move.Tilt) {
+		status, err := p.client.Status()
+		if err != nil {
+			return err
+		}
+		target = status.Position
+	}
+
+	if nil != move.Pan {
+		target.Pan = *move.Pan
+	}
+	if nil != move.Tilt {
+		target.Tilt = *move.Tilt
+	}
+	if nil != move.Zoom {
+		target.Zoom = *move.Zoom
+	}
+
+	if move.Relative {
+		return p.client.RelativeMove(target, panTilt, nil != move.Zoom)
+	}
+
+	return p.client.AbsoluteMove(target, panTilt, nil != move.Zoom)
+}
+
+// waits for the camera to stop, up to timeout, and returns where it is.
+func (p *onvifPort) Settle(timeout time.Duration) (onvif.Status, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// give the camera a moment to start before it can report stopping
+		time.Sleep(200 * time.Millisecond)
+
+		status, err := p.client.Status()
+		if err != nil || !status.Moving || time.Now().After(deadline) {
+			return status, err
+		}
+	}
+}
+
+// makes move on tty and reports where the camera ends up, in the background
+// so the frames after it keep their timing.
+func sendPTZMove(conf config.Config, tty Port, move PTZMove) {
+	port, ok := tty.(*onvifPort)
+	if !ok {
+		printError("%s: %s needs an onvif camera\n", describeCamera(conf, move.Address), move)
+		return
+	}
+
+	if err := port.Move(move); err != nil {
+		printError("%s: %s failed. %s\n", describeCamera(conf, move.Address), move, err)
+		return
+	}
+
+	go func() {
+		status, err := port.Settle(settleTimeout)
+		if err != nil {
+			printError("%s: unable to read position. %s\n", describeCamera(conf, move.Address), err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s at %s\n", describeCamera(conf, move.Address), status.Position)
+	}()
+}
@@ -0,0 +1,191 @@
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsNamespace       = "http://www.onvif.org/ver10/events/wsdl"
+	notificationNamespace = "http://docs.oasis-open.org/wsn/b-2"
+	addressingNamespace   = "http://www.w3.org/2005/08/addressing"
+
+	createPullPointAction = "http://www.onvif.org/ver10/events/wsdl/EventPortType/CreatePullPointSubscriptionRequest"
+	pullMessagesAction    = "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesRequest"
+	renewAction           = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/RenewRequest"
+	unsubscribeAction     = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/UnsubscribeRequest"
+)
+
+var ErrNoEvents = errors.New("camera has no event service")
+
+// a subscription the camera drops unless it's renewed within this long
+const subscriptionLifetime = time.Minute
+
+// Box is where an object the camera's analytics found is in the picture,
+// in normalized coordinates: -1 at the left and bottom edges, 1 at the
+// right and top.
+type Box struct {
+	Left, Top, Right, Bottom float64
+}
+
+// Area is the size of b, in normalized units.
+func (b Box) Area() float64 {
+	return (b.Right - b.Left) * (b.Top - b.Bottom)
+}
+
+// Subscription is a pull point on which a camera queues its event
+// notifications until they're pulled.
+type Subscription struct {
+	client  *Client
+	address string // of the subscription manager
+	renewed time.Time
+}
+
+// Subscribe creates a pull point subscription to every event the camera
+// sends. cameras filter topics differently, so the objects are picked out
+// of whatever arrives.
+func (c *Client) Subscribe() (*Subscription, error) {
+	if "" == c.Events {
+		return nil, ErrNoEvents
+	}
+
+	body := fmt.Sprintf(`<CreatePullPointSubscription xmlns="%s"><InitialTerminationTime>%s</InitialTerminationTime>`+
+		`</CreatePullPointSubscription>`, eventsNamespace, duration(subscriptionLifetime))
+
+	data, err := c.post(c.Events, addressing(createPullPointAction, c.Events), body)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Address string `xml:"Body>CreatePullPointSubscriptionResponse>SubscriptionReference>Address"`
+	}
+	if err := xml.Unmarshal(data, &reply); err != nil {
+		return nil, err
+	}
+	if "" == strings.TrimSpace(reply.Address) {
+		return nil, errors.New("camera returned no subscription address")
+	}
+
+	return &Subscription{client: c, address: strings.TrimSpace(reply.Address), renewed: time.Now()}, nil
+}
+
+// Pull waits up to timeout for notifications and returns the objects they
+// report. the subscription is renewed along the way, before it lapses.
+func (s *Subscription) Pull(timeout time.Duration) ([]Box, error) {
+	if time.Since(s.renewed) > subscriptionLifetime/2 {
+		if err := s.renew(); err != nil {
+			return nil, err
+		}
+	}
+
+	body := fmt.Sprintf(`<PullMessages xmlns="%s"><Timeout>%s</Timeout><MessageLimit>16</MessageLimit></PullMessages>`,
+		eventsNamespace, duration(timeout))
+
+	data, err := s.client.post(s.address, addressing(pullMessagesAction, s.address), body)
+	if err != nil {
+		return nil, err
+	}
+
+	return boxes(data)
+}
+
+func (s *Subscription) renew() error {
+	body := fmt.Sprintf(`<Renew xmlns="%s"><TerminationTime>%s</TerminationTime></Renew>`,
+		notificationNamespace, duration(subscriptionLifetime))
+
+	if _, err := s.client.post(s.address, addressing(renewAction, s.address), body); err != nil {
+		return err
+	}
+	s.renewed = time.Now()
+
+	return nil
+}
+
+// Close ends the subscription, rather than leave it to lapse.
+func (s *Subscription) Close() error {
+	body := fmt.Sprintf(`<Unsubscribe xmlns="%s"/>`, notificationNamespace)
+
+	_, err := s.client.post(s.address, addressing(unsubscribeAction, s.address), body)
+	return err
+}
+
+// returns the WS-Addressing header event services route requests by.
+func addressing(action, to string) string {
+	return fmt.Sprintf(`<Action xmlns="%s">%s</Action><To xmlns="%s">%s</To>`,
+		addressingNamespace, escape(action), addressingNamespace, escape(to))
+}
+
+// returns d as an xml duration, e.g. PT0.5S.
+func duration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
+
+// returns the bounding boxes in the notifications of data, each mapped out
+// of its frame's coordinates, e.g. pixels, by the frame's transformation.
+func boxes(data []byte) ([]Box, error) {
+	var (
+		found                  []Box
+		translateX, translateY float64
+		scaleX, scaleY         = 1.0, 1.0
+	)
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if io.EOF == err {
+			return found, nil
+		}
+		if err != nil {
+			return found, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch element.Name.Local {
+		case "Frame":
+			translateX, translateY, scaleX, scaleY = 0, 0, 1, 1
+		case "Translate":
+			translateX, translateY = attr(element, "x", 0), attr(element, "y", 0)
+		case "Scale":
+			scaleX, scaleY = attr(element, "x", 1), attr(element, "y", 1)
+		case "BoundingBox":
+			box := Box{
+				Left:   translateX + scaleX*attr(element, "left", 0),
+				Right:  translateX + scaleX*attr(element, "right", 0),
+				Top:    translateY + scaleY*attr(element, "top", 0),
+				Bottom: translateY + scaleY*attr(element, "bottom", 0),
+			}
+			// a negative scale flips an axis, e.g. pixel rows counting down
+			if box.Left > box.Right {
+				box.Left, box.Right = box.Right, box.Left
+			}
+			if box.Bottom > box.Top {
+				box.Bottom, box.Top = box.Top, box.Bottom
+			}
+			found = append(found, box)
+		}
+	}
+}
+
+// returns the number in attribute name of element, or fallback.
+func attr(element xml.StartElement, name string, fallback float64) float64 {
+	for _, a := range element.Attr {
+		if name == a.Name.Local {
+			if value, err := strconv.ParseFloat(a.Value, 64); nil == err {
+				return value
+			}
+		}
+	}
+
+	return fallback
+}
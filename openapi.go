@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"net/http"
+	"strconv"
+)
+
+// generic json object, to keep the document below readable
+type jsonObject map[string]interface{}
+
+// describes an operation requiring scope, answering ok on success and any of
+// responses otherwise.
+func operation(summary, scope string, params []jsonObject, ok int, responses ...int) jsonObject {
+	codes := jsonObject{}
+	codes[strconv.Itoa(ok)] = jsonObject{"description": http.StatusText(ok), "content": jsonContent()}
+
+	for _, code := range responses {
+		codes[strconv.Itoa(code)] = jsonObject{"description": http.StatusText(code)}
+	}
+	codes["401"] = jsonObject{"description": "missing or invalid credentials"}
+	codes["403"] = jsonObject{"description": "token lacks the " + scope + " scope or camera access"}
+
+	op := jsonObject{"summary": summary, "responses": codes, "x-scope": scope}
+	if 0 != len(params) {
+		op["parameters"] = params
+	}
+
+	return op
+}
+
+// describes op's success as an image rather than json.
+func imageOperation(op jsonObject) jsonObject {
+	for code, response := range op["responses"].(jsonObject) {
+		if "200" == code {
+			response.(jsonObject)["content"] = jsonObject{"image/*": jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}}
+		}
+	}
+
+	return op
+}
+
+func jsonContent() jsonObject {
+	return jsonObject{"application/json": jsonObject{"schema": jsonObject{"type": "object"}}}
+}
+
+func pathParam(name, description string, schema jsonObject) jsonObject {
+	return jsonObject{"name": name, "in": "path", "required": true, "description": description, "schema": schema}
+}
+
+func queryParam(name, description string, schema jsonObject) jsonObject {
+	return jsonObject{"name": name, "in": "query", "required": false, "description": description, "schema": schema}
+}
+
+// builds an OpenAPI 3 description of the api. configured cameras and tours
+// are listed as examples, so the explorer offers them.
+func openAPIDocument(conf config.Config) jsonObject {
+	var cameras, tours []interface{}
+
+	for _, camera := range conf.Cameras {
+		if "" != camera.Name {
+			cameras = append(cameras, camera.Name)
+		}
+	}
+	for _, tour := range conf.Tours {
+		tours = append(tours, tour.Name)
+	}
+
+	cameraSchema := jsonObject{"type": "string"}
+	if 0 != len(cameras) {
+		cameraSchema["example"] = cameras[0]
+	}
+	tourSchema := jsonObject{"type": "string"}
+	if 0 != len(tours) {
+		tourSchema["enum"] = tours
+	}
+
+	version := VERSION
+	if "" == version {
+		version = "dev"
+	}
+
+	camera := pathParam("camera", "camera name or pelco-d address", cameraSchema)
+	preset := pathParam("preset", "preset number", jsonObject{"type": "integer", "minimum": 1, "maximum": 255})
+	tour := pathParam("tour", "tour name", tourSchema)
+	recording := pathParam("recording", "stored recording name", jsonObject{"type": "string", "pattern": recordingName.String()})
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":       "cctv-ptz",
+			"version":     version,
+			"description": "Pelco-D camera control. commands fail with 409 while the joystick or another source has control.",
+		},
+		"components": jsonObject{
+			"securitySchemes": jsonObject{
+				"bearer": jsonObject{"type": "http", "scheme": "bearer"},
+				"basic":  jsonObject{"type": "http", "scheme": "basic"},
+				"query":  jsonObject{"type": "apiKey", "in": "query", "name": "access_token"},
+			},
+		},
+		"security": []jsonObject{{"bearer": []string{}}, {"basic": []string{}}, {"query": []string{}}},
+		"paths": jsonObject{
+			"/api/state": jsonObject{
+				"get": operation("current address, camera, last command, and controlling source", ScopeView, nil, http.StatusOK),
+			},
+			"/api/state/ws": jsonObject{
+				"get": operation("websocket pushing the state as json on every change", ScopeView, nil, http.StatusSwitchingProtocols),
+			},
+			"/api/orientation": jsonObject{
+				"get": operation("estimated pan, tilt, and zoom of the selected camera", ScopeView,
+					[]jsonObject{queryParam("camera", "camera name or address instead of the selected one", jsonObject{"type": "string"})},
+					http.StatusOK, http.StatusNotFound),
+			},
+			"/api/stats": jsonObject{
+				"get": operation("frames sent, replies, checksum errors, and timeouts per address", ScopeView, nil, http.StatusOK),
+			},
+			"/metrics": jsonObject{
+				"get": operation("the /api/stats counts in the prometheus text format", ScopeView, nil, http.StatusOK),
+			},
+			"/api/cameras/{camera}/presets": jsonObject{
+				"get": operation("list named presets and presets with thumbnails", ScopeView, []jsonObject{camera}, http.StatusOK, http.StatusNotFound),
+			},
+			"/api/cameras/{camera}/presets/{preset}": jsonObject{
+				"post": operation("save the current position as a preset", ScopePreset,
+					[]jsonObject{camera, preset, queryParam("name", "label for the preset", jsonObject{"type": "string"})},
+					http.StatusOK, http.StatusConflict),
+				"delete": operation("clear a preset", ScopePreset, []jsonObject{camera, preset}, http.StatusOK, http.StatusConflict),
+			},
+			"/api/cameras/{camera}/presets/{preset}/call": jsonObject{
+				"post": operation("recall a preset", ScopePreset, []jsonObject{camera, preset}, http.StatusOK, http.StatusConflict),
+			},
+			"/api/cameras/{camera}/presets/{preset}/thumbnail": jsonObject{
+				"get": imageOperation(operation("image taken from the snapshot-url as the preset was saved", ScopeView,
+					[]jsonObject{camera, preset}, http.StatusOK, http.StatusNotFound)),
+			},
+			"/api/cameras/{camera}/tours": jsonObject{
+				"get": operation("list tours and the running tour's status", ScopeView, []jsonObject{camera}, http.StatusOK),
+			},
+			"/api/cameras/{camera}/tours/{tour}": jsonObject{
+				"get": operation("status of a running tour", ScopeView, []jsonObject{camera, tour}, http.StatusOK, http.StatusNotFound),
+			},
+			"/api/cameras/{camera}/tours/{tour}/start": jsonObject{
+				"post": operation("start a tour", ScopeMove,
+					[]jsonObject{camera, tour, queryParam("loop", "repeat until stopped", jsonObject{"type": "boolean"})},
+					http.StatusAccepted, http.StatusNotFound, http.StatusConflict),
+			},
+			"/api/cameras/{camera}/tours/stop": jsonObject{
+				"post": operation("stop the running tour", ScopeMove, []jsonObject{camera}, http.StatusOK, http.StatusNotFound),
+			},
+			"/api/recordings": jsonObject{
+				"get": operation("list stored recordings and the playback status", ScopeView, nil, http.StatusOK),
+			},
+			"/api/recordings/{recording}": jsonObject{
+				"get": operation("describe a stored recording", ScopeView, []jsonObject{recording}, http.StatusOK, http.StatusNotFound),
+				"put": operation("store the request body as a recording, replacing any of the same name", ScopeAdmin,
+					[]jsonObject{recording}, http.StatusCreated, http.StatusBadRequest, http.StatusUnprocessableEntity),
+				"delete": operation("delete a stored recording", ScopeAdmin, []jsonObject{recording}, http.StatusOK, http.StatusNotFound),
+			},
+			"/api/recordings/{recording}/play": jsonObject{
+				"post": operation("play a stored recording, stopping any already playing", ScopeMove,
+					[]jsonObject{recording, queryParam("loop", "repeat until stopped", jsonObject{"type": "boolean"}),
+						queryParam("confirm", "wait at each mark until continued", jsonObject{"type": "boolean"})},
+					http.StatusAccepted, http.StatusNotFound, http.StatusConflict),
+			},
+			"/api/recordings/stop": jsonObject{
+				"post": operation("stop the playing recording", ScopeMove, nil, http.StatusOK, http.StatusNotFound),
+			},
+			"/api/recordings/continue": jsonObject{
+				"post": operation("continue the playing recording from the mark it waits at", ScopeMove, nil,
+					http.StatusOK, http.StatusNotFound, http.StatusConflict),
+			},
+		},
+	}
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(s.conf))
+}
+
+func (s *APIServer) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, docsPage)
+}
+
+// a page listing the operations in /api/openapi.json with a form to try
+// each. api tokens are passed along from the page's own ?access_token=.
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cctv-ptz api</title>
+<style>
+  body { background: #111; color: #ddd; font: 14px monospace; margin: 1em; }
+  .op { border-top: 1px solid #333; padding: 0.5em 0; }
+  .method { display: inline-block; width: 4em; color: #5bd; }
+  .scope { color: #888; }
+  input { background: #222; color: #ddd; border: 1px solid #444; margin-right: 0.5em; }
+  pre { background: #1a1a1a; padding: 0.5em; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h3 id="title">cctv-ptz api</h3>
+<div id="ops">loading...</div>
+<script>
+var params = new URLSearchParams(location.search);
+var token = params.get("access_token");
+
+function withToken(url) {
+  if (!token) return url;
+  return url + (url.indexOf("?") < 0 ? "?" : "&") + "access_token=" + encodeURIComponent(token);
+}
+
+function render(doc) {
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  var ops = document.getElementById("ops");
+  ops.textContent = "";
+
+  Object.keys(doc.paths).sort().forEach(function (path) {
+    Object.keys(doc.paths[path]).forEach(function (method) {
+      var op = doc.paths[path][method];
+      var div = document.createElement("div");
+      div.className = "op";
+
+      var head = document.createElement("div");
+      head.innerHTML = "<span class=method></span><b></b> - <span></span> <span class=scope></span>";
+      head.children[0].textContent = method.toUpperCase();
+      head.children[1].textContent = path;
+      head.children[2].textContent = op.summary;
+      head.children[3].textContent = "(" + op["x-scope"] + ")";
+      div.appendChild(head);
+
+      var inputs = {};
+      (op.parameters || []).forEach(function (p) {
+        var input = document.createElement("input");
+        input.placeholder = p.name;
+        input.title = p.description;
+        if (p.schema.enum) input.value = p.schema.enum[0];
+        if (p.schema.example) input.value = p.schema.example;
+        inputs[p.name] = {param: p, input: input};
+        div.appendChild(input);
+      });
+
+      var button = document.createElement("button");
+      button.textContent = "try";
+      var out = document.createElement("pre");
+      out.hidden = true;
+      div.appendChild(button);
+      div.appendChild(out);
+
+      button.onclick = function () {
+        var url = path, query = [];
+        Object.keys(inputs).forEach(function (name) {
+          var value = inputs[name].input.value;
+          if ("path" == inputs[name].param.in) {
+            url = url.replace("{" + name + "}", encodeURIComponent(value));
+          } else if (value) {
+            query.push(name + "=" + encodeURIComponent(value));
+          }
+        });
+        if (query.length) url += "?" + query.join("&");
+
+        out.hidden = false;
+        out.textContent = method.toUpperCase() + " " + url + "\n...";
+        fetch(withToken(url), {method: method.toUpperCase(), credentials: "same-origin"})
+          .then(function (r) { return r.text().then(function (t) { out.textContent = r.status + " " + r.statusText + "\n" + t; }); })
+          .catch(function (e) { out.textContent = String(e); });
+      };
+
+      ops.appendChild(div);
+    });
+  });
+}
+
+fetch(withToken("/api/openapi.json"), {credentials: "same-origin"})
+  .then(function (r) { return r.json(); })
+  .then(render)
+  .catch(function () { document.getElementById("ops").textContent = "unable to load /api/openapi.json"; });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// assumed rate at full speed for cameras without a calibrated speed table
+const uncalibratedFullRate = 40.0 // deg/sec
+
+// assumed time to zoom from wide to tele for cameras without zoom-time
+const defaultZoomTime = 5 * time.Second
+
+// Orientation is a camera's estimated pan, tilt, and zoom. pan and tilt are
+// relative to wherever tracking started until Referenced, i.e. until the
+// camera reports its position or is sent to an absolute one. zoom is 0.0
+// (wide) to 1.0 (tele).
+type Orientation struct {
+	Address    int          `json:"address"`
+	Camera     string       `json:"camera,omitempty"`
+	Pan        float64      `json:"pan"`
+	Tilt       float64      `json:"tilt"`
+	Zoom       float64      `json:"zoom"`
+	Referenced bool         `json:"referenced"`
+	Calibrated bool         `json:"calibrated"`
+	Moving     bool         `json:"moving"`
+	Source     string       `json:"source"`           // "dead reckoning", "queried", "absolute", or "restored"
+	Preset     int          `json:"preset,omitempty"` // last preset called, until the camera moves
+	Aux        map[int]bool `json:"aux,omitempty"`
+	Updated    time.Time    `json:"updated"`
+}
+
+type estimate struct {
+	Orientation
+
+	panRate  float64 // deg/sec, positive clockwise
+	tiltRate float64 // deg/sec, positive up
+	zoomRate float64 // range/sec, positive toward tele
+	since    time.Time
+}
+
+// Estimator dead reckons each camera's orientation from the frames sent to
+// it, correcting from position replies when cameras send them.
+type Estimator struct {
+	mu        sync.Mutex
+	conf      config.Config
+	estimates map[int]*estimate
+	changes   int // bumped on every update, so savers can skip unchanged state
+}
+
+func NewEstimator(conf config.Config) *Estimator {
+	return &Estimator{conf: conf, estimates: make(map[int]*estimate)}
+}
+
+// replaces the config the speed tables and zoom times come from, e.g. after
+// a reload.
+func (e *Estimator) SetConfig(conf config.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.conf = conf
+}
+
+// returns the estimate for address advanced to now. callers hold e.mu.
+func (e *Estimator) get(address int, now time.Time) *estimate {
+	est, ok := e.estimates[address]
+	if !ok {
+		camera, _ := e.conf.Camera(address)
+		est = &estimate{since: now}
+		est.Address = address
+		est.Camera = camera.Name
+		est.Calibrated = 0 != len(camera.PanSpeeds) && 0 != len(camera.TiltSpeeds)
+		est.Source = "dead reckoning"
+		e.estimates[address] = est
+	}
+
+	elapsed := now.Sub(est.since).Seconds()
+	if elapsed > 0 {
+		est.Pan = wrapPan(est.Pan + est.panRate*elapsed)
+		est.Tilt = math.Max(-90, math.Min(90, est.Tilt+est.tiltRate*elapsed))
+		est.Zoom = math.Max(0, math.Min(1, est.Zoom+est.zoomRate*elapsed))
+		est.since = now
+		if est.Moving {
+			est.Updated = now
+		}
+	}
+
+	return est
+}
+
+// updates the estimate of the addressed camera for a frame sent at now.
+func (e *Estimator) Observe(message PelcoDMessage, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	command, _ := pelco.Decode(message)
+	est := e.get(command.Address, now)
+	camera, _ := e.conf.Camera(command.Address)
+
+	est.Updated = now
+	e.changes++
+
+	if command.Extended {
+		switch command.Code {
+		case pelco.SetPanPosition:
+			est.Pan, est.Source, est.Referenced = panFromPelco(command.Value()), "absolute", true
+		case pelco.SetTiltPosition:
+			est.Tilt, est.Source, est.Referenced = tiltFromPelco(command.Value()), "absolute", true
+		case pelco.CallPreset:
+			// presets move the camera somewhere we can't know
+			est.Referenced = false
+			est.Source = fmt.Sprintf("preset %d", command.Data2)
+			est.Preset = int(command.Data2)
+		case pelco.SetAux, pelco.ClearAux:
+			if nil == est.Aux {
+				est.Aux = make(map[int]bool)
+			}
+			est.Aux[int(command.Data2)] = pelco.SetAux == command.Code
+		}
+		return
+	}
+
+	est.panRate = float64(command.Pan) * speedRate(camera.PanSpeeds, command.PanSpeed)
+	est.tiltRate = float64(command.Tilt) * speedRate(camera.TiltSpeeds, command.TiltSpeed)
+
+	zoomTime := defaultZoomTime
+	if camera.ZoomTime > 0 {
+		zoomTime = camera.ZoomTime
+	}
+
+	est.zoomRate = float64(command.Zoom) / zoomTime.Seconds()
+
+	est.Moving = 0 != est.panRate || 0 != est.tiltRate || 0 != est.zoomRate
+	if est.Moving {
+		est.Source = "dead reckoning"
+		est.Preset = 0
+	}
+}
+
+// corrects the estimate from a camera's position reply.
+func (e *Estimator) Report(response PelcoDResponse, now time.Time) {
+	if !response.Extended {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	est := e.get(int(response.Address), now)
+	value := uint16(response.Data[0])<<8 | uint16(response.Data[1])
+
+	switch response.Opcode {
+	case pelco.PanResponse:
+		est.Pan, est.Referenced = panFromPelco(value), true
+	case pelco.TiltResponse:
+		est.Tilt, est.Referenced = tiltFromPelco(value), true
+	case pelco.ZoomResponse:
+		est.Zoom = zoomFromPelco(value)
+	default:
+		return
+	}
+
+	est.Source = "queried"
+	est.Updated = now
+	e.changes++
+}
+
+// returns the current estimate for address.
+func (e *Estimator) Orientation(address int, now time.Time) Orientation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.get(address, now).Orientation
+}
+
+// returns every camera's estimate and a change count for Changed.
+func (e *Estimator) Snapshot(now time.Time) ([]Orientation, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var all []Orientation
+	for address := range e.estimates {
+		orientation := e.get(address, now).Orientation
+
+		// copy so callers can't race later aux updates
+		aux := make(map[int]bool)
+		for k, v := range orientation.Aux {
+			aux[k] = v
+		}
+		orientation.Aux = aux
+
+		all = append(all, orientation)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Address < all[j].Address })
+
+	return all, e.changes
+}
+
+// reports whether anything changed since the Snapshot that returned changes.
+func (e *Estimator) Changed(changes int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return changes != e.changes
+}
+
+// seeds estimates from a saved snapshot. cameras are assumed to be at rest
+// where they were left.
+func (e *Estimator) Restore(saved []Orientation, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, orientation := range saved {
+		est := e.get(orientation.Address, now)
+		calibrated, camera := est.Calibrated, est.Camera
+
+		est.Orientation = orientation
+		est.Calibrated, est.Camera = calibrated, camera
+		est.Moving = false
+		est.Source = "restored"
+		est.panRate, est.tiltRate, est.zoomRate = 0, 0, 0
+	}
+}
+
+// returns the calibrated rate (deg/sec) of a speed byte, interpolating between
+// calibrated points. inverse of rateToSpeed.
+func speedRate(points []config.SpeedPoint, speed uint8) float64 {
+	if 0 == speed {
+		return 0
+	}
+
+	if speed > pelco.FullSpeed {
+		speed = pelco.FullSpeed
+	}
+
+	if 0 == len(points) {
+		return uncalibratedFullRate * float64(speed) / pelco.FullSpeed
+	}
+
+	sorted := append([]config.SpeedPoint{{Speed: 0, DegreesPerSecond: 0}}, points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Speed < sorted[j].Speed })
+
+	for i := 1; i < len(sorted); i++ {
+		lower, upper := sorted[i-1], sorted[i]
+		if int(speed) <= upper.Speed {
+			t := float64(int(speed)-lower.Speed) / float64(upper.Speed-lower.Speed)
+			return lower.DegreesPerSecond + t*(upper.DegreesPerSecond-lower.DegreesPerSecond)
+		}
+	}
+
+	return sorted[len(sorted)-1].DegreesPerSecond
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// control surfaces are driven by an operator, so they outrank the api and
+// automation, but still yield to the joystick.
+var oscSource = Source{Name: "osc", Priority: 70}
+
+// OSCMessage is a decoded OSC message. arguments are int32, int64, float32,
+// float64, string, or bool.
+type OSCMessage struct {
+	Address string
+	Args    []interface{}
+	Sender  string // host the packet came from, for rate limiting
+}
+
+// decodes an OSC packet, flattening bundles into their messages.
+func parseOSC(packet []byte) ([]OSCMessage, error) {
+	if 0 == len(packet) {
+		return nil, errors.New("empty packet")
+	}
+
+	if '#' == packet[0] {
+		return parseOSCBundle(packet)
+	}
+
+	address, rest, err := readOSCString(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	message := OSCMessage{Address: address}
+
+	// old senders may omit the type tags for argumentless messages
+	if 0 == len(rest) {
+		return []OSCMessage{message}, nil
+	}
+
+	tags, rest, err := readOSCString(rest)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return nil, fmt.Errorf("%s: missing type tags", address)
+	}
+
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'i', 'f':
+			if len(rest) < 4 {
+				return nil, fmt.Errorf("%s: truncated argument", address)
+			}
+			bits := binary.BigEndian.Uint32(rest)
+			if 'i' == tag {
+				message.Args = append(message.Args, int32(bits))
+			} else {
+				message.Args = append(message.Args, math.Float32frombits(bits))
+			}
+			rest = rest[4:]
+		case 'h', 'd':
+			if len(rest) < 8 {
+				return nil, fmt.Errorf("%s: truncated argument", address)
+			}
+			bits := binary.BigEndian.Uint64(rest)
+			if 'h' == tag {
+				message.Args = append(message.Args, int64(bits))
+			} else {
+				message.Args = append(message.Args, math.Float64frombits(bits))
+			}
+			rest = rest[8:]
+		case 's':
+			var text string
+			if text, rest, err = readOSCString(rest); err != nil {
+				return nil, err
+			}
+			message.Args = append(message.Args, text)
+		case 'T', 'F':
+			message.Args = append(message.Args, 'T' == tag)
+		default:
+			return nil, fmt.Errorf("%s: unsupported argument type %c", address, tag)
+		}
+	}
+
+	return []OSCMessage{message}, nil
+}
+
+func parseOSCBundle(packet []byte) ([]OSCMessage, error) {
+	name, rest, err := readOSCString(packet)
+	if err != nil {
+		return nil, err
+	}
+	if "#bundle" != name || len(rest) < 8 {
+		return nil, errors.New("invalid bundle")
+	}
+
+	// skip the time tag; everything is run on arrival
+	rest = rest[8:]
+
+	var messages []OSCMessage
+
+	for 0 != len(rest) {
+		if len(rest) < 4 {
+			return nil, errors.New("truncated bundle")
+		}
+
+		size := int(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if size > len(rest) {
+			return nil, errors.New("truncated bundle")
+		}
+
+		elements, err := parseOSC(rest[:size])
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, elements...)
+		rest = rest[size:]
+	}
+
+	return messages, nil
+}
+
+// reads a nul terminated string padded to a multiple of four bytes.
+func readOSCString(b []byte) (string, []byte, error) {
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return "", nil, errors.New("unterminated string")
+	}
+
+	padded := (end + 4) &^ 3
+	if padded > len(b) {
+		padded = len(b)
+	}
+
+	return string(b[:end]), b[padded:], nil
+}
+
+// returns argument i as a number. bools are 1 or 0.
+func oscNumber(message OSCMessage, i int) (float64, bool) {
+	if i >= len(message.Args) {
+		return 0, false
+	}
+
+	switch value := message.Args[i].(type) {
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float32:
+		return float64(value), true
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// returns argument i as text, formatting numbers without a fraction.
+func oscText(message OSCMessage, i int) (string, bool) {
+	if i >= len(message.Args) {
+		return "", false
+	}
+
+	if text, ok := message.Args[i].(string); ok {
+		return text, true
+	}
+
+	if number, ok := oscNumber(message, i); ok && number == math.Trunc(number) {
+		return fmt.Sprintf("%d", int(number)), true
+	}
+
+	return "", false
+}
+
+// reports whether a trigger message fires. surfaces send 1 on press and 0 on
+// release, or no argument at all.
+func oscPressed(message OSCMessage) bool {
+	value, ok := oscNumber(message, 0)
+
+	return 0 == len(message.Args) || (ok && 0 != value)
+}
+
+// interprets message for the camera at conf.Address. configured bindings
+// are matched first, then the built in /ptz addresses, which may name a
+// camera, e.g. /ptz/gate/preset.
+func oscCommand(conf config.Config, message OSCMessage) (SurfaceCommand, error) {
+	command := SurfaceCommand{Select: -1}
+
+	for _, binding := range conf.OSCBindings {
+		if binding.Address != message.Address {
+			continue
+		}
+
+		if !oscPressed(message) {
+			return command, nil
+		}
+
+		camera, err := oscCamera(conf, binding.Camera)
+		if err != nil {
+			return command, err
+		}
+
+		actions, err := parseMacro(camera, binding.Actions)
+		if err != nil {
+			return command, fmt.Errorf("%s: %s", message.Address, err)
+		}
+
+		for _, action := range actions {
+			if 0 != action.Wait {
+				return command, fmt.Errorf("%s: wait is not supported in osc bindings", message.Address)
+			}
+			command.Messages = append(command.Messages, action.Messages...)
+		}
+
+		return command, nil
+	}
+
+	if !strings.HasPrefix(message.Address, "/ptz/") {
+		return command, fmt.Errorf("unknown address %s", message.Address)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(message.Address, "/ptz/"), "/")
+
+	name, verb := "", parts[0]
+	if 2 == len(parts) {
+		name, verb = parts[0], parts[1]
+	} else if 1 != len(parts) {
+		return command, fmt.Errorf("unknown address %s", message.Address)
+	}
+
+	camera, err := oscCamera(conf, name)
+	if err != nil {
+		return command, err
+	}
+
+	to := func() *pelco.Builder { return pelco.New().To(camera.Address) }
+
+	switch verb {
+	case "camera":
+		text, ok := oscText(message, 0)
+		if !ok {
+			return command, fmt.Errorf("%s: expected a camera name or address", message.Address)
+		}
+		if command.Select, err = parseCamera(conf, text); err != nil {
+			return command, err
+		}
+
+	case "move":
+		pan, okPan := oscNumber(message, 0)
+		tilt, okTilt := oscNumber(message, 1)
+		if !okPan || !okTilt {
+			return command, fmt.Errorf("%s: expected PAN TILT [ZOOM], -1.0 to 1.0", message.Address)
+		}
+		zoom, _ := oscNumber(message, 2)
+
+		move := applyJoystick(to(), clampAxis(float32(pan)), clampAxis(float32(tilt)), clampAxis(float32(zoom)), false, false, false, conf.MaxSpeed)
+		command.Messages = append(command.Messages, move.Build())
+
+	case "stop":
+		command.Messages = append(command.Messages, to().Build())
+
+	case "preset", "aux", "action":
+		if !oscPressed(message) && "action" != verb {
+			return command, nil
+		}
+
+		var text string
+		switch verb {
+		case "preset":
+			number, ok := oscText(message, 0)
+			if !ok {
+				return command, fmt.Errorf("%s: expected a preset number", message.Address)
+			}
+			text = "preset " + number
+		case "aux":
+			// /ptz/aux NUM STATE, where STATE is on/off or 1/0
+			number, ok := oscText(message, 0)
+			state, _ := oscText(message, 1)
+			if !ok {
+				return command, fmt.Errorf("%s: expected an aux number", message.Address)
+			}
+			if "1" == state {
+				state = "on"
+			} else if "0" == state {
+				state = "off"
+			}
+			text = "aux " + number + " " + state
+		case "action":
+			var ok bool
+			if text, ok = oscText(message, 0); !ok {
+				return command, fmt.Errorf("%s: expected an action", message.Address)
+			}
+		}
+
+		action, err := parseAction(camera, text)
+		if err != nil {
+			return command, err
+		}
+		if 0 != action.Wait {
+			return command, fmt.Errorf("%s: wait is not supported over osc", message.Address)
+		}
+		command.Messages = append(command.Messages, action.Messages...)
+
+	default:
+		return command, fmt.Errorf("unknown address %s", message.Address)
+	}
+
+	return command, nil
+}
+
+// returns the named or numbered camera, or the selected one for "".
+func oscCamera(conf config.Config, name string) (config.Camera, error) {
+	address := conf.Address
+
+	if "" != name {
+		var err error
+		if address, err = parseCamera(conf, name); err != nil {
+			return config.Camera{}, err
+		}
+	}
+
+	camera, ok := conf.Camera(address)
+	if !ok {
+		camera.Address = address
+	}
+
+	return camera, nil
+}
+
+// receives OSC packets on conn.
+func listenOSC(conn net.PacketConn) <-chan OSCMessage {
+	messages := make(chan OSCMessage)
+	buffer := make([]byte, 4096)
+
+	go func() {
+		defer close(messages)
+
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				printError("osc: %s\n", err)
+				return
+			}
+
+			decoded, err := parseOSC(buffer[:n])
+			if err != nil {
+				printError("osc: %s\n", err)
+				continue
+			}
+
+			for _, message := range decoded {
+				message.Sender = clientHost(from)
+				messages <- message
+			}
+		}
+	}()
+
+	return messages
+}
+
+func init() {
+	registerInput(oscInput{})
+}
+
+// oscInput takes moves, presets, and aux actions from control surfaces.
+type oscInput struct{}
+
+func (oscInput) Source() Source {
+	return oscSource
+}
+
+// opens the udp port control surfaces send to, when osc is enabled.
+// surfaces past their allowance are dropped, but not their stops.
+func (oscInput) Start(conf config.Config) (<-chan Input, error) {
+	if "" == conf.OSC {
+		return nil, nil
+	}
+
+	conn, err := net.ListenPacket("udp", conf.OSC)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for OSC on %s. %s", conf.OSC, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Listening for OSC on udp %s\n", conn.LocalAddr())
+
+	messages := listenOSC(conn)
+	limiter := NewRateLimiter(conf.RateLimit, conf.RateBurst)
+	inputs := make(chan Input)
+
+	go func() {
+		defer close(inputs)
+
+		for message := range messages {
+			message := message
+
+			inputs <- Input{Source: oscSource, Command: func(conf config.Config) (SurfaceCommand, error) {
+				command, err := oscCommand(conf, message)
+				if nil == err && !isStopCommand(command) {
+					if ok, _ := limiter.Allow(message.Sender, time.Now()); !ok {
+						return SurfaceCommand{Select: -1}, nil
+					}
+				}
+
+				return command, err
+			}}
+		}
+	}()
+
+	return inputs, nil
+}
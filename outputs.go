@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a failed output waits before it is tried again
+const outputRetry = 5 * time.Second
+
+// an output a camera's commands are copied to
+type outputSender interface {
+	Send(message PelcoDMessage)
+	Close()
+}
+
+// Outputs copies each frame to the other outputs of the camera it's
+// addressed to, so a dome wired to both an analog matrix and an IP encoder
+// follows along on both, and every frame to the tee ports. a nil Outputs
+// has none.
+type Outputs struct {
+	cameras map[int][]outputSender
+	tee     []outputSender
+	senders []outputSender
+}
+
+// opens the outputs of every configured camera, and the tee ports. a serial
+// port shared by several cameras is opened once.
+func openOutputs(conf config.Config) (*Outputs, error) {
+	outputs := &Outputs{cameras: make(map[int][]outputSender)}
+	ports := make(map[string]*serialOutput)
+
+	for _, port := range conf.Tee {
+		settings := conf
+		settings.SerialPort = port
+
+		tee := newSerialOutput(settings)
+		outputs.tee = append(outputs.tee, tee)
+		outputs.senders = append(outputs.senders, tee)
+	}
+
+	for _, camera := range conf.Cameras {
+		for _, output := range camera.Outputs {
+			var sender outputSender
+
+			switch output.Type {
+			case "serial":
+				if "" == output.Serial {
+					outputs.Close()
+					return nil, fmt.Errorf("%s: serial output needs a serial port", describeCamera(conf, camera.Address))
+				}
+
+				settings := conf
+				settings.SerialPort = output.Serial
+				if output.Baud > 0 {
+					settings.BaudRate = output.Baud
+				}
+
+				key := settings.SerialPort + "@" + strconv.Itoa(settings.BaudRate)
+				if port, ok := ports[key]; ok {
+					sender = port
+				} else {
+					port = newSerialOutput(settings)
+					ports[key] = port
+					outputs.senders = append(outputs.senders, port)
+					sender = port
+				}
+			case "http":
+				if "" == output.Move {
+					outputs.Close()
+					return nil, fmt.Errorf("%s: http output needs a move url", describeCamera(conf, camera.Address))
+				}
+
+				web := newHTTPOutput(output)
+				outputs.senders = append(outputs.senders, web)
+				sender = web
+			default:
+				outputs.Close()
+				return nil, fmt.Errorf("%s: unknown output type %s. expected serial or http", describeCamera(conf, camera.Address), output.Type)
+			}
+
+			outputs.cameras[camera.Address] = append(outputs.cameras[camera.Address], sender)
+		}
+	}
+
+	return outputs, nil
+}
+
+// copies message to the outputs of the camera it's addressed to.
+func (o *Outputs) Send(message PelcoDMessage) {
+	if nil == o {
+		return
+	}
+
+	for _, sender := range o.cameras[int(message[pelco.Addr])] {
+		sender.Send(message)
+	}
+
+	for _, sender := range o.tee {
+		sender.Send(message)
+	}
+}
+
+func (o *Outputs) Close() {
+	if nil == o {
+		return
+	}
+
+	for _, sender := range o.senders {
+		sender.Close()
+	}
+}
+
+// serialOutput writes Pelco-D frames on a port of their own, or a tcp://,
+// rfc2217://, or udp:// link standing in for one, reopening it a while
+// after it fails.
+type serialOutput struct {
+	settings config.Config
+	encoder  *frameEncoder
+	tty      Port
+	retryAt  time.Time
+	failing  bool
+}
+
+func newSerialOutput(settings config.Config) *serialOutput {
+	o := &serialOutput{settings: settings, encoder: newFrameEncoder(settings)}
+	o.open()
+
+	return o
+}
+
+func (o *serialOutput) open() {
+	var (
+		tty Port
+		err error
+	)
+
+	switch port := o.settings.SerialPort; {
+	case isTCPSerial(port):
+		tty, err = dialTCPSerial(o.settings)
+	case isUDPSerial(port):
+		tty, err = dialUDPSerial(port)
+	default:
+		tty, err = openSerialDevice(o.settings, port)
+	}
+
+	if err != nil {
+		o.failed(err)
+		return
+	}
+
+	o.tty = tty
+}
+
+func (o *serialOutput) failed(err error) {
+	o.retryAt = time.Now().Add(outputRetry)
+
+	if !o.failing {
+		printError("output %s failed. %s\n", o.settings.SerialPort, err)
+		o.failing = true
+	}
+}
+
+func (o *serialOutput) Send(message PelcoDMessage) {
+	if nil == o.tty {
+		if time.Now().Before(o.retryAt) {
+			return
+		}
+		if o.open(); nil == o.tty {
+			return
+		}
+	}
+
+	packet := o.encoder.Encode(message)
+	if 0 == len(packet) {
+		return
+	}
+
+	if _, err := o.tty.Write(packet); err != nil {
+		o.tty.Close()
+		o.tty = nil
+		o.failed(err)
+		return
+	}
+
+	if o.failing {
+		printError("output %s is back\n", o.settings.SerialPort)
+		o.failing = false
+	}
+}
+
+func (o *serialOutput) Close() {
+	if nil != o.tty {
+		o.tty.Close()
+		o.tty = nil
+	}
+}
+
+// an http request for a frame. moves and stops replace one another while
+// they wait, so a slow encoder is only sent the latest motion.
+type outputRequest struct {
+	target string
+	motion bool
+}
+
+// httpOutput requests URLs built from an output's templates, in order, in
+// the background, so a slow encoder doesn't hold up the serial port.
+type httpOutput struct {
+	output config.Output
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []outputRequest
+	wake    chan struct{}
+	done    chan struct{}
+	failing bool
+}
+
+func newHTTPOutput(output config.Output) *httpOutput {
+	o := &httpOutput{
+		output: output,
+		client: &http.Client{Timeout: 2 * time.Second},
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	go o.run()
+
+	return o
+}
+
+func (o *httpOutput) Send(message PelcoDMessage) {
+	request, ok := outputURL(o.output, message)
+	if !ok {
+		return
+	}
+
+	o.mu.Lock()
+	if n := len(o.pending); request.motion && n > 0 && o.pending[n-1].motion {
+		o.pending[n-1] = request
+	} else {
+		o.pending = append(o.pending, request)
+	}
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *httpOutput) run() {
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-o.wake:
+		}
+
+		for {
+			o.mu.Lock()
+			if 0 == len(o.pending) {
+				o.mu.Unlock()
+				break
+			}
+			request := o.pending[0]
+			o.pending = o.pending[1:]
+			o.mu.Unlock()
+
+			o.request(request.target)
+		}
+	}
+}
+
+func (o *httpOutput) request(target string) {
+	response, err := o.client.Get(target)
+	if nil == err {
+		response.Body.Close()
+		if response.StatusCode >= 300 {
+			err = fmt.Errorf("%s", response.Status)
+		}
+	}
+
+	// the templates may hold credentials, so only the host is named
+	host := "http output"
+	if parsed, parseErr := url.Parse(target); nil == parseErr {
+		host = parsed.Host
+	}
+
+	if err != nil && !o.failing {
+		printError("output %s failed. %s\n", host, err)
+		o.failing = true
+	} else if nil == err && o.failing {
+		printError("output %s is back\n", host)
+		o.failing = false
+	}
+}
+
+func (o *httpOutput) Close() {
+	close(o.done)
+}
+
+// returns the request an http output makes for message, or false when it
+// has no template for it.
+func outputURL(output config.Output, message PelcoDMessage) (outputRequest, bool) {
+	command, err := pelco.Decode(message)
+	if err != nil {
+		return outputRequest{}, false
+	}
+
+	var (
+		template string
+		motion   bool
+		fields   = map[string]int{"address": command.Address}
+	)
+
+	if command.Extended {
+		templates := map[uint8]string{
+			pelco.CallPreset:  output.CallPreset,
+			pelco.SetPreset:   output.SetPreset,
+			pelco.ClearPreset: output.ClearPreset,
+			pelco.SetAux:      output.SetAux,
+			pelco.ClearAux:    output.ClearAux,
+		}
+
+		// the menu preset means nothing to an encoder
+		if pelco.SetPreset == command.Code && pelco.MenuPreset == command.Data2 {
+			return outputRequest{}, false
+		}
+
+		template = templates[command.Code]
+		fields["preset"] = int(command.Data2)
+		fields["aux"] = int(command.Data2)
+	} else {
+		motion = true
+		fields["pan"] = command.Pan * speedPercent(command.PanSpeed)
+		fields["tilt"] = command.Tilt * speedPercent(command.TiltSpeed)
+		fields["zoom"] = command.Zoom * 100
+
+		template = output.Move
+		if 0 == fields["pan"] && 0 == fields["tilt"] && 0 == fields["zoom"] && "" != output.Stop {
+			template = output.Stop
+		}
+	}
+
+	if "" == template {
+		return outputRequest{}, false
+	}
+
+	var replace []string
+	for name, value := range fields {
+		replace = append(replace, "{"+name+"}", strconv.Itoa(value))
+	}
+
+	return outputRequest{strings.NewReplacer(replace...).Replace(template), motion}, true
+}
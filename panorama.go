@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how long a camera that answers position queries gets to reach each step
+const panoramaArrival = 15 * time.Second
+
+// how close to a step's pan a camera must be to count as there
+const panoramaTolerance = 0.5
+
+// how long to let the image settle at each step when the camera doesn't set
+// a pause
+const panoramaPause = 2 * time.Second
+
+// PanoramaOptions override the camera's configured sweep.
+type PanoramaOptions struct {
+	Step  float64
+	Pause time.Duration
+	Out   string // directory the images are written to
+}
+
+// returns the pan angles a sweep stops at, from from clockwise to to every
+// step degrees. the last stop is to, even when step doesn't divide the range.
+// a full circle doesn't repeat its first stop.
+func panoramaSteps(from, to, step float64) ([]float64, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("invalid step %.2f. expected more than 0 degrees", step)
+	}
+
+	span := wrapPan(to - from)
+	full := 0 == span
+	if full {
+		span = 360
+	}
+
+	var steps []float64
+	for offset := 0.0; offset < span-1e-9; offset += step {
+		steps = append(steps, wrapPan(from+offset))
+	}
+
+	if !full {
+		steps = append(steps, wrapPan(to))
+	}
+
+	return steps, nil
+}
+
+// returns the extension for an image of contentType, e.g. ".jpg".
+func snapshotExtension(contentType string) string {
+	switch strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]) {
+	case "image/png":
+		return ".png"
+	case "image/bmp":
+		return ".bmp"
+	default:
+		return ".jpg"
+	}
+}
+
+// fetches url with client and writes the image to dir as name plus the
+// extension its content type calls for. returns the file written.
+func saveSnapshot(client *http.Client, url, dir, name string) (string, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if http.StatusOK != response.StatusCode {
+		return "", fmt.Errorf("snapshot failed. %s", response.Status)
+	}
+
+	path := filepath.Join(dir, name+snapshotExtension(response.Header.Get("Content-Type")))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(file, response.Body); err != nil {
+		file.Close()
+		return "", err
+	}
+
+	return path, file.Close()
+}
+
+// waits until the camera at address is within panoramaTolerance of pan.
+// returns errNoReply if it doesn't answer position queries, leaving the
+// pause to cover the move.
+func awaitPan(positioner Positioner, address int, pan float64) error {
+	deadline := time.Now().Add(panoramaArrival)
+
+	for time.Now().Before(deadline) {
+		position, err := positioner.QueryPosition(address)
+		if err == errNoReply {
+			return err
+		}
+
+		if nil == err && math.Abs(panError(position.Pan, pan)) <= panoramaTolerance {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("did not reach pan %.2f within %s", pan, panoramaArrival)
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "panorama",
+		Summary: "sweep a pan range taking snapshots for stitching",
+		Usage:   []string{"[--step DEG] [--pause DURATION] [--dir DIR]"},
+		Options: []string{
+			"--step DEG               - degrees between images, overriding the camera's step.",
+			"--pause DURATION         - wait DURATION at each step before the snapshot, e.g. 2s.",
+			"--dir DIR                - write images to DIR. (default = .)",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			dir := stringArg(arguments, "--dir")
+			if "" == dir {
+				dir = "."
+			}
+			panorama(conf, PanoramaOptions{
+				Step:  floatArg(arguments, "--step", 0),
+				Pause: durationArg(arguments, "--pause", 0),
+				Out:   dir,
+			})
+		},
+	})
+}
+
+// steps the camera across its configured pan range, taking a snapshot at
+// each stop, and writes a numbered image set for stitching, e.g.
+// panorama-001.jpg from the first stop.
+func panorama(conf config.Config, options PanoramaOptions) {
+	camera, _ := conf.Camera(conf.Address)
+	name := describeCamera(conf, conf.Address)
+
+	if "" == camera.SnapshotURL {
+		printError("%s has no snapshot-url\n", name)
+		os.Exit(1)
+	}
+
+	if nil == camera.Panorama {
+		printError("%s has no panorama range\n", name)
+		os.Exit(1)
+	}
+
+	sweep := *camera.Panorama
+	if options.Step > 0 {
+		sweep.Step = options.Step
+	}
+	if options.Pause > 0 {
+		sweep.Pause = options.Pause
+	}
+	if sweep.Pause <= 0 {
+		sweep.Pause = panoramaPause
+	}
+
+	steps, err := panoramaSteps(sweep.PanFrom, sweep.PanTo, sweep.Step)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(options.Out, 0755); err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	conf = conf.ForCamera(conf.Address)
+	conf.Ack = true // position replies tell when each step is reached
+
+	tty, err := openSerial(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	encoder := newFrameEncoder(conf)
+	send := func(message PelcoDMessage) { sendMessage(tty, encoder, message) }
+
+	var positioner Positioner
+
+	if nil != tty {
+		defer tty.Close()
+
+		bus := &Bus{tty: tty, responses: listenResponses(tty)}
+		send = bus.Send
+		positioner = bus
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	width := len(fmt.Sprint(len(steps)))
+	if width < 3 {
+		width = 3
+	}
+
+	for i, pan := range steps {
+		send(pelco.New().To(conf.Address).PanPosition(pelcoFromPan(pan)).Build())
+
+		if nil != positioner {
+			if err := awaitPan(positioner, conf.Address, pan); err == errNoReply {
+				positioner = nil // don't wait out a query timeout on every step
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s\n", name, err)
+			}
+		}
+
+		time.Sleep(sweep.Pause)
+
+		path, err := saveSnapshot(client, camera.SnapshotURL, options.Out, fmt.Sprintf("panorama-%0*d", width, i+1))
+		if err != nil {
+			printError("step %d at pan %.2f: %s\n", i+1, pan, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s pan %.2f\n", path, pan)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: took %d images from pan %.2f to %.2f\n", name, len(steps), sweep.PanFrom, sweep.PanTo)
+}
@@ -0,0 +1,219 @@
+package pelco
+
+// Builder composes a frame. methods change the frame in place and return the
+// builder, so calls chain; Build sets the checksum.
+type Builder struct {
+	message Message
+}
+
+// starts a stop command for address 0.
+func New() *Builder {
+	return &Builder{message: Message{Sync: SyncByte}}
+}
+
+// starts from an existing frame, e.g. to change its address.
+func From(message Message) *Builder {
+	return &Builder{message: message}
+}
+
+func (b *Builder) To(address int) *Builder {
+	b.message[Addr] = uint8(address)
+	return b
+}
+
+func (b *Builder) PanRight(speed uint8) *Builder {
+	b.message[Command2] = b.message[Command2]&^panLeft | panRight
+	b.message[Data1] = speed
+	return b
+}
+
+func (b *Builder) PanLeft(speed uint8) *Builder {
+	b.message[Command2] = b.message[Command2]&^panRight | panLeft
+	b.message[Data1] = speed
+	return b
+}
+
+func (b *Builder) TiltUp(speed uint8) *Builder {
+	b.message[Command2] = b.message[Command2]&^tiltDown | tiltUp
+	b.message[Data2] = speed
+	return b
+}
+
+func (b *Builder) TiltDown(speed uint8) *Builder {
+	b.message[Command2] = b.message[Command2]&^tiltUp | tiltDown
+	b.message[Data2] = speed
+	return b
+}
+
+// pans at a signed speed, positive to the right. zero stops panning.
+func (b *Builder) Pan(speed int) *Builder {
+	switch {
+	case speed > 0:
+		return b.PanRight(uint8(speed))
+	case speed < 0:
+		return b.PanLeft(uint8(-speed))
+	}
+
+	b.message[Command2] &^= panRight | panLeft
+	b.message[Data1] = 0
+	return b
+}
+
+// tilts at a signed speed, positive up. zero stops tilting.
+func (b *Builder) Tilt(speed int) *Builder {
+	switch {
+	case speed > 0:
+		return b.TiltUp(uint8(speed))
+	case speed < 0:
+		return b.TiltDown(uint8(-speed))
+	}
+
+	b.message[Command2] &^= tiltUp | tiltDown
+	b.message[Data2] = 0
+	return b
+}
+
+func (b *Builder) ZoomIn() *Builder {
+	b.message[Command2] = b.message[Command2]&^zoomOut | zoomIn
+	return b
+}
+
+func (b *Builder) ZoomOut() *Builder {
+	b.message[Command2] = b.message[Command2]&^zoomIn | zoomOut
+	return b
+}
+
+func (b *Builder) FocusNear() *Builder {
+	b.message[Command2] &^= focusFar
+	b.message[Command1] |= focusNear
+	return b
+}
+
+func (b *Builder) FocusFar() *Builder {
+	b.message[Command1] &^= focusNear
+	b.message[Command2] |= focusFar
+	return b
+}
+
+func (b *Builder) IrisOpen() *Builder {
+	b.message[Command1] = b.message[Command1]&^irisClose | irisOpen
+	return b
+}
+
+func (b *Builder) IrisClose() *Builder {
+	b.message[Command1] = b.message[Command1]&^irisOpen | irisClose
+	return b
+}
+
+// switches the camera on. the frame carries no motion.
+func (b *Builder) CameraOn() *Builder {
+	b.message[Command1] |= sense | power
+	return b
+}
+
+// switches the camera off.
+func (b *Builder) CameraOff() *Builder {
+	b.message[Command1] = b.message[Command1]&^sense | power
+	return b
+}
+
+// starts the camera's auto scan. like camera on/off it shares the sense
+// bit, so a frame can't switch the camera off and start a scan.
+func (b *Builder) AutoScanOn() *Builder {
+	b.message[Command1] |= sense | autoScan
+	return b
+}
+
+// stops the auto scan, returning the camera to manual control.
+func (b *Builder) AutoScanOff() *Builder {
+	b.message[Command1] = b.message[Command1]&^sense | autoScan
+	return b
+}
+
+// replaces any motion with an extended command and its data bytes.
+func (b *Builder) Extended(command, data1, data2 uint8) *Builder {
+	b.message[Command1] = 0x00
+	b.message[Command2] = command
+	b.message[Data1] = data1
+	b.message[Data2] = data2
+	return b
+}
+
+func (b *Builder) SetPreset(preset uint8) *Builder {
+	return b.Extended(SetPreset, 0x00, preset)
+}
+
+func (b *Builder) ClearPreset(preset uint8) *Builder {
+	return b.Extended(ClearPreset, 0x00, preset)
+}
+
+func (b *Builder) CallPreset(preset uint8) *Builder {
+	return b.Extended(CallPreset, 0x00, preset)
+}
+
+func (b *Builder) SetAux(aux uint8) *Builder {
+	return b.Extended(SetAux, 0x00, aux)
+}
+
+func (b *Builder) ClearAux(aux uint8) *Builder {
+	return b.Extended(ClearAux, 0x00, aux)
+}
+
+// turns the camera 180 degrees, to look behind it.
+func (b *Builder) Flip() *Builder {
+	return b.CallPreset(FlipPreset)
+}
+
+// marks where the camera points as the start of zone, 1-8.
+func (b *Builder) ZoneStart(zone uint8) *Builder {
+	return b.Extended(ZoneStart, 0x00, zone)
+}
+
+// marks where the camera points as the end of zone.
+func (b *Builder) ZoneEnd(zone uint8) *Builder {
+	return b.Extended(ZoneEnd, 0x00, zone)
+}
+
+// starts or stops scanning through the zones.
+func (b *Builder) ZoneScan(on bool) *Builder {
+	if on {
+		return b.Extended(ZoneScanOn, 0x00, 0x00)
+	}
+
+	return b.Extended(ZoneScanOff, 0x00, 0x00)
+}
+
+// starts recording pattern from the moves that follow.
+func (b *Builder) PatternStart(pattern uint8) *Builder {
+	return b.Extended(PatternStart, 0x00, pattern)
+}
+
+// ends the recording of pattern.
+func (b *Builder) PatternStop(pattern uint8) *Builder {
+	return b.Extended(PatternStop, 0x00, pattern)
+}
+
+// plays pattern until the camera is sent something else.
+func (b *Builder) RunPattern(pattern uint8) *Builder {
+	return b.Extended(RunPattern, 0x00, pattern)
+}
+
+// moves to an absolute pan position, in hundredths of a degree.
+func (b *Builder) PanPosition(position uint16) *Builder {
+	return b.Extended(SetPanPosition, uint8(position>>8), uint8(position))
+}
+
+// moves to an absolute tilt position, in hundredths of a degree.
+func (b *Builder) TiltPosition(position uint16) *Builder {
+	return b.Extended(SetTiltPosition, uint8(position>>8), uint8(position))
+}
+
+// asks for a position report, e.g. QueryPan.
+func (b *Builder) Query(query uint8) *Builder {
+	return b.Extended(query, 0x00, 0x00)
+}
+
+// returns the frame with its checksum set.
+func (b *Builder) Build() Message {
+	return b.message.WithChecksum()
+}
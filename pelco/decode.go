@@ -0,0 +1,158 @@
+package pelco
+
+import (
+	"errors"
+)
+
+var (
+	ErrSync     = errors.New("frame does not start with the sync byte")
+	ErrChecksum = errors.New("bad checksum")
+)
+
+// Command is a decoded frame. directions are -1, 0, or 1: Pan is positive to
+// the right, Tilt up, Zoom in, Focus far, Iris open, Power on, and AutoScan
+// on. extended
+// commands set Extended and Code, with Data1 and Data2 as sent.
+type Command struct {
+	Address int
+
+	Pan       int
+	PanSpeed  uint8
+	Tilt      int
+	TiltSpeed uint8
+	Zoom      int
+	Focus     int
+	Iris      int
+	Power     int
+	AutoScan  int
+
+	Extended bool
+	Code     uint8
+	Data1    uint8
+	Data2    uint8
+}
+
+// decodes message. the command is returned even with a bad sync byte or
+// checksum, alongside ErrSync or ErrChecksum, so damaged frames can still be
+// shown.
+func Decode(message Message) (Command, error) {
+	command := Command{
+		Address: int(message[Addr]),
+		Data1:   message[Data1],
+		Data2:   message[Data2],
+	}
+
+	if message.Extended() {
+		command.Extended = true
+		command.Code = message[Command2]
+	} else {
+		command.Pan = direction(message[Command2], panRight, panLeft)
+		command.Tilt = direction(message[Command2], tiltUp, tiltDown)
+		command.Zoom = direction(message[Command2], zoomIn, zoomOut)
+		command.Focus = direction(message[Command2], focusFar, 0) - direction(message[Command1], focusNear, 0)
+		command.Iris = direction(message[Command1], irisOpen, irisClose)
+		if 0 != message[Command1]&power {
+			command.Power = direction(message[Command1], sense, power)
+		}
+		if 0 != message[Command1]&autoScan {
+			command.AutoScan = direction(message[Command1], sense, autoScan)
+		}
+
+		if 0 != command.Pan {
+			command.PanSpeed = message[Data1]
+		}
+		if 0 != command.Tilt {
+			command.TiltSpeed = message[Data2]
+		}
+	}
+
+	if SyncByte != message[Sync] {
+		return command, ErrSync
+	}
+	if !message.Valid() {
+		return command, ErrChecksum
+	}
+
+	return command, nil
+}
+
+func direction(bits, positive, negative uint8) int {
+	switch {
+	case 0 != bits&positive:
+		return 1
+	case 0 != bits&negative:
+		return -1
+	}
+
+	return 0
+}
+
+// the 16 bit value of a position command or response.
+func (c Command) Value() uint16 {
+	return uint16(c.Data1)<<8 | uint16(c.Data2)
+}
+
+// reports whether c stops all motion.
+func (c Command) Stop() bool {
+	return !c.Extended && 0 == c.Pan && 0 == c.Tilt && 0 == c.Zoom && 0 == c.Focus && 0 == c.Iris && 0 == c.Power && 0 == c.AutoScan
+}
+
+// encodes c back into a frame.
+func (c Command) Message() Message {
+	b := New().To(c.Address)
+
+	if c.Extended {
+		return b.Extended(c.Code, c.Data1, c.Data2).Build()
+	}
+
+	switch c.Pan {
+	case 1:
+		b.PanRight(c.PanSpeed)
+	case -1:
+		b.PanLeft(c.PanSpeed)
+	}
+
+	switch c.Tilt {
+	case 1:
+		b.TiltUp(c.TiltSpeed)
+	case -1:
+		b.TiltDown(c.TiltSpeed)
+	}
+
+	switch c.Zoom {
+	case 1:
+		b.ZoomIn()
+	case -1:
+		b.ZoomOut()
+	}
+
+	switch c.Focus {
+	case 1:
+		b.FocusFar()
+	case -1:
+		b.FocusNear()
+	}
+
+	switch c.Iris {
+	case 1:
+		b.IrisOpen()
+	case -1:
+		b.IrisClose()
+	}
+
+	switch c.Power {
+	case 1:
+		b.CameraOn()
+	case -1:
+		b.CameraOff()
+	}
+
+	switch c.AutoScan {
+	case 1:
+		b.AutoScanOn()
+	case -1:
+		b.AutoScanOff()
+	}
+
+	return b.Build()
+}
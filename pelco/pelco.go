@@ -0,0 +1,138 @@
+// Package pelco builds and decodes Pelco-D frames, and translates them to
+// Pelco-P for cameras that only speak that.
+//
+//	message := pelco.New().To(2).PanRight(0x20).TiltUp(0x10).Build()
+//	command, err := pelco.Decode(message)
+package pelco
+
+import (
+	"encoding/hex"
+)
+
+// byte positions in a frame
+const (
+	Sync     = 0
+	Addr     = 1
+	Command1 = 2
+	Command2 = 3
+	Data1    = 4
+	Data2    = 5
+	Checksum = 6
+)
+
+// every frame starts with this byte
+const SyncByte = 0xff
+
+// full scale pan/tilt speed. speeds above this are turbo.
+const FullSpeed = 0x3f
+
+// extended commands, sent in Command2 with Command1 cleared
+const (
+	SetPreset   = 0x03
+	ClearPreset = 0x05
+	CallPreset  = 0x07
+	SetAux      = 0x09
+	ClearAux    = 0x0b
+
+	// patrol zones, 1-8, bounded by where the camera points at zone start
+	// and end, and the scan sweeping through them
+	ZoneStart   = 0x11
+	ZoneEnd     = 0x13
+	ZoneScanOn  = 0x1b
+	ZoneScanOff = 0x1d
+
+	// patterns record the operator's moves between start and stop. cameras
+	// with one pattern ignore the number
+	PatternStart = 0x1f
+	PatternStop  = 0x21
+	RunPattern   = 0x23
+
+	// lens settings. speeds are 0-3; auto modes take 0 auto, 1 on, 2 off
+	ZoomSpeed  = 0x25
+	FocusSpeed = 0x27
+	AutoFocus  = 0x2b
+	AutoIris   = 0x2d
+
+	// absolute positioning, in hundredths of a degree
+	SetPanPosition  = 0x4b
+	SetTiltPosition = 0x4d
+
+	// position queries and their extended responses. positions are in
+	// hundredths of a degree.
+	QueryPan     = 0x51
+	QueryTilt    = 0x53
+	QueryZoom    = 0x55
+	PanResponse  = 0x59
+	TiltResponse = 0x5b
+	ZoomResponse = 0x5d
+)
+
+// presets with a fixed meaning on most cameras: calling FlipPreset turns the
+// camera 180 degrees and ZeroPanPreset pans to 0. setting MenuPreset opens
+// the on-screen menu.
+const (
+	FlipPreset    = 0x21
+	ZeroPanPreset = 0x22
+	MenuPreset    = 0x5f
+)
+
+// standard command bits
+const (
+	focusNear = 1 << 0 // Command1
+	irisOpen  = 1 << 1
+	irisClose = 1 << 2
+	power     = 1 << 3 // camera on with sense, off without
+	autoScan  = 1 << 4 // auto scan with sense, manual without
+	sense     = 1 << 7
+
+	extended = 1 << 0 // Command2
+	panRight = 1 << 1
+	panLeft  = 1 << 2
+	tiltUp   = 1 << 3
+	tiltDown = 1 << 4
+	zoomIn   = 1 << 5
+	zoomOut  = 1 << 6
+	focusFar = 1 << 7
+)
+
+// Message is a Pelco-D frame.
+type Message [7]byte
+
+// returns m with its checksum set. it should be the last change before
+// sending.
+func (m Message) WithChecksum() Message {
+	m[Checksum] = m[Addr] + m[Command1] + m[Command2] + m[Data1] + m[Data2]
+
+	return m
+}
+
+// reports whether m starts with the sync byte and has a correct checksum.
+func (m Message) Valid() bool {
+	return SyncByte == m[Sync] && m.WithChecksum() == m
+}
+
+// reports whether m is a stop command, i.e. requests no action at all.
+func (m Message) Idle() bool {
+	return 0 == m[Command1] && 0 == m[Command2] && 0 == m[Data1] && 0 == m[Data2]
+}
+
+// reports whether m carries an extended command, e.g. a preset or query.
+// standard commands never set bit 0 of Command2.
+func (m Message) Extended() bool {
+	return 0 != m[Command2]&extended
+}
+
+// parses a frame written as hex, e.g. "ff010002200023". short input leaves
+// the remaining bytes zero.
+func ParseHex(text string) (Message, error) {
+	message := Message{}
+
+	bytes, err := hex.DecodeString(text)
+	if err != nil {
+		return message, err
+	}
+
+	copy(message[:], bytes)
+
+	return message, nil
+}
@@ -0,0 +1,96 @@
+package pelco
+
+// Pelco-P frames carry the same commands as Pelco-D in eight bytes: a start
+// byte, the address counted from 0, four data bytes, an end byte, and an
+// XOR checksum over the rest.
+const (
+	PStartByte = 0xa0
+	PEndByte   = 0xaf
+
+	// pelco-p turbo pan speed. pelco-d sends 0xff
+	pTurbo = 0x40
+)
+
+// byte positions in a Pelco-P frame
+const (
+	PStart    = 0
+	PAddr     = 1
+	PData1    = 2
+	PData2    = 3
+	PData3    = 4
+	PData4    = 5
+	PEnd      = 6
+	PChecksum = 7
+)
+
+// Pelco-P Data1 bits Pelco-D keeps elsewhere
+const (
+	pFocusFar  = 1 << 0
+	pFocusNear = 1 << 1
+	pIrisOpen  = 1 << 2
+	pIrisClose = 1 << 3
+	pPower     = 1 << 4 // camera on with pCameraOn, off without
+	pAutoScan  = 1 << 5
+	pCameraOn  = 1 << 6
+)
+
+// PMessage is a Pelco-P frame.
+type PMessage [8]byte
+
+// returns m with its checksum set.
+func (m PMessage) WithChecksum() PMessage {
+	m[PChecksum] = 0
+	for _, b := range m[:PChecksum] {
+		m[PChecksum] ^= b
+	}
+
+	return m
+}
+
+// returns the Pelco-P frame carrying the command of m. Pelco-P counts
+// addresses from 0, so camera 1 is sent as 0. extended commands keep their
+// codes and data; standard ones move the lens bits to where Pelco-P has them.
+func (m Message) PelcoP() PMessage {
+	p := PMessage{PStart: PStartByte, PAddr: m[Addr] - 1, PEnd: PEndByte}
+
+	if m.Extended() {
+		p[PData1], p[PData2], p[PData3], p[PData4] = m[Command1], m[Command2], m[Data1], m[Data2]
+		return p.WithChecksum()
+	}
+
+	// pelco-p's scan bit only starts an auto scan, which the next motion
+	// stops, so auto scan off goes out as a plain stop
+	if 0 != m[Command1]&power {
+		p[PData1] |= pPower
+		if 0 != m[Command1]&sense {
+			p[PData1] |= pCameraOn
+		}
+	}
+	if 0 != m[Command1]&autoScan && 0 != m[Command1]&sense {
+		p[PData1] |= pAutoScan
+	}
+	if 0 != m[Command1]&focusNear {
+		p[PData1] |= pFocusNear
+	}
+	if 0 != m[Command1]&irisOpen {
+		p[PData1] |= pIrisOpen
+	}
+	if 0 != m[Command1]&irisClose {
+		p[PData1] |= pIrisClose
+	}
+	if 0 != m[Command2]&focusFar {
+		p[PData1] |= pFocusFar
+	}
+
+	p[PData2] = m[Command2] & (panRight | panLeft | tiltUp | tiltDown | zoomIn | zoomOut)
+
+	p[PData3], p[PData4] = m[Data1], m[Data2]
+	if p[PData3] > FullSpeed {
+		p[PData3] = pTurbo
+	}
+	if p[PData4] > FullSpeed {
+		p[PData4] = FullSpeed
+	}
+
+	return p.WithChecksum()
+}
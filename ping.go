@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"time"
+)
+
+// sends a pan position query to address, which changes nothing on the
+// camera, and returns the first reply from address within timeout. cameras
+// that don't support the query still answer it with a general response.
+func (b *Bus) Ping(address int, timeout time.Duration) (PelcoDResponse, error) {
+	b.drain()
+
+	b.Send(pelco.New().To(address).Query(pelco.QueryPan).Build())
+
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case response := <-b.responses:
+			if uint8(address) == response.Address {
+				return response, nil
+			}
+		case <-deadline:
+			busStats.Timeout(uint8(address))
+			return PelcoDResponse{}, errNoReply
+		}
+	}
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "ping",
+		Summary: "check that a camera answers",
+		Usage:   []string{"[--timeout DURATION]"},
+		Options: []string{"--timeout DURATION       - give up after DURATION, e.g. 500ms. (default = 1s)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			ping(conf, durationArg(arguments, "--timeout", time.Second))
+		},
+	})
+}
+
+// checks that the camera at conf.Address answers, for monitoring scripts.
+// prints the round trip and exits 0, or exits 1 when the port can't be
+// opened or no valid reply arrives within timeout.
+func ping(conf config.Config, timeout time.Duration) {
+	bus, err := openBus(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer bus.Close()
+
+	camera := describeCamera(conf, conf.Address)
+	sent := time.Now()
+
+	response, err := bus.Ping(conf.Address, timeout)
+	if err != nil {
+		printError("%s: no reply within %s\n", camera, timeout)
+		bus.Close()
+		os.Exit(1)
+	}
+
+	rtt := time.Since(sent).Round(time.Millisecond)
+
+	switch {
+	case response.Extended && pelco.PanResponse == response.Opcode:
+		pan := panFromPelco(uint16(response.Data[0])<<8 | uint16(response.Data[1]))
+		fmt.Printf("%s: replied in %s, pan %.2f\n", camera, rtt, pan)
+	case 0 != response.Alarm:
+		fmt.Printf("%s: replied in %s, alarms %02x\n", camera, rtt, response.Alarm)
+	default:
+		fmt.Printf("%s: replied in %s\n", camera, rtt)
+	}
+}
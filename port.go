@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+)
+
+// Port carries frames out to cameras and their replies back: a serial port,
+// or a network link standing in for one.
+type Port interface {
+	io.ReadWriteCloser
+}
+
+// returns the driver and address of the network camera settings drive in
+// place of the serial port, e.g. "sony http://cam", or "" for none.
+func networkCamera(settings config.Config) string {
+	switch {
+	case "" != settings.Onvif:
+		return "onvif " + settings.Onvif
+	case "" != settings.Sony:
+		return "sony " + settings.Sony
+	case "" != settings.Hanwha:
+		return "hanwha " + settings.Hanwha
+	}
+
+	return ""
+}
+
+// names the port of settings for messages.
+func describePort(settings config.Config) string {
+	if network := networkCamera(settings); "" != network {
+		return redactURL(network)
+	}
+
+	return fmt.Sprintf("%s at %d baud", settings.SerialPort, settings.BaudRate)
+}
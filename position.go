@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+	"os"
+	"time"
+)
+
+// how long to wait for a camera to answer a query
+const queryTimeout = 250 * time.Millisecond
+
+var errNoReply = errors.New("camera did not reply")
+
+// Bus sends frames to cameras and waits for their replies.
+type Bus struct {
+	encoder   *frameEncoder
+	tty       Port
+	responses <-chan PelcoDResponse
+}
+
+// opens the serial port for reading and writing. fails when the port is
+// disabled, since nothing can be read back.
+func openBus(conf config.Config) (*Bus, error) {
+	conf.Ack = true // replies must be readable
+
+	tty, err := openSerial(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if nil == tty {
+		return nil, errors.New("a serial port is required to read camera replies")
+	}
+
+	return &Bus{encoder: newFrameEncoder(conf), tty: tty, responses: listenResponses(tty)}, nil
+}
+
+func (b *Bus) Close() {
+	b.tty.Close()
+}
+
+func (b *Bus) Send(message PelcoDMessage) {
+	sendMessage(b.tty, b.encoder, message)
+}
+
+// discards replies nobody waited for.
+func (b *Bus) drain() {
+	for drained := false; !drained; {
+		select {
+		case <-b.responses:
+		default:
+			drained = true
+		}
+	}
+}
+
+// sends an extended query to address and returns the data of the matching
+// extended reply.
+func (b *Bus) Query(address int, query, reply uint8) (uint16, error) {
+	b.drain()
+
+	b.Send(pelco.New().To(address).Query(query).Build())
+
+	deadline := time.After(queryTimeout)
+
+	for {
+		select {
+		case response := <-b.responses:
+			if response.Extended && reply == response.Opcode && uint8(address) == response.Address {
+				return uint16(response.Data[0])<<8 | uint16(response.Data[1]), nil
+			}
+		case <-deadline:
+			busStats.Timeout(uint8(address))
+			return 0, errNoReply
+		}
+	}
+}
+
+// Position is a camera orientation in degrees. pan increases clockwise from
+// the camera's zero; tilt is positive above the horizon.
+type Position struct {
+	Pan  float64
+	Tilt float64
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("pan %.2f tilt %.2f", p.Pan, p.Tilt)
+}
+
+func (b *Bus) QueryPosition(address int) (Position, error) {
+	pan, err := b.Query(address, pelco.QueryPan, pelco.PanResponse)
+	if err != nil {
+		return Position{}, err
+	}
+
+	tilt, err := b.Query(address, pelco.QueryTilt, pelco.TiltResponse)
+	if err != nil {
+		return Position{}, err
+	}
+
+	return Position{panFromPelco(pan), tiltFromPelco(tilt)}, nil
+}
+
+// returns the zoom of the camera at address, from 0 at wide to 1 at tele.
+func (b *Bus) QueryZoom(address int) (float64, error) {
+	zoom, err := b.Query(address, pelco.QueryZoom, pelco.ZoomResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	return zoomFromPelco(zoom), nil
+}
+
+func zoomFromPelco(value uint16) float64 {
+	return float64(value) / 0xffff
+}
+
+func panFromPelco(value uint16) float64 {
+	return float64(value) / 100
+}
+
+// pelco reports tilt below the horizon as 0-90 degrees and above it as
+// 360 minus the angle.
+func tiltFromPelco(value uint16) float64 {
+	degrees := float64(value) / 100
+
+	if degrees > 180 {
+		return 360 - degrees
+	}
+
+	return -degrees
+}
+
+func pelcoFromPan(degrees float64) uint16 {
+	return uint16(math.Round(wrapPan(degrees)*100)) % 36000
+}
+
+// inverse of tiltFromPelco.
+func pelcoFromTilt(degrees float64) uint16 {
+	if degrees > 0 {
+		degrees = 360 - degrees
+	} else {
+		degrees = -degrees
+	}
+
+	return uint16(math.Round(degrees * 100))
+}
+
+// normalizes degrees to [0, 360).
+func wrapPan(degrees float64) float64 {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	return degrees
+}
+
+// returns the shortest signed rotation from one pan angle to another.
+func panError(from, to float64) float64 {
+	diff := wrapPan(to - from)
+	if diff > 180 {
+		diff -= 360
+	}
+
+	return diff
+}
+
+// proportional speed for an error in degrees, limited to maxSpeed.
+func correctionSpeed(errorDegrees float64, maxSpeed int32) int {
+	const gain = 2.0 // speed steps per degree of error
+
+	speed := int(math.Ceil(math.Abs(errorDegrees) * gain))
+	if speed > int(maxSpeed) {
+		speed = int(maxSpeed)
+	}
+
+	if errorDegrees < 0 {
+		return -speed
+	}
+
+	return speed
+}
+
+// drives the camera by the given offsets using position feedback, correcting
+// until both axes settle within tolerance or the timeout elapses.
+func moveByFeedback(conf config.Config, bus *Bus, pan, tilt, tolerance float64, timeout time.Duration) (Position, Position, error) {
+	start, err := bus.QueryPosition(conf.Address)
+	if err != nil {
+		return Position{}, Position{}, err
+	}
+
+	target := Position{wrapPan(start.Pan + pan), start.Tilt + tilt}
+	current := start
+	deadline := time.Now().Add(timeout)
+	stop := pelco.New().To(conf.Address).Build()
+
+	defer bus.Send(stop)
+
+	for time.Now().Before(deadline) {
+		panOff := panError(current.Pan, target.Pan)
+		tiltOff := target.Tilt - current.Tilt
+
+		if math.Abs(panOff) <= tolerance && math.Abs(tiltOff) <= tolerance {
+			// stop and confirm the camera has settled, retrying if it drifted
+			bus.Send(stop)
+			time.Sleep(200 * time.Millisecond)
+
+			if current, err = bus.QueryPosition(conf.Address); err != nil {
+				return target, current, err
+			}
+
+			if math.Abs(panError(current.Pan, target.Pan)) <= tolerance && math.Abs(target.Tilt-current.Tilt) <= tolerance {
+				return target, current, nil
+			}
+			continue
+		}
+
+		var panSpeed, tiltSpeed int
+		if math.Abs(panOff) > tolerance {
+			panSpeed = correctionSpeed(panOff, conf.MaxSpeed)
+		}
+		if math.Abs(tiltOff) > tolerance {
+			tiltSpeed = correctionSpeed(tiltOff, conf.MaxSpeed)
+		}
+
+		bus.Send(pelco.New().To(conf.Address).Pan(panSpeed).Tilt(tiltSpeed).Build())
+		time.Sleep(50 * time.Millisecond)
+
+		// a dropped reply is retried on the next pass
+		if position, err := bus.QueryPosition(conf.Address); nil == err {
+			current = position
+		}
+	}
+
+	return target, current, fmt.Errorf("not within %.2f degrees after %s", tolerance, timeout)
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "move-by",
+		Summary: "pan and tilt by a number of degrees",
+		Usage:   []string{"[--pan DEG] [--tilt DEG] [--tolerance DEG] [--timeout DURATION] [--open-loop]"},
+		Options: []string{
+			"--pan DEG                - degrees to pan. positive is clockwise. (default = 0)",
+			"--tilt DEG               - degrees to tilt. positive is up. (default = 0)",
+			"--tolerance DEG          - acceptable position error. (default = 0.5)",
+			"--timeout DURATION       - give up after DURATION, e.g. 10s. (default = 15s)",
+			"--open-loop              - time the move from the speed table instead of querying position.",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			moveBy(conf, floatArg(arguments, "--pan", 0), floatArg(arguments, "--tilt", 0),
+				floatArg(arguments, "--tolerance", 0.5), durationArg(arguments, "--timeout", 15*time.Second),
+				arguments["--open-loop"].(bool))
+		},
+	})
+}
+
+// moves the camera relative to its current position from the command line.
+// cameras that don't answer position queries are moved open loop using the
+// calibrated speed table.
+func moveBy(conf config.Config, pan, tilt, tolerance float64, timeout time.Duration, openLoop bool) {
+	bus, err := openBus(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer bus.Close()
+
+	camera := describeCamera(conf, conf.Address)
+
+	if !openLoop {
+		target, final, err := moveByFeedback(conf, bus, pan, tilt, tolerance, timeout)
+		if err != errNoReply {
+			fmt.Printf("%s: target %s, reached %s, error pan %.2f tilt %.2f\n", camera, target, final,
+				panError(final.Pan, target.Pan), target.Tilt-final.Tilt)
+
+			if err != nil {
+				printError("%s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s did not answer position queries. using a timed move.\n", camera)
+	}
+
+	estimate, err := moveByTiming(conf, bus, pan, tilt)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: moved pan %.2f tilt %.2f open loop, estimated error pan ±%.2f tilt ±%.2f degrees\n",
+		camera, pan, tilt, estimate.Pan, estimate.Tilt)
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "status",
+		Summary: "query where cameras point",
+		Usage:   []string{"[--all]"},
+		Options: []string{"--all                    - query every configured camera instead of the selected one."},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			status(conf, arguments["--all"].(bool))
+		},
+	})
+}
+
+// prints the pan, tilt, and zoom the camera at conf.Address reports, or
+// every configured camera's. cameras without zoom queries show zoom ?.
+// exits 1 when a camera doesn't answer.
+func status(conf config.Config, all bool) {
+	addresses := []int{conf.Address}
+	if all {
+		addresses = nil
+		for _, camera := range conf.Cameras {
+			addresses = append(addresses, camera.Address)
+		}
+	}
+
+	failed := false
+
+	for _, address := range addresses {
+		if !queryStatus(conf, address) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// prints the camera at address's position, on the port it's configured on.
+func queryStatus(conf config.Config, address int) bool {
+	camera := describeCamera(conf, address)
+
+	bus, err := openBus(conf.ForCamera(address))
+	if err != nil {
+		printError("%s: %s\n", camera, err)
+		return false
+	}
+	defer bus.Close()
+
+	position, err := bus.QueryPosition(address)
+	if err != nil {
+		printError("%s: %s\n", camera, err)
+		return false
+	}
+
+	zoom := "?"
+	if value, err := bus.QueryZoom(address); nil == err {
+		zoom = fmt.Sprintf("%.0f%%", value*100)
+	}
+
+	fmt.Printf("%s: %s zoom %s\n", camera, position, zoom)
+
+	return true
+}
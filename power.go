@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+)
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "camera",
+		Summary: "switch a camera on or off",
+		Usage:   []string{"(on | off)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			message := pelco.New().To(conf.Address).CameraOff().Build()
+			if arguments["on"].(bool) {
+				message = pelco.New().To(conf.Address).CameraOn().Build()
+			}
+			sendOnce(conf, message)
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "auto-scan",
+		Summary: "start or stop a camera's auto scan",
+		Usage:   []string{"(on | off)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			message := pelco.New().To(conf.Address).AutoScanOff().Build()
+			if arguments["on"].(bool) {
+				message = pelco.New().To(conf.Address).AutoScanOn().Build()
+			}
+			sendOnce(conf, message)
+		},
+	})
+}
+
+// sends message to the serial port of conf and says what it was.
+func sendOnce(conf config.Config, message PelcoDMessage) {
+	line, err := openSerialLine(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer line.Close()
+
+	line.Send(message)
+	fmt.Fprintf(os.Stderr, "%s\n", describeMessage(conf, message))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"strconv"
+)
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "preset",
+		Summary: "set, call, or clear a camera's preset",
+		Usage:   []string{"(set | call | clear) PRESET [--name LABEL]"},
+		Options: []string{"--name LABEL             - with set, name the preset LABEL in the config file."},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			action := "call"
+			if arguments["set"].(bool) {
+				action = "set"
+			} else if arguments["clear"].(bool) {
+				action = "clear"
+			}
+			presetCommand(conf, action, arguments["PRESET"].(string), stringArg(arguments, "--name"))
+		},
+	})
+}
+
+// returns the preset of the camera at address that text names, by number or
+// by its name in the config file.
+func findPreset(conf config.Config, address int, text string) (int, bool) {
+	if number, err := strconv.ParseUint(text, 10, 8); nil == err {
+		return int(number), 0 != number
+	}
+
+	camera, _ := conf.Camera(address)
+	for _, preset := range camera.Presets {
+		if preset.Name == text {
+			return preset.Number, true
+		}
+	}
+
+	return 0, false
+}
+
+// sets, calls, or clears the preset of the camera at conf.Address that text
+// names. setting it with a label names it in the config file, and clearing it
+// removes its name, so the API and preset steps see the change.
+func presetCommand(conf config.Config, action, text, label string) {
+	if "" != label && "set" != action {
+		printError("--name only applies to preset set\n")
+		os.Exit(1)
+	}
+
+	preset, ok := findPreset(conf, conf.Address, text)
+	if !ok {
+		printError("invalid preset %s. expected 1-255 or a preset named in the config file\n", text)
+		os.Exit(1)
+	}
+
+	codes := map[string]uint8{"set": pelco.SetPreset, "call": pelco.CallPreset, "clear": pelco.ClearPreset}
+	sendOnce(conf, pelco.New().To(conf.Address).Extended(codes[action], 0x00, uint8(preset)).Build())
+
+	camera, _ := conf.Camera(conf.Address)
+	named := false
+	for _, p := range camera.Presets {
+		named = named || p.Number == preset
+	}
+
+	if "" == label && ("clear" != action || !named) {
+		return
+	}
+
+	path, err := config.SavePresetName(conf.Address, preset, label)
+	if err != nil {
+		printError("failed to save preset name. %s\n", err)
+		os.Exit(1)
+	}
+
+	if "" == label {
+		fmt.Fprintf(os.Stderr, "removed the name of preset %d from %s\n", preset, path)
+	} else {
+		fmt.Fprintf(os.Stderr, "named preset %d %s in %s\n", preset, label, path)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/boxofrox/cctv-ptz/visca"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baud rates tried when --bauds is not given, the common dome settings
+var defaultProbeBauds = []int{2400, 4800, 9600, 19200, 38400}
+
+// ProbeResult is a camera that answered a probe.
+type ProbeResult struct {
+	Protocol string
+	Baud     int
+	Address  int
+}
+
+// probeQuery is a harmless frame in one protocol, with the test for a reply
+// to it from the camera at address.
+type probeQuery struct {
+	protocol  string
+	addresses []int
+	frame     func(address int) []byte
+	replied   func(data []byte, address int) bool
+}
+
+// returns the queries to probe with: a pan position query for Pelco-D, and
+// a version inquiry for VISCA. Pelco-P and Sensormatic cameras don't answer,
+// so they can't be found this way.
+func probeQueries(maxAddress int) []probeQuery {
+	var pelcoAddresses, viscaAddresses []int
+	for address := 1; address <= maxAddress; address++ {
+		pelcoAddresses = append(pelcoAddresses, address)
+	}
+	for address := visca.MinAddress; address <= visca.MaxAddress; address++ {
+		viscaAddresses = append(viscaAddresses, address)
+	}
+
+	return []probeQuery{
+		{
+			protocol:  "pelco-d",
+			addresses: pelcoAddresses,
+			frame: func(address int) []byte {
+				message := pelco.New().To(address).Query(pelco.QueryPan).Build()
+				return message[:]
+			},
+			replied: pelcoReplyFrom,
+		},
+		{
+			protocol:  "visca",
+			addresses: viscaAddresses,
+			frame:     visca.VersionInquiry,
+			replied: func(data []byte, address int) bool {
+				for _, packet := range visca.Split(data) {
+					if visca.ReplyFrom(packet, address) {
+						return true
+					}
+				}
+				return false
+			},
+		},
+	}
+}
+
+// reports whether data holds a valid Pelco-D reply from address, among
+// whatever else the line carried.
+func pelcoReplyFrom(data []byte, address int) bool {
+	for i, b := range data {
+		if 0xff != b {
+			continue
+		}
+		for _, n := range []int{4, 7} {
+			if i+n > len(data) {
+				continue
+			}
+			if response, ok, _ := parseResponse(data[i : i+n]); ok && uint8(address) == response.Address {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parses a comma separated list of baud rates, e.g. "4800,9600".
+func parseBauds(text string) ([]int, error) {
+	var bauds []int
+
+	for _, field := range strings.Split(text, ",") {
+		baud, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || baud <= 0 {
+			return nil, fmt.Errorf("invalid baud rate %s", field)
+		}
+		bauds = append(bauds, baud)
+	}
+
+	return bauds, nil
+}
+
+// reads r into chunks until it fails, e.g. when the port is closed.
+func readChunks(r io.Reader) <-chan []byte {
+	chunks := make(chan []byte, 20)
+
+	go func() {
+		defer close(chunks)
+
+		buffer := make([]byte, 64)
+		for {
+			n, err := r.Read(buffer)
+			if err != nil {
+				return
+			}
+			if 0 != n {
+				chunks <- append([]byte(nil), buffer[:n]...)
+			}
+		}
+	}()
+
+	return chunks
+}
+
+// writes frame to tty and reports whether a reply that replied accepts
+// arrives within timeout. bytes left over from earlier queries are dropped.
+func probeOnce(tty Port, chunks <-chan []byte, frame []byte, replied func([]byte) bool, timeout time.Duration) bool {
+	for drained := false; !drained; {
+		select {
+		case <-chunks:
+		default:
+			drained = true
+		}
+	}
+
+	if _, err := tty.Write(frame); err != nil {
+		return false
+	}
+
+	var (
+		data     []byte
+		deadline = time.After(timeout)
+	)
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return false
+			}
+			data = append(data, chunk...)
+			if replied(data) {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// opens the serial port of settings at its baud rate. a remote serial
+// server over RFC 2217 is told the rate instead.
+func openProbePort(settings config.Config) (Port, error) {
+	if isRFC2217(settings.SerialPort) {
+		return dialTCPSerial(settings)
+	}
+
+	return openSerialDevice(settings, settings.SerialPort)
+}
+
+// opens the serial port of conf at each baud rate in turn and sends every
+// query to every address, returning the cameras that answered.
+func probe(conf config.Config, bauds []int, queries []probeQuery, timeout time.Duration) ([]ProbeResult, error) {
+	var results []ProbeResult
+
+	settings := conf.ForCamera(conf.Address)
+
+	for _, baud := range bauds {
+		settings.BaudRate = baud
+
+		tty, err := openProbePort(settings)
+		if err != nil {
+			return results, err
+		}
+		chunks := readChunks(tty)
+
+		for _, query := range queries {
+			if conf.Verbose {
+				fmt.Fprintf(os.Stderr, "trying %s at %d baud\n", query.protocol, baud)
+			}
+
+			for _, address := range query.addresses {
+				replied := func(data []byte) bool { return query.replied(data, address) }
+				if probeOnce(tty, chunks, query.frame(address), replied, timeout) {
+					result := ProbeResult{Protocol: query.protocol, Baud: baud, Address: address}
+					fmt.Printf("%s at %d baud, address %d\n", result.Protocol, result.Baud, result.Address)
+					results = append(results, result)
+				}
+			}
+		}
+
+		tty.Close()
+	}
+
+	return results, nil
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "probe",
+		Summary: "find the protocol, baud rate, and address of the cameras on a serial port",
+		Usage:   []string{"[--bauds LIST] [--max-address NUM] [--timeout DURATION]"},
+		Options: []string{
+			"--bauds LIST             - try comma separated baud rates. (default = 2400,4800,9600,19200,38400)",
+			"--max-address NUM        - try Pelco-D addresses 1 through NUM. (default = 16)",
+			"--timeout DURATION       - wait DURATION for each reply. (default = 200ms)",
+		},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			bauds := defaultProbeBauds
+			if text := stringArg(arguments, "--bauds"); "" != text {
+				var err error
+				if bauds, err = parseBauds(text); err != nil {
+					printError("%s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			maxAddress := 16
+			if text := stringArg(arguments, "--max-address"); "" != text {
+				var err error
+				if maxAddress, err = strconv.Atoi(text); err != nil || maxAddress < 1 || maxAddress > 255 {
+					printError("invalid --max-address %s. expected 1-255\n", text)
+					os.Exit(1)
+				}
+			}
+
+			probeCommand(conf, bauds, maxAddress, durationArg(arguments, "--timeout", 200*time.Millisecond))
+		},
+	})
+}
+
+// tries every baud rate, protocol, and address on the serial port of conf
+// with frames that change nothing, printing each camera that answers. exits
+// 1 when none does, since a probe that finds nothing usually means wiring.
+func probeCommand(conf config.Config, bauds []int, maxAddress int, timeout time.Duration) {
+	settings := conf.ForCamera(conf.Address)
+	if network := networkCamera(settings); "" != network {
+		printError("probe only works on serial ports, not %s\n", redactURL(network))
+		os.Exit(1)
+	}
+	if isVISCAIP(settings.SerialPort) || isUDPSerial(settings.SerialPort) ||
+		(isTCPSerial(settings.SerialPort) && !isRFC2217(settings.SerialPort)) {
+		printError("probe only works on serial ports, not %s\n", settings.SerialPort)
+		os.Exit(1)
+	}
+
+	queries := probeQueries(maxAddress)
+
+	var tries int
+	for _, query := range queries {
+		tries += len(query.addresses)
+	}
+	fmt.Fprintf(os.Stderr, "probing %s: %d queries at each of %d baud rates, up to %s\n", settings.SerialPort,
+		tries, len(bauds), time.Duration(tries*len(bauds))*timeout)
+
+	results, err := probe(conf, bauds, queries, timeout)
+	if err != nil {
+		printError("cannot open serial port (%s). %s\n", settings.SerialPort, err)
+		os.Exit(1)
+	}
+
+	if 0 == len(results) {
+		printError("no camera answered on %s\n", settings.SerialPort)
+		os.Exit(1)
+	}
+}
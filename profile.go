@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"sort"
+	"strconv"
+)
+
+// returns the preset calls a profile makes when it takes effect, by address.
+func profilePresets(conf config.Config, profile config.Profile) []PelcoDMessage {
+	var messages []PelcoDMessage
+
+	for camera, preset := range profile.Presets {
+		address := conf.CameraAddress(camera)
+		if address < 0 {
+			var err error
+			if address, err = strconv.Atoi(camera); err != nil {
+				printError("profile %s: unknown camera %s\n", profile.Name, camera)
+				continue
+			}
+		}
+
+		if preset < 1 || preset > 255 {
+			printError("profile %s: invalid preset %d for %s\n", profile.Name, preset, camera)
+			continue
+		}
+
+		messages = append(messages, pelco.New().To(address).CallPreset(uint8(preset)).Build())
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i][pelco.Addr] < messages[j][pelco.Addr] })
+
+	return messages
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordingMark is a "# Mark" comment in a recording, at the recording time
+// of the frames before it.
+type RecordingMark struct {
+	Label string `json:"label"`
+	At    int64  `json:"at_ms"`
+	Frame int    `json:"-"` // frames before the mark, for stored recordings
+}
+
+// parses a "# Mark" comment. returns false for any other line.
+func parseMark(line string, at time.Duration) (RecordingMark, bool) {
+	if !strings.HasPrefix(line, "# Mark ") {
+		return RecordingMark{}, false
+	}
+
+	return RecordingMark{Label: strings.TrimPrefix(line, "# Mark "), At: int64(at / time.Millisecond)}, true
+}
+
+// PlaybackProgress is how far a playback has come through its recording.
+type PlaybackProgress struct {
+	Elapsed  time.Duration  // recording time played
+	Length   time.Duration  // recording time to the end, or to --to
+	NextMark *RecordingMark // the first mark not yet reached, if any
+	Ends     time.Time      // estimated, at the recorded pace. zero while looping
+}
+
+// returns the progress of a playback at position into a recording of length
+// with marks, at now.
+func playbackProgress(position, length time.Duration, marks []RecordingMark, loop bool, now time.Time) PlaybackProgress {
+	if position > length {
+		position = length
+	}
+
+	progress := PlaybackProgress{Elapsed: position, Length: length}
+
+	for i, mark := range marks {
+		if time.Duration(mark.At)*time.Millisecond > position {
+			progress.NextMark = &marks[i]
+			break
+		}
+	}
+
+	if !loop {
+		progress.Ends = now.Add(length - position)
+	}
+
+	return progress
+}
+
+// returns the recording time reached at now, when the frame at next was
+// handed to the sender at handedOff, having sent the frame at previous. the
+// sender waits out the delay between them, so the position moves on with
+// the clock until next goes out.
+func playbackPosition(previous, next time.Duration, handedOff, now time.Time) time.Duration {
+	position := previous + now.Sub(handedOff)
+	if position > next {
+		position = next
+	}
+
+	return position
+}
+
+// prints the progress of a playback over the last line, as the status line
+// does, e.g. "line 120/860 | 00:05:12/00:42:00 | next left in 00:01:30 |
+// ends 10:42:15".
+func printProgress(w io.Writer, line, lines int, progress PlaybackProgress) {
+	clearLine := ""
+	if stderrTerminal {
+		clearLine = "\033[K"
+	}
+
+	fields := []string{
+		stderrColor.Paint(ansiDim, fmt.Sprintf("line %d/%d", line, lines)),
+		stderrColor.Paint(ansiBold+ansiCyan, formatElapsed(progress.Elapsed)+"/"+formatElapsed(progress.Length)),
+	}
+
+	if nil != progress.NextMark {
+		in := time.Duration(progress.NextMark.At)*time.Millisecond - progress.Elapsed
+		fields = append(fields, fmt.Sprintf("next %s in %s", progress.NextMark.Label, formatElapsed(in)))
+	}
+
+	if !progress.Ends.IsZero() {
+		fields = append(fields, stderrColor.Paint(ansiDim, "ends "+progress.Ends.Format("15:04:05")))
+	}
+
+	fmt.Fprintf(w, "%s%s\r", clearLine, strings.Join(fields, " | "))
+}
+
+// playbackTracker follows a playback from stdin, read whole beforehand so
+// its length and marks are known, and reports its progress.
+type playbackTracker struct {
+	mu     sync.Mutex
+	lines  int
+	line   int
+	length time.Duration
+	marks  []RecordingMark
+
+	// recording time of the last frame sent and of the one waiting, and when
+	// the wait began
+	position, upcoming time.Duration
+	sentAt             time.Time
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// scans the recording in data for its length and marks.
+func newPlaybackTracker(data []byte) *playbackTracker {
+	t := &playbackTracker{sentAt: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		t.lines++
+		text := strings.TrimSpace(scanner.Text())
+
+		if mark, ok := parseMark(text, t.length); ok {
+			t.marks = append(t.marks, mark)
+			continue
+		}
+
+		words := strings.Fields(text)
+		if delay, ok := lineDelay(words); ok {
+			t.length += delay
+		}
+	}
+
+	return t
+}
+
+// ends the playback at to, if it's before the end of the recording.
+func (t *playbackTracker) Limit(to time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if to >= 0 && to < t.length {
+		t.length = to
+	}
+}
+
+// notes that the frame on line, at next, was handed to the sender after the
+// frame at previous went out.
+func (t *playbackTracker) Handed(line int, previous, next time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.line, t.position, t.upcoming, t.sentAt = line, previous, next, time.Now()
+}
+
+func (t *playbackTracker) progress(now time.Time) (int, PlaybackProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.line, playbackProgress(playbackPosition(t.position, t.upcoming, t.sentAt, now), t.length, t.marks, false, now)
+}
+
+// prints the progress to w every second until Stop.
+func (t *playbackTracker) Report(w io.Writer) {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			// the last frame has gone out
+			t.mu.Lock()
+			t.position = t.upcoming
+			t.mu.Unlock()
+
+			line, progress := t.progress(time.Now())
+			printProgress(w, line, t.lines, progress)
+			fmt.Fprintln(w)
+			return
+		case now := <-ticker.C:
+			line, progress := t.progress(now)
+			printProgress(w, line, t.lines, progress)
+		}
+	}
+}
+
+// ends Report, once it has printed the final progress.
+func (t *playbackTracker) Stop() {
+	close(t.stop)
+	<-t.stopped
+}
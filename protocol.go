@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/boxofrox/cctv-ptz/visca"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol writes Pelco-D frames in what a kind of camera speaks. frames are
+// Pelco-D everywhere else, e.g. in recordings and on the status line, and
+// translated by a protocol as they go out. a protocol may remember what it
+// sent, e.g. to send the lens only as it changes, so each port has its own.
+type Protocol interface {
+	// returns message as written on the wire, or nil when the protocol has
+	// no counterpart. most protocols hand it to encodeFrame.
+	Frame(message PelcoDMessage) []byte
+
+	// pan, tilt, zoom, focus, and iris, and stopping them all
+	EncodeMove(command pelco.Command) []byte
+	EncodeStop(address int) []byte
+
+	// code is pelco.SetPreset, CallPreset, or ClearPreset
+	EncodePreset(address int, code, preset uint8) []byte
+
+	// any other extended command, e.g. an aux switch or a position
+	EncodeExtended(command pelco.Command) []byte
+
+	// reports why the camera at address can't be reached with settings, or
+	// nil when it can
+	CheckAddress(settings config.Config, address int) error
+}
+
+// protocols frames can be written in, in the order they registered
+var (
+	protocols    []string
+	newProtocols = make(map[string]func() Protocol)
+)
+
+// adds the protocol called name, made fresh for each port by factory.
+// called from init.
+func registerProtocol(name string, factory func() Protocol) {
+	protocols = append(protocols, name)
+	newProtocols[name] = factory
+}
+
+func init() {
+	registerProtocol("pelco-d", func() Protocol { return pelcoD{} })
+	registerProtocol("pelco-p", func() Protocol { return pelcoP{} })
+}
+
+func isValidProtocol(protocol string) bool {
+	_, ok := newProtocols[protocol]
+	return ok
+}
+
+// translates message with the Encode methods of protocol.
+func encodeFrame(protocol Protocol, message PelcoDMessage) []byte {
+	command, _ := pelco.Decode(message)
+
+	switch {
+	case command.Extended && (pelco.SetPreset == command.Code || pelco.CallPreset == command.Code || pelco.ClearPreset == command.Code):
+		return protocol.EncodePreset(command.Address, command.Code, command.Data2)
+	case command.Extended:
+		return protocol.EncodeExtended(command)
+	case command.Stop():
+		return protocol.EncodeStop(command.Address)
+	}
+
+	return protocol.EncodeMove(command)
+}
+
+// checks the global protocol and every camera's, and that a pelco-p camera
+// is selected by an address it can reach.
+func checkProtocols(conf config.Config) error {
+	expected := strings.Join(protocols, " or ")
+
+	if !isValidProtocol(conf.Protocol) {
+		return fmt.Errorf("unknown protocol %s. expected %s", conf.Protocol, expected)
+	}
+	if isVISCAIP(conf.SerialPort) && "visca" != conf.Protocol {
+		return fmt.Errorf("%s needs --protocol visca", conf.SerialPort)
+	}
+
+	for _, camera := range conf.Cameras {
+		if "" != camera.Protocol && !isValidProtocol(camera.Protocol) {
+			return fmt.Errorf("%s: unknown protocol %s. expected %s", describeCamera(conf, camera.Address), camera.Protocol, expected)
+		}
+
+		settings := conf.ForCamera(camera.Address)
+		if isVISCAIP(settings.SerialPort) && "visca" != settings.Protocol {
+			return fmt.Errorf("%s: %s needs protocol visca", describeCamera(conf, camera.Address), settings.SerialPort)
+		}
+
+		if err := newProtocols[settings.Protocol]().CheckAddress(settings, camera.Address); err != nil {
+			return fmt.Errorf("%s: %s", describeCamera(conf, camera.Address), err)
+		}
+	}
+
+	// e.g. the default address 0, which would wrap to pelco-p address 255
+	if settings := conf.ForCamera(conf.Address); "pelco-p" == settings.Protocol && "" == networkCamera(settings) {
+		if err := (pelcoP{}).CheckAddress(settings, conf.Address); err != nil {
+			return fmt.Errorf("address %d: %s. select a camera with -a or --camera", conf.Address, err)
+		}
+	}
+
+	return nil
+}
+
+// pelcoD writes frames as they are, so raw frames keep every bit.
+type pelcoD struct{}
+
+func (pelcoD) Frame(message PelcoDMessage) []byte {
+	return message[:]
+}
+
+func (pelcoD) EncodeMove(command pelco.Command) []byte {
+	message := command.Message()
+	return message[:]
+}
+
+func (pelcoD) EncodeStop(address int) []byte {
+	message := pelco.New().To(address).Build()
+	return message[:]
+}
+
+func (pelcoD) EncodePreset(address int, code, preset uint8) []byte {
+	message := pelco.New().To(address).Extended(code, 0x00, preset).Build()
+	return message[:]
+}
+
+func (pelcoD) EncodeExtended(command pelco.Command) []byte {
+	message := command.Message()
+	return message[:]
+}
+
+func (pelcoD) CheckAddress(config.Config, int) error {
+	return nil
+}
+
+// pelcoP writes each frame as the Pelco-P frame carrying the same command.
+type pelcoP struct{}
+
+func toPelcoP(frame []byte) []byte {
+	var message PelcoDMessage
+	copy(message[:], frame)
+
+	translated := message.PelcoP()
+	return translated[:]
+}
+
+func (pelcoP) Frame(message PelcoDMessage) []byte {
+	return toPelcoP(message[:])
+}
+
+func (pelcoP) EncodeMove(command pelco.Command) []byte {
+	return toPelcoP(pelcoD{}.EncodeMove(command))
+}
+
+func (pelcoP) EncodeStop(address int) []byte {
+	return toPelcoP(pelcoD{}.EncodeStop(address))
+}
+
+func (pelcoP) EncodePreset(address int, code, preset uint8) []byte {
+	return toPelcoP(pelcoD{}.EncodePreset(address, code, preset))
+}
+
+func (pelcoP) EncodeExtended(command pelco.Command) []byte {
+	return toPelcoP(pelcoD{}.EncodeExtended(command))
+}
+
+// frames go to the pelco-p address one below the camera's, so there is no
+// camera 0.
+func (pelcoP) CheckAddress(settings config.Config, address int) error {
+	if address < 1 || address > 0xff {
+		return errors.New("pelco-p cameras are addressed 1-255")
+	}
+
+	return nil
+}
+
+// frameEncoder returns the bytes written for frames, in the protocol of the
+// camera each is addressed to. it keeps a Protocol of each kind for those
+// remembering what they sent, and so belongs to one port.
+type frameEncoder struct {
+	conf      config.Config
+	protocols map[string]Protocol
+}
+
+func newFrameEncoder(conf config.Config) *frameEncoder {
+	return &frameEncoder{conf: conf, protocols: make(map[string]Protocol)}
+}
+
+// replaces the config naming each camera's protocol, e.g. after a profile
+// change.
+func (e *frameEncoder) SetConfig(conf config.Config) {
+	e.conf = conf
+}
+
+// returns the encoder's own protocol called name. names checkProtocols
+// doesn't know are written as Pelco-D.
+func (e *frameEncoder) protocol(name string) Protocol {
+	if protocol, ok := e.protocols[name]; ok {
+		return protocol
+	}
+
+	factory, ok := newProtocols[name]
+	if !ok {
+		return pelcoD{}
+	}
+
+	protocol := factory()
+	e.protocols[name] = protocol
+
+	return protocol
+}
+
+func (e *frameEncoder) Encode(message PelcoDMessage) []byte {
+	address := int(message[pelco.Addr])
+	settings := e.conf.ForCamera(address)
+
+	// network cameras read the frames themselves
+	if "" != networkCamera(settings) {
+		return message[:]
+	}
+
+	protocol := e.protocol(settings.Protocol)
+	if nil != protocol.CheckAddress(settings, address) {
+		return nil
+	}
+
+	return protocol.Frame(message)
+}
+
+// returns how message is written in a recording: as the VISCA commands sent
+// for it to VISCA cameras, and as the frame otherwise. VISCA commands carry
+// the address, so only cameras 1-7 can be recorded that way.
+func (e *frameEncoder) Recorded(message PelcoDMessage) (string, []byte) {
+	address := int(message[pelco.Addr])
+	settings := e.conf.ForCamera(address)
+	if "visca" == settings.Protocol && "" == networkCamera(settings) && isVISCAAddress(address) {
+		if packets := e.protocol("visca").Frame(message); 0 != len(packets) {
+			return "visca", packets
+		}
+	}
+
+	return "pelco-d", message[:]
+}
+
+// reports whether recordings may hold frames in protocol.
+func isRecordedProtocol(protocol string) bool {
+	return "pelco-d" == protocol || "visca" == protocol
+}
+
+// returns the delay ending the words of a frame or move line.
+func lineDelay(words []string) (time.Duration, bool) {
+	if 3 > len(words) {
+		return 0, false
+	}
+
+	text := words[2]
+	switch {
+	case isPTZMove(words[0]):
+		text = words[len(words)-1]
+	case !isRecordedProtocol(words[0]):
+		return 0, false
+	}
+
+	millis, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(millis) * time.Millisecond, true
+}
+
+// recordParser reads the frames of recording lines back. VISCA lines carry
+// only what changed, so it keeps what each camera was last told and merges
+// each line into that.
+type recordParser struct {
+	state map[uint8]pelco.Command
+}
+
+func newRecordParser() *recordParser {
+	return &recordParser{state: make(map[uint8]pelco.Command)}
+}
+
+// returns the frame of the packet text recorded in protocol.
+func (p *recordParser) Frame(protocol, text string) (PelcoDMessage, error) {
+	if "visca" != protocol {
+		message, err := pelco.ParseHex(text)
+		if nil == err && !message.Extended() {
+			command, _ := pelco.Decode(message)
+			p.remember(command)
+		}
+		return message, err
+	}
+
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return PelcoDMessage{}, err
+	}
+
+	return p.viscaFrame(data)
+}
+
+func (p *recordParser) remember(command pelco.Command) {
+	command.Iris = 0 // iris steps don't last beyond their frame
+	p.state[uint8(command.Address)] = command
+}
+
+// the Pelco-D preset commands of VISCA memory operations
+var presetCodes = map[uint8]uint8{
+	visca.Set:    pelco.SetPreset,
+	visca.Recall: pelco.CallPreset,
+	visca.Reset:  pelco.ClearPreset,
+}
+
+func (p *recordParser) viscaFrame(data []byte) (PelcoDMessage, error) {
+	var message PelcoDMessage
+
+	packets := visca.Split(data)
+	if 0 == len(packets) {
+		return message, errors.New("no visca commands")
+	}
+
+	for _, packet := range packets {
+		command, err := visca.Decode(packet)
+		if err != nil {
+			return message, err
+		}
+
+		state := p.state[uint8(command.Address)]
+		state.Address = command.Address
+
+		switch command.Kind {
+		case visca.DriveCommand:
+			state.Pan, state.Tilt = command.Pan, command.Tilt
+			state.PanSpeed, state.TiltSpeed = 0, 0
+			if 0 != command.Pan {
+				state.PanSpeed = pelcoSpeed(command.PanSpeed, visca.MaxPanSpeed)
+			}
+			if 0 != command.Tilt {
+				state.TiltSpeed = pelcoSpeed(command.TiltSpeed, visca.MaxTiltSpeed)
+			}
+		case visca.ZoomCommand:
+			state.Zoom = command.Value
+		case visca.FocusCommand:
+			state.Focus = command.Value
+		case visca.IrisCommand:
+			state.Iris = command.Value
+		case visca.MemoryCommand:
+			code, ok := presetCodes[command.Operation]
+			if !ok {
+				return message, fmt.Errorf("unknown memory operation %d", command.Operation)
+			}
+			message = pelco.New().To(command.Address).Extended(code, 0, command.Memory).Build()
+			continue
+		case visca.MenuCommand:
+			message = pelco.New().To(command.Address).Extended(pelco.SetPreset, 0, pelco.MenuPreset).Build()
+			continue
+		}
+
+		message = state.Message()
+		p.remember(state)
+	}
+
+	return message, nil
+}
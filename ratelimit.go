@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"github.com/boxofrox/cctv-ptz/config"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commands waiting for the interactive loop past this are refused rather
+// than queued, so a flood can't back up behind the bus
+var errQueueFull = errors.New("command queue full. try again shortly")
+
+// clients idle this long are forgotten once there are many of them
+const rateLimiterIdle = 10 * time.Minute
+
+// RateLimiter allows each network client Rate commands a second on average,
+// in bursts of up to Burst, so one misbehaving integration can't flood the
+// bus. a nil limiter allows everything.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// returns a limiter for rate commands a second, or nil when rate is 0 and
+// clients aren't limited. burst is at least one command.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// takes a command from client's allowance at now. when it's spent, returns
+// false and how long until the next command is allowed.
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	if nil == l {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		l.prune(now)
+		bucket = &tokenBucket{tokens: l.burst, filled: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.filled).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.filled = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+
+	return true, 0
+}
+
+// forgets idle clients, whose allowance has long since refilled, once
+// enough have come and gone to matter.
+func (l *RateLimiter) prune(now time.Time) {
+	if len(l.buckets) < 1024 {
+		return
+	}
+
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.filled) > rateLimiterIdle {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// queues command for the interactive loop, unless the queue is full.
+func enqueue(commands chan<- Command, command Command) error {
+	select {
+	case commands <- command:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// names a network client by its host, so reconnecting doesn't reset its
+// allowance.
+func clientHost(addr net.Addr) string {
+	if nil == addr {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// refuses commands from clients over their allowance with 429. reads and
+// stops are never limited, so a limited client can still halt what it
+// started. clients are told apart by principal when the api has
+// credentials, otherwise by host.
+func limitRate(conf config.Config, limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "GET" == r.Method || "HEAD" == r.Method || strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/stop") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := principalFrom(r).Name
+		if !hasAPICredentials(conf) {
+			client, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+
+		if ok, wait := limiter.Allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many commands. retry in "+wait.Round(time.Millisecond).String(), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
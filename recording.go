@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+	"strings"
+	"time"
+)
+
+// version of the recording format written by this build. bump when lines
+// change meaning, so older builds refuse recordings they would misplay.
+const recordingFormat = 1
+
+// recordings start with this prefix and a json header. it is a comment, so
+// builds that predate the header still play the recording.
+const recordingPrefix = "# cctv-ptz recording "
+
+type RecordingHeader struct {
+	Format  int              `json:"format"`
+	Version string           `json:"version"`
+	Started time.Time        `json:"started"`
+	Serial  string           `json:"serial"`
+	Baud    int              `json:"baud"`
+	Cameras []RecordedCamera `json:"cameras,omitempty"`
+}
+
+type RecordedCamera struct {
+	Address int    `json:"address"`
+	Name    string `json:"name"`
+}
+
+func writeRecordingHeader(w io.Writer, conf config.Config, now time.Time) error {
+	header := RecordingHeader{
+		Format:  recordingFormat,
+		Version: VERSION,
+		Started: now,
+		Serial:  conf.SerialPort,
+		Baud:    conf.BaudRate,
+	}
+
+	for _, camera := range conf.Cameras {
+		header.Cameras = append(header.Cameras, RecordedCamera{camera.Address, camera.Name})
+	}
+
+	return header.Write(w)
+}
+
+func (h RecordingHeader) Write(w io.Writer) error {
+	text, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s%s\n", recordingPrefix, text)
+
+	return err
+}
+
+// parses a recording header line. returns false for any other line.
+func parseRecordingHeader(line string) (RecordingHeader, bool, error) {
+	var header RecordingHeader
+
+	if !strings.HasPrefix(line, recordingPrefix) {
+		return header, false, nil
+	}
+
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, recordingPrefix)), &header); err != nil {
+		return header, true, fmt.Errorf("invalid recording header. %s", err)
+	}
+
+	return header, true, nil
+}
+
+// rejects recordings in a newer format, and warns when the recording was
+// made with settings that differ from conf.
+func checkRecordingHeader(header RecordingHeader, conf config.Config) error {
+	if header.Format > recordingFormat {
+		return fmt.Errorf("recording format %d is newer than this build supports (%d). recorded by version %s",
+			header.Format, recordingFormat, header.Version)
+	}
+
+	if 0 != header.Baud && header.Baud != conf.BaudRate {
+		printError("warning: recorded at %d baud, playing at %d baud.\n", header.Baud, conf.BaudRate)
+	}
+
+	for _, camera := range header.Cameras {
+		if name := conf.CameraName(camera.Address); "" != name && name != camera.Name {
+			printError("warning: address %d was %s when recorded, now %s.\n", camera.Address, camera.Name, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploads larger than this are refused. a day of joystick traffic is a few
+// megabytes.
+const maxRecordingUpload = 32 << 20
+
+// stored recordings are plain file names, so a name can't reach outside the
+// recordings directory
+var recordingName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+var errPlaybackStopped = errors.New("playback stopped")
+
+// default location of recordings uploaded through the api
+func defaultRecordingsDir() string {
+	home := os.Getenv("HOME")
+	if "" == home {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "cctv-ptz", "recordings")
+}
+
+func recordingsDir(conf config.Config) string {
+	if "" != conf.RecordingsDir {
+		return conf.RecordingsDir
+	}
+
+	return defaultRecordingsDir()
+}
+
+// reads a whole recording, refusing it at the first line that playback
+// would skip. comments other than marks and blank lines are ignored.
+func parseRecording(r io.Reader) (RecordingHeader, []DelayedMessage, []RecordingMark, error) {
+	var (
+		header  RecordingHeader
+		frames  []DelayedMessage
+		marks   []RecordingMark
+		elapsed time.Duration
+		carried time.Duration // of moves skipped since the last frame
+		parser  = newRecordParser()
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for number := 1; scanner.Scan(); number++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if parsed, ok, err := parseRecordingHeader(text); ok {
+			if err != nil {
+				return header, nil, nil, fmt.Errorf("line %d: %s", number, err)
+			}
+			header = parsed
+			continue
+		}
+
+		if mark, ok := parseMark(text, elapsed); ok {
+			mark.Frame = len(frames)
+			marks = append(marks, mark)
+			continue
+		}
+
+		if "" == text || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		words := strings.Fields(text)
+		if 3 > len(words) {
+			return header, nil, nil, fmt.Errorf("line %d: too few fields", number)
+		}
+
+		// moves ONVIF cameras make themselves are left to playback. their
+		// delays carry over to the next frame
+		if isPTZMove(words[0]) {
+			millis, err := strconv.ParseUint(words[len(words)-1], 10, 64)
+			if err != nil {
+				return header, nil, nil, fmt.Errorf("line %d: invalid duration. %s", number, err)
+			}
+			carried += time.Duration(millis) * time.Millisecond
+			elapsed += time.Duration(millis) * time.Millisecond
+			continue
+		}
+
+		if !isRecordedProtocol(words[0]) {
+			return header, nil, nil, fmt.Errorf("line %d: invalid protocol %s", number, words[0])
+		}
+
+		message, err := parser.Frame(words[0], words[1])
+		if err != nil {
+			return header, nil, nil, fmt.Errorf("line %d: invalid packet. %s", number, err)
+		}
+
+		millis, err := strconv.ParseUint(words[2], 10, 64)
+		if err != nil {
+			return header, nil, nil, fmt.Errorf("line %d: invalid duration. %s", number, err)
+		}
+
+		frames = append(frames, DelayedMessage{Message: message, Delay: carried + time.Duration(millis)*time.Millisecond})
+		elapsed += time.Duration(millis) * time.Millisecond
+		carried = 0
+	}
+
+	if err := scanner.Err(); err != nil {
+		return header, nil, nil, err
+	}
+
+	if header.Format > recordingFormat {
+		return header, nil, nil, fmt.Errorf("recording format %d is newer than this build supports (%d)", header.Format, recordingFormat)
+	}
+
+	if 0 == len(frames) {
+		return header, nil, nil, errors.New("recording has no frames")
+	}
+
+	return header, frames, marks, nil
+}
+
+// returns the addresses frames are sent to, in order.
+func recordingAddresses(frames []DelayedMessage) []int {
+	seen := make(map[int]bool)
+	addresses := []int{}
+
+	for _, frame := range frames {
+		if address := int(frame.Message[pelco.Addr]); !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	sort.Ints(addresses)
+
+	return addresses
+}
+
+func recordingLength(frames []DelayedMessage) time.Duration {
+	var length time.Duration
+	for _, frame := range frames {
+		length += frame.Delay
+	}
+
+	return length
+}
+
+// RecordingInfo describes a stored recording.
+type RecordingInfo struct {
+	Name     string           `json:"name"`
+	Size     int64            `json:"size"`
+	Modified time.Time        `json:"modified"`
+	Frames   int              `json:"frames"`
+	Length   int64            `json:"length_ms"`
+	Cameras  []int            `json:"cameras"`
+	Marks    []RecordingMark  `json:"marks,omitempty"`
+	Header   *RecordingHeader `json:"header,omitempty"`
+}
+
+// reads the stored recording called name.
+func loadRecording(conf config.Config, name string) (RecordingInfo, []DelayedMessage, error) {
+	info := RecordingInfo{Name: name}
+
+	if !recordingName.MatchString(name) {
+		return info, nil, fmt.Errorf("invalid recording name %s", name)
+	}
+
+	f, err := os.Open(filepath.Join(recordingsDir(conf), name))
+	if err != nil {
+		return info, nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return info, nil, err
+	}
+
+	header, frames, marks, err := parseRecording(f)
+	if err != nil {
+		return info, nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	info.Size = stat.Size()
+	info.Modified = stat.ModTime()
+	info.Frames = len(frames)
+	info.Length = int64(recordingLength(frames) / time.Millisecond)
+	info.Cameras = recordingAddresses(frames)
+	info.Marks = marks
+	if 0 != header.Format {
+		info.Header = &header
+	}
+
+	return info, frames, nil
+}
+
+// lists the readable recordings in the recordings directory, by name. a
+// missing directory has none.
+func listRecordings(conf config.Config) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(recordingsDir(conf))
+	if os.IsNotExist(err) {
+		return []RecordingInfo{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	recordings := []RecordingInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !recordingName.MatchString(entry.Name()) {
+			continue
+		}
+
+		if info, _, err := loadRecording(conf, entry.Name()); nil == err {
+			recordings = append(recordings, info)
+		}
+	}
+
+	return recordings, nil
+}
+
+// stores data as the recording called name, replacing any already there.
+func saveRecording(conf config.Config, name string, data []byte) (RecordingInfo, error) {
+	dir := recordingsDir(conf)
+	if "" == dir {
+		return RecordingInfo{}, errors.New("no recordings-dir configured")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return RecordingInfo{}, err
+	}
+
+	// written aside and renamed, so a playback never reads half an upload
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return RecordingInfo{}, err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return RecordingInfo{}, err
+	}
+
+	info, _, err := loadRecording(conf, name)
+
+	return info, err
+}
+
+// RecordingStatus describes the recording playing on the daemon. Elapsed
+// is the recording time played, within the current lap when looping, and
+// Ends the estimated end of a recording that doesn't loop.
+type RecordingStatus struct {
+	Recording string         `json:"recording,omitempty"`
+	Running   bool           `json:"running"`
+	Loop      bool           `json:"loop"`
+	Frame     int            `json:"frame"`
+	Frames    int            `json:"frames"`
+	Started   time.Time      `json:"started"`
+	Elapsed   int64          `json:"elapsed_ms"`
+	Length    int64          `json:"length_ms"`
+	NextMark  *RecordingMark `json:"next_mark,omitempty"`
+	Ends      *time.Time     `json:"ends,omitempty"`
+	Error     string         `json:"error,omitempty"`
+
+	// with confirm, playback stops at each mark and Waiting names the one
+	// it waits at until continued
+	Confirm bool           `json:"confirm,omitempty"`
+	Waiting *RecordingMark `json:"waiting,omitempty"`
+}
+
+type runningRecording struct {
+	status RecordingStatus
+	marks  []RecordingMark
+	stop   chan struct{}
+	resume chan struct{}
+	done   chan struct{} // closed once play has stopped the cameras and let go
+	once   sync.Once
+
+	// recording time of the last frame sent and of the one waiting, and when
+	// the wait began
+	position, upcoming time.Duration
+	sentAt             time.Time
+}
+
+func (p *runningRecording) halt() {
+	p.once.Do(func() { close(p.stop) })
+}
+
+// RecordingPlayer plays stored recordings in the background, one at a time
+// since they share the bus, sending their frames through the arbiter as
+// source.
+type RecordingPlayer struct {
+	mu      sync.Mutex
+	arbiter *Arbiter
+	source  Source
+	send    func(Source, PelcoDMessage)
+	playing *runningRecording
+}
+
+func NewRecordingPlayer(arbiter *Arbiter, source Source, send func(Source, PelcoDMessage)) *RecordingPlayer {
+	return &RecordingPlayer{arbiter: arbiter, source: source, send: send}
+}
+
+// starts playing frames, stopping any recording already playing. marks are
+// reported as the playback reaches them, and with confirm the playback waits
+// at each until Continue.
+func (p *RecordingPlayer) Start(name string, frames []DelayedMessage, marks []RecordingMark, loop, confirm bool) (RecordingStatus, error) {
+	if loop && 0 == recordingLength(frames) {
+		return RecordingStatus{}, errors.New("recording takes no time. it can't loop")
+	}
+
+	// the old playback's stops and release would otherwise land after the
+	// new one took the lock
+	p.mu.Lock()
+	previous := p.playing
+	p.mu.Unlock()
+	if nil != previous {
+		previous.halt()
+		<-previous.done
+	}
+
+	if err := p.arbiter.Acquire(p.source, tourLockTTL, time.Now()); err != nil {
+		return RecordingStatus{}, err
+	}
+
+	now := time.Now()
+	playing := &runningRecording{
+		status: RecordingStatus{Recording: name, Running: true, Loop: loop, Confirm: confirm, Frames: len(frames), Started: now,
+			Length: int64(recordingLength(frames) / time.Millisecond)},
+		marks:    marks,
+		stop:     make(chan struct{}),
+		resume:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		upcoming: frames[0].Delay,
+		sentAt:   now,
+	}
+
+	p.mu.Lock()
+	p.playing = playing
+	p.mu.Unlock()
+
+	go p.play(playing, frames, loop)
+
+	return p.Status(), nil
+}
+
+// sends frames at their recorded pace until they run out, stop closes, or
+// another source takes control.
+func (p *RecordingPlayer) play(playing *runningRecording, frames []DelayedMessage, loop bool) {
+	defer close(playing.done)
+
+	var (
+		err    error
+		moving = make(map[uint8]PelcoDMessage)
+		next   = time.Now()
+	)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+laps:
+	for {
+		mark := 0
+
+		for i, frame := range frames {
+			for ; playing.status.Confirm && mark < len(playing.marks) && playing.marks[mark].Frame <= i; mark++ {
+				if err = p.wait(playing, playing.marks[mark], moving, frame.Delay); err != nil {
+					break laps
+				}
+				next = time.Now()
+			}
+
+			// paced from the start, so delays don't add up over a long tour
+			next = next.Add(frame.Delay)
+
+			timer.Reset(time.Until(next))
+			select {
+			case <-playing.stop:
+				err = errPlaybackStopped
+				break laps
+			case <-timer.C:
+			}
+
+			// holds longer than the lock let other sources take over, as
+			// with tours
+			if err = p.arbiter.Acquire(p.source, tourLockTTL, time.Now()); err != nil {
+				break laps
+			}
+
+			p.send(p.source, frame.Message)
+			trackMotion(moving, frame.Message)
+
+			p.mu.Lock()
+			playing.status.Frame = i + 1
+			playing.position += frame.Delay
+			playing.upcoming, playing.sentAt = playing.position, time.Now()
+			if i+1 < len(frames) {
+				playing.upcoming += frames[i+1].Delay
+			}
+			p.mu.Unlock()
+		}
+
+		if !loop {
+			break
+		}
+
+		p.mu.Lock()
+		playing.position, playing.upcoming = 0, frames[0].Delay
+		p.mu.Unlock()
+	}
+
+	// cameras taken over belong to the new source, otherwise don't leave
+	// them running
+	if nil == err || errPlaybackStopped == err {
+		for address := range moving {
+			p.send(p.source, pelco.New().To(int(address)).Build())
+		}
+	}
+
+	p.arbiter.Release(p.source)
+
+	p.mu.Lock()
+	playing.status.Running = false
+	playing.status.Elapsed = int64(playing.position / time.Millisecond)
+	if nil != err && errPlaybackStopped != err {
+		playing.status.Error = err.Error()
+	}
+	p.mu.Unlock()
+}
+
+// stops the cameras at mark, leaving them to other sources, e.g. for the
+// operator to look around, until Continue sets them moving again.
+func (p *RecordingPlayer) wait(playing *runningRecording, mark RecordingMark, moving map[uint8]PelcoDMessage, delay time.Duration) error {
+	for address := range moving {
+		p.send(p.source, pelco.New().To(int(address)).Build())
+	}
+	p.arbiter.Release(p.source)
+
+	p.mu.Lock()
+	playing.status.Waiting = &mark
+	playing.upcoming = playing.position
+	p.mu.Unlock()
+
+	select {
+	case <-playing.stop:
+		return errPlaybackStopped
+	case <-playing.resume:
+	}
+
+	// Continue took the lock back
+	for _, motion := range moving {
+		p.send(p.source, motion)
+	}
+
+	p.mu.Lock()
+	playing.status.Waiting = nil
+	playing.upcoming, playing.sentAt = playing.position+delay, time.Now()
+	p.mu.Unlock()
+
+	return nil
+}
+
+// continues a playback waiting at a mark, reporting false if none is
+// waiting, or an error if another source has control.
+func (p *RecordingPlayer) Continue() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.playing || !p.playing.status.Running || nil == p.playing.status.Waiting {
+		return false, nil
+	}
+
+	if err := p.arbiter.Acquire(p.source, tourLockTTL, time.Now()); err != nil {
+		return true, err
+	}
+
+	select {
+	case p.playing.resume <- struct{}{}:
+	default:
+	}
+
+	return true, nil
+}
+
+// stops the playing recording, reporting false if none is playing.
+func (p *RecordingPlayer) Stop() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.playing || !p.playing.status.Running {
+		return false
+	}
+
+	p.playing.halt()
+
+	return true
+}
+
+// returns the status of the last recording started.
+func (p *RecordingPlayer) Status() RecordingStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.playing {
+		return RecordingStatus{}
+	}
+
+	status := p.playing.status
+	if !status.Running {
+		return status
+	}
+
+	now := time.Now()
+	position := playbackPosition(p.playing.position, p.playing.upcoming, p.playing.sentAt, now)
+	progress := playbackProgress(position, time.Duration(status.Length)*time.Millisecond, p.playing.marks, status.Loop, now)
+
+	status.Elapsed = int64(progress.Elapsed / time.Millisecond)
+	status.NextMark = progress.NextMark
+	if !progress.Ends.IsZero() {
+		status.Ends = &progress.Ends
+	}
+
+	return status
+}
+
+// reports whether the request's principal may access every camera in info.
+func canAccessRecording(conf config.Config, r *http.Request, info RecordingInfo) bool {
+	principal := principalFrom(r)
+
+	for _, address := range info.Cameras {
+		if !principal.CanAccess(conf, address) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GET lists stored recordings and the playback status. POST stop ends the
+// playback, and POST continue goes on from the mark it waits at. GET NAME
+// describes a recording, PUT NAME stores the request body as one, DELETE
+// NAME removes it. POST NAME/play plays it, looping with ?loop=true and
+// waiting at marks with ?confirm=true.
+func (s *APIServer) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	var parts []string
+	if path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/recordings"), "/"); "" != path {
+		parts = strings.Split(path, "/")
+	}
+
+	switch {
+	case 0 == len(parts) && "GET" == r.Method:
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			recordings, err := listRecordings(s.conf)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			allowed := []RecordingInfo{}
+			for _, info := range recordings {
+				if canAccessRecording(s.conf, r, info) {
+					allowed = append(allowed, info)
+				}
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{"recordings": allowed, "status": s.recordings.Status()})
+		})(w, r)
+
+	case 1 == len(parts) && "stop" == parts[0] && "POST" == r.Method:
+		requireScope(ScopeMove, func(w http.ResponseWriter, r *http.Request) {
+			if !s.recordings.Stop() {
+				http.Error(w, "no recording playing", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, s.recordings.Status())
+		})(w, r)
+
+	case 1 == len(parts) && "continue" == parts[0] && "POST" == r.Method:
+		requireScope(ScopeMove, func(w http.ResponseWriter, r *http.Request) {
+			waiting, err := s.recordings.Continue()
+			if !waiting {
+				http.Error(w, "no recording waiting at a mark", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusOK, s.recordings.Status())
+		})(w, r)
+
+	case 1 == len(parts) && "GET" == r.Method:
+		requireScope(ScopeView, func(w http.ResponseWriter, r *http.Request) {
+			if info, _, ok := s.findRecording(w, r, parts[0]); ok {
+				writeJSON(w, http.StatusOK, info)
+			}
+		})(w, r)
+
+	case 1 == len(parts) && "PUT" == r.Method:
+		requireScope(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+			s.uploadRecording(w, r, parts[0])
+		})(w, r)
+
+	case 1 == len(parts) && "DELETE" == r.Method:
+		requireScope(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+			if _, _, ok := s.findRecording(w, r, parts[0]); !ok {
+				return
+			}
+			if err := os.Remove(filepath.Join(recordingsDir(s.conf), parts[0])); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"deleted": parts[0]})
+		})(w, r)
+
+	case 2 == len(parts) && "play" == parts[1] && "POST" == r.Method:
+		requireScope(ScopeMove, func(w http.ResponseWriter, r *http.Request) {
+			info, frames, ok := s.findRecording(w, r, parts[0])
+			if !ok {
+				return
+			}
+
+			if nil != info.Header {
+				if err := checkRecordingHeader(*info.Header, s.conf); err != nil {
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+					return
+				}
+			}
+
+			// a tour on one of the cameras would fight the recording for it
+			for _, address := range info.Cameras {
+				s.tours.Stop(address)
+			}
+
+			query := r.URL.Query()
+			status, err := s.recordings.Start(info.Name, frames, info.Marks, "true" == query.Get("loop"), "true" == query.Get("confirm"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, status)
+		})(w, r)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// loads the recording called name, answering the request with an error
+// when it can't be read or the principal lacks access to its cameras.
+func (s *APIServer) findRecording(w http.ResponseWriter, r *http.Request, name string) (RecordingInfo, []DelayedMessage, bool) {
+	if !recordingName.MatchString(name) {
+		http.Error(w, "invalid recording name "+name, http.StatusBadRequest)
+		return RecordingInfo{}, nil, false
+	}
+
+	info, frames, err := loadRecording(s.conf, name)
+	if os.IsNotExist(err) {
+		http.Error(w, "unknown recording "+name, http.StatusNotFound)
+		return info, nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return info, nil, false
+	}
+
+	if !canAccessRecording(s.conf, r, info) {
+		http.Error(w, "forbidden. no access to the cameras in "+name, http.StatusForbidden)
+		return info, nil, false
+	}
+
+	return info, frames, true
+}
+
+// stores the request body as the recording called name, once it parses.
+// the principal needs access to every camera the recording addresses, and to
+// those of the recording it replaces.
+func (s *APIServer) uploadRecording(w http.ResponseWriter, r *http.Request, name string) {
+	if !recordingName.MatchString(name) {
+		http.Error(w, "invalid recording name "+name+". use letters, digits, '.', '-', and '_'", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRecordingUpload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	_, frames, _, err := parseRecording(strings.NewReader(string(data)))
+	if err != nil {
+		http.Error(w, "invalid recording. "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !canAccessRecording(s.conf, r, RecordingInfo{Cameras: recordingAddresses(frames)}) {
+		http.Error(w, "forbidden. no access to the cameras in the recording", http.StatusForbidden)
+		return
+	}
+
+	if old, _, err := loadRecording(s.conf, name); nil == err && !canAccessRecording(s.conf, r, old) {
+		http.Error(w, "forbidden. no access to the cameras in "+name, http.StatusForbidden)
+		return
+	}
+
+	info, err := saveRecording(s.conf, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, info)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// returns the settings that differ between old and next but only take
+// effect on restart, because the listeners and clients using them were
+// started with their own copy of the config.
+func restartSettings(old, next config.Config) []string {
+	var changed []string
+
+	settings := []struct {
+		name      string
+		old, next interface{}
+	}{
+		{"listen", old.Listen, next.Listen},
+		{"mdns", []interface{}{old.MDNS, old.MDNSName}, []interface{}{next.MDNS, next.MDNSName}},
+		{"tls-cert", old.TLSCert, next.TLSCert},
+		{"tls-key", old.TLSKey, next.TLSKey},
+		{"api credentials", []interface{}{old.APIToken, old.APIUser, old.APIPassword, old.APITokens},
+			[]interface{}{next.APIToken, next.APIUser, next.APIPassword, next.APITokens}},
+		{"recordings-dir", old.RecordingsDir, next.RecordingsDir},
+		{"thumbnails-dir", old.ThumbnailsDir, next.ThumbnailsDir},
+		{"osc", old.OSC, next.OSC},
+		{"rate limits", []interface{}{old.RateLimit, old.RateBurst, old.CommandQueue},
+			[]interface{}{next.RateLimit, next.RateBurst, next.CommandQueue}},
+		{"control", old.Control, next.Control},
+		{"keyboard", []interface{}{old.Keyboard, old.KeyboardBaud}, []interface{}{next.Keyboard, next.KeyboardBaud}},
+		{"follow", old.Follow, next.Follow},
+		{"obs", old.OBS, next.OBS},
+		{"tally", old.Tally, next.Tally},
+		{"joystick", old.JoystickNumber, next.JoystickNumber},
+		{"controller mappings", []interface{}{old.ControllerDB, old.ControllerMappings},
+			[]interface{}{next.ControllerDB, next.ControllerMappings}},
+		{"record", []interface{}{old.RecordFile, old.RecordRotate, old.RecordMaxSize, old.RecordKeep},
+			[]interface{}{next.RecordFile, next.RecordRotate, next.RecordMaxSize, next.RecordKeep}},
+		{"state-file", old.StateFile, next.StateFile},
+		{"outputs", cameraOutputs(old), cameraOutputs(next)},
+		{"tee", old.Tee, next.Tee},
+	}
+
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.old, setting.next) {
+			changed = append(changed, setting.name)
+		}
+	}
+
+	return changed
+}
+
+// returns the outputs of each camera, by address.
+func cameraOutputs(conf config.Config) map[int][]config.Output {
+	outputs := make(map[int][]config.Output)
+	for _, camera := range conf.Cameras {
+		if 0 != len(camera.Outputs) {
+			outputs[camera.Address] = camera.Outputs
+		}
+	}
+
+	return outputs
+}
+
+// writes what the interactive loop is doing to w: the selected camera and
+// who controls it, the last frame sent to each address, the estimated
+// orientations, and the bus statistics.
+func dumpState(w io.Writer, conf config.Config, dash Dashboard, lastMessages map[uint8]PelcoDMessage, estimator *Estimator) {
+	now := time.Now()
+
+	owner := dash.Owner
+	if "" == owner {
+		owner = "none"
+	}
+
+	fmt.Fprintf(w, "--- state at %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(w, "camera %s, control %s, max speed %d%%", describeCamera(conf, conf.Address), owner, conf.MaxSpeed*100/config.MaxSpeed)
+	if "" != dash.Profile {
+		fmt.Fprintf(w, ", profile %s", dash.Profile)
+	}
+	fmt.Fprintf(w, ", elapsed %s\n", formatElapsed(dash.Elapsed))
+
+	addresses := make([]int, 0, len(lastMessages))
+	for address := range lastMessages {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+
+	fmt.Fprintf(w, "last frames:\n")
+	for _, address := range addresses {
+		message := lastMessages[uint8(address)]
+		fmt.Fprintf(w, "  %x  %s\n", message, describeMessage(conf, message))
+	}
+
+	snapshot, _ := estimator.Snapshot(now)
+
+	fmt.Fprintf(w, "orientation:\n")
+	for _, o := range snapshot {
+		fmt.Fprintf(w, "  %-20s pan %7.2f  tilt %6.2f  zoom %4.2f  %s\n", describeCamera(conf, o.Address), o.Pan, o.Tilt, o.Zoom, o.Source)
+	}
+
+	fmt.Fprintf(w, "bus:\n")
+	printStats(w, StatsReport{Started: busStats.Started(), Addresses: busStats.Snapshot(conf)})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/simulatedsimian/joystick"
+	"sort"
+	"time"
+)
+
+// Repeater decides when a held button acts: once when pressed, again after
+// Delay, then every Interval while it is held. without an Interval it acts
+// only on the press.
+type Repeater struct {
+	Delay    time.Duration
+	Interval time.Duration
+
+	held bool
+	next time.Time
+}
+
+// reports whether the button, pressed or not at now, acts.
+func (r *Repeater) Fire(pressed bool, now time.Time) bool {
+	if !pressed {
+		r.held = false
+		return false
+	}
+
+	if !r.held {
+		r.held = true
+		r.next = now.Add(r.Delay)
+		return true
+	}
+
+	if r.Interval <= 0 || now.Before(r.next) {
+		return false
+	}
+
+	// a late update doesn't bunch the repeats that follow
+	r.next = now.Add(r.Interval)
+
+	return true
+}
+
+// PresetStepper steps the selected camera through its configured presets
+// from a button chord.
+type PresetStepper struct {
+	Mask uint32
+	Step int
+
+	repeat Repeater
+}
+
+func newPresetSteppers(bindings []config.PresetStep) ([]PresetStepper, error) {
+	var result []PresetStepper
+
+	for _, binding := range bindings {
+		mask, err := buttonMask(binding.Buttons)
+		if err != nil {
+			return nil, err
+		}
+
+		if 0 == binding.Step {
+			return nil, fmt.Errorf("preset step needs a step, e.g. 1 or -1")
+		}
+
+		result = append(result, PresetStepper{
+			Mask:   mask,
+			Step:   binding.Step,
+			repeat: Repeater{Delay: binding.Delay, Interval: binding.Interval},
+		})
+	}
+
+	return result, nil
+}
+
+// tracks the chord in state and reports whether to step now. like aux
+// bindings, the chord's buttons are removed from state while held.
+func (s *PresetStepper) Update(state *joystick.State, now time.Time) bool {
+	pressed := isChordPressed(*state, s.Mask)
+
+	if pressed {
+		state.Buttons &^= s.Mask
+	}
+
+	return s.repeat.Fire(pressed, now)
+}
+
+// returns the preset step places from current among the configured presets
+// of the camera at address, wrapping around. a camera away from its presets
+// starts at the first, or at the last stepping back.
+func nextPreset(conf config.Config, address, current, step int) (int, bool) {
+	camera, _ := conf.Camera(address)
+	if 0 == len(camera.Presets) {
+		return 0, false
+	}
+
+	var numbers []int
+	for _, preset := range camera.Presets {
+		numbers = append(numbers, preset.Number)
+	}
+	sort.Ints(numbers)
+
+	index := sort.SearchInts(numbers, current)
+	if index == len(numbers) || numbers[index] != current {
+		if step > 0 {
+			return numbers[0], true
+		}
+		return numbers[len(numbers)-1], true
+	}
+
+	n := len(numbers)
+	index = ((index+step)%n + n) % n
+
+	return numbers[index], true
+}
+
+// ActionBinding runs actions on the selected camera when its chord is
+// pressed, e.g. to run a pattern or flip the camera.
+type ActionBinding struct {
+	Mask    uint32
+	Actions []string
+
+	press Repeater
+}
+
+// checks every binding's chord and actions up front. home is checked when
+// pressed, since it depends on the camera selected.
+func newActionBindings(bindings []config.ButtonAction) ([]ActionBinding, error) {
+	var result []ActionBinding
+
+	for _, binding := range bindings {
+		mask, err := buttonMask(binding.Buttons)
+		if err != nil {
+			return nil, err
+		}
+
+		if 0 == len(binding.Actions) {
+			return nil, fmt.Errorf("button action needs actions, e.g. [flip]")
+		}
+
+		actions, err := parseMacro(config.Camera{Home: &config.Home{}}, binding.Actions)
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range actions {
+			if 0 != action.Wait {
+				return nil, fmt.Errorf("wait is not supported in button actions")
+			}
+		}
+
+		result = append(result, ActionBinding{Mask: mask, Actions: binding.Actions})
+	}
+
+	return result, nil
+}
+
+// tracks the chord in state and reports whether it was just pressed. like
+// aux bindings, the chord's buttons are removed from state while held.
+func (b *ActionBinding) Update(state *joystick.State, now time.Time) bool {
+	pressed := isChordPressed(*state, b.Mask)
+
+	if pressed {
+		state.Buttons &^= b.Mask
+	}
+
+	return b.press.Fire(pressed, now)
+}
+
+// returns the frames the actions send to the camera at address.
+func (b *ActionBinding) Messages(conf config.Config, address int) ([]PelcoDMessage, error) {
+	camera, ok := conf.Camera(address)
+	if !ok {
+		camera.Address = address
+	}
+
+	actions, err := parseMacro(camera, b.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []PelcoDMessage
+	for _, action := range actions {
+		messages = append(messages, action.Messages...)
+	}
+
+	return messages, nil
+}
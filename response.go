@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"time"
+)
+
+// PelcoDResponse is a reply read back from a camera. General responses carry
+// an alarm byte; extended responses echo an opcode with two data bytes.
+type PelcoDResponse struct {
+	Address  uint8
+	Extended bool
+	Alarm    uint8
+	Opcode   uint8
+	Data     [2]uint8
+	Raw      []byte
+}
+
+// the 16 bit value of an extended response, e.g. a position.
+func (r PelcoDResponse) Value() uint16 {
+	return uint16(r.Data[0])<<8 | uint16(r.Data[1])
+}
+
+// returns a one line summary of response, e.g. "gate (1): pan at 123.45" or
+// "addr 2: ack".
+func describeResponse(conf config.Config, response PelcoDResponse) string {
+	text := "ack"
+
+	switch {
+	case !response.Extended && 0 != response.Alarm:
+		text = fmt.Sprintf("ack, alarms %02x", response.Alarm)
+	case !response.Extended:
+	case pelco.PanResponse == response.Opcode:
+		text = fmt.Sprintf("pan at %.2f", panFromPelco(response.Value()))
+	case pelco.TiltResponse == response.Opcode:
+		text = fmt.Sprintf("tilt at %.2f", tiltFromPelco(response.Value()))
+	case pelco.ZoomResponse == response.Opcode:
+		text = fmt.Sprintf("zoom at %.0f%%", zoomFromPelco(response.Value())*100)
+	default:
+		text = fmt.Sprintf("extended response %02x (%02x %02x)", response.Opcode, response.Data[0], response.Data[1])
+	}
+
+	return describeCamera(conf, int(response.Address)) + ": " + text
+}
+
+// reads responses from r until it fails. bytes that don't form a valid frame
+// are skipped so the reader resynchronizes on the next sync byte. the channel
+// is left open on failure so a select on it simply goes quiet.
+func listenResponses(r io.Reader) <-chan PelcoDResponse {
+	io := make(chan PelcoDResponse, 20)
+
+	go func() {
+		var (
+			frame []byte
+			chunk = make([]byte, 64)
+		)
+
+		for {
+			n, err := r.Read(chunk)
+			if err != nil {
+				return
+			}
+
+			for _, b := range chunk[:n] {
+				if 0 == len(frame) && 0xff != b {
+					continue
+				}
+
+				frame = append(frame, b)
+
+				response, ok, valid := parseResponse(frame)
+				if ok {
+					busStats.Response(response.Address)
+					io <- response
+					frame = nil
+				} else if !valid {
+					busStats.ChecksumError(frame[1])
+					frame = resync(frame)
+				}
+			}
+		}
+	}()
+
+	return io
+}
+
+// attempts to parse a complete response from frame. ok reports a complete
+// frame; valid reports whether frame may still become one with more bytes.
+func parseResponse(frame []byte) (response PelcoDResponse, ok bool, valid bool) {
+	if len(frame) < 4 {
+		return response, false, true
+	}
+
+	// general response: sync, address, alarms, checksum
+	if 4 == len(frame) && frame[3] == uint8(frame[1]+frame[2]) {
+		raw := append([]byte{}, frame...)
+		return PelcoDResponse{Address: frame[1], Alarm: frame[2], Raw: raw}, true, true
+	}
+
+	if len(frame) < 7 {
+		return response, false, true
+	}
+
+	// extended response: sync, address, 0x00, opcode, data 1, data 2, checksum
+	if frame[6] == uint8(frame[1]+frame[2]+frame[3]+frame[4]+frame[5]) {
+		raw := append([]byte{}, frame[:7]...)
+		return PelcoDResponse{
+			Address:  frame[1],
+			Extended: true,
+			Opcode:   frame[3],
+			Data:     [2]uint8{frame[4], frame[5]},
+			Raw:      raw,
+		}, true, true
+	}
+
+	return response, false, false
+}
+
+// drops the leading sync byte of a bad frame and restarts at the next one.
+func resync(frame []byte) []byte {
+	for i := 1; i < len(frame); i++ {
+		if 0xff == frame[i] {
+			return append([]byte{}, frame[i:]...)
+		}
+	}
+
+	return nil
+}
+
+// AckState summarizes whether a camera answered the commands sent to it.
+type AckState int
+
+const (
+	AckUnknown AckState = iota
+	AckOk
+	AckFail
+)
+
+func (s AckState) String() string {
+	switch s {
+	case AckOk:
+		return "ok"
+	case AckFail:
+		return "fail"
+	default:
+		return "?"
+	}
+}
+
+type pendingFrame struct {
+	Address uint8
+	Sent    time.Time
+}
+
+// AckTracker pairs responses with the frames that triggered them. cameras
+// answer in order, so each response settles the oldest frame sent to its
+// address, and frames without an answer within Timeout are failures.
+type AckTracker struct {
+	Timeout time.Duration
+	pending []pendingFrame
+	states  map[uint8]AckState
+}
+
+func NewAckTracker(timeout time.Duration) *AckTracker {
+	return &AckTracker{Timeout: timeout, states: make(map[uint8]AckState)}
+}
+
+func (t *AckTracker) Sent(message PelcoDMessage, now time.Time) {
+	t.pending = append(t.pending, pendingFrame{message[pelco.Addr], now})
+}
+
+func (t *AckTracker) Received(response PelcoDResponse) {
+	for i, frame := range t.pending {
+		if frame.Address == response.Address {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			t.states[response.Address] = AckOk
+			return
+		}
+	}
+}
+
+// marks frames that have waited longer than Timeout as failed.
+func (t *AckTracker) Expire(now time.Time) {
+	kept := t.pending[:0]
+
+	for _, frame := range t.pending {
+		if now.Sub(frame.Sent) > t.Timeout {
+			t.states[frame.Address] = AckFail
+			busStats.Timeout(frame.Address)
+		} else {
+			kept = append(kept, frame)
+		}
+	}
+
+	t.pending = kept
+}
+
+func (t *AckTracker) State(address uint8) AckState {
+	return t.states[address]
+}
@@ -0,0 +1,196 @@
+// Package rfc2217 speaks the client side of RFC 2217, the telnet com port
+// control option remote serial servers like the Moxa NPort and ser2net take
+// to set a port's baud rate and framing. data bytes equal to IAC are sent
+// doubled, so a Pelco-D sync byte survives the trip.
+package rfc2217
+
+import (
+	"encoding/binary"
+)
+
+// telnet commands
+const (
+	SE   = 240
+	SB   = 250
+	WILL = 251
+	WONT = 252
+	DO   = 253
+	DONT = 254
+	IAC  = 255
+)
+
+// telnet options
+const (
+	Binary          = 0
+	SuppressGoAhead = 3
+	ComPortOption   = 44
+)
+
+// com port option commands. the server confirms each with the command
+// plus 100
+const (
+	setBaudRate = 1
+	setDataSize = 2
+	setParity   = 3
+	setStopSize = 4
+	setControl  = 5
+)
+
+// parities
+const (
+	ParityNone = 1
+	ParityOdd  = 2
+	ParityEven = 3
+)
+
+// flow control off
+const noFlowControl = 1
+
+// Settings is the framing asked of the server's port.
+type Settings struct {
+	BaudRate int
+	DataBits int
+	Parity   int
+	StopBits int
+}
+
+// Escape returns data with every IAC byte doubled.
+func Escape(data []byte) []byte {
+	escaped := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		escaped = append(escaped, b)
+		if IAC == b {
+			escaped = append(escaped, IAC)
+		}
+	}
+
+	return escaped
+}
+
+func subnegotiation(command byte, value []byte) []byte {
+	packet := []byte{IAC, SB, ComPortOption, command}
+	packet = append(packet, Escape(value)...)
+
+	return append(packet, IAC, SE)
+}
+
+// Negotiate returns what a client sends as it connects: binary transmission
+// both ways, then the com port option with the framing of s and no flow
+// control.
+func Negotiate(s Settings) []byte {
+	packet := []byte{
+		IAC, WILL, Binary,
+		IAC, DO, Binary,
+		IAC, DO, SuppressGoAhead,
+		IAC, WILL, ComPortOption,
+	}
+
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(s.BaudRate))
+
+	packet = append(packet, subnegotiation(setBaudRate, baud)...)
+	packet = append(packet, subnegotiation(setDataSize, []byte{byte(s.DataBits)})...)
+	packet = append(packet, subnegotiation(setParity, []byte{byte(s.Parity)})...)
+	packet = append(packet, subnegotiation(setStopSize, []byte{byte(s.StopBits)})...)
+
+	return append(packet, subnegotiation(setControl, []byte{noFlowControl})...)
+}
+
+// decoder states
+const (
+	data = iota
+	command
+	option
+	sub
+	subCommand
+)
+
+// Decoder separates the serial data a server sends from its telnet
+// commands, answering requests for options the client doesn't offer. the
+// zero value is ready to use, one per connection.
+type Decoder struct {
+	state int
+	verb  byte
+	sub   []byte // of the subnegotiation being read
+
+	// the baud rate the server last confirmed, or 0
+	BaudRate int
+}
+
+// Feed reads chunk, returning the serial data in it and any reply to write
+// back to the server. commands split across chunks are carried over.
+func (d *Decoder) Feed(chunk []byte) (payload, reply []byte) {
+	for _, b := range chunk {
+		switch d.state {
+		case data:
+			if IAC == b {
+				d.state = command
+			} else {
+				payload = append(payload, b)
+			}
+
+		case command:
+			switch b {
+			case IAC:
+				payload = append(payload, IAC)
+				d.state = data
+			case WILL, WONT, DO, DONT:
+				d.verb, d.state = b, option
+			case SB:
+				d.sub, d.state = nil, sub
+			default:
+				d.state = data
+			}
+
+		case option:
+			reply = append(reply, d.answer(b)...)
+			d.state = data
+
+		case sub:
+			if IAC == b {
+				d.state = subCommand
+			} else {
+				d.sub = append(d.sub, b)
+			}
+
+		case subCommand:
+			switch b {
+			case SE:
+				d.confirm(d.sub)
+				d.state = data
+			case IAC:
+				d.sub = append(d.sub, IAC)
+				d.state = sub
+			default:
+				d.state = sub
+			}
+		}
+	}
+
+	return payload, reply
+}
+
+// refuses what the client didn't ask for. requests it did ask for go
+// unanswered, since it has already said so in Negotiate.
+func (d *Decoder) answer(opt byte) []byte {
+	switch d.verb {
+	case DO:
+		if Binary != opt && ComPortOption != opt {
+			return []byte{IAC, WONT, opt}
+		}
+	case WILL:
+		if Binary != opt && SuppressGoAhead != opt {
+			return []byte{IAC, DONT, opt}
+		}
+	}
+
+	return nil
+}
+
+// notes what a com port option confirmation reports.
+func (d *Decoder) confirm(sub []byte) {
+	if 6 == len(sub) && ComPortOption == sub[0] && 100+setBaudRate == sub[1] {
+		d.BaudRate = int(binary.BigEndian.Uint32(sub[2:]))
+	}
+}
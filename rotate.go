@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotated recordings are named after the time they were started, e.g.
+// session-20261014-093000.rec, so they sort in recording order
+const rotatedTimeFormat = "20060102-150405"
+
+// RotatingRecord writes a recording to path, moving it aside and starting a
+// new one at local midnight when Daily is set, or before it would grow past
+// MaxSize bytes. only the newest Keep of the moved files are kept, or all of
+// them when Keep is 0. each new file is started by begin, with the name of
+// the file it continues.
+type RotatingRecord struct {
+	Daily   bool
+	MaxSize int64
+	Keep    int
+
+	path    string
+	begin   func(w io.Writer, from string)
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// opens the recording conf.RecordFile. stdout and /dev/null are written
+// as is; other files rotate as record-rotate, record-max-size, and
+// record-keep say. without any, the file is replaced, as it always was.
+func openRecord(conf config.Config, begin func(w io.Writer, from string)) (io.WriteCloser, error) {
+	switch conf.RecordFile {
+	case "-":
+		return os.Stdout, nil
+	case "/dev/null":
+		return os.Create(conf.RecordFile)
+	}
+
+	maxSize, err := parseSize(conf.RecordMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record-max-size. %s", err)
+	}
+
+	daily := false
+	switch conf.RecordRotate {
+	case "":
+	case "daily":
+		daily = true
+	default:
+		return nil, fmt.Errorf("invalid record-rotate %s. expected daily", conf.RecordRotate)
+	}
+
+	if !daily && 0 == maxSize {
+		return os.Create(conf.RecordFile)
+	}
+
+	r := &RotatingRecord{Daily: daily, MaxSize: maxSize, Keep: conf.RecordKeep, path: conf.RecordFile, begin: begin}
+
+	// the last run's recording is kept rather than overwritten
+	if stat, err := os.Stat(r.path); nil == err && stat.Size() > 0 {
+		if err := r.moveAside(recordStarted(r.path, stat.ModTime())); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.create(time.Now(), ""); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// writes p to the recording, rotating first if it's time. callers write a
+// line at a time, so files only break between lines.
+func (r *RotatingRecord) Write(p []byte) (int, error) {
+	now := time.Now()
+
+	if r.due(len(p), now) {
+		if err := r.rotate(now); err != nil {
+			printError("unable to rotate recording. %s\n", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *RotatingRecord) Close() error {
+	return r.file.Close()
+}
+
+// reports whether writing n bytes at now starts a new file. a file holding
+// nothing but its start is never rotated for size.
+func (r *RotatingRecord) due(n int, now time.Time) bool {
+	if r.Daily {
+		y, m, d := r.started.Date()
+		if ny, nm, nd := now.Date(); ny != y || nm != m || nd != d {
+			return true
+		}
+	}
+
+	return r.MaxSize > 0 && r.size > 0 && r.size+int64(n) > r.MaxSize
+}
+
+// ends the current file with a mark naming the next, moves it aside, and
+// starts a new one.
+func (r *RotatingRecord) rotate(now time.Time) error {
+	name, err := r.rotatedName(r.started, "")
+	if err != nil {
+		return err
+	}
+
+	// the next file is named for now once it is moved aside in turn
+	next, err := r.rotatedName(now, name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.file, "# Rotated to %s\n", filepath.Base(next))
+	r.file.Close()
+
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+
+	if err := r.create(now, filepath.Base(name)); err != nil {
+		return err
+	}
+
+	r.prune()
+
+	return nil
+}
+
+// starts a new file at path, continuing the file moved aside as from, if
+// any.
+func (r *RotatingRecord) create(now time.Time, from string) error {
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.file, r.size, r.started = file, 0, now
+
+	// what begin writes doesn't count, so a max size smaller than the
+	// header doesn't rotate on every line
+	if nil != r.begin {
+		r.begin(file, from)
+	}
+
+	return nil
+}
+
+// moves the file at path aside, named for started.
+func (r *RotatingRecord) moveAside(started time.Time) error {
+	name, err := r.rotatedName(started, "")
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+
+	r.prune()
+
+	return nil
+}
+
+// returns when the recording at path started, from its header, or fallback
+// for recordings without one.
+func recordStarted(path string, fallback time.Time) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		if header, ok, err := parseRecordingHeader(scanner.Text()); ok && nil == err && !header.Started.IsZero() {
+			return header.Started
+		}
+	}
+
+	return fallback
+}
+
+// returns the name the file started at started is moved to. if a file
+// rotated for size in the same second is already there, the name is moved
+// on a second at a time, so names still sort in order. taken, the name just
+// chosen for another file, is skipped as well.
+func (r *RotatingRecord) rotatedName(started time.Time, taken string) (string, error) {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-"
+
+	for i := 0; i < 100; i++ {
+		name := base + started.Add(time.Duration(i)*time.Second).Format(rotatedTimeFormat) + ext
+		if !fileExists(name) && taken != name {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("too many recordings rotated at %s", started.Format(rotatedTimeFormat))
+}
+
+// removes all but the newest Keep moved files.
+func (r *RotatingRecord) prune() {
+	if r.Keep <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(r.path)
+	prefix := filepath.Base(strings.TrimSuffix(r.path, ext)) + "-"
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + `\d{8}-\d{6}` + regexp.QuoteMeta(ext) + "$")
+
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return
+	}
+
+	var rotated []string
+	for _, entry := range entries {
+		if pattern.MatchString(entry.Name()) {
+			rotated = append(rotated, entry.Name())
+		}
+	}
+
+	// names sort by the time their file started
+	sort.Strings(rotated)
+
+	for len(rotated) > r.Keep {
+		if err := os.Remove(filepath.Join(filepath.Dir(r.path), rotated[0])); err != nil {
+			printError("unable to remove old recording. %s\n", err)
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// parses a size in bytes, optionally with a K, M, or G suffix (powers of
+// 1024) and a trailing B, e.g. "50MB". empty is 0.
+func parseSize(text string) (int64, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	if "" == text {
+		return 0, nil
+	}
+
+	text = strings.TrimSuffix(text, "B")
+
+	multiplier := int64(1)
+	for suffix, scale := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if strings.HasSuffix(text, suffix) {
+			text, multiplier = strings.TrimSuffix(text, suffix), scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", text)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/mikepb/go-serial"
+	"time"
+)
+
+// rs485Port raises RTS around every write to a serial port, for RS485
+// adapters that enable their line driver from it rather than on their own.
+// RTS is low the rest of the time, so the adapter hears the cameras' replies.
+type rs485Port struct {
+	*serial.Port
+	before time.Duration // after raising RTS, for the driver to come up
+	after  time.Duration // after the last bit is out, before dropping RTS
+}
+
+// opens the serial device port at the baud rate of settings, raising RTS
+// only while writing when settings asks for rs485-rts.
+func openSerialDevice(settings config.Config, port string) (Port, error) {
+	tty, err := createSerialOptions(settings).Open(port)
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.RS485RTS {
+		return tty, nil
+	}
+
+	if err := tty.SetRTS(serial.RTS_OFF); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("cannot drop RTS on %s. %s", port, err)
+	}
+
+	return &rs485Port{Port: tty, before: settings.RS485RTSBefore, after: settings.RS485RTSAfter}, nil
+}
+
+// writes data with RTS raised, holding it until the frame has left the
+// port rather than just the driver's buffer.
+func (p *rs485Port) Write(data []byte) (int, error) {
+	if err := p.SetRTS(serial.RTS_ON); err != nil {
+		return 0, err
+	}
+	// dropped whatever happens, or the adapter holds the bus
+	defer p.SetRTS(serial.RTS_OFF)
+
+	time.Sleep(p.before)
+
+	n, err := p.Port.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	if err := p.Sync(); err != nil {
+		return n, err
+	}
+
+	time.Sleep(p.after)
+
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/boxofrox/cctv-ptz/sensormatic"
+)
+
+func init() {
+	registerProtocol("sensormatic", func() Protocol { return &sensormaticProtocol{sent: make(map[uint8]pelco.Command)} })
+}
+
+// sensormaticProtocol sends each axis as it starts, stops, or turns, since
+// SpeedDomes carry on until told otherwise. speeds are the dome's own.
+// presets 1 through MaxPreset are set and called; clearing them, the menu,
+// and aux switches have no Sensormatic command and get nothing.
+type sensormaticProtocol struct {
+	sent map[uint8]pelco.Command // last frame of each dome
+}
+
+func (p *sensormaticProtocol) Frame(message PelcoDMessage) []byte {
+	return encodeFrame(p, message)
+}
+
+func (p *sensormaticProtocol) EncodeMove(command pelco.Command) []byte {
+	address := command.Address
+
+	last, sent := p.sent[uint8(address)]
+	p.sent[uint8(address)] = command
+
+	var packets []byte
+	if !sent || last.Pan != command.Pan {
+		packets = append(packets, sensormatic.Pan(address, command.Pan)...)
+	}
+	if !sent || last.Tilt != command.Tilt {
+		packets = append(packets, sensormatic.Tilt(address, command.Tilt)...)
+	}
+	if !sent || last.Zoom != command.Zoom {
+		packets = append(packets, sensormatic.Zoom(address, command.Zoom)...)
+	}
+	if !sent || last.Focus != command.Focus {
+		packets = append(packets, sensormatic.Focus(address, command.Focus)...)
+	}
+	if !sent || last.Iris != command.Iris {
+		packets = append(packets, sensormatic.Iris(address, command.Iris)...)
+	}
+
+	return packets
+}
+
+func (p *sensormaticProtocol) EncodeStop(address int) []byte {
+	return p.EncodeMove(pelco.Command{Address: address})
+}
+
+func (p *sensormaticProtocol) EncodePreset(address int, code, preset uint8) []byte {
+	if 0 == preset || preset > sensormatic.MaxPreset {
+		return nil
+	}
+
+	switch code {
+	case pelco.SetPreset:
+		return sensormatic.SetPreset(address, preset)
+	case pelco.CallPreset:
+		return sensormatic.CallPreset(address, preset)
+	}
+
+	return nil
+}
+
+func (p *sensormaticProtocol) EncodeExtended(pelco.Command) []byte {
+	return nil
+}
+
+func (p *sensormaticProtocol) CheckAddress(settings config.Config, address int) error {
+	if address < sensormatic.MinAddress || address > sensormatic.MaxAddress {
+		return fmt.Errorf("sensormatic domes are addressed %d-%d", sensormatic.MinAddress, sensormatic.MaxAddress)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package sensormatic builds the commands American Dynamics / Sensormatic
+// SpeedDomes take over RS-422. a command is the dome address, 1 through 255,
+// a command byte, any data, and a checksum making the bytes sum to 0.
+//
+// motion commands latch: a dome panning left keeps on until told to stop
+// panning, so they are sent as motion starts, stops, and turns.
+package sensormatic
+
+const (
+	MinAddress = 1
+	MaxAddress = 0xff
+
+	// highest preset a dome saves
+	MaxPreset = 0x60
+)
+
+// command bytes, each axis with its two directions and stop
+const (
+	panLeft  = 0x81
+	panRight = 0x82
+	panStop  = 0x83
+
+	tiltUp   = 0x84
+	tiltDown = 0x85
+	tiltStop = 0x86
+
+	focusFar  = 0x87
+	focusNear = 0x88
+	focusStop = 0x89
+
+	zoomIn   = 0x8a
+	zoomOut  = 0x8b
+	zoomStop = 0x8c
+
+	irisOpen  = 0x8d
+	irisClose = 0x8e
+	irisStop  = 0x8f
+
+	setPreset  = 0xa6
+	callPreset = 0xa7
+)
+
+// returns the command with its checksum appended.
+func command(address int, data ...byte) []byte {
+	packet := append([]byte{byte(address)}, data...)
+
+	var sum byte
+	for _, b := range packet {
+		sum += b
+	}
+
+	return append(packet, -sum)
+}
+
+func axis(address, value int, positive, negative, stop byte) []byte {
+	switch {
+	case value > 0:
+		return command(address, positive)
+	case value < 0:
+		return command(address, negative)
+	}
+
+	return command(address, stop)
+}
+
+// Pan pans right for 1, left for -1, and stops panning for 0.
+func Pan(address, pan int) []byte {
+	return axis(address, pan, panRight, panLeft, panStop)
+}
+
+// Tilt tilts up for 1, down for -1, and stops tilting for 0.
+func Tilt(address, tilt int) []byte {
+	return axis(address, tilt, tiltUp, tiltDown, tiltStop)
+}
+
+// Zoom zooms in for 1, out for -1, and stops for 0.
+func Zoom(address, zoom int) []byte {
+	return axis(address, zoom, zoomIn, zoomOut, zoomStop)
+}
+
+// Focus focuses far for 1, near for -1, and stops for 0.
+func Focus(address, focus int) []byte {
+	return axis(address, focus, focusFar, focusNear, focusStop)
+}
+
+// Iris opens the iris for 1, closes it for -1, and stops for 0.
+func Iris(address, iris int) []byte {
+	return axis(address, iris, irisOpen, irisClose, irisStop)
+}
+
+// SetPreset saves the dome's position as preset, 1 through MaxPreset.
+func SetPreset(address int, preset uint8) []byte {
+	return command(address, setPreset, preset)
+}
+
+// CallPreset moves the dome to preset.
+func CallPreset(address int, preset uint8) []byte {
+	return command(address, callPreset, preset)
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// reports whether the device node of serialPort is there, e.g. after an
+// adapter was plugged back in.
+func serialDevicePresent(serialPort string) bool {
+	_, err := os.Stat(serialPort)
+	return !os.IsNotExist(err)
+}
+
+func serialPortAvailable(serialPort string) (bool, error) {
+	var err error
+
+	goStat, err := os.Stat(serialPort)
+
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		return false, err
+	}
+
+	euid := uint32(os.Geteuid())
+
+	unixStat, ok := goStat.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return false, errors.New("cannot determine file ownership or permissions")
+	}
+
+	if euid == unixStat.Uid && 0 != (0x600&unixStat.Mode) {
+		// we should have owner access!
+		return true, nil
+	}
+
+	if 0 != (0x006 & unixStat.Mode) {
+		// we should have other access!
+		return true, nil
+	}
+
+	if 0 != (0x060 & unixStat.Mode) {
+		groups, err := os.Getgroups()
+
+		if err != nil {
+			return false, err
+		}
+
+		// does any group for user match file's group?
+		for _, gid := range groups {
+			if uint32(gid) == unixStat.Gid {
+				// we should have group access!
+				return true, nil
+			}
+		}
+	}
+
+	return false, errors.New(fmt.Sprintf("access denied. uid (%d) gid (%d) mode (%o)", unixStat.Uid, unixStat.Gid, 0xfff&unixStat.Mode))
+}
@@ -0,0 +1,59 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var queryDosDevice = syscall.NewLazyDLL("kernel32.dll").NewProc("QueryDosDeviceW")
+
+// returns the COM port serialPort names, e.g. COM3 for com3 or \\.\COM10.
+func comPortName(serialPort string) (string, bool) {
+	name := strings.ToUpper(strings.TrimPrefix(serialPort, `\\.\`))
+	if !strings.HasPrefix(name, "COM") {
+		return "", false
+	}
+
+	if number, err := strconv.Atoi(name[3:]); err != nil || number < 1 {
+		return "", false
+	}
+
+	return name, true
+}
+
+// reports whether serialPort is there, e.g. after an adapter was plugged
+// back in. COM ports have no file to stat, so the device is looked up.
+func serialDevicePresent(serialPort string) bool {
+	name, ok := comPortName(serialPort)
+	if !ok {
+		_, err := os.Stat(serialPort)
+		return !os.IsNotExist(err)
+	}
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return false
+	}
+
+	target := make([]uint16, 256)
+	n, _, _ := queryDosDevice.Call(uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&target[0])), uintptr(len(target)))
+
+	return 0 != n
+}
+
+// windows keeps no unix style ownership on COM ports; a port another
+// program holds only fails as it's opened.
+func serialPortAvailable(serialPort string) (bool, error) {
+	if !serialDevicePresent(serialPort) {
+		return false, fmt.Errorf("%s not found", serialPort)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"time"
+)
+
+// SerialLine sends frames on the serial port of the addressed camera,
+// reopening the port when a frame goes to a camera on another port or at
+// another baud rate. a port that keeps failing is replaced by its backup
+// until it can be opened again. frames are copied to the camera's other
+// outputs as well. an adapter that is unplugged is reopened once its device
+// node is back. it is not safe for concurrent use.
+type SerialLine struct {
+	conf      config.Config
+	encoder   *frameEncoder // of tty
+	tty       Port
+	port      string
+	baud      int
+	network   string // network camera driven in place of port
+	settings  config.Config
+	responses chan PelcoDResponse
+	done      chan struct{} // closed when tty is replaced
+
+	failures   map[string]int       // failed writes in a row, by port
+	failedOver map[string]time.Time // ports on their backup, and since when
+
+	lost      bool      // the adapter went away; reopened once it's back
+	checkedAt time.Time // last look for the device node
+
+	outputs *Outputs
+}
+
+// opens the port of the camera at conf.Address.
+func openSerialLine(conf config.Config) (*SerialLine, error) {
+	line := &SerialLine{
+		conf:       conf,
+		responses:  make(chan PelcoDResponse, 20),
+		failures:   make(map[string]int),
+		failedOver: make(map[string]time.Time),
+	}
+
+	tty, err := openSerial(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	line.attach(tty, conf.ForCamera(conf.Address))
+
+	if line.outputs, err = openOutputs(conf); err != nil {
+		line.Close()
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// replaces the config used to pick ports, e.g. after a profile change.
+func (l *SerialLine) SetConfig(conf config.Config) {
+	l.conf = conf
+	l.encoder.SetConfig(conf)
+}
+
+func (l *SerialLine) attach(tty Port, settings config.Config) {
+	l.tty = tty
+	l.encoder = newFrameEncoder(l.conf) // cameras on the new port know nothing yet
+	l.port, l.baud, l.network = settings.SerialPort, settings.BaudRate, networkCamera(settings)
+	l.settings = settings
+	l.lost = false
+	l.done = make(chan struct{})
+
+	// replies are read whether or not --ack tracks them, so they can be
+	// shown and recorded
+	if nil != tty {
+		go forwardResponses(listenResponses(tty), l.responses, l.done)
+	}
+}
+
+// returns the settings of the camera at address, with its backup port in
+// place of a port that failed over.
+func (l *SerialLine) route(address int) config.Config {
+	settings := l.conf.ForCamera(address)
+
+	if _, ok := l.failedOver[settings.SerialPort]; ok {
+		settings.SerialPort = settings.BackupSerial
+	}
+
+	return settings
+}
+
+// switches to the port and baud rate of the camera at address, if different.
+func (l *SerialLine) retarget(address int) error {
+	settings := l.route(address)
+	if settings.SerialPort == l.port && settings.BaudRate == l.baud && networkCamera(settings) == l.network {
+		return nil
+	}
+
+	l.closePort()
+
+	if l.conf.Verbose {
+		fmt.Fprintf(os.Stderr, "switching to %s\n", describePort(settings))
+	}
+
+	tty, err := openPort(settings)
+
+	// remember the settings even on failure, so every frame doesn't retry
+	l.attach(tty, settings)
+
+	return err
+}
+
+// returns the port of the camera at address, e.g. to reach an ONVIF
+// camera's own moves.
+func (l *SerialLine) PortFor(address int) (Port, error) {
+	l.failBack(address)
+
+	if err := l.retarget(address); err != nil {
+		return nil, err
+	}
+	if nil == l.tty {
+		return nil, errors.New("port unavailable")
+	}
+
+	return l.tty, nil
+}
+
+func (l *SerialLine) Send(message PelcoDMessage) {
+	address := int(message[pelco.Addr])
+
+	l.failBack(address)
+
+	err := l.retarget(address)
+	if nil == err {
+		l.checkDevice()
+	}
+
+	if err != nil {
+		printError("cannot open serial port (%s). %s\n", l.port, err)
+	} else if nil == l.tty && "/dev/null" != l.port {
+		// unplugged or not yet plugged in
+		err = errors.New("port unavailable")
+	} else {
+		err = sendMessage(l.tty, l.encoder, message)
+	}
+
+	// the frame that tipped the port over goes out on the backup
+	if nil != err && l.fail(address, err) {
+		if err := l.retarget(address); err != nil {
+			printError("cannot open serial port (%s). %s\n", l.port, err)
+		}
+		sendMessage(l.tty, l.encoder, message)
+	} else if nil != err && nil != l.tty && l.isDevice() {
+		l.lose(err)
+	}
+
+	l.outputs.Send(message)
+}
+
+// how often a serial device is looked for, while lost or open
+const deviceCheckInterval = time.Second
+
+// reports whether the current port is a serial device that can be unplugged,
+// rather than disabled or a link over the network.
+func (l *SerialLine) isDevice() bool {
+	return "/dev/null" != l.port && "" == l.network &&
+		!isVISCAIP(l.port) && !isTCPSerial(l.port) && !isUDPSerial(l.port)
+}
+
+// closes a port whose adapter went away, e.g. unplugged, so it can be
+// reopened once it's back.
+func (l *SerialLine) lose(err error) {
+	printError("serial port %s lost (%s). reopening it when it's back\n", l.port, err)
+
+	l.closePort()
+	l.lost = true
+}
+
+// notices the device node of the open port going away, and reopens a port
+// that isn't open once its node is back, every deviceCheckInterval.
+func (l *SerialLine) checkDevice() {
+	if !l.isDevice() || time.Since(l.checkedAt) < deviceCheckInterval {
+		return
+	}
+	l.checkedAt = time.Now()
+
+	if nil != l.tty {
+		if !serialDevicePresent(l.port) {
+			l.lose(errors.New("device gone"))
+		}
+		return
+	}
+
+	if available, _ := serialPortAvailable(l.port); !available {
+		return
+	}
+
+	tty, err := openSerialDevice(l.settings, l.port)
+	if err != nil {
+		return
+	}
+
+	if l.lost {
+		printError("serial port %s is back\n", l.port)
+	} else {
+		printError("serial port %s is available\n", l.port)
+	}
+
+	l.attach(tty, l.settings)
+}
+
+// counts a failed write to the camera at address, switching its port to the
+// backup once it has failed FailoverErrors times in a row. reports whether
+// it switched.
+func (l *SerialLine) fail(address int, err error) bool {
+	settings := l.conf.ForCamera(address)
+	port := settings.SerialPort
+
+	if "" == settings.BackupSerial || settings.BackupSerial == port {
+		return false
+	}
+	if _, ok := l.failedOver[port]; ok {
+		return false
+	}
+
+	l.failures[port]++
+	if l.failures[port] < settings.FailoverErrors {
+		return false
+	}
+
+	printError("serial port %s failed %d times (%s). switching to backup %s\n",
+		port, l.failures[port], err, settings.BackupSerial)
+
+	l.failures[port] = 0
+	l.failedOver[port] = time.Now()
+
+	return true
+}
+
+// switches the port of the camera at address back from its backup, once
+// FailbackAfter has passed and the port can be opened again.
+func (l *SerialLine) failBack(address int) {
+	settings := l.conf.ForCamera(address)
+	port := settings.SerialPort
+
+	since, ok := l.failedOver[port]
+	if !ok || time.Since(since) < settings.FailbackAfter {
+		return
+	}
+
+	// not yet. wait another FailbackAfter before trying again
+	l.failedOver[port] = time.Now()
+
+	var tty Port
+	if isTCPSerial(port) {
+		gateway, err := dialTCPSerial(settings)
+		if err != nil || !gateway.Linked() {
+			if nil == err {
+				gateway.Close()
+			}
+			return
+		}
+		tty = gateway
+	} else if isUDPSerial(port) {
+		bridge, err := dialUDPSerial(port)
+		if err != nil {
+			return
+		}
+		tty = bridge
+	} else {
+		if available, _ := serialPortAvailable(port); !available {
+			return
+		}
+
+		serialPort, err := openSerialDevice(settings, port)
+		if err != nil {
+			return
+		}
+		tty = serialPort
+	}
+
+	l.closePort()
+	l.attach(tty, settings)
+	delete(l.failedOver, port)
+
+	printError("serial port %s is back. switching from backup %s\n", port, settings.BackupSerial)
+}
+
+// writes bytes verbatim on the port of the camera at address.
+func (l *SerialLine) Write(address int, bytes []byte) {
+	if err := l.retarget(address); err != nil {
+		printError("cannot open serial port (%s). %s\n", l.port, err)
+	} else {
+		l.checkDevice()
+	}
+
+	if nil != l.tty {
+		l.tty.Write(bytes)
+	}
+}
+
+// reports whether the current port is open, rather than disabled.
+func (l *SerialLine) Connected() bool {
+	return nil != l.tty
+}
+
+// returns "up" or "down" when the current port is a link that can drop,
+// e.g. a TCP gateway or an adapter that was unplugged, and "" otherwise.
+func (l *SerialLine) Link() string {
+	if l.lost {
+		return "down"
+	}
+
+	linker, ok := l.tty.(Linker)
+	switch {
+	case !ok:
+		return ""
+	case linker.Linked():
+		return "up"
+	}
+
+	return "down"
+}
+
+// camera replies from whichever port is current.
+func (l *SerialLine) Responses() <-chan PelcoDResponse {
+	return l.responses
+}
+
+func (l *SerialLine) Close() {
+	l.closePort()
+	l.outputs.Close()
+}
+
+func (l *SerialLine) closePort() {
+	if nil != l.done {
+		close(l.done)
+		l.done = nil
+	}
+
+	if nil != l.tty {
+		l.tty.Close()
+		l.tty = nil
+	}
+}
+
+func forwardResponses(from <-chan PelcoDResponse, to chan<- PelcoDResponse, done <-chan struct{}) {
+	for {
+		select {
+		case response := <-from:
+			select {
+			case to <- response:
+			case <-done:
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
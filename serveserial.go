@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// default address serve-serial accepts clients on, the usual serial server
+// port
+const defaultServeSerialAddr = ":4001"
+
+// slow clients don't hold up the bus for longer than this
+const serveSerialWriteTimeout = time.Second
+
+// a frame from a serve-serial client
+type clientFrame struct {
+	conn    net.Conn
+	message PelcoDMessage
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "serve-serial",
+		Summary: "share the serial port with TCP clients sending Pelco-D frames",
+		Usage:   []string{"[--listen ADDR]"},
+		Options: []string{"--listen ADDR            - accept clients on tcp ADDR. (default = :4001)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			addr := stringArg(arguments, "--listen")
+			if "" == addr {
+				addr = defaultServeSerialAddr
+			}
+			serveSerial(conf, addr)
+		},
+	})
+}
+
+// owns the serial port and sends it the Pelco-D frames clients write on tcp
+// addr, a frame at a time and taking addresses in turn, so several operators
+// or scripts share one adapter. frames go out in each camera's protocol, and
+// a camera's replies go back to the client that last sent it a frame. runs
+// until interrupted.
+func serveSerial(conf config.Config, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		printError("unable to accept clients on %s. %s\n", addr, err)
+		os.Exit(1)
+	}
+	defer listener.Close()
+
+	line, err := openSerialLine(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer line.Close()
+
+	fmt.Fprintf(os.Stderr, "Sharing the serial port with clients on tcp %s\n", listener.Addr())
+
+	var (
+		frames = make(chan clientFrame, 20)
+		gone   = make(chan net.Conn)
+	)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(os.Stderr, "client %s connected\n", conn.RemoteAddr())
+
+			go func() {
+				for message := range readFrames(conn) {
+					frames <- clientFrame{conn, message}
+				}
+				gone <- conn
+			}()
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var (
+		queue = NewInterleaver(func(message PelcoDMessage) time.Duration {
+			return frameSlot(conf.ForCamera(int(message[pelco.Addr])).BaudRate)
+		})
+		slotReady <-chan time.Time
+		// client that last sent a frame to each address, for its replies
+		senders = make(map[uint8]net.Conn)
+	)
+
+	sendQueued := func() {
+		slotReady = nil
+
+		if message, ok := queue.Next(time.Now()); ok {
+			line.Send(message)
+		}
+
+		if wait, pending := queue.Wait(time.Now()); pending {
+			slotReady = time.After(wait)
+		}
+	}
+
+	for {
+		select {
+		case frame := <-frames:
+			senders[frame.message[pelco.Addr]] = frame.conn
+			if conf.Verbose {
+				fmt.Printf("client %s: pelco-d %x  %s\n", frame.conn.RemoteAddr(), frame.message,
+					stdoutColor.Paint(ansiDim, "("+describeMessage(conf, frame.message)+")"))
+			}
+			queue.Add(frame.message)
+			if nil == slotReady {
+				sendQueued()
+			}
+
+		case <-slotReady:
+			sendQueued()
+
+		case response := <-line.Responses():
+			conn, ok := senders[response.Address]
+			if !ok {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(serveSerialWriteTimeout))
+			conn.Write(response.Raw)
+
+		case conn := <-gone:
+			fmt.Fprintf(os.Stderr, "client %s disconnected\n", conn.RemoteAddr())
+			for address, sender := range senders {
+				if sender == conn {
+					delete(senders, address)
+				}
+			}
+
+		case <-interrupt:
+			return
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shellHistoryLimit = 500
+
+const shellHelp = `Commands:
+  camera [NAME|ADDRESS]          - show or select the target camera.
+  cameras                        - list configured cameras.
+  move PAN TILT [ZOOM]           - move at normalized speeds -1.0 to 1.0.
+  absolute pan=X tilt=Y zoom=Z   - send an onvif camera to a position. axes may be left out.
+  relative pan=X tilt=Y zoom=Z   - move an onvif camera by an offset.
+  position                       - show where an onvif camera is.
+  stop                           - stop all motion.
+  preset set|call|clear NUM      - manage presets.
+  aux NUM on|off                 - switch an auxiliary output.
+  profile [NAME]                 - show or select the operating profile.
+  decode HEX                     - describe a pelco-d frame without sending it.
+  raw HEX                        - send bytes verbatim.
+  history                        - list previous commands.
+  help                           - print this help message.
+  quit                           - leave the shell.
+`
+
+var shellCommands = []string{"absolute", "aux", "camera", "cameras", "decode", "help", "history", "move", "position", "preset", "profile", "quit", "raw", "relative", "stop"}
+
+type Shell struct {
+	conf   config.Config
+	base   config.Config // conf before profile overrides
+	line   *SerialLine
+	out    io.Writer
+	editor *LineEditor
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "shell",
+		Summary: "type commands to the cameras, without a controller",
+		Usage:   []string{""},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			shell(conf)
+		},
+	})
+}
+
+// runs the interactive shell on stdin, for use without a controller.
+func shell(conf config.Config) {
+	line, err := openSerialLine(conf)
+	if err != nil {
+		panic(err)
+	}
+	defer line.Close()
+
+	startCameras(line.Send, conf)
+	defer stopCameras(line.Send, conf)
+
+	sh := &Shell{conf: conf, base: conf, line: line, out: os.Stdout}
+	if profile, ok := conf.ActiveProfile(time.Now()); ok {
+		sh.conf = conf.WithProfile(profile)
+		sh.conf.Profile = profile.Name
+		line.SetConfig(sh.conf)
+	}
+
+	if !isTerminal(os.Stdin) {
+		// scripted input, e.g. `cctv-ptz shell < commands.txt`
+		sh.runLines(os.Stdin)
+		return
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		sh.runLines(os.Stdin)
+		return
+	}
+	defer restore()
+
+	historyPath := shellHistoryPath()
+
+	sh.editor = NewLineEditor(os.Stdin, os.Stdout)
+	sh.editor.History = loadHistory(historyPath)
+	sh.editor.Complete = sh.complete
+
+	for {
+		text, err := sh.editor.ReadLine(sh.prompt())
+		if err == errInterrupted {
+			continue
+		} else if err != nil {
+			break
+		}
+
+		if !sh.execute(text) {
+			break
+		}
+	}
+
+	if "" != historyPath {
+		os.MkdirAll(filepath.Dir(historyPath), 0755)
+		if err := saveHistory(historyPath, sh.editor.History, shellHistoryLimit); err != nil {
+			printError("unable to save shell history. %s\n", err)
+		}
+	}
+}
+
+func shellHistoryPath() string {
+	home := os.Getenv("HOME")
+	if "" == home {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "cctv-ptz", "history")
+}
+
+func (sh *Shell) runLines(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if !sh.execute(scanner.Text()) {
+			return
+		}
+	}
+}
+
+func (sh *Shell) prompt() string {
+	return stdoutColor.Paint(ansiCyan, describeCamera(sh.conf, sh.conf.Address)) + "> "
+}
+
+// runs a single command line. returns false when the shell should exit.
+func (sh *Shell) execute(text string) bool {
+	words := strings.Fields(text)
+	if 0 == len(words) || strings.HasPrefix(words[0], "#") {
+		return true
+	}
+
+	var err error
+
+	switch words[0] {
+	case "quit", "exit":
+		return false
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+	case "history":
+		if nil != sh.editor {
+			for i, entry := range sh.editor.History {
+				fmt.Fprintf(sh.out, "%4d  %s\n", i+1, entry)
+			}
+		}
+	case "camera":
+		err = sh.selectCamera(words[1:])
+	case "cameras":
+		for _, camera := range sh.conf.Cameras {
+			fmt.Fprintf(sh.out, "%4d  %s\n", camera.Address, camera.Name)
+		}
+	case "move":
+		err = sh.move(words[1:])
+	case "absolute", "relative":
+		err = sh.ptzMove(words)
+	case "position":
+		err = sh.position()
+	case "stop":
+		sh.send(pelco.New().To(sh.conf.Address).Build())
+	case "preset":
+		err = sh.preset(words[1:])
+	case "aux":
+		err = sh.aux(words[1:])
+	case "profile":
+		err = sh.selectProfile(words[1:])
+	case "decode":
+		err = sh.decode(words[1:])
+	case "raw":
+		err = sh.raw(words[1:])
+	default:
+		err = fmt.Errorf("unknown command %s. try help", words[0])
+	}
+
+	if err != nil {
+		printError("%s\n", err)
+	}
+
+	return true
+}
+
+func (sh *Shell) complete(words []string) []string {
+	if 1 == len(words) {
+		return matchPrefix(words[0], shellCommands)
+	}
+
+	switch words[0] {
+	case "camera":
+		if 2 == len(words) {
+			var names []string
+			for _, camera := range sh.conf.Cameras {
+				names = append(names, camera.Name)
+			}
+			return matchPrefix(words[1], names)
+		}
+	case "preset":
+		if 2 == len(words) {
+			return matchPrefix(words[1], []string{"set", "call", "clear"})
+		}
+	case "aux":
+		if 3 == len(words) {
+			return matchPrefix(words[2], []string{"on", "off"})
+		}
+	case "profile":
+		if 2 == len(words) {
+			var names []string
+			for _, profile := range sh.conf.Profiles {
+				names = append(names, profile.Name)
+			}
+			return matchPrefix(words[1], names)
+		}
+	}
+
+	return nil
+}
+
+func (sh *Shell) send(message PelcoDMessage) {
+	sh.line.Send(message)
+	updateThumbnail(sh.conf, message)
+	fmt.Fprintf(sh.out, "sent %x  %s\n", message,
+		stdoutColor.Paint(ansiDim, "("+describeMessage(sh.conf, message)+")"))
+}
+
+func (sh *Shell) selectCamera(args []string) error {
+	if 0 == len(args) {
+		fmt.Fprintf(sh.out, "%s\n", describeCamera(sh.conf, sh.conf.Address))
+		return nil
+	}
+
+	address, err := parseCamera(sh.conf, args[0])
+	if err != nil {
+		return err
+	}
+
+	sh.conf.Address = address
+
+	return nil
+}
+
+// shows the profile in effect, or switches to another, calling its presets.
+func (sh *Shell) selectProfile(args []string) error {
+	if 0 == len(args) {
+		if "" == sh.conf.Profile {
+			fmt.Fprintf(sh.out, "no profile\n")
+		} else {
+			fmt.Fprintf(sh.out, "%s\n", sh.conf.Profile)
+		}
+		return nil
+	}
+
+	profile, ok := sh.base.FindProfile(args[0])
+	if !ok {
+		return fmt.Errorf("unknown profile %s", args[0])
+	}
+
+	address := sh.conf.Address
+	sh.conf = sh.base.WithProfile(profile)
+	sh.conf.Address = address
+	sh.conf.Profile = profile.Name
+	sh.line.SetConfig(sh.conf)
+
+	for _, message := range profilePresets(sh.conf, profile) {
+		sh.send(message)
+	}
+
+	return nil
+}
+
+func (sh *Shell) move(args []string) error {
+	var speeds [3]float32
+
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: move PAN TILT [ZOOM]")
+	}
+
+	for i, arg := range args {
+		value, err := strconv.ParseFloat(arg, 32)
+		if err != nil || value < -1 || value > 1 {
+			return fmt.Errorf("invalid speed %s. expected -1.0 to 1.0", arg)
+		}
+		speeds[i] = float32(value)
+	}
+
+	sh.send(applyJoystick(pelco.New().To(sh.conf.Address), speeds[0], speeds[1], speeds[2], false, false, false, sh.conf.MaxSpeed).Build())
+
+	return nil
+}
+
+// returns the ONVIF port of the camera at address.
+func (sh *Shell) onvifPort(address int) (*onvifPort, error) {
+	tty, err := sh.line.PortFor(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, ok := tty.(*onvifPort)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an onvif camera", describeCamera(sh.conf, address))
+	}
+
+	return port, nil
+}
+
+// makes an absolute or relative move and shows where the camera stops.
+func (sh *Shell) ptzMove(words []string) error {
+	move, err := parsePTZMove(sh.conf, words)
+	if err != nil {
+		return fmt.Errorf("%s. usage: %s [pan=X] [tilt=Y] [zoom=Z]", err, words[0])
+	}
+
+	port, err := sh.onvifPort(move.Address)
+	if err != nil {
+		return err
+	}
+
+	if err := port.Move(move); err != nil {
+		return err
+	}
+
+	status, err := port.Settle(settleTimeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "%s at %s\n", describeCamera(sh.conf, move.Address), status.Position)
+
+	return nil
+}
+
+func (sh *Shell) position() error {
+	port, err := sh.onvifPort(sh.conf.Address)
+	if err != nil {
+		return err
+	}
+
+	status, err := port.client.Status()
+	if err != nil {
+		return err
+	}
+
+	state := "still"
+	if status.Moving {
+		state = "moving"
+	}
+	fmt.Fprintf(sh.out, "%s at %s, %s\n", describeCamera(sh.conf, sh.conf.Address), status.Position, state)
+
+	return nil
+}
+
+func (sh *Shell) preset(args []string) error {
+	var command uint8
+
+	if 2 != len(args) {
+		return errors.New("usage: preset set|call|clear NUM")
+	}
+
+	switch args[0] {
+	case "set":
+		command = pelco.SetPreset
+	case "call":
+		command = pelco.CallPreset
+	case "clear":
+		command = pelco.ClearPreset
+	default:
+		return fmt.Errorf("unknown preset action %s", args[0])
+	}
+
+	preset, err := strconv.ParseUint(args[1], 10, 8)
+	if err != nil || 0 == preset {
+		return fmt.Errorf("invalid preset %s. expected 1-255", args[1])
+	}
+
+	sh.send(pelco.New().To(sh.conf.Address).Extended(command, 0x00, uint8(preset)).Build())
+
+	return nil
+}
+
+func (sh *Shell) aux(args []string) error {
+	var command uint8
+
+	if 2 != len(args) {
+		return errors.New("usage: aux NUM on|off")
+	}
+
+	aux, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil || 0 == aux {
+		return fmt.Errorf("invalid aux %s. expected 1-255", args[0])
+	}
+
+	switch args[1] {
+	case "on":
+		command = pelco.SetAux
+	case "off":
+		command = pelco.ClearAux
+	default:
+		return fmt.Errorf("unknown aux state %s. expected on or off", args[1])
+	}
+
+	sh.send(pelco.New().To(sh.conf.Address).Extended(command, 0x00, uint8(aux)).Build())
+
+	return nil
+}
+
+func (sh *Shell) decode(args []string) error {
+	if 1 != len(args) {
+		return errors.New("usage: decode HEX")
+	}
+
+	message, err := pelco.ParseHex(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sh.out, "%s\n", describeMessage(sh.conf, message))
+
+	if !message.Valid() {
+		fmt.Fprintf(sh.out, "bad checksum %02x. expected %02x\n", message[pelco.Checksum], message.WithChecksum()[pelco.Checksum])
+	}
+
+	return nil
+}
+
+func (sh *Shell) raw(args []string) error {
+	if 0 == len(args) {
+		return errors.New("usage: raw HEX")
+	}
+
+	bytes, err := hex.DecodeString(strings.Join(args, ""))
+	if err != nil {
+		return err
+	}
+
+	sh.line.Write(sh.conf.Address, bytes)
+	fmt.Fprintf(sh.out, "sent %x\n", bytes)
+
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// returns channels receiving SIGHUP, to reload the config, and SIGUSR1, to
+// dump the current state.
+func notifyControlSignals() (<-chan os.Signal, <-chan os.Signal) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGUSR1)
+
+	return reload, dump
+}
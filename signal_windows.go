@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// windows has no SIGHUP or SIGUSR1. the config is only read at start.
+func notifyControlSignals() (<-chan os.Signal, <-chan os.Signal) {
+	return nil, nil
+}
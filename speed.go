@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/simulatedsimian/joystick"
+	"math"
+	"time"
+)
+
+// SpeedCycler steps the max speed through a list of percentages, one step
+// per press of its button chord, or per repeat while held, wrapping back to
+// the first.
+type SpeedCycler struct {
+	Mask  uint32
+	Steps []int // percent of full speed, like --maxspeed
+
+	step   int // index of the step in effect, or -1 before the first press
+	repeat Repeater
+}
+
+// returns the cycler configured by speed-buttons and speed-steps, or nil when
+// no buttons are bound.
+func newSpeedCycler(conf config.Config) (*SpeedCycler, error) {
+	if 0 == len(conf.SpeedButtons) {
+		return nil, nil
+	}
+
+	mask, err := buttonMask(conf.SpeedButtons)
+	if err != nil {
+		return nil, err
+	}
+
+	if 0 == len(conf.SpeedSteps) {
+		return nil, fmt.Errorf("speed-buttons requires speed-steps")
+	}
+
+	for _, step := range conf.SpeedSteps {
+		if step < 1 || step > 100 {
+			return nil, fmt.Errorf("speed step %d out of range 1-100", step)
+		}
+	}
+
+	repeat := Repeater{Delay: conf.SpeedRepeat.Delay, Interval: conf.SpeedRepeat.Interval}
+
+	return &SpeedCycler{Mask: mask, Steps: conf.SpeedSteps, step: -1, repeat: repeat}, nil
+}
+
+// tracks the chord in state and returns the next step's percentage when it
+// is pressed or repeats at now. like aux bindings, the chord's buttons are
+// removed from state while held.
+func (c *SpeedCycler) Update(state *joystick.State, now time.Time) (int, bool) {
+	pressed := isChordPressed(*state, c.Mask)
+
+	if pressed {
+		state.Buttons &^= c.Mask
+	}
+
+	if !c.repeat.Fire(pressed, now) {
+		return 0, false
+	}
+
+	c.step = (c.step + 1) % len(c.Steps)
+
+	return c.Steps[c.step], true
+}
+
+// converts a percentage of full speed to a max speed, as --maxspeed does.
+func speedFromPercent(percent int) int32 {
+	return int32(percent) * config.MaxSpeed / 100
+}
+
+// scales the pan and tilt speeds of message linearly from full speed at wide
+// to teleSpeed of it at full telephoto. zoom is 0.0 (wide) to 1.0 (tele).
+// teleSpeed of 1 or more, or unset, leaves message alone.
+func scaleToZoom(message PelcoDMessage, teleSpeed, zoom float64) PelcoDMessage {
+	if teleSpeed <= 0 || teleSpeed >= 1 || message.Extended() {
+		return message
+	}
+
+	command, _ := pelco.Decode(message)
+	if 0 == command.Pan && 0 == command.Tilt {
+		return message
+	}
+
+	factor := 1 - (1-teleSpeed)*math.Max(0, math.Min(1, zoom))
+
+	// never scale a move down to a stop
+	scale := func(speed uint8) uint8 {
+		return uint8(math.Max(1, math.Round(float64(speed)*factor)))
+	}
+
+	if 0 != command.Pan {
+		command.PanSpeed = scale(command.PanSpeed)
+	}
+	if 0 != command.Tilt {
+		command.TiltSpeed = scale(command.TiltSpeed)
+	}
+
+	return command.Message()
+}
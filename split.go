@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a section of a recording between marks
+type scene struct {
+	label   string
+	started time.Duration // recording time of the mark
+	lines   []string
+	frames  int
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "split",
+		Summary: "cut a recording into a file per mark",
+		Usage:   []string{"FILE"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			splitRecording(arguments["FILE"].(string))
+		},
+	})
+}
+
+// cuts the recording at path into one file per mark, named after the mark,
+// e.g. session-02-left.rec. frames before the first mark go to a "start"
+// file. each file starts with cameras set moving as they were at its mark,
+// and ends by stopping them, so scenes play on their own.
+func splitRecording(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	var (
+		header   RecordingHeader
+		elapsed  time.Duration
+		scenes   = []*scene{{label: "start"}}
+		moving   = make(map[uint8]PelcoDMessage)
+		parser   = newRecordParser()
+		hasStart bool
+	)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+
+		if parsed, ok, err := parseRecordingHeader(text); ok {
+			if err != nil {
+				printError("%s\n", err)
+				os.Exit(1)
+			}
+			header, hasStart = parsed, true
+			continue
+		}
+
+		current := scenes[len(scenes)-1]
+
+		if strings.HasPrefix(text, "# Mark ") {
+			current.lines = append(current.lines, stopMoving(moving)...)
+
+			next := &scene{label: strings.ToLower(strings.TrimPrefix(text, "# Mark ")), started: elapsed}
+			for _, address := range sortedAddresses(moving) {
+				next.lines = append(next.lines, fmt.Sprintf("pelco-d %x 0", moving[address]))
+			}
+			scenes = append(scenes, next)
+			continue
+		}
+
+		words := strings.Fields(text)
+		if delay, ok := lineDelay(words); ok {
+			elapsed += delay
+			current.frames++
+		}
+		if 3 <= len(words) && isRecordedProtocol(words[0]) {
+			if message, err := parser.Frame(words[0], words[1]); nil == err {
+				trackMotion(moving, message)
+			}
+		}
+
+		current.lines = append(current.lines, text)
+	}
+
+	if err := scanner.Err(); err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	if 1 == len(scenes) {
+		printError("%s has no marks. nothing to split.\n", path)
+		os.Exit(1)
+	}
+
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+
+	for i, scene := range scenes {
+		if 0 == scene.frames {
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%02d-%s%s", base, i, sceneFileLabel(scene.label), extension)
+
+		out, err := os.Create(name)
+		if err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+
+		if hasStart {
+			sceneHeader := header
+			sceneHeader.Started = header.Started.Add(scene.started)
+			sceneHeader.Write(out)
+		}
+
+		for _, line := range scene.lines {
+			fmt.Fprintln(out, line)
+		}
+
+		if err := out.Close(); err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "%s  %d frames from %s\n", name, scene.frames, formatElapsed(scene.started))
+	}
+}
+
+// returns stop frames for every moving camera, gapped like stopAll.
+func stopMoving(moving map[uint8]PelcoDMessage) []string {
+	var lines []string
+
+	for _, address := range sortedAddresses(moving) {
+		lines = append(lines, fmt.Sprintf("pelco-d %x 10", pelco.New().To(int(address)).Build()))
+	}
+
+	return lines
+}
+
+func sortedAddresses(moving map[uint8]PelcoDMessage) []uint8 {
+	var addresses []uint8
+	for address := range moving {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	return addresses
+}
+
+// makes a mark label safe for a file name.
+func sceneFileLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || '-' == r || '_' == r {
+			return r
+		}
+		return '-'
+	}, label)
+}
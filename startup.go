@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Action is one step of a camera macro: frames to send, then a pause.
+type Action struct {
+	Messages []PelcoDMessage
+	Wait     time.Duration
+}
+
+// returns the frames that send the camera at address home.
+func homeMessages(address int, home config.Home) []PelcoDMessage {
+	var messages []PelcoDMessage
+
+	if home.Preset > 0 {
+		messages = append(messages, pelco.New().To(address).CallPreset(uint8(home.Preset)).Build())
+	}
+
+	if nil != home.Pan {
+		messages = append(messages, pelco.New().To(address).PanPosition(pelcoFromPan(*home.Pan)).Build())
+	}
+
+	if nil != home.Tilt {
+		messages = append(messages, pelco.New().To(address).TiltPosition(pelcoFromTilt(*home.Tilt)).Build())
+	}
+
+	return messages
+}
+
+// parses a macro action for camera, e.g. "zoom-speed 2", "auto-iris on",
+// "preset 1", "aux 2 off", "iris close", "pattern run 1", "zone start 2",
+// "camera off", "stop", "home", "raw ff0100...", or "wait 2s".
+func parseAction(camera config.Camera, text string) (Action, error) {
+	words := strings.Fields(text)
+	if 0 == len(words) {
+		return Action{}, errors.New("empty action")
+	}
+
+	message := func() *pelco.Builder { return pelco.New().To(camera.Address) }
+	extended := func(command uint8, data2 uint64) Action {
+		return Action{Messages: []PelcoDMessage{message().Extended(command, 0x00, uint8(data2)).Build()}}
+	}
+
+	argument := func(index int, max uint64) (uint64, error) {
+		if len(words) <= index {
+			return 0, fmt.Errorf("%s: missing argument", words[0])
+		}
+
+		value, err := strconv.ParseUint(words[index], 0, 8)
+		if err != nil || value > max {
+			return 0, fmt.Errorf("%s: invalid argument %s. expected 0-%d", words[0], words[index], max)
+		}
+
+		return value, nil
+	}
+
+	autoMode := func(command uint8) (Action, error) {
+		if 2 != len(words) {
+			return Action{}, fmt.Errorf("%s: expected auto, on, or off", words[0])
+		}
+
+		for mode, name := range autoModes {
+			if name == words[1] {
+				return extended(command, uint64(mode)), nil
+			}
+		}
+
+		return Action{}, fmt.Errorf("%s: unknown mode %s. expected auto, on, or off", words[0], words[1])
+	}
+
+	switch words[0] {
+	case "home":
+		if nil == camera.Home {
+			return Action{}, fmt.Errorf("home: no home configured")
+		}
+		return Action{Messages: homeMessages(camera.Address, *camera.Home)}, nil
+
+	case "preset", "set-preset", "clear-preset":
+		preset, err := argument(1, 255)
+		if err != nil {
+			return Action{}, err
+		}
+		codes := map[string]uint8{"preset": pelco.CallPreset, "set-preset": pelco.SetPreset, "clear-preset": pelco.ClearPreset}
+		return extended(codes[words[0]], preset), nil
+
+	case "pattern":
+		// pattern start|stop|run [NUM]. cameras with one pattern ignore NUM
+		codes := map[string]uint8{"start": pelco.PatternStart, "stop": pelco.PatternStop, "run": pelco.RunPattern}
+		if len(words) < 2 || len(words) > 3 || 0 == codes[words[1]] {
+			return Action{}, errors.New("pattern: expected pattern start|stop|run [NUM]")
+		}
+		var pattern uint64
+		if 3 == len(words) {
+			var err error
+			if pattern, err = argument(2, 255); err != nil {
+				return Action{}, err
+			}
+		}
+		return extended(codes[words[1]], pattern), nil
+
+	case "zone":
+		codes := map[string]uint8{"start": pelco.ZoneStart, "end": pelco.ZoneEnd}
+		if 3 != len(words) || 0 == codes[words[1]] {
+			return Action{}, errors.New("zone: expected zone start|end NUM")
+		}
+		zone, err := argument(2, 8)
+		if err != nil {
+			return Action{}, err
+		}
+		if 0 == zone {
+			return Action{}, errors.New("zone: invalid argument 0. expected 1-8")
+		}
+		return extended(codes[words[1]], zone), nil
+
+	case "zone-scan":
+		if 2 != len(words) || ("on" != words[1] && "off" != words[1]) {
+			return Action{}, errors.New("zone-scan: expected zone-scan on|off")
+		}
+		return Action{Messages: []PelcoDMessage{message().ZoneScan("on" == words[1]).Build()}}, nil
+
+	case "camera":
+		if 2 != len(words) || ("on" != words[1] && "off" != words[1]) {
+			return Action{}, errors.New("camera: expected camera on|off")
+		}
+		if "on" == words[1] {
+			return Action{Messages: []PelcoDMessage{message().CameraOn().Build()}}, nil
+		}
+		return Action{Messages: []PelcoDMessage{message().CameraOff().Build()}}, nil
+
+	case "auto-scan":
+		if 2 != len(words) || ("on" != words[1] && "off" != words[1]) {
+			return Action{}, errors.New("auto-scan: expected auto-scan on|off")
+		}
+		if "on" == words[1] {
+			return Action{Messages: []PelcoDMessage{message().AutoScanOn().Build()}}, nil
+		}
+		return Action{Messages: []PelcoDMessage{message().AutoScanOff().Build()}}, nil
+
+	case "flip":
+		return Action{Messages: []PelcoDMessage{message().Flip().Build()}}, nil
+
+	case "aux":
+		aux, err := argument(1, 255)
+		if err != nil {
+			return Action{}, err
+		}
+		if 3 != len(words) || ("on" != words[2] && "off" != words[2]) {
+			return Action{}, errors.New("aux: expected aux NUM on|off")
+		}
+		if "on" == words[2] {
+			return extended(pelco.SetAux, aux), nil
+		}
+		return extended(pelco.ClearAux, aux), nil
+
+	case "zoom-speed", "focus-speed":
+		speed, err := argument(1, 3)
+		if err != nil {
+			return Action{}, err
+		}
+		if "zoom-speed" == words[0] {
+			return extended(pelco.ZoomSpeed, speed), nil
+		}
+		return extended(pelco.FocusSpeed, speed), nil
+
+	case "auto-focus":
+		return autoMode(pelco.AutoFocus)
+
+	case "auto-iris":
+		return autoMode(pelco.AutoIris)
+
+	case "iris":
+		// keeps driving the iris until the next stop
+		if 2 != len(words) || ("open" != words[1] && "close" != words[1]) {
+			return Action{}, errors.New("iris: expected iris open|close")
+		}
+		return Action{Messages: []PelcoDMessage{applyJoystick(message(), 0, 0, 0, "open" == words[1], "close" == words[1], false, 0).Build()}}, nil
+
+	case "focus":
+		// like iris, keeps focusing until the next stop
+		if 2 != len(words) || ("near" != words[1] && "far" != words[1]) {
+			return Action{}, errors.New("focus: expected focus near|far")
+		}
+		if "near" == words[1] {
+			return Action{Messages: []PelcoDMessage{message().FocusNear().Build()}}, nil
+		}
+		return Action{Messages: []PelcoDMessage{message().FocusFar().Build()}}, nil
+
+	case "stop":
+		return Action{Messages: []PelcoDMessage{message().Build()}}, nil
+
+	case "raw":
+		// vendor specific settings, e.g. digital zoom, have no standard frame
+		if 2 != len(words) {
+			return Action{}, errors.New("raw: expected raw HEX")
+		}
+		raw, err := pelco.ParseHex(words[1])
+		if err != nil {
+			return Action{}, err
+		}
+		return Action{Messages: []PelcoDMessage{raw}}, nil
+
+	case "wait":
+		if 2 != len(words) {
+			return Action{}, errors.New("wait: expected wait DURATION")
+		}
+		wait, err := time.ParseDuration(words[1])
+		if err != nil {
+			return Action{}, fmt.Errorf("wait: invalid duration %s", words[1])
+		}
+		return Action{Wait: wait}, nil
+	}
+
+	return Action{}, fmt.Errorf("unknown action %s", words[0])
+}
+
+// parses every action of a macro up front, so a typo doesn't leave a camera
+// half configured.
+func parseMacro(camera config.Camera, texts []string) ([]Action, error) {
+	var actions []Action
+
+	for _, text := range texts {
+		action, err := parseAction(camera, text)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+func runMacro(send func(PelcoDMessage), actions []Action) {
+	for _, action := range actions {
+		for _, message := range action.Messages {
+			send(message)
+
+			// leave the bus idle long enough for receivers to frame each message
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		time.Sleep(action.Wait)
+	}
+}
+
+// runs each camera's startup actions, or sends it home when it has none, so
+// a restart after a power failure begins from a known state.
+func startCameras(send func(PelcoDMessage), conf config.Config) {
+	for _, camera := range conf.Cameras {
+		texts := camera.Startup
+		if 0 == len(texts) && nil != camera.Home {
+			texts = []string{"home"}
+		}
+
+		if 0 == len(texts) {
+			continue
+		}
+
+		actions, err := parseMacro(camera, texts)
+		if err != nil {
+			printError("startup for %s skipped. %s\n", describeCamera(conf, camera.Address), err)
+			continue
+		}
+
+		if !conf.Quiet {
+			fmt.Fprintf(os.Stderr, "Starting %s\n", describeCamera(conf, camera.Address))
+		}
+
+		runMacro(send, actions)
+	}
+}
+
+// runs each camera's shutdown actions, leaving the cameras in a defined state
+// when cctv-ptz exits.
+func stopCameras(send func(PelcoDMessage), conf config.Config) {
+	for _, camera := range conf.Cameras {
+		if 0 == len(camera.Shutdown) {
+			continue
+		}
+
+		actions, err := parseMacro(camera, camera.Shutdown)
+		if err != nil {
+			printError("shutdown for %s skipped. %s\n", describeCamera(conf, camera.Address), err)
+			continue
+		}
+
+		if !conf.Quiet {
+			fmt.Fprintf(os.Stderr, "Shutting down %s\n", describeCamera(conf, camera.Address))
+		}
+
+		runMacro(send, actions)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// default location of the saved camera state
+func defaultStatePath() string {
+	home := os.Getenv("HOME")
+	if "" == home {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "cctv-ptz", "state.json")
+}
+
+// reads camera state saved by saveCameraState. a missing file is no state.
+func loadCameraState(path string) ([]Orientation, error) {
+	var saved []Orientation
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// writes camera state to path. the file is replaced in one step so a crash
+// mid-write leaves the previous state intact.
+func saveCameraState(path string, state []Orientation) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
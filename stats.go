@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AddressStats counts the bus traffic with one address.
+type AddressStats struct {
+	Address        int    `json:"address"`
+	Camera         string `json:"camera,omitempty"`
+	Sent           uint64 `json:"sent"`
+	Responses      uint64 `json:"responses"`
+	ChecksumErrors uint64 `json:"checksum_errors"`
+	Timeouts       uint64 `json:"timeouts"`
+}
+
+// BusStats counts frames sent, replies, corrupt replies, and unanswered
+// frames per address for the life of the process, so failing wiring shows
+// up as a rising error count before a camera stops responding altogether.
+type BusStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	addresses map[int]*AddressStats
+}
+
+// every frame on the bus is counted here
+var busStats = NewBusStats()
+
+func NewBusStats() *BusStats {
+	return &BusStats{started: time.Now(), addresses: make(map[int]*AddressStats)}
+}
+
+func (s *BusStats) count(address uint8, field func(*AddressStats) *uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.addresses[int(address)]
+	if !ok {
+		stats = &AddressStats{Address: int(address)}
+		s.addresses[int(address)] = stats
+	}
+
+	*field(stats)++
+}
+
+func (s *BusStats) Sent(address uint8) {
+	s.count(address, func(a *AddressStats) *uint64 { return &a.Sent })
+}
+
+func (s *BusStats) Response(address uint8) {
+	s.count(address, func(a *AddressStats) *uint64 { return &a.Responses })
+}
+
+func (s *BusStats) ChecksumError(address uint8) {
+	s.count(address, func(a *AddressStats) *uint64 { return &a.ChecksumErrors })
+}
+
+func (s *BusStats) Timeout(address uint8) {
+	s.count(address, func(a *AddressStats) *uint64 { return &a.Timeouts })
+}
+
+// returns the counts of every address seen, in address order, named from
+// conf.
+func (s *BusStats) Snapshot(conf config.Config) []AddressStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := []AddressStats{}
+	for _, stats := range s.addresses {
+		copied := *stats
+		copied.Camera = conf.CameraName(copied.Address)
+		all = append(all, copied)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Address < all[j].Address })
+
+	return all
+}
+
+func (s *BusStats) Started() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.started
+}
+
+// StatsReport is the body of /api/stats.
+type StatsReport struct {
+	Started   time.Time      `json:"started"`
+	Addresses []AddressStats `json:"addresses"`
+}
+
+func (s *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, StatsReport{Started: busStats.Started(), Addresses: s.allowedStats(r)})
+}
+
+// serves the counts in the prometheus text format.
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := s.allowedStats(r)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metrics := []struct {
+		name, help string
+		value      func(AddressStats) uint64
+	}{
+		{"cctv_ptz_frames_sent_total", "Pelco-D frames sent to the address.", func(a AddressStats) uint64 { return a.Sent }},
+		{"cctv_ptz_responses_total", "Valid replies from the address.", func(a AddressStats) uint64 { return a.Responses }},
+		{"cctv_ptz_checksum_errors_total", "Replies from the address with a bad checksum.", func(a AddressStats) uint64 { return a.ChecksumErrors }},
+		{"cctv_ptz_timeouts_total", "Frames or queries the address did not answer in time.", func(a AddressStats) uint64 { return a.Timeouts }},
+	}
+
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, a := range stats {
+			fmt.Fprintf(w, "%s{address=\"%d\",camera=%q} %d\n", metric.name, a.Address, a.Camera, metric.value(a))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP cctv_ptz_start_time_seconds Start time of the process since the epoch.\n")
+	fmt.Fprintf(w, "# TYPE cctv_ptz_start_time_seconds gauge\n")
+	fmt.Fprintf(w, "cctv_ptz_start_time_seconds %d\n", busStats.Started().Unix())
+}
+
+// returns the counts for cameras the request's principal may access.
+func (s *APIServer) allowedStats(r *http.Request) []AddressStats {
+	principal := principalFrom(r)
+
+	allowed := []AddressStats{}
+	for _, stats := range busStats.Snapshot(s.conf) {
+		if principal.CanAccess(s.conf, stats.Address) {
+			allowed = append(allowed, stats)
+		}
+	}
+
+	return allowed
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "stats",
+		Summary: "print the command counts of a running cctv-ptz",
+		Usage:   []string{"[-l ADDR]"},
+		Options: []string{listenOption},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			showStats(conf)
+		},
+	})
+}
+
+// fetches the counts from the cctv-ptz serving the api on conf.Listen, using
+// the configured credentials, and prints them as a table.
+func showStats(conf config.Config) {
+	report, err := fetchStats(conf)
+	if err != nil {
+		printError("%s\n", err)
+		os.Exit(1)
+	}
+
+	printStats(os.Stdout, report)
+}
+
+// prints report as a table, a row per address.
+func printStats(w io.Writer, report StatsReport) {
+	fmt.Fprintf(w, "since %s\n", report.Started.Format(time.RFC3339))
+	fmt.Fprintf(w, "%-20s %10s %10s %10s %10s %8s\n", "camera", "sent", "responses", "checksum", "timeouts", "errors")
+
+	for _, a := range report.Addresses {
+		name := fmt.Sprintf("addr %d", a.Address)
+		if "" != a.Camera {
+			name = fmt.Sprintf("%s (%d)", a.Camera, a.Address)
+		}
+
+		// share of frames that went unanswered or came back corrupt
+		var rate float64
+		if 0 != a.Sent {
+			rate = 100 * float64(a.ChecksumErrors+a.Timeouts) / float64(a.Sent)
+		}
+
+		fmt.Fprintf(w, "%-20s %10d %10d %10d %10d %7.1f%%\n", name, a.Sent, a.Responses, a.ChecksumErrors, a.Timeouts, rate)
+	}
+}
+
+func fetchStats(conf config.Config) (StatsReport, error) {
+	var report StatsReport
+
+	addresses := listenAddresses(conf.Listen)
+	if 0 == len(addresses) {
+		return report, errors.New("no api to ask. give the address with -l, or set listen in the config")
+	}
+
+	// a server listening on all interfaces is reached on loopback
+	host, port, err := net.SplitHostPort(addresses[0])
+	if err != nil {
+		return report, err
+	}
+	if "" == host || "0.0.0.0" == host {
+		host = "127.0.0.1"
+	} else if "::" == host {
+		host = "::1"
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	scheme := "http"
+
+	if "" != conf.TLSCert {
+		scheme = "https"
+
+		// trust the server's own certificate, which is often self-signed
+		pem, err := os.ReadFile(conf.TLSCert)
+		if err != nil {
+			return report, err
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+
+	request, err := http.NewRequest("GET", scheme+"://"+net.JoinHostPort(host, port)+"/api/stats", nil)
+	if err != nil {
+		return report, err
+	}
+
+	if "" != conf.APIToken {
+		request.Header.Set("Authorization", "Bearer "+conf.APIToken)
+	} else if "" != conf.APIUser {
+		request.SetBasicAuth(conf.APIUser, conf.APIPassword)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return report, err
+	}
+	defer response.Body.Close()
+
+	if http.StatusOK != response.StatusCode {
+		return report, fmt.Errorf("api refused stats. %s", response.Status)
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&report)
+
+	return report, err
+}
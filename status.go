@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"strings"
+	"time"
+)
+
+// returns a short, human-readable list of the actions encoded in message.
+func describeActions(message PelcoDMessage) []string {
+	var actions []string
+
+	command, _ := pelco.Decode(message)
+	if command.Extended {
+		return []string{describeExtended(command)}
+	}
+
+	if 1 == command.Pan {
+		actions = append(actions, fmt.Sprintf("pan right %d%%", speedPercent(command.PanSpeed)))
+	} else if -1 == command.Pan {
+		actions = append(actions, fmt.Sprintf("pan left %d%%", speedPercent(command.PanSpeed)))
+	}
+
+	if 1 == command.Tilt {
+		actions = append(actions, fmt.Sprintf("tilt up %d%%", speedPercent(command.TiltSpeed)))
+	} else if -1 == command.Tilt {
+		actions = append(actions, fmt.Sprintf("tilt down %d%%", speedPercent(command.TiltSpeed)))
+	}
+
+	if 1 == command.Zoom {
+		actions = append(actions, "zoom in")
+	} else if -1 == command.Zoom {
+		actions = append(actions, "zoom out")
+	}
+
+	if 1 == command.Focus {
+		actions = append(actions, "focus far")
+	} else if -1 == command.Focus {
+		actions = append(actions, "focus near")
+	}
+
+	if 1 == command.Iris {
+		actions = append(actions, "iris open")
+	} else if -1 == command.Iris {
+		actions = append(actions, "iris close")
+	}
+
+	if 1 == command.Power {
+		actions = append(actions, "camera on")
+	} else if -1 == command.Power {
+		actions = append(actions, "camera off")
+	}
+
+	if 1 == command.AutoScan {
+		actions = append(actions, "auto scan on")
+	} else if -1 == command.AutoScan {
+		actions = append(actions, "auto scan off")
+	}
+
+	if 0 == len(actions) {
+		actions = append(actions, "stop")
+	}
+
+	return actions
+}
+
+func describeExtended(command pelco.Command) string {
+	switch command.Code {
+	case pelco.SetPreset:
+		if pelco.MenuPreset == command.Data2 {
+			return fmt.Sprintf("menu (set preset %d)", command.Data2)
+		}
+		return fmt.Sprintf("set preset %d", command.Data2)
+	case pelco.ClearPreset:
+		return fmt.Sprintf("clear preset %d", command.Data2)
+	case pelco.CallPreset:
+		switch command.Data2 {
+		case pelco.FlipPreset:
+			return fmt.Sprintf("flip (call preset %d)", command.Data2)
+		case pelco.ZeroPanPreset:
+			return fmt.Sprintf("zero pan (call preset %d)", command.Data2)
+		}
+		return fmt.Sprintf("call preset %d", command.Data2)
+	case pelco.SetAux:
+		return fmt.Sprintf("aux %d on", command.Data2)
+	case pelco.ClearAux:
+		return fmt.Sprintf("aux %d off", command.Data2)
+	case pelco.ZoneStart:
+		return fmt.Sprintf("zone %d start", command.Data2)
+	case pelco.ZoneEnd:
+		return fmt.Sprintf("zone %d end", command.Data2)
+	case pelco.ZoneScanOn:
+		return "zone scan on"
+	case pelco.ZoneScanOff:
+		return "zone scan off"
+	case pelco.PatternStart:
+		return fmt.Sprintf("pattern %d start", command.Data2)
+	case pelco.PatternStop:
+		return fmt.Sprintf("pattern %d stop", command.Data2)
+	case pelco.RunPattern:
+		return fmt.Sprintf("run pattern %d", command.Data2)
+	case pelco.ZoomSpeed:
+		return fmt.Sprintf("zoom speed %d", command.Data2)
+	case pelco.FocusSpeed:
+		return fmt.Sprintf("focus speed %d", command.Data2)
+	case pelco.AutoFocus:
+		return "auto focus " + describeAutoMode(command.Data2)
+	case pelco.AutoIris:
+		return "auto iris " + describeAutoMode(command.Data2)
+	case pelco.SetPanPosition:
+		return fmt.Sprintf("pan to %.2f", panFromPelco(command.Value()))
+	case pelco.SetTiltPosition:
+		return fmt.Sprintf("tilt to %.2f", tiltFromPelco(command.Value()))
+	case pelco.QueryPan:
+		return "query pan"
+	case pelco.QueryTilt:
+		return "query tilt"
+	case pelco.QueryZoom:
+		return "query zoom"
+	default:
+		return fmt.Sprintf("extended command %02x (%02x %02x)", command.Code, command.Data1, command.Data2)
+	}
+}
+
+var autoModes = []string{"auto", "on", "off"}
+
+func describeAutoMode(mode byte) string {
+	if int(mode) < len(autoModes) {
+		return autoModes[mode]
+	}
+
+	return fmt.Sprintf("mode %d", mode)
+}
+
+func speedPercent(speed byte) int {
+	if speed > pelco.FullSpeed {
+		speed = pelco.FullSpeed
+	}
+
+	return int(speed) * 100 / pelco.FullSpeed
+}
+
+func describeCamera(conf config.Config, address int) string {
+	if name := conf.CameraName(address); "" != name {
+		return fmt.Sprintf("%s (%d)", name, address)
+	}
+
+	return fmt.Sprintf("addr %d", address)
+}
+
+// returns a one line summary of message, e.g. "addr 2: pan right 63%, zoom in".
+func describeMessage(conf config.Config, message PelcoDMessage) string {
+	return describeCamera(conf, int(message[pelco.Addr])) + ": " + strings.Join(describeActions(message), ", ")
+}
+
+func describeRecording(recordFile string) string {
+	switch recordFile {
+	case "/dev/null":
+		return "rec off"
+	case "-":
+		return "rec stdout"
+	default:
+		return "rec " + recordFile
+	}
+}
+
+func formatElapsed(elapsed time.Duration) string {
+	seconds := int64(elapsed / time.Second)
+
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// rings the terminal bell and leaves a line in the scrollback naming the newly
+// selected camera, so an accidental address change doesn't go unnoticed.
+func announceAddress(w io.Writer, conf config.Config) {
+	var bell, clearLine string
+
+	if stderrTerminal {
+		bell = "\a"
+		clearLine = "\033[K"
+	}
+
+	text := fmt.Sprintf(">>> now controlling %s <<<", describeCamera(conf, conf.Address))
+
+	fmt.Fprintf(w, "%s%s%s\n", bell, clearLine, stderrColor.Paint(ansiBold+ansiYellow, text))
+}
+
+// Dashboard holds the live values shown on the status line.
+type Dashboard struct {
+	Message PelcoDMessage
+	Elapsed time.Duration
+	Ack     AckState
+	Owner   string // source in control of the cameras
+	Limited bool   // motion was clipped at a soft limit
+	Profile string // operating profile in effect
+	Speed   int    // max speed percent picked with the speed buttons, or 0
+	Battery *Battery
+	Link    string // "up" or "down" for a port over a link that can drop, or ""
+
+	// where the selected camera last reported pointing, while the report
+	// is fresh
+	Position *Orientation
+}
+
+// reports older than this aren't shown, e.g. once the camera stops answering
+const positionReadoutAge = 3 * time.Second
+
+// returns orientation for the position readout when it came from the
+// camera's own reply within positionReadoutAge of now, and nil otherwise.
+func positionReadout(orientation Orientation, now time.Time) *Orientation {
+	if "queried" != orientation.Source || now.Sub(orientation.Updated) > positionReadoutAge {
+		return nil
+	}
+
+	return &orientation
+}
+
+func describeAck(state AckState) string {
+	switch state {
+	case AckOk:
+		return stderrColor.Paint(ansiGreen, "ack ok")
+	case AckFail:
+		return stderrColor.Paint(ansiBold+ansiRed, "ack FAIL")
+	default:
+		return stderrColor.Paint(ansiDim, "ack ?")
+	}
+}
+
+// leaves a line in the scrollback when the operating profile changes.
+func announceProfile(w io.Writer, profile config.Profile) {
+	var clearLine string
+
+	if stderrTerminal {
+		clearLine = "\033[K"
+	}
+
+	text := fmt.Sprintf(">>> %s profile <<<", profile.Name)
+
+	fmt.Fprintf(w, "%s%s\n", clearLine, stderrColor.Paint(ansiBold+ansiCyan, text))
+}
+
+func announceReload(w io.Writer) {
+	var clearLine string
+
+	if stderrTerminal {
+		clearLine = "\033[K"
+	}
+
+	fmt.Fprintf(w, "%s%s\n", clearLine, stderrColor.Paint(ansiBold+ansiCyan, ">>> config reloaded <<<"))
+}
+
+func announceStop(w io.Writer, count int) {
+	var bell, clearLine string
+
+	if stderrTerminal {
+		bell = "\a"
+		clearLine = "\033[K"
+	}
+
+	text := fmt.Sprintf(">>> EMERGENCY STOP sent to %d cameras <<<", count)
+
+	fmt.Fprintf(w, "%s%s%s\n", bell, clearLine, stderrColor.Paint(ansiBold+ansiRed, text))
+}
+
+// redraws the single line dashboard in place.
+func printStatus(w io.Writer, conf config.Config, dash Dashboard) {
+	var (
+		message     = dash.Message
+		actions     = strings.Join(describeActions(message), ", ")
+		actionColor = ansiGreen
+		clearLine   = ""
+	)
+
+	if "stop" == actions {
+		actionColor = ansiDim
+	}
+
+	if stderrTerminal {
+		clearLine = "\033[K"
+	}
+
+	fields := []string{
+		stderrColor.Paint(ansiBold+ansiCyan, describeCamera(conf, int(message[pelco.Addr]))),
+		stderrColor.Paint(actionColor, actions),
+		describeRecording(conf.RecordFile),
+		stderrColor.Paint(ansiDim, formatElapsed(dash.Elapsed)),
+	}
+
+	if conf.Ack {
+		fields = append(fields, describeAck(dash.Ack))
+	}
+
+	switch dash.Link {
+	case "up":
+		fields = append(fields, stderrColor.Paint(ansiDim, "link up"))
+	case "down":
+		fields = append(fields, stderrColor.Paint(ansiBold+ansiRed, "LINK DOWN"))
+	}
+
+	if nil != dash.Position {
+		fields = append(fields, fmt.Sprintf("pan %.1f tilt %.1f zoom %.0f%%", dash.Position.Pan, dash.Position.Tilt, dash.Position.Zoom*100))
+	}
+
+	if "" != dash.Profile {
+		fields = append(fields, stderrColor.Paint(ansiDim, dash.Profile))
+	}
+
+	if conf.FineAdjust {
+		fields = append(fields, stderrColor.Paint(ansiDim, "fine"))
+	}
+
+	if conf.SwapAxes {
+		fields = append(fields, stderrColor.Paint(ansiDim, "swap"))
+	}
+
+	if 0 != dash.Speed {
+		fields = append(fields, stderrColor.Paint(ansiDim, fmt.Sprintf("spd %d%%", dash.Speed)))
+	}
+
+	if nil != dash.Battery {
+		if dash.Battery.Low(conf.BatteryLow) {
+			fields = append(fields, stderrColor.Paint(ansiBold+ansiYellow, strings.ToUpper(dash.Battery.String())))
+		} else {
+			fields = append(fields, stderrColor.Paint(ansiDim, dash.Battery.String()))
+		}
+	}
+
+	if dash.Limited {
+		fields = append(fields, stderrColor.Paint(ansiBold+ansiYellow, "LIMIT"))
+	}
+
+	// only call out control when the operator doesn't have it
+	if "" != dash.Owner && joystickSource.Name != dash.Owner {
+		fields = append(fields, stderrColor.Paint(ansiBold+ansiYellow, "ctl "+dash.Owner))
+	}
+
+	fmt.Fprintf(w, "%s%s\r", clearLine, strings.Join(fields, " | "))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	surfaceLockTTL = 2 * time.Second
+
+	// a surface button may start a move and only stop it on release, so
+	// control is held longer while moving
+	surfaceMoveTTL = 30 * time.Second
+)
+
+// SurfaceCommand is what a control surface or room controller asks of the
+// interactive loop: a camera to select (-1 for none) and frames to send.
+type SurfaceCommand struct {
+	Select   int
+	Messages []PelcoDMessage
+}
+
+// reports whether command only stops cameras. stops are never rate limited,
+// so a client over its allowance can still halt what it started.
+func isStopCommand(command SurfaceCommand) bool {
+	if 0 == len(command.Messages) {
+		return false
+	}
+
+	for _, message := range command.Messages {
+		if !message.Idle() {
+			return false
+		}
+	}
+
+	return true
+}
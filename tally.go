@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/mikepb/go-serial"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTallyTopic = "cctv-ptz/tally"
+
+	// retained messages are refreshed this often, in case the broker lost
+	// them or a publish went down with a dead connection
+	tallyRefresh = 30 * time.Second
+)
+
+// TallyState is the json published on the tally topic.
+type TallyState struct {
+	Address int    `json:"address"`
+	Camera  string `json:"camera,omitempty"`
+	Moving  bool   `json:"moving"`
+	Live    bool   `json:"live"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// a tally line and the camera it belongs to
+type tallyLight struct {
+	address int
+	name    string
+	set     func(on bool) error
+	close   func()
+}
+
+// Tally follows the operator state and announces the live camera.
+type Tally struct {
+	conf   config.Config
+	topic  string
+	mqtt   *MQTTClient
+	lights []tallyLight
+
+	mu      sync.Mutex
+	closed  bool
+	live    map[int]bool // live state last published per address
+	lastErr string       // a down broker is reported once, not on every update
+}
+
+// starts announcing the live camera from hub's updates, or returns nil when
+// no broker or tally lines are configured.
+func startTally(conf config.Config, hub *StateHub) (*Tally, error) {
+	settings := conf.Tally
+	if "" == settings.MQTT && 0 == len(settings.Lines) {
+		return nil, nil
+	}
+
+	t := &Tally{conf: conf, topic: settings.Topic, live: make(map[int]bool)}
+	if "" == t.topic {
+		t.topic = defaultTallyTopic
+	}
+
+	if "" != settings.MQTT {
+		clientID := settings.ClientID
+		if "" == clientID {
+			hostname, _ := os.Hostname()
+			clientID = "cctv-ptz-" + hostname
+		}
+
+		client, err := NewMQTTClient(settings.MQTT, clientID, settings.Username, settings.Password)
+		if err != nil {
+			return nil, err
+		}
+		client.SetWill(t.topic+"/online", "0", "1")
+		t.mqtt = client
+	}
+
+	for _, line := range settings.Lines {
+		light, err := openTallyLight(conf, line)
+		if err != nil {
+			t.closeLights()
+			return nil, err
+		}
+		t.lights = append(t.lights, light)
+	}
+
+	go t.run(hub)
+
+	return t, nil
+}
+
+func openTallyLight(conf config.Config, line config.TallyLine) (tallyLight, error) {
+	address, err := parseCamera(conf, line.Camera)
+	if err != nil {
+		return tallyLight{}, fmt.Errorf("tally: %s", err)
+	}
+
+	light := tallyLight{address: address, close: func() {}}
+
+	switch {
+	case "" != line.GPIO && "" != line.Serial:
+		return light, fmt.Errorf("tally: camera %s has both gpio and serial", line.Camera)
+	case "" != line.GPIO:
+		light.name = line.GPIO
+		light.set = func(on bool) error {
+			return os.WriteFile(line.GPIO, []byte(tallyValue(on)), 0644)
+		}
+	case "" != line.Serial:
+		tty, err := serial.Open(line.Serial, &serial.Options{Mode: serial.MODE_WRITE, BitRate: 9600, DataBits: 8, StopBits: 1})
+		if err != nil {
+			return light, fmt.Errorf("tally: cannot open %s. %s", line.Serial, err)
+		}
+		light.name = line.Serial
+		light.set = func(on bool) error {
+			if on {
+				return tty.SetRTS(serial.RTS_ON)
+			}
+			return tty.SetRTS(serial.RTS_OFF)
+		}
+		light.close = func() { tty.Close() }
+	default:
+		return light, fmt.Errorf("tally: camera %s needs gpio or serial", line.Camera)
+	}
+
+	// start dark, rather than however the last run left it
+	return light, light.set(false)
+}
+
+func (t *Tally) run(hub *StateHub) {
+	updates, _ := hub.Subscribe()
+	refresh := time.NewTicker(tallyRefresh)
+	defer refresh.Stop()
+
+	last := t.stateFrom(hub.Current())
+	t.announce(last, true)
+
+	for {
+		select {
+		case state := <-updates:
+			if next := t.stateFrom(state); next != last {
+				last = next
+				t.announce(last, false)
+			}
+		case <-refresh.C:
+			t.announce(last, true)
+		}
+	}
+}
+
+func (t *Tally) stateFrom(state State) TallyState {
+	tally := TallyState{Address: state.Address, Camera: state.Camera, Owner: state.Owner}
+
+	var message PelcoDMessage
+	if frame, err := hex.DecodeString(state.Command); nil == err && len(frame) == len(message) {
+		copy(message[:], frame)
+		tally.Moving = !message.Idle() && !message.Extended()
+	}
+
+	tally.Live = tally.Moving || !t.conf.Tally.MovingOnly
+
+	return tally
+}
+
+// publishes state and lights the tally lines of the live camera. every
+// address is republished when all is set, otherwise only those that changed.
+func (t *Tally) announce(state TallyState, all bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	live := map[int]bool{state.Address: state.Live}
+	for address := range t.live {
+		if address != state.Address {
+			live[address] = false
+		}
+	}
+	for _, light := range t.lights {
+		if _, ok := live[light.address]; !ok {
+			live[light.address] = false
+		}
+	}
+
+	// the old camera goes dark before the new one lights, so two are never
+	// live at once
+	for _, on := range []bool{false, true} {
+		for _, light := range t.lights {
+			if live[light.address] == on && (all || on != t.live[light.address]) {
+				if err := light.set(on); err != nil {
+					printError("tally: %s. %s\n", light.name, err)
+				}
+			}
+		}
+	}
+
+	if nil != t.mqtt {
+		payload, _ := json.Marshal(state)
+		err := t.mqtt.Publish(t.topic, payload, true)
+
+		for _, on := range []bool{false, true} {
+			for address, value := range live {
+				if nil != err || value != on || (!all && on == t.live[address]) {
+					continue
+				}
+
+				err = t.mqtt.Publish(t.topic+"/"+strconv.Itoa(address), []byte(tallyValue(on)), true)
+			}
+		}
+
+		if nil != err && err.Error() != t.lastErr {
+			printError("tally: %s\n", err)
+		}
+		t.lastErr = ""
+		if nil != err {
+			t.lastErr = err.Error()
+		}
+	}
+
+	t.live = live
+}
+
+// darkens the tally lines and says goodbye to the broker.
+func (t *Tally) Close() {
+	if nil == t {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+
+	for _, light := range t.lights {
+		light.set(false)
+	}
+	t.closeLights()
+
+	if nil != t.mqtt {
+		for address, on := range t.live {
+			if on {
+				t.mqtt.Publish(t.topic+"/"+strconv.Itoa(address), []byte("0"), true)
+			}
+		}
+		t.mqtt.Publish(t.topic+"/online", []byte("0"), true)
+		t.mqtt.Close()
+	}
+}
+
+func (t *Tally) closeLights() {
+	for _, light := range t.lights {
+		light.close()
+	}
+}
+
+// gpio values and per camera topics are 1 while live, otherwise 0.
+func tallyValue(on bool) string {
+	if on {
+		return "1"
+	}
+
+	return "0"
+}
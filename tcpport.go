@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/rfc2217"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serial settings of this form write frames to an Ethernet-to-RS485 gateway
+// listening at HOST:PORT, e.g. tcp://192.168.1.50:4001
+const tcpScheme = "tcp://"
+
+// serial settings of this form reach a remote serial server at HOST:PORT
+// over RFC 2217, setting its baud rate and framing, e.g.
+// rfc2217://192.168.1.50:4001
+const rfc2217Scheme = "rfc2217://"
+
+const (
+	tcpDialTimeout   = 2 * time.Second
+	tcpWriteTimeout  = time.Second
+	tcpRedialBackoff = 2 * time.Second
+)
+
+var errLinkDown = errors.New("link down")
+
+func isTCPSerial(port string) bool {
+	return strings.HasPrefix(port, tcpScheme) || isRFC2217(port)
+}
+
+func isRFC2217(port string) bool {
+	return strings.HasPrefix(port, rfc2217Scheme)
+}
+
+// Linker is a Port over a link that can drop and come back on its own, e.g. a
+// TCP gateway, so its state is worth showing.
+type Linker interface {
+	Linked() bool
+}
+
+// tcpPort stands in for a serial port with a TCP connection to a gateway
+// that puts the bytes on its RS485 line. a dropped connection is redialed in
+// the background; frames written meanwhile fail with errLinkDown rather than
+// wait, since the next frame carries the state the camera needs anyway.
+// over RFC 2217 the port's framing is negotiated on every connect.
+type tcpPort struct {
+	address string
+	telnet  *rfc2217.Settings // framing to ask for, or nil for raw bytes
+	data    chan []byte
+	pending []byte // of the chunk being read
+	done    chan struct{}
+
+	mu     sync.Mutex
+	conn   net.Conn // nil while the link is down
+	closed bool
+}
+
+// dials the gateway of a tcp://HOST:PORT or rfc2217://HOST:PORT setting,
+// the latter at the baud rate of settings. a gateway that doesn't answer
+// yet is not an error; the port starts down and keeps redialing.
+func dialTCPSerial(settings config.Config) (*tcpPort, error) {
+	port := settings.SerialPort
+
+	p := &tcpPort{address: strings.TrimPrefix(port, tcpScheme), data: make(chan []byte, 20), done: make(chan struct{})}
+
+	if isRFC2217(port) {
+		p.address = strings.TrimPrefix(port, rfc2217Scheme)
+		p.telnet = &rfc2217.Settings{
+			BaudRate: settings.BaudRate,
+			DataBits: 8,
+			Parity:   rfc2217.ParityNone,
+			StopBits: 1,
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(p.address); err != nil {
+		return nil, fmt.Errorf("invalid serial %s. expected %sHOST:PORT", port, port[:strings.Index(port, "://")+3])
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		printError("cannot reach %s. %s. retrying every %s\n", p.address, err, tcpRedialBackoff)
+	}
+	p.conn = conn
+
+	go p.run(conn)
+
+	return p, nil
+}
+
+// reads the connection until it drops, then redials until the port closes.
+func (p *tcpPort) run(conn net.Conn) {
+	for {
+		if nil != conn {
+			err := p.read(conn)
+
+			p.mu.Lock()
+			closed := p.closed
+			p.conn = nil
+			p.mu.Unlock()
+			conn.Close()
+
+			if closed {
+				return
+			}
+			printError("link to %s lost. %s. reconnecting\n", p.address, err)
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(tcpRedialBackoff):
+		}
+
+		var err error
+		if conn, err = p.dial(); err != nil {
+			conn = nil
+			continue
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.conn = conn
+		p.mu.Unlock()
+
+		printError("link to %s is back\n", p.address)
+	}
+}
+
+// connects to the gateway, negotiating the framing over RFC 2217.
+func (p *tcpPort) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.address, tcpDialTimeout)
+	if err != nil || nil == p.telnet {
+		return conn, err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	if _, err := conn.Write(rfc2217.Negotiate(*p.telnet)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (p *tcpPort) read(conn net.Conn) error {
+	var (
+		buffer  = make([]byte, 256)
+		decoder rfc2217.Decoder
+		warned  bool
+	)
+
+	for {
+		n, err := conn.Read(buffer)
+
+		chunk := append([]byte(nil), buffer[:n]...)
+		if nil != p.telnet {
+			var reply []byte
+			if chunk, reply = decoder.Feed(chunk); 0 != len(reply) {
+				conn.Write(reply)
+			}
+
+			// servers confirm the rate they actually set
+			if confirmed := decoder.BaudRate; 0 != confirmed && p.telnet.BaudRate != confirmed && !warned {
+				printError("%s set its port to %d baud, not %d\n", p.address, confirmed, p.telnet.BaudRate)
+				warned = true
+			}
+		}
+
+		if 0 != len(chunk) {
+			select {
+			case p.data <- chunk:
+			default: // nobody is reading
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *tcpPort) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+
+	if nil == conn {
+		return 0, errLinkDown
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+
+	packet := data
+	if nil != p.telnet {
+		packet = rfc2217.Escape(data)
+	}
+
+	if _, err := conn.Write(packet); err != nil {
+		// the reader sees the closed connection and redials
+		conn.Close()
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (p *tcpPort) Read(b []byte) (int, error) {
+	if 0 == len(p.pending) {
+		select {
+		case chunk := <-p.data:
+			p.pending = chunk
+		case <-p.done:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+
+	return n, nil
+}
+
+// reports whether the connection to the gateway is up.
+func (p *tcpPort) Linked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return nil != p.conn
+}
+
+func (p *tcpPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	conn := p.conn
+	p.conn = nil
+	if nil != conn {
+		return conn.Close()
+	}
+
+	return nil
+}
+
+func (p *tcpPort) String() string {
+	if nil != p.telnet {
+		return fmt.Sprintf("RFC 2217 to %s at %d baud", p.address, p.telnet.BaudRate)
+	}
+
+	return fmt.Sprintf("TCP to %s", p.address)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long after a preset is saved before its thumbnail is taken, so the
+// frame has gone out and the image isn't mid-move
+const thumbnailDelay = 500 * time.Millisecond
+
+// default location of preset thumbnails
+func defaultThumbnailsDir() string {
+	home := os.Getenv("HOME")
+	if "" == home {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "cctv-ptz", "thumbnails")
+}
+
+func thumbnailsDir(conf config.Config) string {
+	if "" != conf.ThumbnailsDir {
+		return conf.ThumbnailsDir
+	}
+
+	return defaultThumbnailsDir()
+}
+
+// thumbnails are named for the camera and preset, e.g. 1-3.jpg, so a renamed
+// preset keeps its image
+func thumbnailName(address, number int) string {
+	return fmt.Sprintf("%d-%d", address, number)
+}
+
+// returns the thumbnail saved for preset number on the camera at address,
+// or "" when there is none.
+func thumbnailPath(conf config.Config, address, number int) string {
+	dir := thumbnailsDir(conf)
+	if "" == dir {
+		return ""
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, thumbnailName(address, number)+".*"))
+	if 0 == len(matches) {
+		return ""
+	}
+
+	return matches[0]
+}
+
+// returns the presets on the camera at address that have thumbnails, saved
+// by any source, named or not.
+func thumbnailPresets(conf config.Config, address int) []int {
+	dir := thumbnailsDir(conf)
+	if "" == dir {
+		return nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, strconv.Itoa(address)+"-*.*"))
+
+	var numbers []int
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), filepath.Ext(match))
+		if number, err := strconv.Atoi(strings.TrimPrefix(name, strconv.Itoa(address)+"-")); nil == err {
+			numbers = append(numbers, number)
+		}
+	}
+
+	return numbers
+}
+
+// removes the thumbnail of preset number on the camera at address.
+func removeThumbnail(conf config.Config, address, number int) {
+	if path := thumbnailPath(conf, address, number); "" != path {
+		if err := os.Remove(path); err != nil {
+			printError("unable to remove thumbnail. %s\n", err)
+		}
+	}
+}
+
+// keeps preset thumbnails in step with message: saving a preset on a camera
+// with a snapshot-url takes a thumbnail in the background, and clearing one
+// removes it. the menu preset is left alone.
+func updateThumbnail(conf config.Config, message PelcoDMessage) {
+	command, err := pelco.Decode(message)
+	if err != nil || !command.Extended || pelco.MenuPreset == command.Data2 {
+		return
+	}
+
+	address, number := command.Address, int(command.Data2)
+
+	switch command.Code {
+	case pelco.SetPreset:
+		camera, _ := conf.Camera(address)
+		dir := thumbnailsDir(conf)
+		if "" == camera.SnapshotURL || "" == dir {
+			return
+		}
+
+		go func() {
+			time.Sleep(thumbnailDelay)
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				printError("unable to save thumbnail. %s\n", err)
+				return
+			}
+
+			// the new image may be of another type than the old
+			previous := thumbnailPath(conf, address, number)
+
+			client := &http.Client{Timeout: 10 * time.Second}
+			path, err := saveSnapshot(client, camera.SnapshotURL, dir, thumbnailName(address, number))
+			if err != nil {
+				printError("unable to save thumbnail of preset %d on %s. %s\n", number, describeCamera(conf, address), err)
+				return
+			}
+
+			if "" != previous && previous != path {
+				os.Remove(previous)
+			}
+		}()
+	case pelco.ClearPreset:
+		removeThumbnail(conf, address, number)
+	}
+}
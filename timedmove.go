@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+	"time"
+)
+
+// scheduling jitter assumed between starting and stopping a timed move
+const timingJitter = 20 * time.Millisecond
+
+// shortest run worth timing. shorter runs are dominated by acceleration.
+const minTimedRun = 500 * time.Millisecond
+
+// TimedRun is a constant speed move lasting Duration.
+type TimedRun struct {
+	Speed    int // signed
+	Duration time.Duration
+	Error    float64 // estimated error in degrees
+}
+
+// plans a run covering degrees on an axis with the given speed table. prefers
+// the fastest calibrated speed that still runs at least minTimedRun.
+func planRun(points []config.SpeedPoint, degrees float64, maxSpeed int32) (TimedRun, error) {
+	if 0 == degrees {
+		return TimedRun{}, nil
+	}
+
+	if 0 == len(points) {
+		return TimedRun{}, errors.New("no speed table. run cctv-ptz calibrate first")
+	}
+
+	var (
+		distance = math.Abs(degrees)
+		best     TimedRun
+		found    bool
+	)
+
+	for _, point := range points {
+		if point.Speed > int(maxSpeed) || point.DegreesPerSecond <= 0 {
+			continue
+		}
+
+		run := TimedRun{
+			Speed:    point.Speed,
+			Duration: time.Duration(distance / point.DegreesPerSecond * float64(time.Second)),
+			Error:    point.DegreesPerSecond * timingJitter.Seconds(),
+		}
+
+		// the fastest run that is long enough wins. failing that, the longest run.
+		longEnough := run.Duration >= minTimedRun
+		bestLongEnough := best.Duration >= minTimedRun
+
+		switch {
+		case !found:
+			best, found = run, true
+		case longEnough && (!bestLongEnough || run.Speed > best.Speed):
+			best = run
+		case !longEnough && !bestLongEnough && run.Duration > best.Duration:
+			best = run
+		}
+	}
+
+	if !found {
+		return TimedRun{}, fmt.Errorf("no calibrated speed at or below max speed %d", maxSpeed)
+	}
+
+	if degrees < 0 {
+		best.Speed = -best.Speed
+	}
+
+	return best, nil
+}
+
+// moves by the given offsets without feedback, timing the pan and tilt runs
+// from the camera's speed table. returns the estimated error of each axis,
+// which accounts for timing jitter only, not acceleration or backlash.
+func moveByTiming(conf config.Config, bus *Bus, pan, tilt float64) (Position, error) {
+	camera, _ := conf.Camera(conf.Address)
+
+	panRun, err := planRun(camera.PanSpeeds, pan, conf.MaxSpeed)
+	if err != nil {
+		return Position{}, fmt.Errorf("pan: %s", err)
+	}
+
+	tiltRun, err := planRun(camera.TiltSpeeds, tilt, conf.MaxSpeed)
+	if err != nil {
+		return Position{}, fmt.Errorf("tilt: %s", err)
+	}
+
+	runTimed(bus.Send, conf.Address, panRun, tiltRun)
+
+	return Position{panRun.Error, tiltRun.Error}, nil
+}
+
+// runs both axes together, stopping each after its own duration.
+func runTimed(send func(PelcoDMessage), address int, panRun, tiltRun TimedRun) {
+	frame := func(pan, tilt int) PelcoDMessage {
+		return pelco.New().To(address).Pan(pan).Tilt(tilt).Build()
+	}
+
+	start := time.Now()
+	send(frame(panRun.Speed, tiltRun.Speed))
+
+	first, second := panRun.Duration, tiltRun.Duration
+	if first > second {
+		first, second = second, first
+	}
+
+	time.Sleep(first - time.Since(start))
+	if panRun.Duration > tiltRun.Duration {
+		send(frame(panRun.Speed, 0))
+	} else if panRun.Duration < tiltRun.Duration {
+		send(frame(0, tiltRun.Speed))
+	}
+
+	time.Sleep(second - time.Since(start))
+	send(frame(0, 0))
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// interval between speed updates while touring
+const tourTick = 100 * time.Millisecond
+
+// how long to wait for a camera to reach the first waypoint's preset
+const presetSettle = 3 * time.Second
+
+// how long to take reaching the first waypoint when it has no duration
+const firstGlide = 5 * time.Second
+
+var errTourStopped = errors.New("tour stopped")
+
+// Positioner reads back a camera's position, for cameras that support it.
+type Positioner interface {
+	QueryPosition(address int) (Position, error)
+}
+
+// TourProgress reports where a running tour is.
+type TourProgress struct {
+	Tour     string
+	Segment  int // index of the waypoint being approached
+	Segments int
+	Position Position // estimated
+}
+
+// returns the speed byte whose calibrated rate best matches rate (deg/sec),
+// interpolating between calibrated points. limited to maxSpeed.
+func rateToSpeed(points []config.SpeedPoint, rate float64, maxSpeed int32) int {
+	if rate <= 0 || 0 == len(points) {
+		return 0
+	}
+
+	sorted := append([]config.SpeedPoint{{Speed: 0, DegreesPerSecond: 0}}, points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DegreesPerSecond < sorted[j].DegreesPerSecond })
+
+	speed := sorted[len(sorted)-1].Speed
+
+	for i := 1; i < len(sorted); i++ {
+		lower, upper := sorted[i-1], sorted[i]
+		if rate <= upper.DegreesPerSecond {
+			t := (rate - lower.DegreesPerSecond) / (upper.DegreesPerSecond - lower.DegreesPerSecond)
+			speed = int(math.Round(float64(lower.Speed) + t*float64(upper.Speed-lower.Speed)))
+			break
+		}
+	}
+
+	if speed < 1 {
+		speed = 1
+	}
+
+	if speed > int(maxSpeed) {
+		speed = int(maxSpeed)
+	}
+
+	return speed
+}
+
+// derivative of smoothstep, 3u^2 - 2u^3. integrates to 1 over [0, 1] and
+// starts and ends at zero, so moves ease in and out.
+func easeRate(u float64) float64 {
+	if u <= 0 || u >= 1 {
+		return 0
+	}
+
+	return 6 * u * (1 - u)
+}
+
+// resolves the address a tour runs on.
+func tourAddress(conf config.Config, tour config.Tour) (int, error) {
+	if "" == tour.Camera {
+		return conf.Address, nil
+	}
+
+	if address := conf.CameraAddress(tour.Camera); address >= 0 {
+		return address, nil
+	}
+
+	address, err := strconv.Atoi(tour.Camera)
+	if err != nil {
+		return 0, fmt.Errorf("tour %s: unknown camera %s", tour.Name, tour.Camera)
+	}
+
+	return address, nil
+}
+
+// runs tour once, sending frames with send. position feedback from positioner
+// (which may be nil) corrects the start of each segment; otherwise the camera
+// is assumed to reach every waypoint, and to start at from (e.g. the end of
+// the previous lap) or, when from is nil, at the first waypoint. returns
+// errTourStopped when stop closes.
+func runTour(conf config.Config, tour config.Tour, send func(PelcoDMessage), positioner Positioner,
+	from *Position, stop <-chan struct{}, report func(TourProgress)) error {
+
+	if len(tour.Waypoints) < 2 {
+		return fmt.Errorf("tour %s needs at least two waypoints", tour.Name)
+	}
+
+	address, err := tourAddress(conf, tour)
+	if err != nil {
+		return err
+	}
+
+	camera, _ := conf.Camera(address)
+	if 0 == len(camera.PanSpeeds) || 0 == len(camera.TiltSpeeds) {
+		return fmt.Errorf("%s has no speed table. run cctv-ptz calibrate first", describeCamera(conf, address))
+	}
+
+	frame := func(pan, tilt int) PelcoDMessage {
+		return pelco.New().To(address).Pan(pan).Tilt(tilt).Build()
+	}
+
+	defer send(frame(0, 0))
+
+	sleep := func(d time.Duration) error {
+		select {
+		case <-stop:
+			return errTourStopped
+		case <-time.After(d):
+			return nil
+		}
+	}
+
+	// eases from current to waypoint over duration
+	glide := func(current Position, waypoint config.Waypoint) error {
+		panDistance := panError(current.Pan, waypoint.Pan)
+		tiltDistance := waypoint.Tilt - current.Tilt
+		duration := waypoint.Duration.Seconds()
+		start := time.Now()
+
+		for elapsed := 0.0; elapsed < duration; elapsed = time.Since(start).Seconds() {
+			// speed at the middle of the coming tick
+			u := (elapsed + tourTick.Seconds()/2) / duration
+			scale := easeRate(u) / duration
+
+			pan := rateToSpeed(camera.PanSpeeds, math.Abs(panDistance)*scale, conf.MaxSpeed)
+			tilt := rateToSpeed(camera.TiltSpeeds, math.Abs(tiltDistance)*scale, conf.MaxSpeed)
+
+			if panDistance < 0 {
+				pan = -pan
+			}
+			if tiltDistance < 0 {
+				tilt = -tilt
+			}
+
+			send(frame(pan, tilt))
+
+			if err := sleep(tourTick); err != nil {
+				return err
+			}
+		}
+
+		send(frame(0, 0))
+
+		return sleep(waypoint.Hold)
+	}
+
+	first := tour.Waypoints[0]
+	current := Position{first.Pan, first.Tilt}
+
+	if first.Preset > 0 {
+		send(pelco.New().To(address).CallPreset(uint8(first.Preset)).Build())
+		if err := sleep(presetSettle); err != nil {
+			return err
+		}
+		first.Duration = 0
+	} else if position, err := startPosition(address, positioner, from); nil == err {
+		current = position
+		if 0 == first.Duration {
+			first.Duration = firstGlide
+		}
+	}
+
+	if err := glide(current, first); err != nil {
+		return err
+	}
+
+	for i := 1; i < len(tour.Waypoints); i++ {
+		waypoint := tour.Waypoints[i]
+		current = Position{tour.Waypoints[i-1].Pan, tour.Waypoints[i-1].Tilt}
+
+		if nil != positioner {
+			if position, err := positioner.QueryPosition(address); nil == err {
+				current = position
+			}
+		}
+
+		if nil != report {
+			report(TourProgress{tour.Name, i, len(tour.Waypoints) - 1, current})
+		}
+
+		if waypoint.Duration <= 0 {
+			return fmt.Errorf("tour %s: waypoint %d needs a duration", tour.Name, i)
+		}
+
+		if err := glide(current, waypoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns the camera's reported position, falling back to from.
+func startPosition(address int, positioner Positioner, from *Position) (Position, error) {
+	if nil != positioner {
+		if position, err := positioner.QueryPosition(address); nil == err {
+			return position, nil
+		}
+	}
+
+	if nil != from {
+		return *from, nil
+	}
+
+	return Position{}, errNoReply
+}
+
+// the position a tour ends at.
+func tourEnd(tour config.Tour) *Position {
+	last := tour.Waypoints[len(tour.Waypoints)-1]
+	return &Position{last.Pan, last.Tilt}
+}
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "tour",
+		Summary: "run a configured tour",
+		Usage:   []string{"TOUR [--loop]"},
+		Options: []string{"--loop                   - repeat the tour until interrupted."},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			tourCommand(conf, arguments["TOUR"].(string), arguments["--loop"].(bool))
+		},
+	})
+}
+
+// runs a configured tour from the command line.
+func tourCommand(conf config.Config, name string, loop bool) {
+	tour, ok := conf.Tour(name)
+	if !ok {
+		printError("unknown tour %s\n", name)
+		os.Exit(1)
+	}
+
+	conf.Ack = true // position replies correct the tour when available
+
+	tty, err := openSerial(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	encoder := newFrameEncoder(conf)
+	send := func(message PelcoDMessage) { sendMessage(tty, encoder, message) }
+
+	var positioner Positioner
+
+	if nil != tty {
+		defer tty.Close()
+
+		bus := &Bus{tty: tty, responses: listenResponses(tty)}
+		send = bus.Send
+		positioner = bus
+	}
+
+	report := func(progress TourProgress) {
+		fmt.Fprintf(os.Stderr, "%s: waypoint %d of %d from %s\n", progress.Tour, progress.Segment, progress.Segments, progress.Position)
+	}
+
+	var from *Position
+
+	for {
+		if err := runTour(conf, tour, send, positioner, from, nil, report); err != nil {
+			printError("%s\n", err)
+			os.Exit(1)
+		}
+
+		if !loop {
+			return
+		}
+
+		from = tourEnd(tour)
+	}
+}
+
+// tours started through the api hold control for this long between frames.
+// holds at a waypoint longer than this let other sources take over.
+const tourLockTTL = 10 * time.Second
+
+// TourStatus describes the tour running on a camera.
+type TourStatus struct {
+	Tour     string    `json:"tour"`
+	Address  int       `json:"address"`
+	Running  bool      `json:"running"`
+	Loop     bool      `json:"loop"`
+	Segment  int       `json:"segment"`
+	Segments int       `json:"segments"`
+	Started  time.Time `json:"started"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type runningTour struct {
+	status TourStatus
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (t *runningTour) halt() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// TourRunner runs tours in the background, at most one per camera, sending
+// their frames through the arbiter as source.
+type TourRunner struct {
+	mu      sync.Mutex
+	conf    config.Config
+	arbiter *Arbiter
+	source  Source
+	send    func(Source, PelcoDMessage)
+	tours   map[int]*runningTour
+}
+
+func NewTourRunner(conf config.Config, arbiter *Arbiter, source Source, send func(Source, PelcoDMessage)) *TourRunner {
+	return &TourRunner{conf: conf, arbiter: arbiter, source: source, send: send, tours: make(map[int]*runningTour)}
+}
+
+// starts tour on address, replacing any tour already running there.
+func (r *TourRunner) Start(tour config.Tour, address int, loop bool) (TourStatus, error) {
+	if err := r.arbiter.Acquire(r.source, tourLockTTL, time.Now()); err != nil {
+		return TourStatus{}, err
+	}
+
+	tour.Camera = strconv.Itoa(address)
+
+	running := &runningTour{
+		status: TourStatus{Tour: tour.Name, Address: address, Running: true, Loop: loop, Started: time.Now()},
+		stop:   make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	if previous, ok := r.tours[address]; ok {
+		previous.halt()
+	}
+	r.tours[address] = running
+	r.mu.Unlock()
+
+	// a source that takes control away ends the tour
+	send := func(message PelcoDMessage) {
+		if err := r.arbiter.Acquire(r.source, tourLockTTL, time.Now()); err != nil {
+			running.halt()
+			return
+		}
+		r.send(r.source, message)
+	}
+
+	report := func(progress TourProgress) {
+		r.mu.Lock()
+		running.status.Segment = progress.Segment
+		running.status.Segments = progress.Segments
+		r.mu.Unlock()
+	}
+
+	go func() {
+		var (
+			err  error
+			from *Position
+		)
+
+		for {
+			if err = runTour(r.conf, tour, send, nil, from, running.stop, report); err != nil || !loop {
+				break
+			}
+			from = tourEnd(tour)
+		}
+
+		r.arbiter.Release(r.source)
+
+		r.mu.Lock()
+		running.status.Running = false
+		if nil != err && errTourStopped != err {
+			running.status.Error = err.Error()
+		}
+		r.mu.Unlock()
+	}()
+
+	return r.Status(address), nil
+}
+
+// stops the tour on address, reporting false if none is running.
+func (r *TourRunner) Stop(address int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	running, ok := r.tours[address]
+	if !ok || !running.status.Running {
+		return false
+	}
+
+	running.halt()
+
+	return true
+}
+
+// returns the status of the last tour started on address.
+func (r *TourRunner) Status(address int) TourStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if running, ok := r.tours[address]; ok {
+		return running.status
+	}
+
+	return TourStatus{Address: address}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// serial settings of this form send raw frames to a UDP serial bridge or
+// camera encoder at HOST:PORT, e.g. udp://192.168.1.60:6000?copies=2
+const udpScheme = "udp://"
+
+// most copies of a frame a lossy link is worth
+const maxUDPCopies = 5
+
+func isUDPSerial(port string) bool {
+	return strings.HasPrefix(port, udpScheme)
+}
+
+// udpPort stands in for a serial port with a datagram per frame. nothing
+// acknowledges them, so a lossy link can have each frame sent several times;
+// cameras take a repeated frame as the same command. whatever the bridge
+// sends back, e.g. a camera's replies, is read as if from the line.
+type udpPort struct {
+	conn    *net.UDPConn
+	copies  int
+	replies chan []byte
+	pending []byte // of the reply being read
+}
+
+// dials the bridge of a udp://HOST:PORT[?copies=N] setting.
+func dialUDPSerial(port string) (*udpPort, error) {
+	parsed, err := url.Parse(port)
+	if err != nil || "" == parsed.Port() {
+		return nil, fmt.Errorf("invalid serial %s. expected udp://HOST:PORT", port)
+	}
+
+	copies := 1
+	if text := parsed.Query().Get("copies"); "" != text {
+		if copies, err = strconv.Atoi(text); err != nil || copies < 1 || copies > maxUDPCopies {
+			return nil, fmt.Errorf("invalid copies %s in %s. expected 1-%d", text, port, maxUDPCopies)
+		}
+	}
+
+	remote, err := net.ResolveUDPAddr("udp", parsed.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &udpPort{conn: conn, copies: copies, replies: make(chan []byte, 20)}
+	go p.listen()
+
+	return p, nil
+}
+
+// sends data, a frame, in copies datagrams.
+func (p *udpPort) Write(data []byte) (int, error) {
+	for i := 0; i < p.copies; i++ {
+		if _, err := p.conn.Write(data); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// reads datagrams until the port closes.
+func (p *udpPort) listen() {
+	defer close(p.replies)
+
+	buffer := make([]byte, 1500)
+	for {
+		n, err := p.conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue // e.g. refused while the bridge is down
+		}
+
+		select {
+		case p.replies <- append([]byte(nil), buffer[:n]...):
+		default: // nobody is reading
+		}
+	}
+}
+
+func (p *udpPort) Read(b []byte) (int, error) {
+	if 0 == len(p.pending) {
+		reply, ok := <-p.replies
+		if !ok {
+			return 0, io.EOF
+		}
+		p.pending = reply
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+
+	return n, nil
+}
+
+func (p *udpPort) Close() error {
+	return p.conn.Close()
+}
+
+func (p *udpPort) String() string {
+	if p.copies > 1 {
+		return fmt.Sprintf("UDP to %s, %d copies of each frame", p.conn.RemoteAddr(), p.copies)
+	}
+
+	return fmt.Sprintf("UDP to %s", p.conn.RemoteAddr())
+}
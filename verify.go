@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// time between trajectory samples while a simulated camera is moving
+const verifyInterval = 500 * time.Millisecond
+
+// the range of positions a simulated camera passed through
+type sweep struct {
+	panMin, panMax   float64
+	tiltMin, tiltMax float64
+	pastLimits       int // samples past the camera's soft limits
+}
+
+func (s *sweep) add(at Orientation) {
+	s.panMin, s.panMax = math.Min(s.panMin, at.Pan), math.Max(s.panMax, at.Pan)
+	s.tiltMin, s.tiltMax = math.Min(s.tiltMin, at.Tilt), math.Max(s.tiltMax, at.Tilt)
+}
+
+// plays frames from c into a simulated camera on a virtual clock instead of
+// the serial port, writing each camera's trajectory to w: a line per frame,
+// a sample every verifyInterval while moving, and a summary at the end.
+// positions start from zero, as the estimator's do. nothing sleeps, so an
+// hour long tour is checked in a moment.
+func verifyMessages(c <-chan DelayedMessage, conf config.Config, w io.Writer) {
+	var (
+		estimator = NewEstimator(conf)
+		epoch     = time.Unix(0, 0)
+		clock     time.Duration
+		sweeps    = make(map[int]*sweep)
+	)
+
+	sample := func(address int, actions []string) {
+		at := estimator.Orientation(address, epoch.Add(clock))
+
+		s, ok := sweeps[address]
+		if !ok {
+			s = &sweep{panMin: at.Pan, panMax: at.Pan, tiltMin: at.Tilt, tiltMax: at.Tilt}
+			sweeps[address] = s
+		}
+		s.add(at)
+
+		line := fmt.Sprintf("%s  %-14s pan %7.2f  tilt %6.2f  zoom %4.2f",
+			formatVerifyTime(clock), describeCamera(conf, address), at.Pan, at.Tilt, at.Zoom)
+
+		if camera, ok := conf.Camera(address); ok && exceedsLimits(camera.Limits, at) {
+			s.pastLimits++
+			line += "  PAST LIMITS"
+		}
+
+		if 0 != len(actions) {
+			line += "  " + strings.Join(actions, ", ")
+		}
+
+		fmt.Fprintln(w, line)
+	}
+
+	// samples moving cameras between frames, up to until
+	advance := func(until time.Duration) {
+		for next := (clock/verifyInterval + 1) * verifyInterval; next < until; next += verifyInterval {
+			clock = next
+
+			for _, at := range verifyMoving(estimator, epoch.Add(clock)) {
+				sample(at.Address, nil)
+			}
+		}
+
+		clock = until
+	}
+
+	for pkg := range c {
+		advance(clock + pkg.Delay)
+
+		if nil != pkg.Move && pkg.Move.Degrees {
+			for _, message := range pkg.Move.Messages() {
+				estimator.Observe(message, epoch.Add(clock))
+			}
+			sample(pkg.Move.Address, []string{pkg.Move.String()})
+			continue
+		}
+
+		if nil != pkg.Move {
+			// the camera goes there on its own, at a speed nothing here knows
+			sample(pkg.Move.Address, []string{pkg.Move.String()})
+			continue
+		}
+
+		estimator.Observe(pkg.Message, epoch.Add(clock))
+		sample(int(pkg.Message[pelco.Addr]), describeActions(pkg.Message))
+	}
+
+	// keep sampling anything left moving for a while, so a missing stop shows
+	if moving := verifyMoving(estimator, epoch.Add(clock)); 0 != len(moving) {
+		advance(clock + 2*verifyInterval)
+		for _, at := range moving {
+			sample(at.Address, []string{"still moving at end of recording"})
+		}
+	}
+
+	fmt.Fprintln(w)
+
+	orientations, _ := estimator.Snapshot(epoch.Add(clock))
+	for _, at := range orientations {
+		s := sweeps[at.Address]
+
+		fmt.Fprintf(w, "%s: ends at pan %.2f, tilt %.2f, zoom %.2f. pan %.2f to %.2f, tilt %.2f to %.2f",
+			describeCamera(conf, at.Address), at.Pan, at.Tilt, at.Zoom, s.panMin, s.panMax, s.tiltMin, s.tiltMax)
+
+		if 0 != s.pastLimits {
+			fmt.Fprintf(w, ". %d samples past soft limits", s.pastLimits)
+		}
+		if !at.Referenced {
+			// no absolute move, so relative to the start or the last preset
+			fmt.Fprintf(w, ". unreferenced")
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "%s simulated\n", formatVerifyTime(clock))
+}
+
+// returns the simulated cameras that are moving at now.
+func verifyMoving(estimator *Estimator, now time.Time) []Orientation {
+	var moving []Orientation
+
+	orientations, _ := estimator.Snapshot(now)
+	for _, at := range orientations {
+		if at.Moving {
+			moving = append(moving, at)
+		}
+	}
+
+	return moving
+}
+
+// reports whether at is past any of limits. unlike clipToLimits, this trusts
+// unreferenced positions, since a simulation has nothing better to go on.
+func exceedsLimits(limits *config.Limits, at Orientation) bool {
+	if nil == limits {
+		return false
+	}
+
+	if nil != limits.PanMin && nil != limits.PanMax && !inPanRange(at.Pan, *limits.PanMin, *limits.PanMax) {
+		return true
+	}
+
+	return (nil != limits.TiltMax && at.Tilt > *limits.TiltMax) ||
+		(nil != limits.TiltMin && at.Tilt < *limits.TiltMin)
+}
+
+// formats a virtual clock time to the millisecond, e.g. 00:01:02.500.
+func formatVerifyTime(elapsed time.Duration) string {
+	return fmt.Sprintf("%s.%03d", formatElapsed(elapsed), int64(elapsed/time.Millisecond)%1000)
+}
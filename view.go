@@ -0,0 +1,116 @@
+package main
+
+// a page drawing the selected camera's estimated orientation: a compass for
+// pan, an arc for tilt, and a bar for zoom, over its preset thumbnails, which
+// call the preset when clicked. api tokens are passed along from the page's
+// own ?access_token=.
+const viewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cctv-ptz</title>
+<style>
+  body { background: #111; color: #ddd; font: 14px monospace; margin: 1em; }
+  canvas { display: block; margin-top: 1em; }
+  .warn { color: #e0b050; }
+  #presets figure { display: inline-block; margin: 0 1em 1em 0; cursor: pointer; text-align: center; }
+  #presets img { display: block; width: 160px; border: 1px solid #555; }
+</style>
+</head>
+<body>
+<div id="camera">connecting...</div>
+<div id="detail"></div>
+<canvas id="view" width="480" height="260"></canvas>
+<div id="presets"></div>
+<script>
+var params = new URLSearchParams(location.search);
+var query = params.has("access_token") ? "?access_token=" + encodeURIComponent(params.get("access_token")) : "";
+var canvas = document.getElementById("view");
+var g = canvas.getContext("2d");
+
+function draw(o) {
+  var name = o.camera ? o.camera + " (" + o.address + ")" : "addr " + o.address;
+  document.getElementById("camera").textContent = name + (o.moving ? "  moving" : "");
+  var detail = document.getElementById("detail");
+  detail.textContent = "pan " + o.pan.toFixed(1) + "°  tilt " + o.tilt.toFixed(1) +
+    "°  zoom " + Math.round(o.zoom * 100) + "%  (" + o.source + ")" +
+    (o.referenced ? "" : "  relative to start") + (o.calibrated ? "" : "  uncalibrated");
+  detail.className = o.referenced && o.calibrated ? "" : "warn";
+
+  g.clearRect(0, 0, canvas.width, canvas.height);
+  g.strokeStyle = "#555";
+  g.lineWidth = 2;
+
+  // pan compass, 0 degrees up, clockwise
+  var cx = 120, cy = 130, r = 100;
+  g.beginPath(); g.arc(cx, cy, r, 0, 2 * Math.PI); g.stroke();
+  var a = (o.pan - 90) * Math.PI / 180;
+  var half = Math.max(2, 30 * (1 - o.zoom)) * Math.PI / 180; // narrows as zoom increases
+  g.fillStyle = "rgba(80, 180, 220, 0.4)";
+  g.beginPath(); g.moveTo(cx, cy); g.arc(cx, cy, r, a - half, a + half); g.closePath(); g.fill();
+  g.strokeStyle = "#5bd";
+  g.beginPath(); g.moveTo(cx, cy); g.lineTo(cx + r * Math.cos(a), cy + r * Math.sin(a)); g.stroke();
+
+  // tilt arc, horizon level, up is up
+  var tx = 300, ty = 130;
+  g.strokeStyle = "#555";
+  g.beginPath(); g.arc(tx, ty, r, -Math.PI / 2, Math.PI / 2); g.stroke();
+  g.beginPath(); g.moveTo(tx, ty); g.lineTo(tx + r, ty); g.stroke();
+  var t = -o.tilt * Math.PI / 180;
+  g.strokeStyle = "#5bd";
+  g.beginPath(); g.moveTo(tx, ty); g.lineTo(tx + r * Math.cos(t), ty + r * Math.sin(t)); g.stroke();
+
+  // zoom bar, wide at the bottom
+  g.strokeStyle = "#555";
+  g.strokeRect(440, 30, 20, 200);
+  g.fillStyle = "#5bd";
+  g.fillRect(440, 230 - 200 * o.zoom, 20, 200 * o.zoom);
+}
+
+var presetsAddress = -1, presetsLoaded = 0;
+
+function showPresets(address, presets) {
+  var strip = document.getElementById("presets");
+  strip.textContent = "";
+  presets.filter(function (p) { return p.thumbnail; }).forEach(function (p) {
+    var figure = document.createElement("figure");
+    var img = document.createElement("img");
+    img.src = p.thumbnail + query + (query ? "&" : "?") + "t=" + presetsLoaded;
+    var caption = document.createElement("figcaption");
+    caption.textContent = p.number + (p.name ? " " + p.name : "");
+    figure.appendChild(img);
+    figure.appendChild(caption);
+    figure.onclick = function () {
+      fetch("/api/cameras/" + address + "/presets/" + p.number + "/call" + query, {method: "POST", credentials: "same-origin"});
+    };
+    strip.appendChild(figure);
+  });
+}
+
+// refreshes the thumbnails when the camera changes, and every 10 seconds for
+// presets saved meanwhile
+function loadPresets(address) {
+  if (address === presetsAddress && Date.now() - presetsLoaded < 10000) {
+    return;
+  }
+  presetsAddress = address;
+  presetsLoaded = Date.now();
+  fetch("/api/cameras/" + address + "/presets" + query, {credentials: "same-origin"})
+    .then(function (r) { return r.json(); })
+    .then(function (presets) { showPresets(address, presets); })
+    .catch(function () {});
+}
+
+function poll() {
+  fetch("/api/orientation" + query, {credentials: "same-origin"})
+    .then(function (r) { return r.json(); })
+    .then(function (o) { draw(o); loadPresets(o.address); })
+    .catch(function () { document.getElementById("camera").textContent = "disconnected"; })
+    .finally(function () { setTimeout(poll, 200); });
+}
+
+poll();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"github.com/boxofrox/cctv-ptz/visca"
+)
+
+func init() {
+	registerProtocol("visca", func() Protocol { return &viscaProtocol{sent: make(map[uint8]pelco.Command)} })
+}
+
+// viscaProtocol drives pan and tilt with every frame, so a lost command is
+// made good by the next, and zoom and focus as they change. an iris step
+// goes out for each frame opening or closing the iris. presets map to VISCA
+// memories of the same number, and the menu preset toggles the menu. frames
+// VISCA has no command for get nothing.
+type viscaProtocol struct {
+	sent map[uint8]pelco.Command // last frame of each camera
+}
+
+func isVISCAAddress(address int) bool {
+	return address >= visca.MinAddress && address <= visca.MaxAddress
+}
+
+func (p *viscaProtocol) Frame(message PelcoDMessage) []byte {
+	return encodeFrame(p, message)
+}
+
+func (p *viscaProtocol) EncodeMove(command pelco.Command) []byte {
+	address := command.Address
+
+	last, sent := p.sent[uint8(address)]
+	p.sent[uint8(address)] = command
+
+	packets := visca.Drive(address, command.Pan, viscaSpeed(command.PanSpeed, visca.MaxPanSpeed),
+		command.Tilt, viscaSpeed(command.TiltSpeed, visca.MaxTiltSpeed))
+
+	if !sent || last.Zoom != command.Zoom {
+		packets = append(packets, visca.Zoom(address, command.Zoom)...)
+	}
+	if !sent || last.Focus != command.Focus {
+		packets = append(packets, visca.Focus(address, command.Focus)...)
+	}
+	if 0 != command.Iris {
+		packets = append(packets, visca.Iris(address, command.Iris)...)
+	}
+
+	return packets
+}
+
+func (p *viscaProtocol) EncodeStop(address int) []byte {
+	return p.EncodeMove(pelco.Command{Address: address})
+}
+
+func (p *viscaProtocol) EncodePreset(address int, code, preset uint8) []byte {
+	switch {
+	case pelco.SetPreset == code && pelco.MenuPreset == preset:
+		return visca.Menu(address)
+	case pelco.SetPreset == code:
+		return visca.Memory(address, visca.Set, preset)
+	case pelco.CallPreset == code:
+		return visca.Memory(address, visca.Recall, preset)
+	case pelco.ClearPreset == code:
+		return visca.Memory(address, visca.Reset, preset)
+	}
+
+	return nil
+}
+
+func (p *viscaProtocol) EncodeExtended(pelco.Command) []byte {
+	return nil
+}
+
+// a camera of its own on the network answers whatever its address.
+func (p *viscaProtocol) CheckAddress(settings config.Config, address int) error {
+	if !isVISCAIP(settings.SerialPort) && !isVISCAAddress(address) {
+		return fmt.Errorf("visca cameras are addressed %d-%d", visca.MinAddress, visca.MaxAddress)
+	}
+
+	return nil
+}
+
+// scales a Pelco-D speed, 0 to FullSpeed with turbo above, to a VISCA speed
+// of 1 to max. pelcoSpeed undoes it exactly, so played back VISCA recordings
+// send what was recorded.
+func viscaSpeed(speed, max uint8) uint8 {
+	if speed > pelco.FullSpeed {
+		speed = pelco.FullSpeed
+	}
+
+	return 1 + uint8(int(speed)*int(max-1)/pelco.FullSpeed)
+}
+
+func pelcoSpeed(speed, max uint8) uint8 {
+	if speed < 1 {
+		return 0
+	}
+
+	return uint8(((int(speed)-1)*pelco.FullSpeed + int(max) - 2) / int(max-1))
+}
@@ -0,0 +1,76 @@
+package visca
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// VISCA over IP wraps each command in a UDP datagram with an 8 byte header:
+// the payload type, the payload length, and a sequence number the camera
+// uses to spot lost or repeated commands. the header byte of the command is
+// always 0x81, whatever the camera's serial address.
+const (
+	DefaultPort = 52381
+
+	CommandPayload      = 0x0100
+	InquiryPayload      = 0x0110
+	ReplyPayload        = 0x0111
+	ControlPayload      = 0x0200
+	ControlReplyPayload = 0x0201
+
+	headerSize = 8
+)
+
+// control payloads
+const (
+	ResetSequence = 0x01
+
+	// a control reply reporting the sequence number was not the one expected
+	sequenceError = 0x0f01
+)
+
+var ErrShortPacket = errors.New("packet shorter than its header")
+
+// Packet returns the datagram carrying payload with sequence number.
+func Packet(payloadType uint16, sequence uint32, payload []byte) []byte {
+	packet := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint16(packet[0:], payloadType)
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[4:], sequence)
+
+	return append(packet, payload...)
+}
+
+// ParsePacket splits a datagram into its header fields and payload.
+func ParsePacket(packet []byte) (uint16, uint32, []byte, error) {
+	if len(packet) < headerSize {
+		return 0, 0, nil, ErrShortPacket
+	}
+
+	payloadType := binary.BigEndian.Uint16(packet[0:])
+	length := int(binary.BigEndian.Uint16(packet[2:]))
+	sequence := binary.BigEndian.Uint32(packet[4:])
+
+	payload := packet[headerSize:]
+	if length < len(payload) {
+		payload = payload[:length]
+	}
+
+	return payloadType, sequence, payload, nil
+}
+
+// IsSequenceError reports whether a control reply payload says the camera
+// lost track of the sequence, so it must be reset.
+func IsSequenceError(payload []byte) bool {
+	return 2 <= len(payload) && sequenceError == binary.BigEndian.Uint16(payload)
+}
+
+// OverIP returns command addressed as VISCA over IP expects.
+func OverIP(command []byte) []byte {
+	command = append([]byte(nil), command...)
+	if 0 != len(command) {
+		command[0] = header(1)
+	}
+
+	return command
+}
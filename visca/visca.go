@@ -0,0 +1,238 @@
+// Package visca builds and reads the VISCA commands Sony and PTZOptics heads
+// take over RS-232/RS-422. a command starts with 0x80 plus the camera
+// address, 1 through 7, and ends with 0xff.
+package visca
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	Terminator = 0xff
+
+	MinAddress = 1
+	MaxAddress = 7
+
+	// fastest pan and tilt drive speeds
+	MaxPanSpeed  = 0x18
+	MaxTiltSpeed = 0x14
+
+	// highest memory a preset can be saved in
+	MaxMemory = 0x7f
+)
+
+// directions of Drive. pan is positive to the right and tilt up.
+const (
+	left  = 0x01
+	right = 0x02
+	up    = 0x01
+	down  = 0x02
+	still = 0x03
+)
+
+// memory operations
+const (
+	Reset  = 0x00
+	Set    = 0x01
+	Recall = 0x02
+)
+
+var (
+	ErrTerminator = errors.New("command does not end with 0xff")
+	ErrHeader     = errors.New("command does not start with a camera address")
+)
+
+func header(address int) byte {
+	return 0x80 | byte(address&0x07)
+}
+
+// Drive moves the camera at address: pan and tilt are -1, 0, or 1, at speeds
+// from 1 to MaxPanSpeed and MaxTiltSpeed.
+func Drive(address, pan int, panSpeed uint8, tilt int, tiltSpeed uint8) []byte {
+	return []byte{header(address), 0x01, 0x06, 0x01, clamp(panSpeed, MaxPanSpeed), clamp(tiltSpeed, MaxTiltSpeed),
+		direction(pan, right, left), direction(tilt, up, down), Terminator}
+}
+
+// Zoom zooms in (tele) for 1, out (wide) for -1, and stops for 0.
+func Zoom(address, zoom int) []byte {
+	return []byte{header(address), 0x01, 0x04, 0x07, lens(zoom), Terminator}
+}
+
+// Focus focuses far for 1, near for -1, and stops for 0.
+func Focus(address, focus int) []byte {
+	return []byte{header(address), 0x01, 0x04, 0x08, lens(focus), Terminator}
+}
+
+// Iris opens the iris a step for 1 and closes it a step for -1.
+func Iris(address, iris int) []byte {
+	return []byte{header(address), 0x01, 0x04, 0x0b, lens(iris), Terminator}
+}
+
+// Memory sets, recalls, or resets preset memory.
+func Memory(address int, operation, memory uint8) []byte {
+	return []byte{header(address), 0x01, 0x04, 0x3f, operation, memory & MaxMemory, Terminator}
+}
+
+// Menu shows the on-screen menu, or hides it when shown.
+func Menu(address int) []byte {
+	return []byte{header(address), 0x01, 0x06, 0x06, 0x10, Terminator}
+}
+
+// VersionInquiry asks the camera at address for its vendor and model. it
+// changes nothing, so it is safe for finding cameras.
+func VersionInquiry(address int) []byte {
+	return []byte{header(address), 0x09, 0x00, 0x02, Terminator}
+}
+
+// ReplyFrom reports whether packet is a reply from the camera at address:
+// an ack, completion, or error, each starting with 0x80 plus the address
+// plus 8 in the high nibble.
+func ReplyFrom(packet []byte, address int) bool {
+	return len(packet) >= 3 && byte((address+8)<<4) == packet[0] && Terminator == packet[len(packet)-1]
+}
+
+func clamp(speed, max uint8) uint8 {
+	switch {
+	case speed < 1:
+		return 1
+	case speed > max:
+		return max
+	}
+
+	return speed
+}
+
+func direction(value int, positive, negative uint8) uint8 {
+	switch {
+	case value > 0:
+		return positive
+	case value < 0:
+		return negative
+	}
+
+	return still
+}
+
+func lens(value int) uint8 {
+	switch {
+	case value > 0:
+		return 0x02
+	case value < 0:
+		return 0x03
+	}
+
+	return 0x00
+}
+
+// Command is a decoded VISCA command. Kind says which of the fields are set.
+type Command struct {
+	Address int
+	Kind    Kind
+
+	Pan       int // -1, 0, or 1, as for Drive
+	PanSpeed  uint8
+	Tilt      int
+	TiltSpeed uint8
+	Value     int // -1, 0, or 1 of Zoom, Focus, and Iris
+
+	Operation uint8 // of Memory
+	Memory    uint8
+}
+
+type Kind int
+
+const (
+	DriveCommand Kind = iota
+	ZoomCommand
+	FocusCommand
+	IrisCommand
+	MemoryCommand
+	MenuCommand
+)
+
+// Split cuts data at each terminator into commands. an unterminated tail
+// is returned as its own command, for Decode to reject.
+func Split(data []byte) [][]byte {
+	var commands [][]byte
+
+	for start := 0; start < len(data); {
+		end := start
+		for end < len(data) && Terminator != data[end] {
+			end++
+		}
+		if end < len(data) {
+			end++
+		}
+
+		commands = append(commands, data[start:end])
+		start = end
+	}
+
+	return commands
+}
+
+// Decode reads one command built by this package.
+func Decode(packet []byte) (Command, error) {
+	var command Command
+
+	if 0 == len(packet) || Terminator != packet[len(packet)-1] {
+		return command, ErrTerminator
+	}
+	if len(packet) < 5 || 0x80 != packet[0]&0xf8 || 0 == packet[0]&0x07 || 0x01 != packet[1] {
+		return command, ErrHeader
+	}
+
+	command.Address = int(packet[0] & 0x07)
+	body := packet[2 : len(packet)-1]
+
+	switch {
+	case 6 == len(body) && 0x06 == body[0] && 0x01 == body[1]:
+		command.Kind = DriveCommand
+		command.PanSpeed, command.TiltSpeed = body[2], body[3]
+		command.Pan = unDirection(body[4], right, left)
+		command.Tilt = unDirection(body[5], up, down)
+
+	case 3 == len(body) && 0x04 == body[0] && 0x07 == body[1]:
+		command.Kind, command.Value = ZoomCommand, unLens(body[2])
+
+	case 3 == len(body) && 0x04 == body[0] && 0x08 == body[1]:
+		command.Kind, command.Value = FocusCommand, unLens(body[2])
+
+	case 3 == len(body) && 0x04 == body[0] && 0x0b == body[1]:
+		command.Kind, command.Value = IrisCommand, unLens(body[2])
+
+	case 4 == len(body) && 0x04 == body[0] && 0x3f == body[1]:
+		command.Kind, command.Operation, command.Memory = MemoryCommand, body[2], body[3]
+
+	case 3 == len(body) && 0x06 == body[0] && 0x06 == body[1] && 0x10 == body[2]:
+		command.Kind = MenuCommand
+
+	default:
+		return command, fmt.Errorf("unknown command % x", packet)
+	}
+
+	return command, nil
+}
+
+func unDirection(value, positive, negative uint8) int {
+	switch value {
+	case positive:
+		return 1
+	case negative:
+		return -1
+	}
+
+	return 0
+}
+
+func unLens(value uint8) int {
+	switch value & 0x0f {
+	case 0x02:
+		return 1
+	case 0x03:
+		return -1
+	}
+
+	return 0
+}
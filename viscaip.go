@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boxofrox/cctv-ptz/visca"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// serial settings of this form send VISCA over IP to the camera at HOST
+const viscaIPScheme = "visca-ip://"
+
+func isVISCAIP(port string) bool {
+	return strings.HasPrefix(port, viscaIPScheme)
+}
+
+// viscaIPPort sends the VISCA commands written to it to a camera over UDP,
+// each in a datagram of its own. the sequence is reset when the port opens,
+// when the camera reports losing it, and before the number wraps. replies
+// to commands are read back as the bare VISCA they carry.
+type viscaIPPort struct {
+	conn    *net.UDPConn
+	replies chan []byte
+	pending []byte // of the reply being read
+
+	mu       sync.Mutex
+	sequence uint32
+	reset    bool // send a reset before the next command
+}
+
+// dials the camera of a visca-ip://HOST[:PORT] setting.
+func dialVISCAIP(port string) (*viscaIPPort, error) {
+	address := strings.TrimPrefix(port, viscaIPScheme)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(visca.DefaultPort))
+	}
+
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &viscaIPPort{conn: conn, replies: make(chan []byte, 20), reset: true}
+	go p.listen()
+
+	return p, nil
+}
+
+func (p *viscaIPPort) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, command := range visca.Split(data) {
+		if p.reset || ^uint32(0) == p.sequence {
+			if _, err := p.conn.Write(visca.Packet(visca.ControlPayload, 0, []byte{visca.ResetSequence})); err != nil {
+				return 0, err
+			}
+			p.sequence, p.reset = 0, false
+		}
+
+		p.sequence++
+		if _, err := p.conn.Write(visca.Packet(visca.CommandPayload, p.sequence, visca.OverIP(command))); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+// reads replies until the port closes.
+func (p *viscaIPPort) listen() {
+	defer close(p.replies)
+
+	buffer := make([]byte, 1500)
+	for {
+		n, err := p.conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue // e.g. refused while the camera boots
+		}
+
+		payloadType, _, payload, err := visca.ParsePacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+
+		switch payloadType {
+		case visca.ControlReplyPayload:
+			if visca.IsSequenceError(payload) {
+				p.mu.Lock()
+				p.reset = true
+				p.mu.Unlock()
+			}
+		case visca.ReplyPayload:
+			select {
+			case p.replies <- append([]byte(nil), payload...):
+			default: // nobody is reading
+			}
+		}
+	}
+}
+
+func (p *viscaIPPort) Read(b []byte) (int, error) {
+	if 0 == len(p.pending) {
+		reply, ok := <-p.replies
+		if !ok {
+			return 0, io.EOF
+		}
+		p.pending = reply
+	}
+
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+
+	return n, nil
+}
+
+func (p *viscaIPPort) Close() error {
+	return p.conn.Close()
+}
+
+func (p *viscaIPPort) String() string {
+	return fmt.Sprintf("VISCA over IP to %s", p.conn.RemoteAddr())
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minimal RFC 6455 websocket, enough to push text messages to browsers, and
+// to talk to obs-websocket as a client. no extensions or fragmented reads.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+type WebSocket struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	mu     sync.Mutex // serializes writes
+	client bool       // clients mask their frames
+}
+
+func isWebSocketRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// completes the websocket handshake and takes over the connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !isWebSocketRequest(r) || "" == key {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket request")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(digest[:])
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &WebSocket{conn: conn, rw: rw}, nil
+}
+
+// connects to a ws:// or wss:// url and completes the websocket handshake.
+func dialWebSocket(rawurl string) (*WebSocket, error) {
+	target, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	host := target.Host
+	var conn net.Conn
+
+	switch target.Scheme {
+	case "ws":
+		if "" == target.Port() {
+			host += ":80"
+		}
+		conn, err = net.DialTimeout("tcp", host, 5*time.Second)
+	case "wss":
+		if "" == target.Port() {
+			host += ":443"
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", host, nil)
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %s", target.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	path := target.RequestURI()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	rw.WriteString("GET " + path + " HTTP/1.1\r\n")
+	rw.WriteString("Host: " + target.Host + "\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Key: " + key + "\r\n")
+	rw.WriteString("Sec-WebSocket-Version: 13\r\n\r\n")
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	response, err := http.ReadResponse(rw.Reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	if http.StatusSwitchingProtocols != response.StatusCode ||
+		base64.StdEncoding.EncodeToString(digest[:]) != response.Header.Get("Sec-WebSocket-Accept") {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake refused. %s", response.Status)
+	}
+
+	return &WebSocket{conn: conn, rw: rw, client: true}, nil
+}
+
+func (ws *WebSocket) WriteText(text []byte) error {
+	return ws.writeFrame(wsText, text)
+}
+
+func (ws *WebSocket) Close() error {
+	ws.writeFrame(wsClose, nil)
+	return ws.conn.Close()
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if ws.client {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xffff:
+		header = append(header, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if ws.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		header = append(header, mask[:]...)
+
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+
+	return ws.rw.Flush()
+}
+
+// reads the next data frame, answering pings along the way. returns io.EOF
+// when the client closes the connection.
+func (ws *WebSocket) ReadMessage() ([]byte, error) {
+	for {
+		var head [2]byte
+
+		if _, err := io.ReadFull(ws.rw, head[:]); err != nil {
+			return nil, err
+		}
+
+		opcode := head[0] & 0x0f
+		masked := 0 != head[1]&0x80
+		length := uint64(head[1] & 0x7f)
+
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+
+		if length > 1<<20 {
+			return nil, errors.New("websocket frame too large")
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(ws.rw, payload); err != nil {
+			return nil, err
+		}
+
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case wsClose:
+			return nil, io.EOF
+		case wsPing:
+			if err := ws.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+			// ignore
+		default:
+			return payload, nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/boxofrox/cctv-ptz/config"
+	"github.com/boxofrox/cctv-ptz/pelco"
+	"os"
+	"strconv"
+)
+
+func init() {
+	registerCommand(&Subcommand{
+		Name:    "zone",
+		Summary: "mark where a camera points as an edge of a scan zone",
+		Usage:   []string{"(start | end) ZONE"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			zone, err := strconv.ParseUint(arguments["ZONE"].(string), 10, 8)
+			if err != nil || 0 == zone || zone > 8 {
+				printError("invalid zone %s. expected 1-8\n", arguments["ZONE"])
+				os.Exit(1)
+			}
+
+			message := pelco.New().To(conf.Address).ZoneEnd(uint8(zone)).Build()
+			if arguments["start"].(bool) {
+				message = pelco.New().To(conf.Address).ZoneStart(uint8(zone)).Build()
+			}
+			sendOnce(conf, message)
+		},
+	})
+
+	registerCommand(&Subcommand{
+		Name:    "zone-scan",
+		Summary: "start or stop a camera scanning its zones",
+		Usage:   []string{"(on | off)"},
+		Run: func(conf config.Config, arguments map[string]interface{}) {
+			sendOnce(conf, pelco.New().To(conf.Address).ZoneScan(arguments["on"].(bool)).Build())
+		},
+	})
+}